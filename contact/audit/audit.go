@@ -0,0 +1,109 @@
+// Package audit records who changed which field of which contact, and when:
+// Repository decorates any contact.Repository, appending an Entry to a Log for
+// every change made through it.
+package audit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+type Action string
+
+const (
+	Created  Action = "created"
+	Updated  Action = "updated"
+	Deleted  Action = "deleted"
+	Restored Action = "restored"
+	Purged   Action = "purged"
+)
+
+// Stored tells whether the Contact of an entry with the action is one stored
+// by the change, rather than the one the change deleted.
+func (me Action) Stored() bool {
+	return me == Created || me == Updated || me == Restored
+}
+
+// Entry records a change of a contact
+type Entry struct {
+	ContactId contact.Id
+	At        time.Time
+	// Actor is who made the change (see WithActor)
+	Actor  string
+	Action Action
+	// Note tells why the change was made, if the actor said (see WithNote)
+	Note    string `json:",omitempty"`
+	Changes []Change
+	// Contact is the contact after the change, or before it when deleted or purged
+	Contact contact.Contact
+}
+
+// Log stores the entries; it is safe for concurrent use.
+type Log interface {
+	Append(ctx context.Context, entries ...Entry) error
+	// History lists the entries of the contact, oldest first.
+	History(ctx context.Context, id contact.Id) ([]Entry, error)
+}
+
+// InMemoryLog is a Log lost when the process exits
+type InMemoryLog struct {
+	mu        sync.RWMutex
+	byContact map[contact.Id][]Entry
+}
+
+func NewInMemoryLog() *InMemoryLog {
+	return &InMemoryLog{byContact: make(map[contact.Id][]Entry)}
+}
+
+func (me *InMemoryLog) Append(ctx context.Context, entries ...Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, e := range entries {
+		e.Contact = e.Contact.Clone()
+		me.byContact[e.ContactId] = append(me.byContact[e.ContactId], e)
+	}
+	return nil
+}
+
+func (me *InMemoryLog) History(ctx context.Context, id contact.Id) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return slices.Clone(me.byContact[id]), nil
+}
+
+type actorKey struct{}
+
+type noteKey struct{}
+
+// WithActor returns a context whose changes are made by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorOf returns the actor of the context, "unknown" if none
+func ActorOf(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+// WithNote returns a context whose changes are made for the reason given, eg. "reverted to version 2"
+func WithNote(ctx context.Context, note string) context.Context {
+	return context.WithValue(ctx, noteKey{}, note)
+}
+
+func noteOf(ctx context.Context) string {
+	note, _ := ctx.Value(noteKey{}).(string)
+	return note
+}
@@ -0,0 +1,152 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+)
+
+var joe = contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("joe@example.com")}}}
+
+func actions(entries []audit.Entry) (result []audit.Action) {
+	for _, e := range entries {
+		result = append(result, e.Action)
+	}
+	return result
+}
+
+func TestRepositoryRecordsChanges(t *testing.T) {
+	ctx := audit.WithActor(context.Background(), "alice")
+	log := audit.NewInMemoryLog()
+	repo := audit.NewRepository(contact.NewInMemoryContactRepository(), log)
+
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	renamed := joe.Clone()
+	renamed.Version = 1
+	renamed.LastName = "Smith"
+	if err := repo.Store(audit.WithNote(ctx, "married"), renamed); err != nil {
+		t.Fatal(err)
+	}
+	stale := joe.Clone()
+	stale.FirstName = "Joseph"
+	if err := repo.Store(ctx, stale); err == nil {
+		t.Fatal("expected a version conflict")
+	}
+	if deleted, err := repo.Delete(ctx, joe.Id); !deleted || err != nil {
+		t.Fatalf("expected deletion, got %v, %v", deleted, err)
+	}
+	if restored, err := repo.Restore(ctx, joe.Id); !restored || err != nil {
+		t.Fatalf("expected restore, got %v, %v", restored, err)
+	}
+	if _, err := repo.Delete(ctx, joe.Id); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := repo.PurgeDeletedBefore(ctx, time.Now().Add(time.Minute)); purged != 1 || err != nil {
+		t.Fatalf("expected 1 purged, got %v, %v", purged, err)
+	}
+
+	history, err := log.History(ctx, joe.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []audit.Action{audit.Created, audit.Updated, audit.Deleted, audit.Restored, audit.Deleted, audit.Purged}
+	if got := actions(history); !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	update := history[1]
+	if update.Actor != "alice" || update.Note != "married" || update.Contact.Version != 2 {
+		t.Errorf("unexpected update entry %#v", update)
+	}
+	if expected := []audit.Change{{Field: "Last Name", Before: "Bloggs", After: "Smith"}}; !slices.Equal(update.Changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, update.Changes)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	after := joe.Clone()
+	after.Emails = append(after.Emails, contact.EmailEntry{Label: contact.Work, Email: contact.MustParseEmail("joe@work.example.com")})
+	expected := []audit.Change{{
+		Field:  "Emails",
+		Before: "joe@example.com (primary)",
+		After:  "joe@example.com (primary); joe@work.example.com (work)",
+	}}
+	if got := audit.Diff(joe, after); !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if got := audit.Diff(joe, joe.Clone()); len(got) != 0 {
+		t.Errorf("expected no changes, got %v", got)
+	}
+}
+
+func TestFileLogReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := audit.OpenFileLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := audit.Entry{ContactId: joe.Id, At: time.Now().UTC(), Actor: "alice", Action: audit.Created, Changes: audit.Diff(contact.Contact{}, joe), Contact: joe}
+	if err := log.Append(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	// a crash mid-write leaves a torn line behind
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"ContactId":`)
+	f.Close()
+
+	log, err = audit.OpenFileLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if err := log.Append(ctx, audit.Entry{ContactId: joe.Id, Action: audit.Deleted, Contact: joe}); err != nil {
+		t.Fatal(err)
+	}
+	history, err := log.History(ctx, joe.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(history); !slices.Equal(got, []audit.Action{audit.Created, audit.Deleted}) {
+		t.Fatalf("unexpected history %v", got)
+	}
+	if got := history[0]; got.Actor != "alice" || !got.Contact.Equal(joe) || !slices.Equal(got.Changes, entry.Changes) {
+		t.Errorf("expected %#v, got %#v", entry, got)
+	}
+}
+
+// failingLog fails to append any entry
+type failingLog struct{ audit.Log }
+
+func (failingLog) Append(context.Context, ...audit.Entry) error {
+	return errors.New("disk full")
+}
+
+func TestRepositoryCountsFailedEntries(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	repo := audit.NewRepository(contact.NewInMemoryContactRepository(), failingLog{audit.NewInMemoryLog()})
+	failed := audit.FailedEntries()
+	if err := repo.Store(context.Background(), joe); err != nil {
+		t.Fatalf("expected the change made whatever the log, got %v", err)
+	}
+	if got := audit.FailedEntries() - failed; got != 1 {
+		t.Errorf("expected 1 failed entry, got %d", got)
+	}
+}
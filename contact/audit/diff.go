@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// Change is the value of a field before and after a change; the phones,
+// e-mails, addresses, and tags are each one field, listing all the entries.
+type Change struct {
+	Field         string
+	Before, After string
+}
+
+// Diff lists the fields that differ between before and after; custom fields
+// are named by their CustomField.Name, eg. "custom company".
+func Diff(before, after contact.Contact) (changes []Change) {
+	fields := []Change{
+		{"First Name", before.FirstName, after.FirstName},
+		{"Last Name", before.LastName, after.LastName},
+		{"Phones", describe(before.Phones), describe(after.Phones)},
+		{"Emails", describe(before.Emails), describe(after.Emails)},
+		{"Addresses", describe(before.Addresses), describe(after.Addresses)},
+		{"Tags", joinTags(before.Tags), joinTags(after.Tags)},
+	}
+	names := slices.AppendSeq(slices.Collect(maps.Keys(before.Custom)), maps.Keys(after.Custom))
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		fields = append(fields, Change{"custom " + name, before.Custom[name], after.Custom[name]})
+	}
+	return slices.DeleteFunc(fields, func(c Change) bool { return c.Before == c.After })
+}
+
+// describe lists the entries, eg. "joe@example.com (home, primary); joe@work.example.com (work)"
+func describe[E contact.PhoneEntry | contact.EmailEntry | contact.Address](entries []E) string {
+	var descriptions []string
+	for _, e := range entries {
+		var value string
+		var label contact.Label
+		var primary bool
+		switch e := any(e).(type) {
+		case contact.PhoneEntry:
+			value, label, primary = e.Phone.String(), e.Label, e.Primary
+		case contact.EmailEntry:
+			value, label, primary = e.Email.String(), e.Label, e.Primary
+		case contact.Address:
+			value, label, primary = e.String(), e.Label, e.Primary
+		}
+		var qualifiers []string
+		if label != "" {
+			qualifiers = append(qualifiers, string(label))
+		}
+		if primary {
+			qualifiers = append(qualifiers, "primary")
+		}
+		if len(qualifiers) > 0 {
+			value = fmt.Sprintf("%s (%s)", value, strings.Join(qualifiers, ", "))
+		}
+		descriptions = append(descriptions, value)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+func joinTags(tags []contact.Tag) string {
+	var names []string
+	for _, t := range tags {
+		names = append(names, string(t))
+	}
+	return strings.Join(names, ", ")
+}
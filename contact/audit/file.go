@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// FileLog is a Log persisted as JSON lines, one Entry each, appended to a
+// file; only where the entries of each contact are is kept in memory, and the
+// history read from the file.
+type FileLog struct {
+	mu   sync.RWMutex
+	file *os.File
+	// size is the length of the complete lines, past which the next is written
+	size      int64
+	byContact map[contact.Id][]span
+	// broken is why the file can't be appended to anymore, if a failed write
+	// could not be undone
+	broken error
+}
+
+// span is where an entry is in the file
+type span struct {
+	offset, length int64
+}
+
+// OpenFileLog indexes the log at path, creating it if missing. A last line
+// without a newline (eg. after a crash mid-write) is dropped.
+func OpenFileLog(path string) (*FileLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	me := &FileLog{file: file, byContact: make(map[contact.Id][]span)}
+	err = me.load()
+	if err == nil {
+		err = file.Truncate(me.size)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("loading audit log %q: %w", path, err)
+	}
+	return me, nil
+}
+
+// load indexes the entries of the file, up to the end of the last complete line
+func (me *FileLog) load() error {
+	lines := bufio.NewReader(io.NewSectionReader(me.file, 0, 1<<62))
+	for {
+		line, err := lines.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Dropping a torn audit log entry of %d bytes", len(line))
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var e struct{ ContactId contact.Id }
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("entry at offset %d: %w", me.size, err)
+		}
+		me.index(e.ContactId, int64(len(line)))
+	}
+}
+
+// index adds an entry of the contact, length long, at the end of the file
+func (me *FileLog) index(id contact.Id, length int64) {
+	me.byContact[id] = append(me.byContact[id], span{me.size, length})
+	me.size += length
+}
+
+// Append writes the entries at the end of the file; if that fails, what was
+// written of them is truncated, so that the next entries don't follow a torn line.
+func (me *FileLog) Append(ctx context.Context, entries ...Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	lengths := make([]int64, len(entries))
+	for i, e := range entries {
+		before := buf.Len()
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		lengths[i] = int64(buf.Len() - before)
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if me.broken != nil {
+		return me.broken
+	}
+	_, err := me.file.WriteAt(buf.Bytes(), me.size)
+	if err == nil {
+		err = me.file.Sync()
+	}
+	if err != nil {
+		if truncErr := me.file.Truncate(me.size); truncErr != nil {
+			me.broken = fmt.Errorf("audit log left with a torn entry: %w", truncErr)
+		}
+		return err
+	}
+	for i, e := range entries {
+		me.index(e.ContactId, lengths[i])
+	}
+	return nil
+}
+
+func (me *FileLog) History(ctx context.Context, id contact.Id) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// entries are never rewritten: they can be read while others are appended
+	me.mu.RLock()
+	spans := slices.Clone(me.byContact[id])
+	me.mu.RUnlock()
+	history := make([]Entry, len(spans))
+	var line []byte
+	for i, s := range spans {
+		line = slices.Grow(line[:0], int(s.length))[:s.length]
+		if _, err := me.file.ReadAt(line, s.offset); err != nil {
+			return nil, fmt.Errorf("reading the audit entry at offset %d: %w", s.offset, err)
+		} else if err := json.Unmarshal(line, &history[i]); err != nil {
+			return nil, fmt.Errorf("audit entry at offset %d: %w", s.offset, err)
+		}
+	}
+	return history, nil
+}
+
+func (me *FileLog) Close() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.file.Close()
+}
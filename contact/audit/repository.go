@@ -0,0 +1,229 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// Repository decorates a contact.Repository, appending to Log an Entry for
+// each contact stored, deleted, restored, purged, retagged, or merged through it.
+//
+// Changes are serialized, so that the state read before each of them is the
+// one it changes; a failure to append to the log is logged and counted (see
+// FailedEntries), but does not fail the change, which already happened.
+type Repository struct {
+	contact.Repository
+	log Log
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func NewRepository(repo contact.Repository, log Log) *Repository {
+	return &Repository{Repository: repo, log: log, now: time.Now}
+}
+
+func (me *Repository) Store(ctx context.Context, cs ...contact.Contact) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var entries []Entry
+	for _, c := range cs {
+		before, found, err := me.Repository.FindById(ctx, c.Id)
+		if err != nil {
+			return err
+		}
+		stored := c.Clone()
+		stored.Version = c.Version + 1
+		if found {
+			entries = append(entries, me.entry(ctx, Updated, Diff(before, stored), stored))
+		} else {
+			entries = append(entries, me.entry(ctx, Created, Diff(contact.Contact{}, stored), stored))
+		}
+	}
+	if err := me.Repository.Store(ctx, cs...); err != nil {
+		return err
+	} else {
+		me.append(ctx, entries...)
+		return nil
+	}
+}
+
+func (me *Repository) Merge(ctx context.Context, merged, other contact.Contact) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	before, found, err := me.Repository.FindById(ctx, merged.Id)
+	if err != nil {
+		return err
+	}
+	otherBefore, _, err := me.Repository.FindById(ctx, other.Id)
+	if err != nil {
+		return err
+	}
+	stored := merged.Clone()
+	stored.Version = merged.Version + 1
+	entry := me.entry(ctx, Created, Diff(contact.Contact{}, stored), stored)
+	if found {
+		entry = me.entry(ctx, Updated, Diff(before, stored), stored)
+	}
+	if err := me.Repository.Merge(ctx, merged, other); err != nil {
+		return err
+	} else {
+		me.append(ctx, me.entry(ctx, Deleted, nil, otherBefore), entry)
+		return nil
+	}
+}
+
+func (me *Repository) Delete(ctx context.Context, id contact.Id) (bool, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	before, found, err := me.Repository.FindById(ctx, id)
+	if err != nil || !found {
+		return false, err
+	}
+	if deleted, err := me.Repository.Delete(ctx, id); err != nil || !deleted {
+		return deleted, err
+	} else {
+		me.append(ctx, me.entry(ctx, Deleted, nil, before))
+		return true, nil
+	}
+}
+
+func (me *Repository) DeleteAll(ctx context.Context, ids ...contact.Id) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var entries []Entry
+	for _, id := range ids {
+		before, found, err := me.Repository.FindById(ctx, id)
+		if err != nil {
+			return err
+		} else if found && !slices.ContainsFunc(entries, func(e Entry) bool { return e.ContactId == id }) {
+			entries = append(entries, me.entry(ctx, Deleted, nil, before))
+		}
+	}
+	if err := me.Repository.DeleteAll(ctx, ids...); err != nil {
+		return err
+	} else {
+		me.append(ctx, entries...)
+		return nil
+	}
+}
+
+func (me *Repository) Restore(ctx context.Context, id contact.Id) (bool, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if restored, err := me.Repository.Restore(ctx, id); err != nil || !restored {
+		return restored, err
+	} else if after, found, err := me.Repository.FindById(ctx, id); err != nil || !found {
+		log.Printf("Error auditing the restore of contact %q: found %v, error %v", id, found, err)
+		return true, nil
+	} else {
+		me.append(ctx, me.entry(ctx, Restored, nil, after))
+		return true, nil
+	}
+}
+
+func (me *Repository) Purge(ctx context.Context, id contact.Id) (bool, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	before, found, err := me.Repository.FindDeletedById(ctx, id)
+	if err != nil || !found {
+		return false, err
+	}
+	if purged, err := me.Repository.Purge(ctx, id); err != nil || !purged {
+		return purged, err
+	} else {
+		me.append(ctx, me.entry(ctx, Purged, nil, before.Contact))
+		return true, nil
+	}
+}
+
+func (me *Repository) PurgeDeletedBefore(ctx context.Context, t time.Time) (int, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var purging []contact.Contact
+	for page, more := (contact.Page{Size: 100}), true; more; {
+		var deleted []contact.DeletedContact
+		var err error
+		if deleted, page, more, err = me.Repository.FindDeleted(ctx, page); err != nil {
+			return 0, err
+		}
+		for _, d := range deleted {
+			if d.DeletedAt.Before(t) {
+				purging = append(purging, d.Contact)
+			}
+		}
+	}
+	purged, err := me.Repository.PurgeDeletedBefore(ctx, t)
+	if err != nil {
+		return purged, err
+	}
+	var entries []Entry
+	for _, c := range purging {
+		entries = append(entries, me.entry(ctx, Purged, nil, c))
+	}
+	me.append(ctx, entries...)
+	return purged, nil
+}
+
+func (me *Repository) RenameTag(ctx context.Context, to contact.Tag, from ...contact.Tag) (int, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var before []contact.Contact
+	for page, more := (contact.Page{Size: 100}), true; more; {
+		var tagged []contact.Contact
+		var err error
+		if tagged, page, more, err = me.Repository.FindByQuery(ctx, contact.TagQuery(from...), page); err != nil {
+			return 0, err
+		}
+		before = append(before, tagged...)
+	}
+	renamed, err := me.Repository.RenameTag(ctx, to, from...)
+	if err != nil {
+		return renamed, err
+	}
+	var entries []Entry
+	for _, c := range before {
+		after, _ := c.RenameTag(to, from...)
+		after.Version = c.Version + 1
+		entries = append(entries, me.entry(ctx, Updated, Diff(c, after), after))
+	}
+	me.append(ctx, entries...)
+	return renamed, nil
+}
+
+func (me *Repository) entry(ctx context.Context, action Action, changes []Change, c contact.Contact) Entry {
+	return Entry{
+		ContactId: c.Id,
+		At:        me.now(),
+		Actor:     ActorOf(ctx),
+		Action:    action,
+		Note:      noteOf(ctx),
+		Changes:   changes,
+		Contact:   c,
+	}
+}
+
+// failedEntries counts the entries no Repository could append to its Log
+var failedEntries atomic.Int64
+
+// FailedEntries is how many entries could not be appended since the process
+// started: changes made, but missing from the history.
+func FailedEntries() int64 {
+	return failedEntries.Load()
+}
+
+func (me *Repository) append(ctx context.Context, entries ...Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	// the change happened: record it even if the request is being cancelled
+	if err := me.log.Append(context.WithoutCancel(ctx), entries...); err != nil {
+		log.Printf("Error appending %d audit entries: %v", len(entries), err)
+		failedEntries.Add(int64(len(entries)))
+	}
+}
@@ -1,9 +1,12 @@
 package contact
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"strings"
+	"time"
 
+	"dev.acorello.it/go/contacts/search"
 	"github.com/google/uuid"
 )
 
@@ -34,40 +37,156 @@ func (me Id) HasSameId(c Contact) bool {
 	return me == c.Id
 }
 
+// Contact has any number of phones, e-mails, and addresses; at most one of
+// each is marked as Primary (see the Phone, Email, and Address methods).
 type Contact struct {
 	Id
-	FirstName, LastName, Phone, Email string
+	// Version counts the times the contact was stored: 0 if it never was.
+	// A change to a contact is stored only if it carries the Version of the
+	// stored contact it was made to (see VersionConflictError).
+	Version             int
+	FirstName, LastName string
+	Phones              []PhoneEntry
+	Emails              []EmailEntry
+	Addresses           []Address
+	// Tags are sorted, without repetitions (see SortedTags)
+	Tags []Tag
+	// Custom has the values of the custom fields of the installation, keyed
+	// by CustomField.Name; blank values are left out (see CustomFields.Parse).
+	Custom map[string]string `json:",omitempty"`
 }
 
-func (my Contact) AnyFieldContains(s string) bool {
-	p := strings.Contains
-	return p(my.FirstName, s) || p(my.LastName, s) || p(my.Phone, s) || p(my.Email, s)
+// SearchFields are the fields indexed for full-text search: names, e-mails,
+// phones (E.164 and national format), and addresses; names weigh more than the rest.
+func (my Contact) SearchFields() (fields []search.Field) {
+	for _, f := range queryFields {
+		fields = append(fields, my.fields(f)...)
+	}
+	return fields
+}
+
+// EmailOwnerError is returned by Repository.Store when the e-mail of the
+// contact is already assigned to a different contact.
+type EmailOwnerError struct {
+	Email Email
+	Owner Id
+}
+
+func (me EmailOwnerError) Error() string {
+	return fmt.Sprintf("e-mail already assigned to contact with id %q", me.Owner)
+}
+
+// DuplicateEmailError is returned by Repository.Store when a contact has the
+// same e-mail twice (see Email.Canonical): at Position in its Emails, and before.
+type DuplicateEmailError struct {
+	Id       Id
+	Email    Email
+	Position int
 }
 
-type Page struct {
-	Offset, Size int
+func (me DuplicateEmailError) Error() string {
+	return fmt.Sprintf("contact with id %q has the e-mail %s twice", me.Id, me.Email)
 }
 
-func (me Page) Next() Page {
-	return Page{
-		Offset: me.Offset + 1,
-		Size:   me.Size,
+// CheckEmails returns a DuplicateEmailError if the contact has the same e-mail twice
+func (me Contact) CheckEmails() error {
+	seen := make(map[string]bool)
+	for i, e := range me.Emails {
+		if seen[e.Email.Canonical()] {
+			return DuplicateEmailError{Id: me.Id, Email: e.Email, Position: i}
+		}
+		seen[e.Email.Canonical()] = true
 	}
+	return nil
+}
+
+// VersionConflictError is returned by Repository.Store when a contact is
+// stale: it was changed, or deleted, since the Version it carries.
+type VersionConflictError struct {
+	Id Id
+	// Version is the one carried by the contact, Current that of the stored
+	// contact: 0 if there is none.
+	Version, Current int
+}
+
+func (me VersionConflictError) Error() string {
+	return fmt.Sprintf("contact with id %q is at version %d, not %d", me.Id, me.Current, me.Version)
+}
+
+// NotFoundError is returned by Repository.DeleteAll when one of the contacts does not exist.
+type NotFoundError struct {
+	Id Id
+}
+
+func (me NotFoundError) Error() string {
+	return fmt.Sprintf("contact with id %q not found", me.Id)
 }
 
-func (me Page) StartOffset() int {
-	return me.Offset * me.Size
+// DeletedContact is a contact in the trash, since DeletedAt (see Repository.Delete)
+type DeletedContact struct {
+	Contact
+	DeletedAt time.Time
 }
 
-func (me Page) EndOffset() int {
-	return me.StartOffset() + me.Size
+// UnmarshalJSON decodes DeletedAt too, which the promoted Contact.UnmarshalJSON would skip
+func (me *DeletedContact) UnmarshalJSON(data []byte) error {
+	var decoded struct{ DeletedAt time.Time }
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	} else if err := me.Contact.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	me.DeletedAt = decoded.DeletedAt
+	return nil
 }
 
+// Repository stands for a component performing I/O: every method accepts a
+// context and reports failures as an error. When the context is done before
+// the operation completes the error is ctx.Err().
 type Repository interface {
-	FindById(id Id) (c Contact, found bool)
-	Delete(id Id)
-	FindAll(page Page) (result []Contact, more bool)
-	Store(c Contact) error
-	FindBySearchTerm(term string, page Page) (result []Contact, more bool)
-	FindIdByEmail(email string) (res Id, found bool)
+	FindById(ctx context.Context, id Id) (c Contact, found bool, err error)
+	// Delete moves the contact to the trash, where none of the other methods but
+	// the ones of deleted contacts find it, and its e-mails are free; it reports
+	// whether a contact with the given id existed.
+	Delete(ctx context.Context, id Id) (deleted bool, err error)
+	// DeleteAll deletes all the contacts, as Delete does, or none: it returns a
+	// NotFoundError if any of them does not exist.
+	DeleteAll(ctx context.Context, ids ...Id) error
+	// FindDeleted lists the contacts in the trash, most recently deleted first (see DeletedPageOf).
+	FindDeleted(ctx context.Context, page Page) (result []DeletedContact, next Page, more bool, err error)
+	FindDeletedById(ctx context.Context, id Id) (d DeletedContact, found bool, err error)
+	// Restore moves the contact back from the trash, with the next Version, and
+	// reports whether it was there; it returns an EmailOwnerError if one of its
+	// e-mails was taken meanwhile.
+	Restore(ctx context.Context, id Id) (restored bool, err error)
+	// Purge removes the contact from the trash for good, reporting whether it was there.
+	Purge(ctx context.Context, id Id) (purged bool, err error)
+	// PurgeDeletedBefore purges the contacts deleted before t, returning how many.
+	PurgeDeletedBefore(ctx context.Context, t time.Time) (purged int, err error)
+	// FindAll returns a page of contacts, in its Order (see PageOf); next is the
+	// page after it, and more tells if there are contacts there.
+	FindAll(ctx context.Context, page Page) (result []Contact, next Page, more bool, err error)
+	// Count counts the contacts, not those in the trash.
+	Count(ctx context.Context) (int, error)
+	// Store stores all of cs, or none: it returns an EmailOwnerError if any of
+	// their e-mails belongs to another contact, in the repository or among cs,
+	// a DuplicateEmailError if one has an e-mail twice, and a
+	// VersionConflictError if any of them is stale. Each contact is
+	// stored with the next Version, and out of the trash if it was there.
+	Store(ctx context.Context, cs ...Contact) error
+	// Merge stores merged, as Store does, and moves other, merged into it, to
+	// the trash, all or nothing: merged may take the e-mails of other. other
+	// must be at the stored Version too, else it returns a VersionConflictError.
+	Merge(ctx context.Context, merged, other Contact) error
+	// FindByQuery returns the contacts matching q, in the page's Order or, by
+	// default, best matches first (see QueryPageOf and Page.RankedBy).
+	FindByQuery(ctx context.Context, q Query, page Page) (result []Contact, next Page, more bool, err error)
+	// FindIdByEmail finds the contact owning email, in its canonical form (see Email.Canonical).
+	FindIdByEmail(ctx context.Context, email Email) (res Id, found bool, err error)
+	// FindTags counts the contacts having each tag, in tag order.
+	FindTags(ctx context.Context) ([]TagCount, error)
+	// RenameTag replaces the tags from with to, on all the contacts having
+	// them, storing each with the next Version; to is merged with them if some
+	// contacts have it already. It returns how many contacts changed.
+	RenameTag(ctx context.Context, to Tag, from ...Tag) (renamed int, err error)
 }
@@ -0,0 +1,98 @@
+// Package csv encodes contacts as CSV (RFC 4180), one per row after a header
+// row; only the primary phone, e-mail, and address of each contact are encoded.
+// It also reads the records of CSV files of any shape, to import them.
+package csv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// MediaType is the media type of CSV
+const MediaType = "text/csv"
+
+// Header names the columns, in order
+var Header = []string{"Id", "First Name", "Last Name", "Phone", "Email", "Street", "City", "Postal Code", "Region", "Country"}
+
+type Writer struct {
+	w *csv.Writer
+}
+
+// NewWriter returns a Writer having written the Header to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	me := &Writer{w: csv.NewWriter(w)}
+	if err := me.w.Write(Header); err != nil {
+		return nil, err
+	}
+	return me, nil
+}
+
+func (me *Writer) Write(c contact.Contact) error {
+	a := c.Address()
+	return me.w.Write([]string{
+		c.Id.String(),
+		c.FirstName,
+		c.LastName,
+		c.Phone().String(),
+		c.Email().String(),
+		a.Street,
+		a.City,
+		a.PostalCode,
+		a.Region,
+		a.Country,
+	})
+}
+
+// Flush writes any buffered rows to the underlying io.Writer
+func (me *Writer) Flush() error {
+	me.w.Flush()
+	return me.w.Error()
+}
+
+// Record is a record of a CSV file
+type Record struct {
+	// Line is the number of the line the record starts at, from 1
+	Line   int
+	Fields []string
+}
+
+// ReadRecords reads all the records of a CSV file, as exported by spreadsheets:
+// the fields may be separated by ',' or, if the first line has more of them,
+// by ';'; a leading byte order mark is skipped. Records shorter than the
+// longest are padded with blank fields.
+func ReadRecords(r io.Reader) (records []Record, err error) {
+	b := bufio.NewReader(r)
+	if bom, err := b.Peek(3); err == nil && bytes.Equal(bom, []byte("\xEF\xBB\xBF")) {
+		b.Discard(3)
+	}
+	cr := csv.NewReader(b)
+	buffered, _ := b.Peek(b.Buffered()) // filled by the Peek above
+	firstLine, _, _ := bytes.Cut(buffered, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	width := 0
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		records = append(records, Record{Line: line, Fields: fields})
+		width = max(width, len(fields))
+	}
+	for i, rec := range records {
+		for len(rec.Fields) < width {
+			rec.Fields = append(rec.Fields, "")
+		}
+		records[i] = rec
+	}
+	return records, nil
+}
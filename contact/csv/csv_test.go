@@ -0,0 +1,47 @@
+package csv
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestWriteThenReadRecords(t *testing.T) {
+	var b strings.Builder
+	w, err := NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs, Jr.",
+		Emails: []contact.EmailEntry{{Email: contact.MustParseEmail("joe@example.com")}}}
+	if err := w.Write(joe); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadRecords(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !slices.Equal(records[0].Fields, Header) || records[1].Fields[2] != "Bloggs, Jr." || records[1].Line != 2 {
+		t.Errorf("unexpected records %#v", records)
+	}
+}
+
+func TestReadRecordsOfSpreadsheets(t *testing.T) {
+	records, err := ReadRecords(strings.NewReader("\xEF\xBB\xBFFirst;Last;Email\r\nJoe;\"Multi\nLine\"\r\nJane;Doe;jane@example.com\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Record{
+		{Line: 1, Fields: []string{"First", "Last", "Email"}},
+		{Line: 2, Fields: []string{"Joe", "Multi\nLine", ""}},
+		{Line: 4, Fields: []string{"Jane", "Doe", "jane@example.com"}},
+	}
+	if !slices.EqualFunc(records, expected, func(a, b Record) bool { return a.Line == b.Line && slices.Equal(a.Fields, b.Fields) }) {
+		t.Errorf("expected %#v, got %#v", expected, records)
+	}
+}
@@ -0,0 +1,255 @@
+package contact
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"dev.acorello.it/go/contacts/seq"
+)
+
+// CustomFieldType is the type of the values of a CustomField
+type CustomFieldType string
+
+const (
+	TextType   CustomFieldType = "text"
+	DateType   CustomFieldType = "date"
+	NumberType CustomFieldType = "number"
+	URLType    CustomFieldType = "url"
+	EnumType   CustomFieldType = "enum"
+)
+
+var CustomFieldTypes = []CustomFieldType{TextType, DateType, NumberType, URLType, EnumType}
+
+// DateLayout is the format of the values of date fields
+const DateLayout = "2006-01-02"
+
+// maxTextLength limits the text values of fields without a MaxLength
+const maxTextLength = 2000
+
+var customFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// CustomField is a field an installation adds to its contacts (see
+// ParseCustomFields), eg. a company or a birthday; the contacts have its values
+// in Custom, keyed by Name.
+type CustomField struct {
+	// Name keys the values: lower case letters, digits, and underscores
+	Name  string          `json:"name"`
+	Label string          `json:"label"`
+	Type  CustomFieldType `json:"type"`
+	// Required fields must have a value
+	Required bool `json:"required,omitempty"`
+	// MaxLength limits text values, in characters
+	MaxLength int `json:"maxLength,omitempty"`
+	// Pattern is a regular expression text values must match, whole
+	Pattern string `json:"pattern,omitempty"`
+	// Multiline text values are edited in a text area, eg. notes
+	Multiline bool `json:"multiline,omitempty"`
+	// Min and Max limit number and date values, in their format
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
+	// Options are the values of an enum field
+	Options []string `json:"options,omitempty"`
+	// List fields are shown as columns of the contact list
+	List bool `json:"list,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// CustomFields are the custom fields of an installation, in the order they are shown
+type CustomFields []CustomField
+
+// ParseCustomFields decodes a JSON array of custom fields, eg.
+//
+//	[{"name": "company", "label": "Company", "type": "text", "list": true},
+//	 {"name": "stage", "label": "Stage", "type": "enum", "options": ["lead", "customer"]}]
+//
+// checking that their names are valid and distinct, and their rules make sense.
+func ParseCustomFields(r io.Reader) (fields CustomFields, err error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("decoding custom fields: %w", err)
+	}
+	for i := range fields {
+		if err := fields[i].compile(); err != nil {
+			return nil, fmt.Errorf("custom field %q: %w", fields[i].Name, err)
+		}
+	}
+	if seq.HasDuplicates(seq.Map(func(f CustomField) string { return f.Name }, fields...)...) {
+		return nil, errors.New("custom field names must be unique")
+	}
+	return fields, nil
+}
+
+// compile checks the definition, compiling its Pattern
+func (me *CustomField) compile() (err error) {
+	switch {
+	case !customFieldName.MatchString(me.Name):
+		return errors.New("the name must be lower case letters, digits, and underscores")
+	case strings.TrimSpace(me.Label) == "":
+		return errors.New("the label is blank")
+	case !slices.Contains(CustomFieldTypes, me.Type):
+		return fmt.Errorf("unknown type %q, expected one of %q", me.Type, CustomFieldTypes)
+	case me.Type == EnumType && len(me.Options) == 0:
+		return errors.New("an enum needs options")
+	case me.Type != EnumType && len(me.Options) > 0:
+		return errors.New("only an enum has options")
+	case me.Type != TextType && (me.MaxLength != 0 || me.Pattern != "" || me.Multiline):
+		return errors.New("only a text has maxLength, pattern, and multiline")
+	case me.Type != NumberType && me.Type != DateType && (me.Min != "" || me.Max != ""):
+		return errors.New("only a number or a date has min and max")
+	case me.MaxLength < 0:
+		return errors.New("maxLength is negative")
+	}
+	if me.Pattern != "" {
+		if me.pattern, err = regexp.Compile(`^(?:` + me.Pattern + `)$`); err != nil {
+			return err
+		}
+	}
+	for _, limit := range []string{me.Min, me.Max} {
+		if limit == "" {
+			continue
+		} else if _, err := me.parseTyped(limit); err != nil {
+			return fmt.Errorf("invalid limit %q: %w", limit, err)
+		}
+	}
+	return nil
+}
+
+// Parse validates s as a value of the field, returning it in normal form: text
+// trimmed, dates as DateLayout, numbers in decimal notation, URLs as parsed;
+// the blank value is "", valid unless the field is Required.
+func (me CustomField) Parse(s string) (value string, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" && me.Required {
+		return "", errors.New("required")
+	} else if s == "" {
+		return "", nil
+	}
+	if value, err = me.parseTyped(s); err != nil {
+		return "", err
+	}
+	switch me.Type {
+	case TextType:
+		if n := utf8.RuneCountInString(value); n > cmp.Or(me.MaxLength, maxTextLength) {
+			return "", fmt.Errorf("longer than %d characters", cmp.Or(me.MaxLength, maxTextLength))
+		} else if me.Pattern != "" && !me.compiledPattern().MatchString(value) {
+			return "", fmt.Errorf("does not match the pattern %s", me.Pattern)
+		}
+	case NumberType, DateType:
+		if me.Min != "" && me.less(value, me.Min) {
+			return "", fmt.Errorf("less than %s", me.Min)
+		} else if me.Max != "" && me.less(me.Max, value) {
+			return "", fmt.Errorf("more than %s", me.Max)
+		}
+	}
+	return value, nil
+}
+
+// parseTyped parses s as a value of the field's type, without other rules
+func (me CustomField) parseTyped(s string) (string, error) {
+	switch me.Type {
+	case DateType:
+		if t, err := time.Parse(DateLayout, s); err != nil {
+			return "", fmt.Errorf("not a date like %s", DateLayout)
+		} else {
+			return t.Format(DateLayout), nil
+		}
+	case NumberType:
+		if f, err := strconv.ParseFloat(s, 64); err != nil {
+			return "", errors.New("not a number")
+		} else {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+	case URLType:
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", errors.New("not an http or https URL")
+		} else {
+			return u.String(), nil
+		}
+	case EnumType:
+		if i := slices.IndexFunc(me.Options, func(o string) bool { return strings.EqualFold(o, s) }); i < 0 {
+			return "", fmt.Errorf("not one of %q", me.Options)
+		} else {
+			return me.Options[i], nil
+		}
+	default:
+		return s, nil
+	}
+}
+
+// less compares values of a number or date field, in normal form
+func (me CustomField) less(a, b string) bool {
+	if me.Type == NumberType {
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		return x < y
+	}
+	return a < b // DateLayout sorts as text
+}
+
+func (me CustomField) compiledPattern() *regexp.Regexp {
+	if me.pattern == nil { // not made by ParseCustomFields
+		return regexp.MustCompile(`^(?:` + me.Pattern + `)$`)
+	}
+	return me.pattern
+}
+
+// Parse validates the values, keyed by field Name, of all the fields,
+// returning those not blank in normal form (see CustomField.Parse), or the
+// errors keyed by field Name.
+func (me CustomFields) Parse(values map[string]string) (custom map[string]string, errs map[string]error) {
+	for _, f := range me {
+		if v, err := f.Parse(values[f.Name]); err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[f.Name] = err
+		} else if v != "" {
+			if custom == nil {
+				custom = make(map[string]string)
+			}
+			custom[f.Name] = v
+		}
+	}
+	return custom, errs
+}
+
+// Has tells if one of the fields has the name
+func (me CustomFields) Has(name string) bool {
+	return slices.ContainsFunc(me, func(f CustomField) bool { return f.Name == name })
+}
+
+// Unknown returns the values, among custom, of fields not among these, eg.
+// removed since the values were stored.
+func (me CustomFields) Unknown(custom map[string]string) (unknown map[string]string) {
+	for name, value := range custom {
+		if !me.Has(name) {
+			if unknown == nil {
+				unknown = make(map[string]string)
+			}
+			unknown[name] = value
+		}
+	}
+	return unknown
+}
+
+// Listed are the fields shown as columns of the contact list
+func (me CustomFields) Listed() (listed CustomFields) {
+	for _, f := range me {
+		if f.List {
+			listed = append(listed, f)
+		}
+	}
+	return listed
+}
@@ -0,0 +1,68 @@
+package contact
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCustomFields(t *testing.T) {
+	for _, c := range []struct {
+		json, expectedErr string
+	}{
+		{`[{"name": "company", "label": "Company", "type": "text"}, {"name": "company", "label": "Firm", "type": "text"}]`, "unique"},
+		{`[{"name": "Company", "label": "Company", "type": "text"}]`, "lower case"},
+		{`[{"name": "age", "label": "Age", "type": "integer"}]`, "unknown type"},
+		{`[{"name": "stage", "label": "Stage", "type": "enum"}]`, "needs options"},
+		{`[{"name": "age", "label": "Age", "type": "number", "min": "zero"}]`, "invalid limit"},
+		{`[{"name": "code", "label": "Code", "type": "text", "pattern": "[a-z"}]`, "missing closing ]"},
+		{`[{"name": "code", "label": "Code", "type": "text", "size": 3}]`, "unknown field"},
+	} {
+		if _, err := ParseCustomFields(strings.NewReader(c.json)); err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+			t.Errorf("%s: expected an error about %q, got %v", c.json, c.expectedErr, err)
+		}
+	}
+}
+
+func TestCustomFieldParse(t *testing.T) {
+	fields, err := ParseCustomFields(strings.NewReader(`[
+		{"name": "code", "label": "Code", "type": "text", "maxLength": 4, "pattern": "[A-Z]+"},
+		{"name": "age", "label": "Age", "type": "number", "min": "0", "max": "150", "required": true},
+		{"name": "since", "label": "Since", "type": "date", "min": "2000-01-01"},
+		{"name": "site", "label": "Site", "type": "url"},
+		{"name": "stage", "label": "Stage", "type": "enum", "options": ["lead", "customer"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, age, since, site, stage := fields[0], fields[1], fields[2], fields[3], fields[4]
+	for _, c := range []struct {
+		field             CustomField
+		value, expected   string
+		expectedErrPrefix string
+	}{
+		{code, " ABC ", "ABC", ""},
+		{code, "", "", ""},
+		{code, "ABCDE", "", "longer than 4"},
+		{code, "abc", "", "does not match"},
+		{age, "42.50", "42.5", ""},
+		{age, "1e2", "100", ""},
+		{age, "", "", "required"},
+		{age, "-1", "", "less than 0"},
+		{age, "151", "", "more than 150"},
+		{age, "old", "", "not a number"},
+		{since, "2024-02-29", "2024-02-29", ""},
+		{since, "1999-12-31", "", "less than 2000-01-01"},
+		{since, "29/02/2024", "", "not a date"},
+		{site, "https://example.com/a b", "https://example.com/a%20b", ""},
+		{site, "example.com", "", "not an http"},
+		{stage, "Customer", "customer", ""},
+		{stage, "partner", "", "not one of"},
+	} {
+		got, err := c.field.Parse(c.value)
+		if c.expectedErrPrefix != "" && (err == nil || !strings.HasPrefix(err.Error(), c.expectedErrPrefix)) {
+			t.Errorf("%s %q: expected an error starting with %q, got %q, %v", c.field.Name, c.value, c.expectedErrPrefix, got, err)
+		} else if c.expectedErrPrefix == "" && (err != nil || got != c.expected) {
+			t.Errorf("%s %q: expected %q, got %q, %v", c.field.Name, c.value, c.expected, got, err)
+		}
+	}
+}
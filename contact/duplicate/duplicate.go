@@ -0,0 +1,146 @@
+// Package duplicate finds contacts suspected to be the same person, and merges them.
+//
+// Two contacts are compared by how similar their names are, and by the phones
+// and e-mails they share; to keep the comparisons few, only contacts sharing a
+// phone, an e-mail, or the start of their names are compared, each with the
+// few sorting next to it by name among those sharing it.
+package duplicate
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// Pair is two contacts suspected to be the same person
+type Pair struct {
+	A, B contact.Contact
+	// Score tells how likely they are the same, from 0 to 1
+	Score float64
+	// Reasons explain the suspicion, eg. "same phone +447911123456"
+	Reasons []string
+}
+
+const (
+	// similarNames is the name similarity enough to suspect a duplicate, eg.
+	// of "Jon Smith" and "John Smith"
+	similarNames = 0.96
+	// similarNamesSharing is the name similarity enough when a phone or an e-mail is shared
+	similarNamesSharing = 0.75
+)
+
+// Compare compares the two contacts, reporting whether they are suspected to be
+// the same person: if their names are very similar, or similar and they share
+// a phone or an e-mail (in normal form: E.164, or the canonical address
+// without dots and "+" suffix in the local part).
+func Compare(a, b contact.Contact) (p Pair, suspected bool) {
+	p = Pair{A: a, B: b}
+	names := nameSimilarity(a, b)
+	if names == 1 {
+		p.Reasons = append(p.Reasons, "same name")
+	} else if names >= similarNamesSharing {
+		p.Reasons = append(p.Reasons, "similar names")
+	}
+	p.Score = 0.6 * names
+	shares := false
+	if phone, found := shared(a.Phones, b.Phones, func(e contact.PhoneEntry) string { return e.Phone.E164() }); found {
+		p.Reasons = append(p.Reasons, "same phone "+phone)
+		p.Score += 0.25
+		shares = true
+	}
+	if email, found := shared(a.Emails, b.Emails, func(e contact.EmailEntry) string { return normalEmail(e.Email) }); found {
+		p.Reasons = append(p.Reasons, "same e-mail "+email)
+		p.Score += 0.15
+		shares = true
+	}
+	return p, names >= similarNames || (shares && names >= similarNamesSharing)
+}
+
+// shared returns the first non-blank key of an entry of xs that an entry of ys has too
+func shared[E any](xs, ys []E, key func(E) string) (string, bool) {
+	for _, x := range xs {
+		if k := key(x); k != "" && slices.ContainsFunc(ys, func(y E) bool { return key(y) == k }) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// blockingKeys are the keys a contact is compared by with the others having
+// any of them: its phones, its e-mails, and the first letters of its last name
+// with the initial of its first name, as contacts suspected by their names
+// alone have very similar ones (see similarNames).
+func blockingKeys(c contact.Contact) (keys []string) {
+	for _, p := range c.Phones {
+		if p.Phone.E164() != "" {
+			keys = append(keys, "phone:"+p.Phone.E164())
+		}
+	}
+	for _, e := range c.Emails {
+		keys = append(keys, "email:"+normalEmail(e.Email))
+	}
+	if last := prefix(c.LastName, 3); last != "" {
+		keys = append(keys, "name:"+last+" "+prefix(c.FirstName, 1))
+	} else {
+		keys = append(keys, "first:"+prefix(c.FirstName, 3))
+	}
+	return keys
+}
+
+const (
+	// blockWindow is how many of the contacts sharing a blocking key, sorted
+	// by name, each one is compared with: those following it.
+	blockWindow = 20
+	// maxPairs is how many pairs Find returns at most
+	maxPairs = 500
+)
+
+// Find returns the suspected duplicates among the contacts of repo, at most
+// maxPairs of them, most likely first; a contact may be in more than one pair.
+func Find(ctx context.Context, repo contact.Repository) (pairs []Pair, err error) {
+	var all []contact.Contact
+	for page, more := (contact.Page{Size: 1000}), true; more; {
+		var cs []contact.Contact
+		if cs, page, more, err = repo.FindAll(ctx, page); err != nil {
+			return nil, err
+		}
+		all = append(all, cs...)
+	}
+	blocks := make(map[string][]int) // positions in all
+	names := make([]string, len(all))
+	for i, c := range all {
+		names[i] = c.SortKey(contact.ByLastName)
+		for _, k := range blockingKeys(c) {
+			blocks[k] = append(blocks[k], i)
+		}
+	}
+	compared := make(map[[2]int]bool)
+	for _, block := range blocks {
+		slices.SortFunc(block, func(i, j int) int { return cmp.Or(strings.Compare(names[i], names[j]), cmp.Compare(i, j)) })
+		for x, i := range block {
+			for _, j := range block[x+1 : min(x+1+blockWindow, len(block))] {
+				i, j := min(i, j), max(i, j)
+				if compared[[2]int{i, j}] {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+				if p, suspected := Compare(all[i], all[j]); suspected {
+					pairs = append(pairs, p)
+				}
+			}
+		}
+	}
+	slices.SortFunc(pairs, func(a, b Pair) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.A.Id, b.A.Id), cmp.Compare(a.B.Id, b.B.Id))
+	})
+	return pairs[:min(len(pairs), maxPairs)], nil
+}
+
+// Merge stores merged, the contact kept, and deletes other, the one merged
+// into it, all or nothing (see contact.Repository.Merge).
+func Merge(ctx context.Context, repo contact.Repository, merged, other contact.Contact) error {
+	return repo.Merge(ctx, merged, other)
+}
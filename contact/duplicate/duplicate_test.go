@@ -0,0 +1,188 @@
+package duplicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	for _, c := range []struct {
+		x, y     string
+		expected float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		{"smith", "smith", 1},
+		{"abc", "xyz", 0},
+		{"", "smith", 0},
+	} {
+		if got := jaroWinkler(c.x, c.y); math.Abs(got-c.expected) > 0.001 {
+			t.Errorf("jaroWinkler(%q, %q): expected %.3f, got %.3f", c.x, c.y, c.expected, got)
+		}
+	}
+}
+
+func phones(ps ...string) (entries []contact.PhoneEntry) {
+	for _, p := range ps {
+		entries = append(entries, contact.PhoneEntry{Phone: contact.MustParsePhone(p)})
+	}
+	return entries
+}
+
+func emails(es ...string) (entries []contact.EmailEntry) {
+	for _, e := range es {
+		entries = append(entries, contact.EmailEntry{Email: contact.MustParseEmail(e)})
+	}
+	return entries
+}
+
+func TestCompare(t *testing.T) {
+	jon := contact.Contact{Id: contact.NewId(), FirstName: "Jon", LastName: "Smith", Phones: phones("07911 123456")}
+	for _, c := range []struct {
+		other     contact.Contact
+		suspected bool
+		reasons   []string
+	}{
+		{contact.Contact{FirstName: "Jonathan", LastName: "Smith", Phones: phones("+44 7911 123456")}, true, []string{"similar names", "same phone +447911123456"}},
+		{contact.Contact{FirstName: "jon", LastName: "SMITH"}, true, []string{"same name"}},
+		{contact.Contact{FirstName: "Jonathan", LastName: "Smith"}, false, []string{"similar names"}},
+		{contact.Contact{FirstName: "Mary", LastName: "Smith", Phones: phones("07911 123456")}, false, []string{"same phone +447911123456"}},
+		{contact.Contact{FirstName: "John", LastName: "Smith"}, true, []string{"similar names"}},
+		{contact.Contact{FirstName: "Jon", LastName: "Smyth", Emails: emails("j.o.n+work@example.com")}, false, []string{"similar names"}},
+	} {
+		p, suspected := Compare(jon, c.other)
+		if suspected != c.suspected || !slices.Equal(p.Reasons, c.reasons) {
+			t.Errorf("%s %s: expected suspected %t because of %q, got %t because of %q", c.other.FirstName, c.other.LastName, c.suspected, c.reasons, suspected, p.Reasons)
+		}
+	}
+	jon.Emails = emails("JON@example.com")
+	smyth := contact.Contact{FirstName: "Jon", LastName: "Smyth", Emails: emails("j.o.n+work@example.com")}
+	if p, suspected := Compare(jon, smyth); !suspected || !slices.Contains(p.Reasons, "same e-mail jon@example.com") {
+		t.Errorf("expected the e-mails to be compared in normal form, got %t because of %q", suspected, p.Reasons)
+	}
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	jon := contact.Contact{Id: contact.NewId(), FirstName: "Jon", LastName: "Smith", Phones: phones("07911 123456")}
+	jonathan := contact.Contact{Id: contact.NewId(), FirstName: "Jonathan", LastName: "Smith", Phones: phones("07911 123456")}
+	mary := contact.Contact{Id: contact.NewId(), FirstName: "Mary", LastName: "Smith", Phones: phones("07911 123456")}
+	bob := contact.Contact{Id: contact.NewId(), FirstName: "Bob", LastName: "Jones"}
+	if err := repo.Store(ctx, jon, jonathan, mary, bob); err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := Find(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 || !slices.Equal([]contact.Id{pairs[0].A.Id, pairs[0].B.Id}, []contact.Id{jon.Id, jonathan.Id}) {
+		t.Errorf("expected Jon and Jonathan Smith to be the only suspected duplicates, got %#v", pairs)
+	}
+}
+
+// TestFindIsCapped finds more namesakes than maxPairs, all sharing a phone
+func TestFindIsCapped(t *testing.T) {
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	var cs []contact.Contact
+	for i := range maxPairs + 10 {
+		for range 2 {
+			cs = append(cs, contact.Contact{Id: contact.NewId(), FirstName: fmt.Sprint("First", i), LastName: fmt.Sprint("Last", i), Phones: phones("07911 123456")})
+		}
+	}
+	if err := repo.Store(ctx, cs...); err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := Find(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != maxPairs || pairs[0].Score != 0.85 || pairs[0].A.LastName != pairs[0].B.LastName {
+		t.Errorf("expected %d namesakes, got %d, first %#v", maxPairs, len(pairs), pairs[0])
+	}
+}
+
+// BenchmarkFind finds the duplicates among contacts with a few common first
+// names, and random last names, thousands with the same initial.
+func BenchmarkFind(b *testing.B) {
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	firstNames := []string{"John", "Mary", "Ann", "Bob", "Ed", "Liz", "Tom", "Sue"}
+	var cs []contact.Contact
+	for i := range 50_000 {
+		id := contact.NewId()
+		cs = append(cs, contact.Contact{Id: id, FirstName: firstNames[i%len(firstNames)], LastName: string(id[:8]),
+			Emails: emails(fmt.Sprintf("contact%d@example.com", i))})
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	if err := repo.Store(ctx, cs...); err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		if _, err := Find(ctx, repo); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	jon := contact.Contact{Id: contact.NewId(), FirstName: "Jon", LastName: "Smith", Emails: emails("jon@example.com")}
+	jonathan := contact.Contact{Id: contact.NewId(), FirstName: "Jonathan", LastName: "Smith", Emails: emails("jonathan@example.com")}
+	if err := repo.Store(ctx, jon, jonathan); err != nil {
+		t.Fatal(err)
+	}
+	jon.Version, jonathan.Version = 1, 1
+
+	stale := jonathan
+	stale.Version = 0
+	if err := Merge(ctx, repo, jon, stale); !errors.As(err, new(contact.VersionConflictError)) {
+		t.Errorf("expected a VersionConflictError for a stale contact, got %v", err)
+	}
+
+	merged := jon
+	merged.FirstName = "Jonathan"
+	merged.Emails = append(emails("jonathan@example.com"), merged.Emails...)
+	if err := Merge(ctx, repo, merged, jonathan); err != nil {
+		t.Fatal(err)
+	}
+	if c, _, _ := repo.FindById(ctx, jon.Id); c.FirstName != "Jonathan" || len(c.Emails) != 2 {
+		t.Errorf("expected the merged contact, with the e-mails of both, got %#v", c)
+	}
+	if _, found, _ := repo.FindDeletedById(ctx, jonathan.Id); !found {
+		t.Errorf("expected the other contact in the trash")
+	}
+}
+
+func TestMergeIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	jon := contact.Contact{Id: contact.NewId(), FirstName: "Jon", LastName: "Smith"}
+	jonathan := contact.Contact{Id: contact.NewId(), FirstName: "Jonathan", LastName: "Smith"}
+	if err := repo.Store(ctx, jon, jonathan); err != nil {
+		t.Fatal(err)
+	}
+	jonathan.Version = 1
+	if err := Merge(ctx, repo, jon, jonathan); !errors.As(err, new(contact.VersionConflictError)) {
+		t.Errorf("expected the merged contact, at Version 0, to conflict, got %v", err)
+	}
+	if c, found, _ := repo.FindById(ctx, jonathan.Id); !found || c.Version != 1 {
+		t.Errorf("expected the other contact untouched, got %#v", c)
+	}
+	if _, found, _ := repo.FindDeletedById(ctx, jonathan.Id); found {
+		t.Errorf("expected the other contact not in the trash")
+	}
+}
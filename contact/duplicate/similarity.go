@@ -0,0 +1,104 @@
+package duplicate
+
+import (
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// nameSimilarity compares the first names, and the last names, of the
+// contacts: 1 if both are the same, case aside, 0 if they have nothing in
+// common. Blank names are left out, unless both contacts have them blank.
+func nameSimilarity(a, b contact.Contact) float64 {
+	var sum float64
+	var n int
+	for _, names := range [][2]string{{a.FirstName, b.FirstName}, {a.LastName, b.LastName}} {
+		x, y := fold(names[0]), fold(names[1])
+		if x == "" && y == "" {
+			continue
+		}
+		sum += wordSimilarity(x, y)
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// wordSimilarity is the Jaro-Winkler similarity of x and y, but at least
+// prefixSimilarity if one starts with the other, as "jon" and "jonathan" do.
+func wordSimilarity(x, y string) float64 {
+	similarity := jaroWinkler(x, y)
+	if x != "" && y != "" && (strings.HasPrefix(x, y) || strings.HasPrefix(y, x)) {
+		similarity = max(similarity, prefixSimilarity)
+	}
+	return similarity
+}
+
+const prefixSimilarity = 0.9
+
+func fold(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// jaroWinkler returns the Jaro similarity of x and y, raised for a common
+// prefix of up to 4 runes: 1 if they are equal, 0 if they have nothing in common.
+func jaroWinkler(x, y string) float64 {
+	a, b := []rune(x), []rune(y)
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	window := max(max(len(a), len(b))/2-1, 0)
+	matchedA, matchedB := make([]bool, len(a)), make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		for j := max(i-window, 0); j < min(i+window+1, len(b)); j++ {
+			if !matchedB[j] && a[i] == b[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+	prefix := 0
+	for prefix < min(4, len(a), len(b)) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// normalEmail is the address in canonical form without what mail servers
+// commonly ignore in the local part: dots, and a "+" suffix.
+func normalEmail(e contact.Email) string {
+	local, domain, found := strings.Cut(e.Canonical(), "@")
+	if !found {
+		return e.Canonical()
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return strings.ReplaceAll(local, ".", "") + "@" + domain
+}
+
+// prefix is the first n runes of the name, folded
+func prefix(name string, n int) string {
+	runes := []rune(fold(name))
+	return string(runes[:min(n, len(runes))])
+}
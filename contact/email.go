@@ -0,0 +1,98 @@
+package contact
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Email is an e-mail address with the domain in lower case. The zero value is
+// the blank address.
+type Email struct {
+	address string
+}
+
+// ParseEmail accepts a bare address (eg. "Joe@Example.com", not "Joe <joe@example.com>")
+// as defined by RFC 5322 (see net/mail) and lowers the case of its domain.
+// A blank s is the blank Email.
+func ParseEmail(s string) (Email, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Email{}, nil
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return Email{}, fmt.Errorf("invalid e-mail address")
+	}
+	if addr.Name != "" || strings.ContainsAny(s, "<>") {
+		return Email{}, fmt.Errorf("expected only the e-mail address, without a name")
+	}
+	at := strings.LastIndexByte(addr.Address, '@')
+	return Email{address: addr.Address[:at] + strings.ToLower(addr.Address[at:])}, nil
+}
+
+func MustParseEmail(s string) Email {
+	e, err := ParseEmail(s)
+	if err != nil {
+		panic(fmt.Sprintf("MustParseEmail failed for %q: %v", s, err))
+	}
+	return e
+}
+
+// lenientEmail parses s like ParseEmail but keeps as it is an address it
+// can't parse; for addresses stored before they were validated.
+func lenientEmail(s string) Email {
+	if e, err := ParseEmail(s); err == nil {
+		return e
+	}
+	return Email{address: strings.TrimSpace(s)}
+}
+
+// String returns the address with the local part as the user typed it
+func (me Email) String() string {
+	return me.address
+}
+
+// Canonical returns the address in lower case. RFC 5321 lets a server tell
+// local parts apart by case, though few do: this app chooses to treat
+// addresses with the same canonical form as the same mailbox, and
+// repositories use it to tell who owns an address.
+func (me Email) Canonical() string {
+	return strings.ToLower(me.address)
+}
+
+func (me Email) IsZero() bool {
+	return me.address == ""
+}
+
+func (me Email) MarshalJSON() ([]byte, error) {
+	return json.Marshal(me.address)
+}
+
+func (me *Email) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*me = lenientEmail(s)
+	return nil
+}
+
+// Value stores the address as String returns it, see Scan.
+func (me Email) Value() (driver.Value, error) {
+	return me.address, nil
+}
+
+func (me *Email) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		*me = lenientEmail(src)
+	case []byte:
+		*me = lenientEmail(string(src))
+	default:
+		return fmt.Errorf("can't scan %T into an Email", src)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package contact
+
+import "testing"
+
+func TestParseEmail(t *testing.T) {
+	for input, expected := range map[string]string{
+		" Joe.Bloggs@Example.COM ": "Joe.Bloggs@example.com",
+		"joe+tag@example.com":      "joe+tag@example.com",
+		"":                         "",
+	} {
+		if got, err := ParseEmail(input); err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+		} else if got.String() != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, got)
+		}
+	}
+	for _, input := range []string{"joe", "joe@", "@example.com", "joe@@example.com", "Joe <joe@example.com>", "<joe@example.com>"} {
+		if e, err := ParseEmail(input); err == nil {
+			t.Errorf("%q: expected an error, got %q", input, e)
+		}
+	}
+	if a, b := MustParseEmail("Joe@Example.com"), MustParseEmail("joe@example.com"); a.Canonical() != b.Canonical() {
+		t.Errorf("%q and %q should have the same canonical form", a, b)
+	}
+}
@@ -0,0 +1,153 @@
+package contact
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Label tells what a phone, e-mail, or address of a contact is for; it may be blank.
+type Label string
+
+const (
+	Home   Label = "home"
+	Work   Label = "work"
+	Mobile Label = "mobile"
+	Other  Label = "other"
+)
+
+// Labels are the valid labels, blank excluded
+var Labels = []Label{Home, Work, Mobile, Other}
+
+func (me Label) IsValid() bool {
+	return me == "" || slices.Contains(Labels, me)
+}
+
+type PhoneEntry struct {
+	Label   Label
+	Primary bool
+	Phone   Phone
+}
+
+type EmailEntry struct {
+	Label   Label
+	Primary bool
+	Email   Email
+}
+
+type Address struct {
+	Label                                     Label
+	Primary                                   bool
+	Street, City, PostalCode, Region, Country string
+}
+
+func (me Address) IsZero() bool {
+	return me.Street == "" && me.City == "" && me.PostalCode == "" && me.Region == "" && me.Country == ""
+}
+
+// String returns the address on one line, eg. "1 Main St, London, N1 9GU, UK"
+func (me Address) String() string {
+	var parts []string
+	for _, p := range []string{me.Street, me.City, me.PostalCode, me.Region, me.Country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (me PhoneEntry) isPrimary() bool { return me.Primary }
+func (me EmailEntry) isPrimary() bool { return me.Primary }
+func (me Address) isPrimary() bool    { return me.Primary }
+
+// primary returns the entry marked as primary, or the first if none is
+func primary[E interface{ isPrimary() bool }](entries []E) (e E) {
+	if i := slices.IndexFunc(entries, E.isPrimary); i >= 0 {
+		return entries[i]
+	} else if len(entries) > 0 {
+		return entries[0]
+	}
+	return e
+}
+
+// Phone returns the primary phone, the blank Phone if there are none
+func (my Contact) Phone() Phone {
+	return primary(my.Phones).Phone
+}
+
+// Email returns the primary e-mail, the blank Email if there are none
+func (my Contact) Email() Email {
+	return primary(my.Emails).Email
+}
+
+// Address returns the primary address, the zero Address if there are none
+func (my Contact) Address() Address {
+	return primary(my.Addresses)
+}
+
+// Clone returns a copy of the contact not sharing its entries
+func (my Contact) Clone() Contact {
+	my.Phones = slices.Clone(my.Phones)
+	my.Emails = slices.Clone(my.Emails)
+	my.Addresses = slices.Clone(my.Addresses)
+	my.Tags = slices.Clone(my.Tags)
+	my.Custom = maps.Clone(my.Custom)
+	return my
+}
+
+// Equal reports whether the contacts have the same values, whatever their Version
+func (my Contact) Equal(other Contact) bool {
+	return my.Id == other.Id &&
+		my.FirstName == other.FirstName &&
+		my.LastName == other.LastName &&
+		slices.Equal(my.Phones, other.Phones) &&
+		slices.Equal(my.Emails, other.Emails) &&
+		slices.Equal(my.Addresses, other.Addresses) &&
+		slices.Equal(my.Tags, other.Tags) &&
+		maps.Equal(my.Custom, other.Custom)
+}
+
+// UnmarshalJSON also accepts contacts encoded when they had a single
+// "Phone" and "Email", making them the primary entries.
+func (me *Contact) UnmarshalJSON(data []byte) error {
+	type contactFields Contact // without methods, to not recurse
+	var decoded struct {
+		contactFields
+		Phone Phone
+		Email Email
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*me = Contact(decoded.contactFields)
+	if !decoded.Phone.IsZero() && len(me.Phones) == 0 {
+		me.Phones = []PhoneEntry{{Primary: true, Phone: decoded.Phone}}
+	}
+	if !decoded.Email.IsZero() && len(me.Emails) == 0 {
+		me.Emails = []EmailEntry{{Primary: true, Email: decoded.Email}}
+	}
+	return nil
+}
+
+// NormalizePrimaries leaves one primary entry of each kind: the first one
+// marked as such, or the first entry if none is.
+func (me *Contact) NormalizePrimaries() {
+	normalizePrimary(me.Phones, func(e *PhoneEntry) *bool { return &e.Primary })
+	normalizePrimary(me.Emails, func(e *EmailEntry) *bool { return &e.Primary })
+	normalizePrimary(me.Addresses, func(e *Address) *bool { return &e.Primary })
+}
+
+func normalizePrimary[E any](entries []E, primary func(*E) *bool) {
+	found := false
+	for i := range entries {
+		isPrimary := primary(&entries[i])
+		if found {
+			*isPrimary = false
+		}
+		found = found || *isPrimary
+	}
+	if !found && len(entries) > 0 {
+		*primary(&entries[0]) = true
+	}
+}
@@ -0,0 +1,83 @@
+package contact
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPrimaryEntries(t *testing.T) {
+	c := Contact{Phones: []PhoneEntry{
+		{Label: Home, Phone: MustParsePhone("020 7946 0000")},
+		{Label: Mobile, Primary: true, Phone: MustParsePhone("07911 123456")},
+	}}
+	if c.Phone().E164() != "+447911123456" {
+		t.Errorf("expected the phone marked as primary, got %q", c.Phone())
+	}
+	c.Phones[1].Primary = false
+	if c.Phone().E164() != "+442079460000" {
+		t.Errorf("expected the first phone when none is marked as primary, got %q", c.Phone())
+	}
+	if !c.Email().IsZero() || !c.Address().IsZero() {
+		t.Errorf("expected blank e-mail and address, got %q and %q", c.Email(), c.Address())
+	}
+	c.Phones = append(c.Phones, PhoneEntry{Primary: true}, PhoneEntry{Primary: true})
+	c.NormalizePrimaries()
+	if c.Phones[0].Primary || !c.Phones[2].Primary || c.Phones[3].Primary {
+		t.Errorf("expected only the first phone marked as primary to stay so, got %#v", c.Phones)
+	}
+	c.Phones[2].Primary = false
+	c.NormalizePrimaries()
+	if !c.Phones[0].Primary {
+		t.Errorf("expected the first phone to become primary, got %#v", c.Phones)
+	}
+}
+
+func TestUnmarshalSingleEntryContact(t *testing.T) {
+	var c Contact
+	err := json.Unmarshal([]byte(`{"Id":"00000000-0000-0000-0000-000000000001","FirstName":"Joe","LastName":"Bloggs",
+		"Phone":"07911 123456","Email":"joe@example.com"}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Id != MustParseId("00000000-0000-0000-0000-000000000001") || c.FirstName != "Joe" ||
+		len(c.Phones) != 1 || !c.Phones[0].Primary || c.Phone().String() != "07911 123456" ||
+		len(c.Emails) != 1 || c.Email().String() != "joe@example.com" {
+		t.Errorf("unexpected %#v", c)
+	}
+	data, err := json.Marshal(fixedContactsList[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil || !c.Equal(fixedContactsList[0]) {
+		t.Errorf("expected %#v, got %#v (err: %v)", fixedContactsList[0], c, err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	tags, err := ParseTags(" Family,  best   Friends ,, family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Tag{"best friends", "family"}; !slices.Equal(tags, expected) {
+		t.Errorf("expected %q, got %q", expected, tags)
+	}
+	for _, malformed := range []string{"  ", strings.Repeat("x", maxTagLength+1)} {
+		if _, err := ParseTag(malformed); err == nil {
+			t.Errorf("%q: expected an error", malformed)
+		}
+	}
+	c := Contact{Tags: tags}
+	if renamed, changed := c.RenameTag("friends", "best friends", "pals"); !changed || !slices.Equal(renamed.Tags, []Tag{"family", "friends"}) {
+		t.Errorf("expected the tag renamed, got %q", renamed.Tags)
+	} else if !slices.Equal(c.Tags, tags) {
+		t.Errorf("expected the original contact unchanged, got %q", c.Tags)
+	}
+	if _, changed := c.RenameTag("family", "work"); changed {
+		t.Errorf("expected no change renaming a tag the contact does not have")
+	}
+	if renamed, _ := c.RenameTag("family", "best friends"); !slices.Equal(renamed.Tags, []Tag{"family"}) {
+		t.Errorf("expected the tags merged, got %q", renamed.Tags)
+	}
+}
@@ -5,105 +5,111 @@ var fixedContactsList = []Contact{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000001"),
 		FirstName: "Joe",
 		LastName:  "Bloggs",
-		Phone:     "+44(0)751123456",
-		Email:     "joebloggs@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123456")}},
+		Emails: []EmailEntry{
+			{Label: Home, Primary: true, Email: MustParseEmail("joebloggs@example.com")},
+			{Label: Work, Email: MustParseEmail("joe.bloggs@work.example.com")},
+		},
+		Addresses: []Address{
+			{Label: Home, Primary: true, Street: "1 Main Street", City: "London", PostalCode: "N1 9GU", Country: "United Kingdom"},
+		},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000002"),
 		FirstName: "Jane",
 		LastName:  "Doe",
-		Phone:     "+44(0)751123457",
-		Email:     "janedoe@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123457")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("janedoe@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000003"),
 		FirstName: "Sam",
 		LastName:  "Smith",
-		Phone:     "+44(0)751123458",
-		Email:     "samsmith@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123458")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("samsmith@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000004"),
 		FirstName: "Ann",
 		LastName:  "Taylor",
-		Phone:     "+44(0)751123459",
-		Email:     "anntaylor@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123459")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("anntaylor@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000005"),
 		FirstName: "Bob",
 		LastName:  "Brown",
-		Phone:     "+44(0)751123460",
-		Email:     "bobbrown@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123460")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("bobbrown@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000006"),
 		FirstName: "Lucy",
 		LastName:  "Green",
-		Phone:     "+44(0)751123461",
-		Email:     "lucygreen@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123461")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("lucygreen@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000007"),
 		FirstName: "Dan",
 		LastName:  "White",
-		Phone:     "+44(0)751123462",
-		Email:     "danwhite@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123462")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("danwhite@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000008"),
 		FirstName: "Eva",
 		LastName:  "Black",
-		Phone:     "+44(0)751123463",
-		Email:     "evablack@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123463")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("evablack@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000009"),
 		FirstName: "Tom",
 		LastName:  "Gray",
-		Phone:     "+44(0)751123464",
-		Email:     "tomgray@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123464")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("tomgray@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000010"),
 		FirstName: "Sue",
 		LastName:  "Jones",
-		Phone:     "+44(0)751123465",
-		Email:     "suejones@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123465")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("suejones@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000011"),
 		FirstName: "Lee",
 		LastName:  "Davis",
-		Phone:     "+44(0)751123466",
-		Email:     "leedavis@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123466")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("leedavis@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000012"),
 		FirstName: "Amy",
 		LastName:  "Adams",
-		Phone:     "+44(0)751123467",
-		Email:     "amyadams@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123467")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("amyadams@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000013"),
 		FirstName: "Max",
 		LastName:  "Mills",
-		Phone:     "+44(0)751123468",
-		Email:     "maxmills@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123468")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("maxmills@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000014"),
 		FirstName: "Tina",
 		LastName:  "Turner",
-		Phone:     "+44(0)751123469",
-		Email:     "tinaturner@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123469")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("tinaturner@example.com")}},
 	},
 	{
 		Id:        MustParseId("00000000-0000-0000-0000-000000000015"),
 		FirstName: "Rob",
 		LastName:  "Rider",
-		Phone:     "+44(0)751123470",
-		Email:     "robrider@example.com",
+		Phones:    []PhoneEntry{{Label: Mobile, Primary: true, Phone: MustParsePhone("+44(0)751123470")}},
+		Emails:    []EmailEntry{{Label: Home, Primary: true, Email: MustParseEmail("robrider@example.com")}},
 	},
 }
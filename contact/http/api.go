@@ -0,0 +1,411 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/seq"
+	"dev.acorello.it/go/contacts/templates"
+	"github.com/acorello/uttpil"
+)
+
+// APIPaths are the resources of the JSON API, the counterpart of Paths for scripts
+type APIPaths struct {
+	// Contacts lists (GET) and creates (POST) contacts
+	Contacts Path
+	// Contact gets (GET), updates (PUT), and deletes (DELETE) the contact
+	// whose id is its "{id}" wildcard (eg. "/api/contacts/{id}")
+	Contact Path
+}
+
+type apiPaths APIPaths
+
+const apiIdWildcard = "{id}"
+
+// Validated checks that:
+// - paths are distinct
+// - Contact has the wildcard of the id
+func (my APIPaths) Validated() (v apiPaths, err error) {
+	if seq.HasDuplicates(my.Contacts, my.Contact) {
+		return v, fmt.Errorf("path elements must be unique. Got %+v", my)
+	}
+	if !strings.Contains(my.Contact.String(), apiIdWildcard) {
+		return v, fmt.Errorf("path of contact must contain %q. Got %q", apiIdWildcard, my.Contact)
+	}
+	return apiPaths(my), nil
+}
+
+func (my apiPaths) contact(id contact.Id) string {
+	return strings.Replace(my.Contact.String(), apiIdWildcard, url.PathEscape(id.String()), 1)
+}
+
+func RegisterAPIHandlers(mux *http.ServeMux, paths apiPaths, repo contact.Repository, fields contact.CustomFields) {
+	h := contactAPIHandler{
+		paths:             paths,
+		contactRepository: repo,
+		fields:            fields,
+	}
+	mux.Handle(paths.Contacts.String(), uttpil.ForMethod{
+		GET:  h.GetList,
+		POST: h.Post,
+	})
+	mux.Handle(paths.Contact.String(), uttpil.ForMethod{
+		GET:    h.Get,
+		PUT:    h.Put,
+		DELETE: h.Delete,
+	})
+}
+
+type contactAPIHandler struct {
+	paths             apiPaths
+	contactRepository contact.Repository
+	fields            contact.CustomFields
+}
+
+// apiContact is contact.Contact as read and written by the JSON API
+type apiContact struct {
+	Id string `json:"id"`
+	// Version is that of the contact read; when updating, that of the contact
+	// changed, unless the If-Match header names it (see etag).
+	Version   int          `json:"version"`
+	FirstName string       `json:"firstName"`
+	LastName  string       `json:"lastName"`
+	Phones    []apiPhone   `json:"phones"`
+	Emails    []apiEmail   `json:"emails"`
+	Addresses []apiAddress `json:"addresses"`
+	Tags      []string     `json:"tags"`
+	// Custom has the values of the custom fields, by name
+	Custom map[string]string `json:"custom"`
+}
+
+type apiEntry struct {
+	Label   contact.Label `json:"label"`
+	Primary bool          `json:"primary"`
+}
+
+type apiPhone struct {
+	apiEntry
+	Number string `json:"number"`
+	// E164 is ignored when reading
+	E164 string `json:"e164,omitempty"`
+}
+
+type apiEmail struct {
+	apiEntry
+	Address string `json:"address"`
+}
+
+type apiAddress struct {
+	apiEntry
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	PostalCode string `json:"postalCode"`
+	Region     string `json:"region"`
+	Country    string `json:"country"`
+}
+
+func apiContactOf(c contact.Contact) apiContact {
+	a := apiContact{
+		Id:        c.Id.String(),
+		Version:   c.Version,
+		FirstName: c.FirstName,
+		LastName:  c.LastName,
+		Phones:    []apiPhone{},
+		Emails:    []apiEmail{},
+		Addresses: []apiAddress{},
+		Tags:      []string{},
+		Custom:    map[string]string{},
+	}
+	maps.Copy(a.Custom, c.Custom)
+	for _, t := range c.Tags {
+		a.Tags = append(a.Tags, string(t))
+	}
+	for _, p := range c.Phones {
+		a.Phones = append(a.Phones, apiPhone{
+			apiEntry: apiEntry{Label: p.Label, Primary: p.Primary},
+			Number:   p.Phone.String(),
+			E164:     p.Phone.E164(),
+		})
+	}
+	for _, e := range c.Emails {
+		a.Emails = append(a.Emails, apiEmail{
+			apiEntry: apiEntry{Label: e.Label, Primary: e.Primary},
+			Address:  e.Email.String(),
+		})
+	}
+	for _, ad := range c.Addresses {
+		a.Addresses = append(a.Addresses, apiAddress{
+			apiEntry:   apiEntry{Label: ad.Label, Primary: ad.Primary},
+			Street:     ad.Street,
+			City:       ad.City,
+			PostalCode: ad.PostalCode,
+			Region:     ad.Region,
+			Country:    ad.Country,
+		})
+	}
+	return a
+}
+
+// values returns the contact as the contact form would submit it, to parse it
+// with parseContact: the key of each entry is its position, so the field
+// errors of the entries are keyed by their position in the request.
+func (me apiContact) values() url.Values {
+	v := url.Values{}
+	v.Set(CustomerId, me.Id)
+	v.Set("Version", strconv.Itoa(me.Version))
+	v.Set("FirstName", me.FirstName)
+	v.Set("LastName", me.LastName)
+	for i, p := range me.Phones {
+		p.addTo(v, "Phone", i)
+		v.Add("PhoneNumber", p.Number)
+	}
+	for i, e := range me.Emails {
+		e.addTo(v, "Email", i)
+		v.Add("EmailAddress", e.Address)
+	}
+	for i, a := range me.Addresses {
+		a.addTo(v, "Address", i)
+		v.Add("AddressStreet", a.Street)
+		v.Add("AddressCity", a.City)
+		v.Add("AddressPostalCode", a.PostalCode)
+		v.Add("AddressRegion", a.Region)
+		v.Add("AddressCountry", a.Country)
+	}
+	v.Set("Tags", strings.Join(me.Tags, ","))
+	for name, value := range me.Custom {
+		v.Set("Custom."+name, value)
+	}
+	return v
+}
+
+func (me apiEntry) addTo(v url.Values, kind string, position int) {
+	key := strconv.Itoa(position)
+	v.Add(kind+"Key", key)
+	v.Add(kind+"Label", string(me.Label))
+	if me.Primary && !v.Has("Primary"+kind) {
+		v.Set("Primary"+kind, key)
+	}
+}
+
+type apiContactList struct {
+	Contacts []apiContact `json:"contacts"`
+	// NextPage is blank on the last page
+	NextPage string `json:"nextPage,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+	// FieldErrors are keyed like templates.ErrorMap, eg. "FirstName", "Emails.1"
+	FieldErrors map[string]string `json:"fieldErrors,omitempty"`
+}
+
+func (h contactAPIHandler) GetList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	searchTerm := strings.TrimSpace(q.Get("SearchTerm"))
+	page, err := parsePage(q.Get("pageAfter"), q.Get("pageSize"), q.Get("Sort"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid page: %v", err), nil)
+		return
+	}
+	contacts, next, more, queryErr, err := findContacts(r.Context(), h.contactRepository, searchTerm, page)
+	if queryErr != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid search term", templates.ErrorMap{"SearchTerm": queryErr})
+		return
+	} else if err != nil {
+		writeAPIRepositoryError(w, err)
+		return
+	}
+	list := apiContactList{Contacts: seq.Map(apiContactOf, contacts...)}
+	if list.Contacts == nil {
+		list.Contacts = []apiContact{}
+	}
+	if more {
+		list.NextPage = string(searchPageURL(next, searchTerm, h.paths.Contacts.String()))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h contactAPIHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if id, ok := apiContactId(w, r); !ok {
+		return
+	} else if theContact, found, err := h.contactRepository.FindById(r.Context(), id); err != nil {
+		writeAPIRepositoryError(w, err)
+	} else if !found {
+		writeAPIError(w, http.StatusNotFound, "contact not found", nil)
+	} else {
+		w.Header().Set("ETag", etag(theContact, apiMediaType))
+		writeJSON(w, http.StatusOK, apiContactOf(theContact))
+	}
+}
+
+// Post creates a contact, with a new id whatever the id in the request
+func (h contactAPIHandler) Post(w http.ResponseWriter, r *http.Request) {
+	var body apiContact
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %v", err), nil)
+		return
+	}
+	body.Id, body.Version = "", 0
+	if theContact, ok := h.store(w, r, body); ok {
+		w.Header().Set("Location", h.paths.contact(theContact.Id))
+		w.Header().Set("ETag", etag(theContact, apiMediaType))
+		writeJSON(w, http.StatusCreated, apiContactOf(theContact))
+	}
+}
+
+// Put replaces the contact, which must exist, with the one in the request if it
+// is still at the version named by the If-Match header or, without it, by the
+// version in the request; without either, it answers 428 Precondition Required.
+func (h contactAPIHandler) Put(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiContactId(w, r)
+	if !ok {
+		return
+	}
+	var body apiContact
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %v", err), nil)
+		return
+	}
+	body.Id = id.String()
+	stored, found, err := h.contactRepository.FindById(r.Context(), id)
+	if err != nil {
+		writeAPIRepositoryError(w, err)
+		return
+	} else if !found {
+		writeAPIError(w, http.StatusNotFound, "contact not found", nil)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !matchesETag(ifMatch, stored) {
+			writeAPIError(w, http.StatusPreconditionFailed, "contact changed meanwhile", nil)
+			return
+		}
+		body.Version = stored.Version
+	} else if body.Version == 0 {
+		writeAPIError(w, http.StatusPreconditionRequired, "the version to replace is required, in If-Match or in the body", nil)
+		return
+	}
+	// the values of fields no longer configured are read along with the others:
+	// sent back unchanged, they are kept, as when left out
+	maps.DeleteFunc(body.Custom, func(name, value string) bool {
+		return !h.fields.Has(name) && stored.Custom[name] == value
+	})
+	if theContact, ok := h.store(w, r, body); ok {
+		w.Header().Set("ETag", etag(theContact, apiMediaType))
+		writeJSON(w, http.StatusOK, apiContactOf(theContact))
+	}
+}
+
+// matchesETag reports whether the If-Match header lists the entity tag of the
+// contact, or is "*"; weak entity tags never match.
+func matchesETag(ifMatch string, c contact.Contact) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag(c, apiMediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// store validates and stores the contact, returning it as stored; if it fails
+// it writes the error and returns false.
+func (h contactAPIHandler) store(w http.ResponseWriter, r *http.Request, body apiContact) (c contact.Contact, ok bool) {
+	theContact, _, fieldErrors := parseContact(body.values(), h.fields)
+	if len(fieldErrors) > 0 {
+		log.Printf("Invalid contact: %+v", fieldErrors)
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid contact", fieldErrors)
+		return c, false
+	} else if err := keepUnknownCustom(r.Context(), h.contactRepository, h.fields, &theContact); err != nil {
+		writeAPIRepositoryError(w, err)
+		return c, false
+	}
+	var emailOwnerErr contact.EmailOwnerError
+	if err := h.contactRepository.Store(r.Context(), theContact); errors.As(err, &emailOwnerErr) {
+		log.Printf("Error storing contact: %v", err)
+		fieldErrors := templates.NewErrorMap()
+		for i, e := range body.Emails {
+			if email, err := contact.ParseEmail(e.Address); err == nil && email.Canonical() == emailOwnerErr.Email.Canonical() {
+				fieldErrors[fmt.Sprintf("Emails.%d", i)] = fmt.Errorf("email address already in use")
+			}
+		}
+		writeAPIError(w, http.StatusConflict, "email address already in use", fieldErrors)
+		return c, false
+	} else if errors.As(err, new(contact.VersionConflictError)) {
+		log.Printf("Error storing contact: %v", err)
+		status := http.StatusConflict
+		if r.Header.Get("If-Match") != "" {
+			status = http.StatusPreconditionFailed
+		}
+		writeAPIError(w, status, "contact changed meanwhile", nil)
+		return c, false
+	} else if err != nil {
+		writeAPIRepositoryError(w, err)
+		return c, false
+	}
+	theContact.Version++
+	log.Printf("Stored: %#v", theContact)
+	return theContact, true
+}
+
+func (h contactAPIHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if id, ok := apiContactId(w, r); !ok {
+		return
+	} else if deleted, err := h.contactRepository.Delete(r.Context(), id); err != nil {
+		writeAPIRepositoryError(w, err)
+	} else if !deleted {
+		writeAPIError(w, http.StatusNotFound, "contact not found", nil)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// apiContactId parses the id in the path; if it fails it writes the error and returns false
+func apiContactId(w http.ResponseWriter, r *http.Request) (contact.Id, bool) {
+	_id := r.PathValue("id")
+	id, err := contact.ParseId(_id)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse id %q: %v", _id, err), nil)
+		return id, false
+	}
+	return id, true
+}
+
+// apiMediaType is that of the requests and responses of the JSON API
+const apiMediaType = "application/json"
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", apiMediaType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding JSON: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string, fieldErrors templates.ErrorMap) {
+	body := apiError{Error: msg}
+	if len(fieldErrors) > 0 {
+		body.FieldErrors = make(map[string]string, len(fieldErrors))
+		for field, err := range fieldErrors {
+			body.FieldErrors[field] = err.Error()
+		}
+	}
+	writeJSON(w, status, body)
+}
+
+func writeAPIRepositoryError(w http.ResponseWriter, err error) {
+	status := repositoryErrorStatus(err)
+	log.Printf("repository error (responding %d): %v", status, err)
+	if status == statusClientClosedRequest {
+		w.WriteHeader(status) // nobody is listening
+	} else {
+		writeAPIError(w, status, http.StatusText(status), nil)
+	}
+}
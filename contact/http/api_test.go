@@ -0,0 +1,144 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestAPI(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	paths, err := APIPaths{Contacts: "/api/contacts", Contact: "/api/contacts/{id}"}.Validated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterAPIHandlers(mux, paths, contact.NewInMemoryContactRepository(), nil)
+
+	do := func(method, target, body string, status int, decoded any) *httptest.ResponseRecorder {
+		t.Helper()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if res.Code != status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, status, res.Code, res.Body)
+		}
+		if decoded != nil {
+			if err := json.Unmarshal(res.Body.Bytes(), decoded); err != nil {
+				t.Fatalf("%s %s: %v", method, target, err)
+			}
+		}
+		return res
+	}
+
+	var created apiContact
+	res := do(http.MethodPost, "/api/contacts", `{
+		"firstName": "Joe", "lastName": "Bloggs",
+		"phones": [{"label": "mobile", "number": "07911 123456"}],
+		"emails": [{"label": "home", "address": "joe@example.com"}, {"address": " "}, {"label": "work", "address": "joe@work.example.com", "primary": true}]
+	}`, http.StatusCreated, &created)
+	if location := res.Header().Get("Location"); location != "/api/contacts/"+created.Id {
+		t.Errorf("unexpected location %q", location)
+	}
+	if len(created.Emails) != 2 || !created.Emails[1].Primary || created.Phones[0].E164 != "+447911123456" {
+		t.Errorf("unexpected contact %+v", created)
+	}
+
+	var got apiContact
+	do(http.MethodGet, "/api/contacts/"+created.Id, "", http.StatusOK, &got)
+	if got.LastName != "Bloggs" {
+		t.Errorf("unexpected contact %+v", got)
+	}
+
+	var invalid apiError
+	do(http.MethodPut, "/api/contacts/"+created.Id, `{
+		"version": 1, "firstName": "Joe!", "lastName": "Bloggs",
+		"emails": [{"address": ""}, {"address": "not an e-mail"}]
+	}`, http.StatusUnprocessableEntity, &invalid)
+	if invalid.FieldErrors["FirstName"] == "" || invalid.FieldErrors["Emails.1"] == "" {
+		t.Errorf("expected field errors keyed by the position in the request, got %+v", invalid)
+	}
+	var duplicate apiError
+	do(http.MethodPost, "/api/contacts", `{
+		"firstName": "Jane", "lastName": "Doe",
+		"emails": [{"address": "jane@example.com"}, {"address": ""}, {"address": "JANE@example.com"}]
+	}`, http.StatusUnprocessableEntity, &duplicate)
+	if duplicate.FieldErrors["Emails.2"] != "duplicate e-mail address" || len(duplicate.FieldErrors) != 1 {
+		t.Errorf("expected the repeated e-mail rejected, got %+v", duplicate)
+	}
+
+	var conflict apiError
+	do(http.MethodPost, "/api/contacts", `{
+		"firstName": "Jane", "lastName": "Bloggs",
+		"emails": [{"address": "jane@example.com"}, {"address": "JOE@example.com"}]
+	}`, http.StatusConflict, &conflict)
+	if conflict.FieldErrors["Emails.1"] == "" {
+		t.Errorf("expected the e-mail in use to be reported, got %+v", conflict)
+	}
+
+	var list apiContactList
+	do(http.MethodGet, "/api/contacts?SearchTerm=last:bloggs", "", http.StatusOK, &list)
+	if len(list.Contacts) != 1 || list.NextPage != "" {
+		t.Errorf("unexpected list %+v", list)
+	}
+	do(http.MethodGet, "/api/contacts?pageSize=ten", "", http.StatusBadRequest, nil)
+
+	do(http.MethodDelete, "/api/contacts/"+created.Id, "", http.StatusNoContent, nil)
+	do(http.MethodGet, "/api/contacts/"+created.Id, "", http.StatusNotFound, nil)
+	do(http.MethodPut, "/api/contacts/"+created.Id, `{"firstName": "Joe", "lastName": "Bloggs", "emails": [{"address": "joe@example.com"}]}`, http.StatusNotFound, nil)
+	do(http.MethodGet, "/api/contacts/not-an-id", "", http.StatusBadRequest, nil)
+}
+
+func TestAPIRejectsStaleUpdates(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	paths, err := APIPaths{Contacts: "/api/contacts", Contact: "/api/contacts/{id}"}.Validated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterAPIHandlers(mux, paths, contact.NewInMemoryContactRepository(), nil)
+
+	do := func(method, target, ifMatch, body string, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("%s %s (If-Match %s): expected status %d, got %d: %s", method, target, ifMatch, status, res.Code, res.Body)
+		}
+		return res
+	}
+	joe := `{"firstName": "Joe", "lastName": "Bloggs", "emails": [{"address": "joe@example.com"}]}`
+	res := do(http.MethodPost, "/api/contacts", "", joe, http.StatusCreated)
+	location := res.Header().Get("Location")
+	if etag := res.Header().Get("ETag"); etag != `"1-json"` {
+		t.Errorf("expected ETag %q, got %q", `"1-json"`, etag)
+	}
+	res = do(http.MethodPut, location, `"1-json"`, joe, http.StatusOK)
+	if etag := res.Header().Get("ETag"); etag != `"2-json"` {
+		t.Errorf("expected ETag %q, got %q", `"2-json"`, etag)
+	}
+	do(http.MethodPut, location, `"1-json"`, joe, http.StatusPreconditionFailed)
+	do(http.MethodPut, location, `"2-html"`, joe, http.StatusPreconditionFailed)
+	do(http.MethodPut, location, `W/"2-json"`, joe, http.StatusPreconditionFailed)
+	do(http.MethodPut, location, "", joe, http.StatusPreconditionRequired)
+	do(http.MethodPut, location, "", strings.Replace(joe, "{", `{"version": 1, `, 1), http.StatusConflict)
+	do(http.MethodPut, location, `"0-json", "2-json"`, joe, http.StatusOK)
+	res = do(http.MethodPut, location, "", strings.Replace(joe, "{", `{"version": 3, `, 1), http.StatusOK)
+	if etag := do(http.MethodGet, location, "", "", http.StatusOK).Header().Get("ETag"); etag != `"4-json"` || etag != res.Header().Get("ETag") {
+		t.Errorf("expected ETag %q, got %q", `"4-json"`, etag)
+	}
+}
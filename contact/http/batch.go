@@ -0,0 +1,140 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+)
+
+// PostBatch applies the Action submitted to the contacts selected in the list,
+// by their Id: "delete" moves them all to the trash, or none, answering with a
+// partial that removes their rows; "tag" adds the Tag given to all of them, or
+// none; "export" downloads them, in the Format given, as GetExport does.
+func (h contactHTTPHandler) PostBatch(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	var ids []contact.Id
+	for _, _id := range r.PostForm[CustomerId] {
+		if id, err := contact.ParseId(_id); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse id %q: %v", _id, err), http.StatusBadRequest)
+			return
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	switch action := r.PostForm.Get("Action"); action {
+	case "delete":
+		h.deleteBatch(w, r, ids)
+	case "tag":
+		h.tagBatch(w, r, ids)
+	case "export":
+		h.exportBatch(w, r, ids)
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+	}
+}
+
+func (h contactHTTPHandler) deleteBatch(w http.ResponseWriter, r *http.Request, ids []contact.Id) {
+	result := ht.BatchResult{URLs: ht.BatchResultURLs{Show: h.paths.Trash.TemplateURL()}}
+	var notFoundErr contact.NotFoundError
+	if len(ids) == 0 {
+		result.Error = errors.New("no contacts selected")
+	} else if err := h.contactRepository.DeleteAll(r.Context(), ids...); errors.As(err, &notFoundErr) {
+		log.Printf("Error deleting contacts: %v", err)
+		result.Error = fmt.Errorf("no contacts were deleted: one of them was deleted meanwhile, reload the list")
+	} else if err != nil {
+		writeRepositoryError(w, err)
+		return
+	} else {
+		log.Printf("Deleted: %q", ids)
+		result.Message = fmt.Sprintf("Deleted %d contacts.", len(ids))
+		result.Deleted = ids
+	}
+	if err := ht.WriteBatchResult(w, result); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+func (h contactHTTPHandler) tagBatch(w http.ResponseWriter, r *http.Request, ids []contact.Id) {
+	var result ht.BatchResult
+	tag, err := contact.ParseTag(r.PostForm.Get("Tag"))
+	if err != nil {
+		result.Error = fmt.Errorf("invalid tag: %w", err)
+	} else if len(ids) == 0 {
+		result.Error = errors.New("no contacts selected")
+	} else {
+		var tagged []contact.Contact
+		for _, id := range ids {
+			if c, found, err := h.contactRepository.FindById(r.Context(), id); err != nil {
+				writeRepositoryError(w, err)
+				return
+			} else if !found {
+				result.Error = fmt.Errorf("no contacts were tagged: one of them was deleted meanwhile, reload the list")
+				break
+			} else if !c.HasTag(tag) && !slices.ContainsFunc(tagged, id.HasSameId) {
+				c.Tags = contact.SortedTags(append(c.Tags, tag))
+				tagged = append(tagged, c)
+			}
+		}
+		if result.Error == nil {
+			err = h.contactRepository.Store(r.Context(), tagged...)
+		}
+		if errors.As(err, new(contact.VersionConflictError)) {
+			log.Printf("Error tagging contacts: %v", err)
+			result.Error = fmt.Errorf("no contacts were tagged: one of them was changed meanwhile, try again")
+		} else if err != nil {
+			writeRepositoryError(w, err)
+			return
+		} else if result.Error == nil {
+			log.Printf("Tagged %q: %q", tag, ids)
+			result.Message = fmt.Sprintf("Tagged %d contacts with %q.", len(ids), tag)
+			result.URLs.Show = h.paths.List.Add("SearchTerm", contact.TagQuery(tag).String()).TemplateURL()
+		}
+	}
+	if err := ht.WriteBatchResult(w, result); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// exportBatch downloads the contacts selected, but those deleted meanwhile
+func (h contactHTTPHandler) exportBatch(w http.ResponseWriter, r *http.Request, ids []contact.Id) {
+	formatName := r.PostForm.Get("Format")
+	if formatName == "" {
+		formatName = "vcard"
+	}
+	format, found := exportFormats[formatName]
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown format %q", formatName), http.StatusBadRequest)
+		return
+	} else if len(ids) == 0 {
+		http.Error(w, "no contacts selected", http.StatusBadRequest)
+		return
+	}
+	var contacts []contact.Contact
+	for _, id := range ids {
+		if c, found, err := h.contactRepository.FindById(r.Context(), id); err != nil {
+			writeRepositoryError(w, err)
+			return
+		} else if found {
+			contacts = append(contacts, c)
+		}
+	}
+	writeExportHeader(w, format, "contacts")
+	export, err := format.newWriter(w)
+	if err == nil {
+		err = writePage(w, export, contacts)
+	}
+	if err == nil {
+		err = export.Close()
+	}
+	if err != nil {
+		log.Printf("error exporting: %v", err)
+	}
+}
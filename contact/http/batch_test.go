@@ -0,0 +1,73 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestBatch(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewPopulatedInMemoryContactRepository()
+	contacts, _, _, err := repo.FindAll(ctx, contact.Page{Size: 2})
+	if err != nil || len(contacts) != 2 {
+		t.Fatalf("expected 2 contacts, got %d (err: %v)", len(contacts), err)
+	}
+	joe, jane := contacts[0], contacts[1]
+	mux := newTestMux(t, repo)
+	post := func(form url.Values, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/contact/batch", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("expected status %d, got %d", status, res.Code)
+		}
+		return res
+	}
+	ids := []string{joe.Id.String(), jane.Id.String()}
+
+	list := httptest.NewRecorder()
+	mux.ServeHTTP(list, httptest.NewRequest(http.MethodGet, "/contact/list", nil))
+	if !strings.Contains(list.Body.String(), `form="Batch" name="Id" value="`+joe.Id.String()+`"`) {
+		t.Errorf("expected the list to let select the contacts")
+	}
+
+	res := post(url.Values{"Action": {"export"}, "Format": {"csv"}, "Id": ids}, http.StatusOK)
+	if body := res.Body.String(); !strings.Contains(body, joe.LastName) || !strings.Contains(body, jane.LastName) ||
+		!strings.HasPrefix(res.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("expected both contacts exported as CSV, got %q", body)
+	}
+
+	missing := contact.NewId().String()
+	page := post(url.Values{"Action": {"delete"}, "Id": append(ids, missing)}, http.StatusOK).Body.String()
+	if !strings.Contains(page, "no contacts were deleted") {
+		t.Errorf("expected the partial to explain why no contacts were deleted, got %q", page)
+	}
+	if _, found, _ := repo.FindById(ctx, joe.Id); !found {
+		t.Errorf("expected none of the selection to be deleted")
+	}
+
+	page = post(url.Values{"Action": {"delete"}, "Id": ids}, http.StatusOK).Body.String()
+	for _, expected := range []string{"Deleted 2 contacts.", `hx-swap-oob="delete:#Contact-` + joe.Id.String() + `"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the partial, got %q", expected, page)
+		}
+	}
+	if deleted, _, _, _ := repo.FindDeleted(ctx, contact.Page{Size: 10}); len(deleted) != 2 {
+		t.Errorf("expected the selection in the trash, got %#v", deleted)
+	}
+	post(url.Values{"Action": {"tickle"}, "Id": ids}, http.StatusBadRequest)
+}
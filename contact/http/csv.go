@@ -0,0 +1,292 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/csv"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+	"dev.acorello.it/go/contacts/templates"
+)
+
+// maxCSVSize is the size of the largest CSV file accepted for import
+const maxCSVSize = 2 << 20
+
+// csvSampleSize is the number of rows shown by the preview of a CSV file
+const csvSampleSize = 10
+
+// csvHeaders are the headers, lower case and without spaces, guessed to name
+// the fields of ht.CSVFields; our own CSV export among them (see csv.Header).
+// Those of the custom fields are their names and labels (see csvImport.guess).
+var csvHeaders = map[string]string{
+	"firstname": "FirstName", "first": "FirstName", "givenname": "FirstName",
+	"lastname": "LastName", "last": "LastName", "surname": "LastName", "familyname": "LastName",
+	"phone": "Phone", "phonenumber": "Phone", "telephone": "Phone", "mobile": "Phone", "tel": "Phone",
+	"email": "Email", "emailaddress": "Email", "e-mail": "Email", "mail": "Email",
+}
+
+// csvImport is a CSV file, with its columns mapped to fields
+type csvImport struct {
+	data      string
+	records   []csv.Record
+	hasHeader bool
+	columns   []ht.CSVColumn
+	fields    []ht.CSVField
+}
+
+// rows are the records to import, the header excluded
+func (me csvImport) rows() []csv.Record {
+	if me.hasHeader && len(me.records) > 0 {
+		return me.records[1:]
+	}
+	return me.records
+}
+
+// parseCSVImport reads the uploaded File, guessing its mapping from its header,
+// or the Data submitted again with its mapping: a Column input for each column,
+// naming one of ht.CSVFields or of the custom fields.
+func parseCSVImport(w http.ResponseWriter, r *http.Request, custom contact.CustomFields) (imp csvImport, err error) {
+	imp.fields = ht.CSVFieldsWith(custom)
+	r.Body = http.MaxBytesReader(w, r.Body, maxCSVSize)
+	if err := r.ParseMultipartForm(maxCSVSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return imp, err
+	}
+	file, _, err := r.FormFile("File")
+	uploaded := err == nil
+	if uploaded {
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return imp, err
+		}
+		imp.data = string(data)
+	} else {
+		imp.data = r.FormValue("Data")
+	}
+	if imp.records, err = csv.ReadRecords(strings.NewReader(imp.data)); err != nil {
+		return imp, err
+	} else if len(imp.records) == 0 {
+		return imp, fmt.Errorf("the file is empty")
+	}
+	firstRecord := imp.records[0].Fields
+	imp.columns = make([]ht.CSVColumn, len(firstRecord))
+	if uploaded {
+		for i, header := range firstRecord {
+			imp.columns[i].Field = imp.guess(header)
+			imp.hasHeader = imp.hasHeader || imp.columns[i].Field != ""
+		}
+	} else {
+		imp.hasHeader = r.FormValue("HasHeader") != ""
+		for i, field := range r.Form["Column"] {
+			if i < len(imp.columns) && slices.ContainsFunc(imp.fields, func(f ht.CSVField) bool { return f.Name == field }) {
+				imp.columns[i].Field = field
+			}
+		}
+	}
+	for i := range imp.columns {
+		if imp.hasHeader {
+			imp.columns[i].Header = firstRecord[i]
+		} else {
+			imp.columns[i].Header = fmt.Sprintf("Column %d", i+1)
+		}
+	}
+	return imp, nil
+}
+
+// guess returns the field a header names, if any
+func (me csvImport) guess(header string) string {
+	normal := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(strings.Join(strings.Fields(s), "")), "_", "")
+	}
+	if field, found := csvHeaders[normal(header)]; found {
+		return field
+	}
+	for _, f := range me.fields {
+		if name, isCustom := strings.CutPrefix(f.Name, "Custom."); isCustom && (normal(header) == normal(name) || normal(header) == normal(f.Label)) {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// mappingError tells whether a field is mapped to more than one column
+func (me csvImport) mappingError() error {
+	mapped := make(map[string]int)
+	for _, c := range me.columns {
+		if c.Field != "" {
+			mapped[c.Field]++
+		}
+	}
+	var mappingErrors []string
+	for _, field := range me.fields {
+		if mapped[field.Name] > 1 {
+			mappingErrors = append(mappingErrors, fmt.Sprintf("%s is mapped to more than one column", field.Label))
+		}
+	}
+	if len(mappingErrors) > 0 {
+		return errors.New(strings.Join(mappingErrors, "; "))
+	}
+	return nil
+}
+
+// validate validates each row as the contact form would, with fields, and that its e-mail
+// belongs to no contact, nor to an earlier row; it returns the contacts of the
+// valid rows, and the rows, each with its error if invalid.
+func (me csvImport) validate(ctx context.Context, repo contact.Repository, fields contact.CustomFields) (valid []contact.Contact, rows []ht.CSVRow, err error) {
+	emailLines := make(map[string]int) // line of the row with the canonical e-mail
+	for _, record := range me.rows() {
+		row := ht.CSVRow{Line: record.Line, Cells: record.Fields}
+		c, fieldErrors := me.parseContact(record, fields)
+		if len(fieldErrors) > 0 {
+			row.Error = describeFieldErrors(fieldErrors)
+		} else if line, found := emailLines[c.Email().Canonical()]; found {
+			row.Error = fmt.Errorf("Email: same as line %d", line)
+		} else if _, found, err := repo.FindIdByEmail(ctx, c.Email()); err != nil {
+			return nil, nil, err
+		} else if found {
+			row.Error = fmt.Errorf("Email: already in use")
+		} else {
+			emailLines[c.Email().Canonical()] = record.Line
+			valid = append(valid, c)
+		}
+		rows = append(rows, row)
+	}
+	return valid, rows, nil
+}
+
+// parseContact parses a record as a new contact, with parseContact; its field
+// errors are keyed by the Name of the fields.
+func (me csvImport) parseContact(record csv.Record, fields contact.CustomFields) (contact.Contact, templates.ErrorMap) {
+	values := url.Values{"PhoneKey": {"0"}, "PrimaryPhone": {"0"}, "EmailKey": {"0"}, "PrimaryEmail": {"0"}}
+	for i, column := range me.columns {
+		switch column.Field {
+		case "Phone":
+			values.Set("PhoneNumber", record.Fields[i])
+		case "Email":
+			values.Set("EmailAddress", record.Fields[i])
+		case "FirstName", "LastName":
+			values.Set(column.Field, record.Fields[i])
+		default:
+			if strings.HasPrefix(column.Field, "Custom.") {
+				values.Set(column.Field, record.Fields[i])
+			}
+		}
+	}
+	c, _, fieldErrors := parseContact(values, fields)
+	csvErrors := templates.NewErrorMap()
+	for field, err := range fieldErrors {
+		switch field {
+		case "Phones.0":
+			field = "Phone"
+		case "Emails", "Emails.0":
+			field = "Email"
+		}
+		csvErrors[field] = err
+	}
+	return c, csvErrors
+}
+
+func (h contactHTTPHandler) GetCSVImport(w http.ResponseWriter, r *http.Request) {
+	if err := ht.WriteCSVImportPage(w, h.csvImportURLs()); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// PostCSVPreview previews the import of the uploaded CSV file or, when a mapping
+// changes, of the file as mapped.
+func (h contactHTTPHandler) PostCSVPreview(w http.ResponseWriter, r *http.Request) {
+	imp, err := parseCSVImport(w, r, h.fields)
+	if err != nil {
+		log.Printf("Error reading CSV: %v", err)
+		h.writeCSVPreview(w, ht.CSVPreview{Data: imp.data, Error: fmt.Errorf("failed to read the file: %v", err)})
+		return
+	}
+	h.previewCSVImport(r.Context(), w, imp, imp.mappingError())
+}
+
+func (h contactHTTPHandler) previewCSVImport(ctx context.Context, w http.ResponseWriter, imp csvImport, importErr error) {
+	valid, rows, err := imp.validate(ctx, h.contactRepository, h.fields)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	preview := ht.CSVPreview{
+		Data:        imp.data,
+		HasHeader:   imp.hasHeader,
+		Columns:     imp.columns,
+		Sample:      ht.CSVRows{Columns: imp.columns, Rows: rows[:min(len(rows), csvSampleSize)]},
+		Invalid:     ht.CSVRows{Columns: imp.columns, Rows: invalidRows(rows)},
+		Total:       len(rows),
+		Valid:       len(valid),
+		ImportError: importErr,
+	}
+	h.writeCSVPreview(w, preview)
+}
+
+// PostCSVImport stores the contacts of the valid rows together, skipping the
+// invalid ones; if it fails, nothing is imported and the preview shows why.
+func (h contactHTTPHandler) PostCSVImport(w http.ResponseWriter, r *http.Request) {
+	imp, err := parseCSVImport(w, r, h.fields)
+	if err != nil {
+		log.Printf("Error reading CSV: %v", err)
+		h.writeCSVPreview(w, ht.CSVPreview{Data: imp.data, Error: fmt.Errorf("failed to read the file: %v", err)})
+		return
+	} else if err := imp.mappingError(); err != nil {
+		h.previewCSVImport(r.Context(), w, imp, err)
+		return
+	}
+	valid, rows, err := imp.validate(r.Context(), h.contactRepository, h.fields)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	} else if len(valid) == 0 {
+		h.previewCSVImport(r.Context(), w, imp, fmt.Errorf("no valid rows to import"))
+		return
+	}
+	var emailOwnerErr contact.EmailOwnerError
+	if err := h.contactRepository.Store(r.Context(), valid...); errors.As(err, &emailOwnerErr) {
+		log.Printf("Error importing CSV: %v", err)
+		h.previewCSVImport(r.Context(), w, imp, fmt.Errorf("nothing was imported: the e-mail address %s was taken meanwhile", emailOwnerErr.Email))
+		return
+	} else if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	log.Printf("Imported %d contacts from CSV", len(valid))
+	results := ht.CSVResults{
+		Imported: len(valid),
+		Skipped:  ht.CSVRows{Columns: imp.columns, Rows: invalidRows(rows)},
+		URLs:     h.csvImportURLs(),
+	}
+	if err := ht.WriteCSVResults(w, results); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+func invalidRows(rows []ht.CSVRow) []ht.CSVRow {
+	return slices.DeleteFunc(slices.Clone(rows), func(row ht.CSVRow) bool { return row.Error == nil })
+}
+
+func (h contactHTTPHandler) writeCSVPreview(w http.ResponseWriter, p ht.CSVPreview) {
+	p.URLs = h.csvImportURLs()
+	p.Fields = ht.CSVFieldsWith(h.fields)
+	if err := ht.WriteCSVPreview(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+func (h contactHTTPHandler) csvImportURLs() ht.CSVImportPageURLs {
+	return ht.CSVImportPageURLs{
+		Preview:     h.paths.CSVPreview.TemplateURL(),
+		Import:      h.paths.CSVImport.TemplateURL(),
+		ContactList: h.paths.List.TemplateURL(),
+	}
+}
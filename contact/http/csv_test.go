@@ -0,0 +1,77 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestCSVImport(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs",
+		Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("joe@example.com")}}}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestMux(t, repo)
+	data := "Given Name,Surname,E-mail,Notes\n" +
+		"Jane,Doe,jane@example.com,ok\n" +
+		"Joe!,Bloggs,joe.bloggs@example.com,invalid name\n" +
+		"Janet,Doe,JANE@example.com,same e-mail as line 2\n" +
+		"Joe,Impostor,joe@example.com,e-mail in use\n"
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("File", "contacts.csv")
+	part.Write([]byte(data))
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/contact/import/csv/preview", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	preview := res.Body.String()
+	for _, expected := range []string{`<option value="FirstName" selected>`, `<option value="Email" selected>`,
+		"FirstName: invalid name", "Email: same as line 2", "Email: already in use", "1 of 4 rows are valid"} {
+		if !strings.Contains(preview, expected) {
+			t.Errorf("expected %q in the preview", expected)
+		}
+	}
+
+	mapping := url.Values{"Data": {data}, "HasHeader": {"on"}, "Column": {"FirstName", "LastName", "Email", ""}}
+	req = httptest.NewRequest(http.MethodPost, "/contact/import/csv", strings.NewReader(mapping.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if results := res.Body.String(); !strings.Contains(results, "Imported 1 contacts") || !strings.Contains(results, "Skipped rows") {
+		t.Errorf("unexpected results %s", results)
+	}
+	if id, found, _ := repo.FindIdByEmail(ctx, contact.MustParseEmail("jane@example.com")); !found {
+		t.Errorf("expected Jane to be imported")
+	} else if jane, _, _ := repo.FindById(ctx, id); jane.FirstName != "Jane" || jane.LastName != "Doe" {
+		t.Errorf("unexpected %#v", jane)
+	}
+
+	mapping.Set("Column", "Email")
+	mapping.Add("Column", "Email")
+	req = httptest.NewRequest(http.MethodPost, "/contact/import/csv", strings.NewReader(mapping.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if !strings.Contains(res.Body.String(), "Email is mapped to more than one column") {
+		t.Errorf("expected the mapping to be rejected, got %s", res.Body)
+	}
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestCustomFields(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	fields, err := contact.ParseCustomFields(strings.NewReader(`[
+		{"name": "company", "label": "Company", "type": "text", "list": true},
+		{"name": "stage", "label": "Stage", "type": "enum", "options": ["lead", "customer"], "required": true},
+		{"name": "birthday", "label": "Birthday", "type": "date", "max": "2020-12-31"},
+		{"name": "site", "label": "Web Site", "type": "url"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	mux := newTestMuxWithFields(t, repo, fields)
+	serve := func(method, target string, form url.Values, status int) string {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, status, res.Code, res.Body)
+		}
+		return res.Body.String()
+	}
+
+	if page := serve(http.MethodGet, "/contact/form", nil, http.StatusOK); !strings.Contains(page, `<select name="Custom.stage" id="Custom.stage" required>`) ||
+		!strings.Contains(page, `type="date" value=""`) || !strings.Contains(page, `max="2020-12-31"`) {
+		t.Errorf("expected an input for each custom field, by type, got %q", page)
+	}
+
+	id := contact.NewId()
+	form := url.Values{
+		"Id": {id.String()}, "FirstName": {"Joe"}, "LastName": {"Bloggs"},
+		"EmailKey": {"0"}, "EmailLabel": {""}, "EmailAddress": {"joe@example.com"},
+		"Custom.company": {" Acme "}, "Custom.stage": {"Customer"}, "Custom.birthday": {"2021-01-01"}, "Custom.site": {"ftp://acme.example"},
+	}
+	page := serve(http.MethodPost, "/contact/form", form, http.StatusOK)
+	if !strings.Contains(page, "more than 2020-12-31") || !strings.Contains(page, "not an http or https URL") || !strings.Contains(page, `value="ftp://acme.example"`) {
+		t.Errorf("expected the invalid values shown as typed, with their errors, got %q", page)
+	}
+
+	form.Set("Custom.birthday", "1980-02-29")
+	form.Set("Custom.site", "https://acme.example")
+	form.Set("Custom.removed", "a field no longer configured")
+	if page := serve(http.MethodPost, "/contact/form", form, http.StatusOK); !strings.Contains(page, "removed: unknown field") {
+		t.Errorf("expected the value of an unknown field rejected, got %q", page)
+	}
+	form.Del("Custom.removed")
+	serve(http.MethodPost, "/contact/form", form, http.StatusFound)
+	stored, _, _ := repo.FindById(ctx, id)
+	if expected := map[string]string{"company": "Acme", "stage": "customer", "birthday": "1980-02-29", "site": "https://acme.example"}; !maps.Equal(stored.Custom, expected) {
+		t.Errorf("expected %v, got %v", expected, stored.Custom)
+	}
+	if page := serve(http.MethodGet, "/contact/?Id="+id.String(), nil, http.StatusOK); !strings.Contains(page, `Web Site: <a href="https://acme.example"`) {
+		t.Errorf("expected the values on the contact page, got %q", page)
+	}
+	if page := serve(http.MethodGet, "/contact/list", nil, http.StatusOK); !strings.Contains(page, "<th>Company</th>") || !strings.Contains(page, "<td>Acme</td>") {
+		t.Errorf("expected a column for the listed field, got %q", page)
+	}
+	csvImport := url.Values{"Data": {"Jane,Doe,jane@example.com,lead\n"}, "Column": {"FirstName", "LastName", "Email"}}
+	if page := serve(http.MethodPost, "/contact/import/csv/preview", csvImport, http.StatusOK); !strings.Contains(page, "Custom.stage: required") ||
+		!strings.Contains(page, `<option value="Custom.stage" >Stage</option>`) {
+		t.Errorf("expected the imported contact to lack the required field, which a column can be mapped to, got %q", page)
+	}
+	csvImport["Column"] = append(csvImport["Column"], "Custom.stage")
+	serve(http.MethodPost, "/contact/import/csv", csvImport, http.StatusOK)
+	if id, found, _ := repo.FindIdByEmail(ctx, contact.MustParseEmail("jane@example.com")); !found {
+		t.Error("expected the contact imported with the field mapped")
+	} else if jane, _, _ := repo.FindById(ctx, id); jane.Custom["stage"] != "lead" {
+		t.Errorf("expected the value of the mapped column, got %v", jane.Custom)
+	}
+}
+
+// TestRemovedCustomFieldsAreKept saves a contact having the value of a field
+// no longer configured, from the form and the API: the value is kept, but not
+// changed.
+func TestRemovedCustomFieldsAreKept(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	fields := contact.CustomFields{{Name: "company", Label: "Company", Type: contact.TextType}}
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: []contact.EmailEntry{{Email: contact.MustParseEmail("joe@example.com")}}, Custom: map[string]string{"company": "Acme", "removed": "kept"}}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestMuxWithFields(t, repo, fields)
+	paths, err := APIPaths{Contacts: "/api/contacts", Contact: "/api/contacts/{id}"}.Validated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterAPIHandlers(mux, paths, repo, fields)
+	serve := func(method, target, contentType, body string, status int) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, status, res.Code, res.Body)
+		}
+	}
+	expectStored := func(expected map[string]string) {
+		t.Helper()
+		if stored, _, _ := repo.FindById(ctx, joe.Id); !maps.Equal(stored.Custom, expected) {
+			t.Errorf("expected %v, got %v", expected, stored.Custom)
+		}
+	}
+
+	form := url.Values{"Id": {joe.Id.String()}, "Version": {"1"}, "FirstName": {"Joe"}, "LastName": {"Bloggs"},
+		"EmailKey": {"0"}, "EmailLabel": {""}, "EmailAddress": {"joe@example.com"}, "Custom.company": {"Initech"}}
+	serve(http.MethodPost, "/contact/form", "application/x-www-form-urlencoded", form.Encode(), http.StatusFound)
+	expectStored(map[string]string{"company": "Initech", "removed": "kept"})
+
+	target := "/api/contacts/" + joe.Id.String()
+	serve(http.MethodPut, target, "application/json", `{"version": 2, "firstName": "Joe", "lastName": "Bloggs", "emails": [{"address": "joe@example.com"}], "custom": {"company": "Acme", "removed": "kept"}}`, http.StatusOK)
+	expectStored(map[string]string{"company": "Acme", "removed": "kept"})
+	serve(http.MethodPut, target, "application/json", `{"version": 3, "firstName": "Joe", "lastName": "Bloggs", "emails": [{"address": "joe@example.com"}], "custom": {"removed": "changed"}}`, http.StatusUnprocessableEntity)
+	serve(http.MethodPut, target, "application/json", `{"version": 3, "firstName": "Joe", "lastName": "Bloggs", "emails": [{"address": "joe@example.com"}], "custom": {}}`, http.StatusOK)
+	expectStored(map[string]string{"removed": "kept"})
+}
@@ -0,0 +1,228 @@
+package http
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+	"dev.acorello.it/go/contacts/contact/duplicate"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+)
+
+// GetDuplicates lists the contacts suspected to be duplicates, most likely first
+func (h contactHTTPHandler) GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	pairs, err := duplicate.Find(r.Context(), h.contactRepository)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	p := ht.DuplicatesPage{URLs: ht.DuplicatesPageURLs{ContactList: h.paths.List.TemplateURL()}}
+	for _, pair := range pairs {
+		p.Rows = append(p.Rows, ht.DuplicateRow{
+			Pair:    pair,
+			Percent: int(math.Round(pair.Score * 100)),
+			Merge:   h.paths.Merge.Add("A", pair.A.Id.String()).Add("B", pair.B.Id.String()).TemplateURL(),
+		})
+	}
+	if err := ht.WriteDuplicatesPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// GetMerge shows the contacts A and B side by side, to pick the values of the
+// contact they merge into.
+func (h contactHTTPHandler) GetMerge(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", http.StatusBadRequest)
+	} else if a, b, ok := h.findMerging(w, r); ok {
+		h.writeMergePage(w, a, b, nil)
+	}
+}
+
+// PostMerge merges the contacts A and B into the one to Keep, with the values
+// picked (see mergeContacts), then redirects to it; the merge page shows why
+// if they could not be merged.
+func (h contactHTTPHandler) PostMerge(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	a, b, ok := h.findMerging(w, r)
+	if !ok {
+		return
+	}
+	for name, c := range map[string]contact.Contact{"VersionA": a, "VersionB": b} {
+		if version, err := strconv.Atoi(r.PostForm.Get(name)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+			return
+		} else if version != c.Version {
+			h.writeMergePage(w, a, b, fmt.Errorf("the contacts were not merged: they were changed meanwhile, review them again"))
+			return
+		}
+	}
+	merged, other, err := mergeContacts(a, b, r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the values of fields no longer configured are merged too, unchecked
+	checked := apiContactOf(merged)
+	maps.DeleteFunc(checked.Custom, func(name, _ string) bool { return !h.fields.Has(name) })
+	if _, _, fieldErrors := parseContact(checked.values(), h.fields); len(fieldErrors) > 0 {
+		log.Printf("Error validating merged contact: %+v", fieldErrors)
+		h.writeMergePage(w, a, b, fmt.Errorf("the contacts were not merged: %w", describeFieldErrors(fieldErrors)))
+		return
+	}
+	ctx := audit.WithNote(r.Context(), fmt.Sprintf("merged with %s", other.Id))
+	var emailOwnerErr contact.EmailOwnerError
+	if err := duplicate.Merge(ctx, h.contactRepository, merged, other); errors.As(err, &emailOwnerErr) {
+		log.Printf("Error merging contacts: %v", err)
+		h.writeMergePage(w, a, b, fmt.Errorf("the contacts were not merged: the e-mail address %s is in use by another contact", emailOwnerErr.Email))
+	} else if errors.As(err, new(contact.VersionConflictError)) {
+		log.Printf("Error merging contacts: %v", err)
+		h.writeMergePage(w, a, b, fmt.Errorf("the contacts were not merged: they were changed meanwhile, review them again"))
+	} else if err != nil {
+		writeRepositoryError(w, err)
+	} else {
+		log.Printf("Merged %q into %q", other.Id, merged.Id)
+		http.Redirect(w, r, h.paths.Root.Add(CustomerId, merged.Id.String()).String(), http.StatusSeeOther)
+	}
+}
+
+// findMerging finds the contacts with the ids A and B of the form, answering
+// 400 Bad Request if they are invalid, and 404 Not Found if they don't exist.
+func (h contactHTTPHandler) findMerging(w http.ResponseWriter, r *http.Request) (a, b contact.Contact, ok bool) {
+	var cs [2]contact.Contact
+	for i, name := range []string{"A", "B"} {
+		id, err := contact.ParseId(strings.TrimSpace(r.Form.Get(name)))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+			return a, b, false
+		}
+		c, found, err := h.contactRepository.FindById(r.Context(), id)
+		if err != nil {
+			writeRepositoryError(w, err)
+			return a, b, false
+		} else if !found {
+			http.Error(w, fmt.Sprintf("contact %q not found", id), http.StatusNotFound)
+			return a, b, false
+		}
+		cs[i] = c
+	}
+	if cs[0].Id == cs[1].Id {
+		http.Error(w, "a contact can't be merged with itself", http.StatusBadRequest)
+		return a, b, false
+	}
+	return cs[0], cs[1], true
+}
+
+func (h contactHTTPHandler) writeMergePage(w http.ResponseWriter, a, b contact.Contact, mergeErr error) {
+	p := ht.MergePage{
+		A:     a,
+		B:     b,
+		Tags:  contact.SortedTags(append(append([]contact.Tag{}, a.Tags...), b.Tags...)),
+		Error: mergeErr,
+		URLs: ht.MergePageURLs{
+			Merge:      h.paths.Merge.TemplateURL(),
+			Duplicates: h.paths.Duplicates.TemplateURL(),
+		},
+	}
+	if err := ht.WriteMergePage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// mergeContacts makes the contact a and b merge into, and the other one, from
+// the values picked in form: the contact to Keep ("A" or "B"), whose Id and
+// Version the merged contact has; whose FirstName and LastName it has; the
+// Phone, Email, and Address entries it has (eg. "B.0" for the first of b);
+// and its Tags. Entries picked from both are kept once, and one of each kind
+// stays primary (see contact.Contact.NormalizePrimaries). The values of the
+// custom fields are those of the contact kept, or else of the other.
+func mergeContacts(a, b contact.Contact, form url.Values) (merged, other contact.Contact, err error) {
+	pick := func(name string) (contact.Contact, error) {
+		switch form.Get(name) {
+		case "A":
+			return a, nil
+		case "B":
+			return b, nil
+		default:
+			return contact.Contact{}, fmt.Errorf("invalid %s %q: expected A or B", name, form.Get(name))
+		}
+	}
+	keep, err := pick("Keep")
+	if err != nil {
+		return merged, other, err
+	}
+	other = b
+	if keep.Id == b.Id {
+		other = a
+	}
+	first, err := pick("FirstName")
+	if err != nil {
+		return merged, other, err
+	}
+	last, err := pick("LastName")
+	if err != nil {
+		return merged, other, err
+	}
+	merged = contact.Contact{Id: keep.Id, Version: keep.Version, FirstName: first.FirstName, LastName: last.LastName}
+	if merged.Phones, err = pickEntries(form["Phone"], a.Phones, b.Phones, func(e contact.PhoneEntry) string {
+		return cmp.Or(e.Phone.E164(), e.Phone.String())
+	}); err != nil {
+		return merged, other, err
+	}
+	if merged.Emails, err = pickEntries(form["Email"], a.Emails, b.Emails, func(e contact.EmailEntry) string {
+		return e.Email.Canonical()
+	}); err != nil {
+		return merged, other, err
+	}
+	if merged.Addresses, err = pickEntries(form["Address"], a.Addresses, b.Addresses, func(e contact.Address) string {
+		return strings.ToLower(e.String())
+	}); err != nil {
+		return merged, other, err
+	}
+	merged.NormalizePrimaries()
+	for _, s := range form["Tag"] {
+		if t, err := contact.ParseTag(s); err != nil {
+			return merged, other, fmt.Errorf("invalid tag %q: %v", s, err)
+		} else {
+			merged.Tags = append(merged.Tags, t)
+		}
+	}
+	merged.Tags = contact.SortedTags(merged.Tags)
+	if len(keep.Custom)+len(other.Custom) > 0 {
+		merged.Custom = make(map[string]string)
+		maps.Copy(merged.Custom, other.Custom)
+		maps.Copy(merged.Custom, keep.Custom)
+	}
+	return merged, other, nil
+}
+
+// pickEntries returns the entries picked, eg. "A.0" for the first of as,
+// skipping those with the same key as one picked before.
+func pickEntries[E any](picks []string, as, bs []E, key func(E) string) (picked []E, err error) {
+	seen := make(map[string]bool)
+	for _, p := range picks {
+		which, index, _ := strings.Cut(p, ".")
+		entries := map[string][]E{"A": as, "B": bs}[which]
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(entries) {
+			return nil, fmt.Errorf("invalid entry %q", p)
+		}
+		if e := entries[i]; !seen[key(e)] {
+			seen[key(e)] = true
+			picked = append(picked, e)
+		}
+	}
+	return picked, nil
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestDuplicatesAreMerged(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	jon := contact.Contact{Id: contact.NewId(), FirstName: "Jon", LastName: "Smith",
+		Phones: []contact.PhoneEntry{{Primary: true, Phone: contact.MustParsePhone("07911 123456")}},
+		Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("jon@example.com")}},
+		Tags:   []contact.Tag{"friends"}}
+	jonathan := contact.Contact{Id: contact.NewId(), FirstName: "Jonathan", LastName: "Smith",
+		Phones: []contact.PhoneEntry{{Primary: true, Phone: contact.MustParsePhone("+44 7911 123456")}, {Label: contact.Work, Phone: contact.MustParsePhone("020 7946 0000")}},
+		Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("jonathan@example.com")}},
+		Tags:   []contact.Tag{"work"}}
+	if err := repo.Store(ctx, jon, jonathan); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestMux(t, repo)
+	get := func(target string) string {
+		t.Helper()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status %d, got %d", target, http.StatusOK, res.Code)
+		}
+		return res.Body.String()
+	}
+	post := func(form url.Values, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/contact/merge", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("expected status %d, got %d: %s", status, res.Code, res.Body)
+		}
+		return res
+	}
+
+	review := "/contact/merge?" + url.Values{"A": {jon.Id.String()}, "B": {jonathan.Id.String()}}.Encode()
+	if page := get("/contact/duplicates"); !strings.Contains(page, "same phone &#43;447911123456") || !strings.Contains(page, strings.ReplaceAll(review, "&", "&amp;")) {
+		t.Errorf("expected the pair listed, linking its review, got %q", page)
+	}
+	if page := get(review); !strings.Contains(page, `name="Phone" value="B.1"`) || !strings.Contains(page, `name="Tag" value="work"`) {
+		t.Errorf("expected the entries and tags of both contacts to pick from, got %q", page)
+	}
+
+	form := url.Values{
+		"A": {jon.Id.String()}, "VersionA": {"1"}, "B": {jonathan.Id.String()}, "VersionB": {"1"},
+		"Keep": {"A"}, "FirstName": {"B"}, "LastName": {"A"},
+		"Phone": {"A.0", "B.0", "B.1"}, "Email": {"A.0", "B.0"}, "Tag": {"friends", "work"},
+	}
+	stale := url.Values{}
+	for k, v := range form {
+		stale[k] = v
+	}
+	stale.Set("VersionB", "0")
+	if page := post(stale, http.StatusOK).Body.String(); !strings.Contains(page, "changed meanwhile") {
+		t.Errorf("expected the merge to be refused, got %q", page)
+	}
+
+	noEmail := url.Values{}
+	for k, v := range form {
+		noEmail[k] = v
+	}
+	noEmail.Del("Email")
+	if page := post(noEmail, http.StatusOK).Body.String(); !strings.Contains(page, "at least one e-mail address is required") {
+		t.Errorf("expected the merge without e-mails to be refused, got %q", page)
+	}
+	if _, found, _ := repo.FindById(ctx, jonathan.Id); !found {
+		t.Errorf("expected the other contact untouched by a refused merge")
+	}
+
+	res := post(form, http.StatusSeeOther)
+	if location := res.Header().Get("Location"); location != "/contact/?Id="+jon.Id.String() {
+		t.Errorf("expected a redirect to the merged contact, got %q", location)
+	}
+	merged, _, _ := repo.FindById(ctx, jon.Id)
+	if merged.FirstName != "Jonathan" || merged.Version != 2 || len(merged.Phones) != 2 || len(merged.Emails) != 2 ||
+		!slices.Equal(merged.Tags, []contact.Tag{"friends", "work"}) {
+		t.Errorf("expected the values picked, phones once, got %#v", merged)
+	}
+	if !merged.Emails[0].Primary || merged.Emails[1].Primary {
+		t.Errorf("expected only the first e-mail to stay primary, got %#v", merged.Emails)
+	}
+	if _, found, _ := repo.FindDeletedById(ctx, jonathan.Id); !found {
+		t.Errorf("expected the other contact in the trash")
+	}
+}
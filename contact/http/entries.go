@@ -0,0 +1,146 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+	"dev.acorello.it/go/contacts/templates"
+)
+
+// formRow is a row of repeatable entries as submitted by the contact form:
+// each of its inputs (eg. "PhoneKey", "PhoneLabel", "PhoneNumber") is
+// repeated once per row, in the order of the rows.
+type formRow struct {
+	ht.EntryRow
+	values   url.Values
+	position int
+}
+
+func (me formRow) get(name string) string {
+	if values := me.values[me.Kind+name]; me.position < len(values) {
+		return strings.TrimSpace(values[me.position])
+	}
+	return ""
+}
+
+func formRows(values url.Values, kind string) (rows []formRow) {
+	primaryKey := values.Get("Primary" + kind)
+	for i, key := range values[kind+"Key"] {
+		row := formRow{
+			EntryRow: ht.EntryRow{Kind: kind, Key: key, Primary: key == primaryKey},
+			values:   values,
+			position: i,
+		}
+		row.Label = contact.Label(row.get("Label"))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// failEmail sets err on the rows of email, among the rows parseEntries
+// returned for values, and returns it keyed as parseEntries does.
+func failEmail(values url.Values, rows *ht.EntryRows, email contact.Email, err error) templates.ErrorMap {
+	errs := templates.NewErrorMap()
+	i := 0 // position in rows.EmailRows, which has no blank rows
+	for _, row := range formRows(values, "Email") {
+		address := row.get("Address")
+		if address == "" {
+			continue
+		}
+		if e, parseErr := contact.ParseEmail(address); parseErr == nil && e.Canonical() == email.Canonical() {
+			rows.EmailRows[i].Error = err
+			errs[fmt.Sprintf("Emails.%d", row.position)] = err
+		}
+		i++
+	}
+	return errs
+}
+
+func (me formRow) labelError() error {
+	if !me.Label.IsValid() {
+		return fmt.Errorf("unknown label %q", me.Label)
+	}
+	return nil
+}
+
+// parseEntries reads into c the phones, e-mails, and addresses of the contact
+// form, dropping blank rows. It returns the rows as typed, to show them again
+// if invalid; the error of a row is keyed by its kind and its position as
+// submitted, blank rows included (eg. "Emails.1").
+func parseEntries(values url.Values, c *contact.Contact) (rows ht.EntryRows, errs templates.ErrorMap) {
+	errs = templates.NewErrorMap()
+	fail := func(row *ht.EntryRow, key string, err error) {
+		row.Error = err
+		errs[key] = err
+	}
+	for _, row := range formRows(values, "Phone") {
+		phoneRow := ht.PhoneRow{EntryRow: row.EntryRow, Number: row.get("Number")}
+		if phoneRow.Number == "" {
+			continue
+		}
+		errKey := fmt.Sprintf("Phones.%d", row.position)
+		if phone, err := contact.ParsePhone(phoneRow.Number); err != nil {
+			fail(&phoneRow.EntryRow, errKey, err)
+		} else if err := row.labelError(); err != nil {
+			fail(&phoneRow.EntryRow, errKey, err)
+		} else {
+			c.Phones = append(c.Phones, contact.PhoneEntry{Label: row.Label, Primary: row.Primary, Phone: phone})
+		}
+		rows.PhoneRows = append(rows.PhoneRows, phoneRow)
+	}
+	canonicalEmails := make(map[string]bool)
+	for _, row := range formRows(values, "Email") {
+		emailRow := ht.EmailRow{EntryRow: row.EntryRow, Address: row.get("Address")}
+		if emailRow.Address == "" {
+			continue
+		}
+		errKey := fmt.Sprintf("Emails.%d", row.position)
+		if email, err := contact.ParseEmail(emailRow.Address); err != nil {
+			fail(&emailRow.EntryRow, errKey, err)
+		} else if canonicalEmails[email.Canonical()] {
+			fail(&emailRow.EntryRow, errKey, fmt.Errorf("duplicate e-mail address"))
+		} else if err := row.labelError(); err != nil {
+			fail(&emailRow.EntryRow, errKey, err)
+		} else {
+			canonicalEmails[email.Canonical()] = true
+			c.Emails = append(c.Emails, contact.EmailEntry{Label: row.Label, Primary: row.Primary, Email: email})
+		}
+		rows.EmailRows = append(rows.EmailRows, emailRow)
+	}
+	if len(rows.EmailRows) == 0 {
+		errs["Emails"] = fmt.Errorf("at least one e-mail address is required")
+	}
+	for _, row := range formRows(values, "Address") {
+		addressRow := ht.AddressRow{
+			EntryRow:   row.EntryRow,
+			Street:     row.get("Street"),
+			City:       row.get("City"),
+			PostalCode: row.get("PostalCode"),
+			Region:     row.get("Region"),
+			Country:    row.get("Country"),
+		}
+		address := contact.Address{
+			Label:      row.Label,
+			Primary:    row.Primary,
+			Street:     addressRow.Street,
+			City:       addressRow.City,
+			PostalCode: addressRow.PostalCode,
+			Region:     addressRow.Region,
+			Country:    addressRow.Country,
+		}
+		if address.IsZero() {
+			continue
+		}
+		if err := row.labelError(); err != nil {
+			fail(&addressRow.EntryRow, fmt.Sprintf("Addresses.%d", row.position), err)
+		} else {
+			c.Addresses = append(c.Addresses, address)
+		}
+		rows.AddressRows = append(rows.AddressRows, addressRow)
+	}
+	c.NormalizePrimaries()
+	return rows, errs
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/csv"
+	"dev.acorello.it/go/contacts/contact/vcard"
+)
+
+// exportPageSize is the number of contacts read from the repository at a time by GetExport
+const exportPageSize = 50
+
+// exportWriter writes the contacts exported one by one
+type exportWriter interface {
+	Write(c contact.Contact) error
+	// Flush writes what is buffered, at the end of each page
+	Flush() error
+	// Close ends the export, without closing the underlying io.Writer
+	Close() error
+}
+
+type exportFormat struct {
+	contentType, extension string
+	newWriter              func(w io.Writer) (exportWriter, error)
+}
+
+// exportFormats are keyed by the Format parameter of GetExport
+var exportFormats = map[string]exportFormat{
+	"vcard": {vcard.MediaType + "; charset=utf-8", "vcf", func(w io.Writer) (exportWriter, error) {
+		return vCardExportWriter{w}, nil
+	}},
+	"csv": {csv.MediaType + "; charset=utf-8; header=present", "csv", func(w io.Writer) (exportWriter, error) {
+		cw, err := csv.NewWriter(w)
+		return csvExportWriter{cw}, err
+	}},
+	"json": {"application/json", "json", func(w io.Writer) (exportWriter, error) {
+		return &jsonExportWriter{w: w, enc: json.NewEncoder(w)}, nil
+	}},
+}
+
+func exportURL(export Path, searchTerm string, order contact.Order, format string) template.URL {
+	if searchTerm != "" {
+		export = export.Add("SearchTerm", searchTerm)
+	}
+	if order != (contact.Order{}) {
+		export = export.Add("Sort", order.String())
+	}
+	return export.Add("Format", format).TemplateURL()
+}
+
+// GetExport downloads, in the Format requested (vCard by default, CSV, or
+// JSON), the contact with the given Id or, if none is given, all the contacts
+// matching SearchTerm, or all contacts if blank, in the list's Sort or by last
+// name, rather than by relevance, which would rank all the matches for each
+// page. These are streamed as read from the repository, a page at a time.
+func (h contactHTTPHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	formatName := q.Get("Format")
+	if formatName == "" {
+		formatName = "vcard"
+	}
+	format, found := exportFormats[formatName]
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown format %q", formatName), http.StatusBadRequest)
+		return
+	}
+	if q.Has(CustomerId) {
+		h.exportContact(w, r, q.Get(CustomerId), format)
+		return
+	}
+	searchTerm := strings.TrimSpace(q.Get("SearchTerm"))
+	order, err := contact.ParseOrder(q.Get("Sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order.By = order.Field()
+	var export exportWriter
+	exported := 0
+	for page, more := (contact.Page{Size: exportPageSize, Order: order}), true; more; {
+		var contacts []contact.Contact
+		var queryErr, err error
+		contacts, page, more, queryErr, err = findContacts(r.Context(), h.contactRepository, searchTerm, page)
+		if queryErr != nil {
+			http.Error(w, queryErr.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil && export == nil {
+			writeRepositoryError(w, err)
+			return
+		} else if err != nil {
+			log.Printf("export interrupted after %d contacts: %v", exported, err)
+			return // too late to change the status
+		}
+		if export == nil {
+			writeExportHeader(w, format, "contacts")
+			if export, err = format.newWriter(w); err != nil {
+				log.Printf("error exporting: %v", err)
+				return
+			}
+		}
+		if err := writePage(w, export, contacts); err != nil {
+			log.Printf("error exporting: %v", err)
+			return
+		}
+		exported += len(contacts)
+	}
+	if err := export.Close(); err != nil {
+		log.Printf("error exporting: %v", err)
+	}
+}
+
+// writePage writes the contacts of a page, then flushes them to the client
+func writePage(w http.ResponseWriter, export exportWriter, contacts []contact.Contact) error {
+	for _, c := range contacts {
+		if err := export.Write(c); err != nil {
+			return err
+		}
+	}
+	if err := export.Flush(); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (h contactHTTPHandler) exportContact(w http.ResponseWriter, r *http.Request, _id string, format exportFormat) {
+	if id, err := contact.ParseId(_id); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse id %q: %v", _id, err), http.StatusBadRequest)
+	} else if theContact, found, err := h.contactRepository.FindById(r.Context(), id); err != nil {
+		writeRepositoryError(w, err)
+	} else if !found {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		writeExportHeader(w, format, exportFileName(theContact))
+		export, err := format.newWriter(w)
+		if err == nil {
+			err = writePage(w, export, []contact.Contact{theContact})
+		}
+		if err == nil {
+			err = export.Close()
+		}
+		if err != nil {
+			log.Printf("error exporting: %v", err)
+		}
+	}
+}
+
+// writeExportHeader names the file in the Content-Disposition header; a name
+// that isn't ASCII is sent as the UTF-8 filename* of RFC 6266, with an ASCII
+// filename, its other letters as '_', for the clients lacking support.
+func writeExportHeader(w http.ResponseWriter, format exportFormat, fileName string) {
+	w.Header().Set("Content-Type", format.contentType)
+	name := fileName + "." + format.extension
+	ascii := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, name)
+	disposition := fmt.Sprintf("attachment; filename=%q", ascii)
+	if ascii != name {
+		var encoded strings.Builder
+		for _, b := range []byte(name) {
+			if b > unicode.MaxASCII {
+				fmt.Fprintf(&encoded, "%%%02X", b)
+			} else {
+				encoded.WriteByte(b)
+			}
+		}
+		disposition += "; filename*=UTF-8''" + encoded.String()
+	}
+	w.Header().Set("Content-Disposition", disposition)
+}
+
+// exportFileName is the name of the contact, eg. "Bloggs_Joe", only with
+// letters, digits, '-', and '_', safe in a Content-Disposition header once
+// encoded by writeExportHeader.
+func exportFileName(c contact.Contact) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '_'
+		default:
+			return -1
+		}
+	}, c.LastName+" "+c.FirstName)
+}
+
+type vCardExportWriter struct {
+	w io.Writer
+}
+
+func (me vCardExportWriter) Write(c contact.Contact) error {
+	return vcard.Write(me.w, c)
+}
+
+func (me vCardExportWriter) Flush() error { return nil }
+func (me vCardExportWriter) Close() error { return nil }
+
+type csvExportWriter struct {
+	*csv.Writer
+}
+
+func (me csvExportWriter) Close() error {
+	return me.Flush()
+}
+
+// jsonExportWriter writes an array of contacts, as represented by the JSON API
+type jsonExportWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (me *jsonExportWriter) Write(c contact.Contact) error {
+	separator := ","
+	if me.count == 0 {
+		separator = "["
+	}
+	me.count++
+	if _, err := io.WriteString(me.w, separator); err != nil {
+		return err
+	}
+	return me.enc.Encode(apiContactOf(c))
+}
+
+func (me *jsonExportWriter) Flush() error { return nil }
+
+func (me *jsonExportWriter) Close() error {
+	end := "]\n"
+	if me.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(me.w, end)
+	return err
+}
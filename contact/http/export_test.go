@@ -0,0 +1,94 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// pageCountingRepository counts the pages read by FindAll and FindByQuery
+type pageCountingRepository struct {
+	contact.Repository
+	pages []contact.Page
+}
+
+func (me *pageCountingRepository) FindAll(ctx context.Context, page contact.Page) ([]contact.Contact, contact.Page, bool, error) {
+	me.pages = append(me.pages, page)
+	return me.Repository.FindAll(ctx, page)
+}
+
+func (me *pageCountingRepository) FindByQuery(ctx context.Context, q contact.Query, page contact.Page) ([]contact.Contact, contact.Page, bool, error) {
+	me.pages = append(me.pages, page)
+	return me.Repository.FindByQuery(ctx, q, page)
+}
+
+func TestExportStreamsAllPages(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	const size = 2*exportPageSize + 20
+	var contacts []contact.Contact
+	for i := range size {
+		contacts = append(contacts, contact.Contact{Id: contact.NewId(), FirstName: "First", LastName: fmt.Sprint("Last", i),
+			Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail(fmt.Sprintf("c%d@example.com", i))}}})
+	}
+	inMemory := contact.NewInMemoryContactRepository()
+	if err := inMemory.Store(ctx, contacts...); err != nil {
+		t.Fatal(err)
+	}
+	repo := &pageCountingRepository{Repository: inMemory}
+	mux := newTestMux(t, repo)
+
+	export := func(target string) string {
+		repo.pages = nil
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", target, res.Code)
+		}
+		if len(repo.pages) != 3 || repo.pages[2].After == "" {
+			t.Errorf("%s: expected 3 pages to be read, got %v", target, repo.pages)
+		}
+		return res.Body.String()
+	}
+
+	if lines := strings.Count(export("/contact/export?SearchTerm=first&Format=csv"), "\n"); lines != size+1 {
+		t.Errorf("expected %d CSV lines, header included, got %d", size+1, lines)
+	}
+	if order := repo.pages[1].Order; order != (contact.Order{By: contact.ByLastName}) {
+		t.Errorf("expected the matches by last name, not ranked for each page, got %v", order)
+	}
+	var exported []apiContact
+	if err := json.Unmarshal([]byte(export("/contact/export?Format=json")), &exported); err != nil || len(exported) != size {
+		t.Errorf("expected %d contacts, got %d (err: %v)", size, len(exported), err)
+	}
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/contact/export?SearchTerm=nobody&Format=json", nil))
+	if strings.TrimSpace(res.Body.String()) != "[]" {
+		t.Errorf("expected an empty array, got %q", res.Body)
+	}
+}
+
+func TestExportFileNameIsEncoded(t *testing.T) {
+	for name, expected := range map[[2]string]string{
+		{"Joe", "Bloggs"}: `attachment; filename="Bloggs_Joe.vcf"`,
+		{"Zoë", "Müller"}: `attachment; filename="M_ller_Zo_.vcf"; filename*=UTF-8''M%C3%BCller_Zo%C3%AB.vcf`,
+	} {
+		res := httptest.NewRecorder()
+		writeExportHeader(res, exportFormats["vcard"], exportFileName(contact.Contact{FirstName: name[0], LastName: name[1]}))
+		if disposition := res.Header().Get("Content-Disposition"); disposition != expected {
+			t.Errorf("expected %s, got %s", expected, disposition)
+		}
+	}
+}
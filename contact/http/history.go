@@ -0,0 +1,106 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+)
+
+// GetHistory lists the changes of the contact, most recent first
+func (h contactHTTPHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	if id, ok := submittedId(w, r); ok {
+		h.writeHistoryPage(w, r, id, nil)
+	}
+}
+
+// PostHistory reverts the contact to the Version given, storing it as it was
+// then; the history shows why if it could not be reverted.
+func (h contactHTTPHandler) PostHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := submittedId(w, r)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(r.Form.Get("Version"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid Version: %v", err), http.StatusBadRequest)
+		return
+	}
+	entries, err := h.auditLog.History(r.Context(), id)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	i := slices.IndexFunc(entries, func(e audit.Entry) bool { return e.Contact.Version == version && e.Action.Stored() })
+	if i < 0 {
+		http.Error(w, fmt.Sprintf("no version %d of contact %q", version, id), http.StatusNotFound)
+		return
+	}
+	current, found, err := h.contactRepository.FindById(r.Context(), id)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	} else if !found {
+		h.writeHistoryPage(w, r, id, fmt.Errorf("the contact was not reverted: it is deleted"))
+		return
+	}
+	reverted := entries[i].Contact.Clone()
+	reverted.Version = current.Version
+	ctx := audit.WithNote(r.Context(), fmt.Sprintf("reverted to version %d", version))
+	var emailOwnerErr contact.EmailOwnerError
+	if err := h.contactRepository.Store(ctx, reverted); errors.As(err, &emailOwnerErr) {
+		log.Printf("Error reverting contact: %v", err)
+		h.writeHistoryPage(w, r, id, fmt.Errorf("the contact was not reverted: its e-mail address %s is now in use by another contact", emailOwnerErr.Email))
+	} else if errors.As(err, new(contact.VersionConflictError)) {
+		log.Printf("Error reverting contact: %v", err)
+		h.writeHistoryPage(w, r, id, fmt.Errorf("the contact was not reverted: it was changed meanwhile"))
+	} else if err != nil {
+		writeRepositoryError(w, err)
+	} else {
+		log.Printf("Reverted %q to version %d", id, version)
+		http.Redirect(w, r, h.paths.History.Add(CustomerId, id.String()).String(), http.StatusSeeOther)
+	}
+}
+
+func (h contactHTTPHandler) writeHistoryPage(w http.ResponseWriter, r *http.Request, id contact.Id, revertErr error) {
+	entries, err := h.auditLog.History(r.Context(), id)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	current, found, err := h.contactRepository.FindById(r.Context(), id)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	} else if !found && len(entries) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_id := id.String()
+	p := ht.HistoryPage{
+		Contact: current,
+		Error:   revertErr,
+		URLs:    ht.HistoryPageURLs{ContactList: h.paths.List.TemplateURL()},
+	}
+	if found {
+		p.URLs.Contact = h.paths.Root.Add(CustomerId, _id).TemplateURL()
+	} else {
+		p.Contact = entries[len(entries)-1].Contact // as it was when last changed
+	}
+	for _, e := range slices.Backward(entries) {
+		row := ht.HistoryRow{Entry: e}
+		if found && e.Action.Stored() && e.Contact.Version != current.Version {
+			row.Revert = h.paths.History.Add(CustomerId, _id).Add("Version", strconv.Itoa(e.Contact.Version)).TemplateURL()
+		}
+		p.Rows = append(p.Rows, row)
+	}
+	if err := ht.WriteHistoryPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestHistoryAndRevert(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	mux := newTestMux(t, repo)
+	do := func(req *http.Request, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("%s %s: expected status %d, got %d", req.Method, req.URL, status, res.Code)
+		}
+		return res
+	}
+	id := contact.NewId()
+	_id := id.String()
+	post := func(version, lastName string) {
+		t.Helper()
+		form := url.Values{
+			"Id": {_id}, "Version": {version}, "FirstName": {"Joe"}, "LastName": {lastName},
+			"EmailKey": {"e"}, "EmailAddress": {"joe@example.com"}, "PrimaryEmail": {"e"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/contact/form", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		do(req, http.StatusFound)
+	}
+	post("0", "Bloggs")
+	post("1", "Smith")
+
+	if page := do(httptest.NewRequest(http.MethodGet, "/contact/?Id="+_id, nil), http.StatusOK).Body.String(); !strings.Contains(page, `href="/contact/history?Id=`+_id+`"`) {
+		t.Errorf("expected the contact page to link its history")
+	}
+	page := do(httptest.NewRequest(http.MethodGet, "/contact/history?Id="+_id, nil), http.StatusOK).Body.String()
+	for _, expected := range []string{"<td>Last Name</td>", "<td>Bloggs</td>", "<td>Smith</td>",
+		`hx-post="/contact/history?Id=` + _id + `&amp;Version=1"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the history", expected)
+		}
+	}
+	if strings.Contains(page, "Version=2") {
+		t.Errorf("expected no revert to the current version")
+	}
+
+	location := do(httptest.NewRequest(http.MethodPost, "/contact/history?Id="+_id+"&Version=1", nil), http.StatusSeeOther).Header().Get("Location")
+	if stored, _, _ := repo.FindById(ctx, id); stored.LastName != "Bloggs" || stored.Version != 3 {
+		t.Errorf("expected the contact reverted at version 3, got %#v", stored)
+	}
+	if page := do(httptest.NewRequest(http.MethodGet, location, nil), http.StatusOK).Body.String(); !strings.Contains(page, "reverted to version 1") {
+		t.Errorf("expected the history to note the revert")
+	}
+	do(httptest.NewRequest(http.MethodPost, "/contact/history?Id="+_id+"&Version=9", nil), http.StatusNotFound)
+	do(httptest.NewRequest(http.MethodGet, "/contact/history?Id="+contact.NewId().String(), nil), http.StatusNotFound)
+}
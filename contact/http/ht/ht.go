@@ -2,12 +2,17 @@ package ht
 
 import (
 	"embed"
+	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	"slices"
+	"strings"
 
 	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+	"dev.acorello.it/go/contacts/contact/duplicate"
 	"dev.acorello.it/go/contacts/seq"
 	"dev.acorello.it/go/contacts/templates"
 )
@@ -17,12 +22,28 @@ var myTemplates embed.FS
 
 var contactTemplate,
 	contactFormTemplate,
-	contactListTemplate *template.Template
+	contactListTemplate,
+	contactImportTemplate,
+	contactCSVImportTemplate,
+	contactTrashTemplate,
+	contactHistoryTemplate,
+	contactTagsTemplate,
+	contactDuplicatesTemplate,
+	contactMergeTemplate,
+	contactTenantsTemplate *template.Template
 
 func init() {
 	contactTemplate = makeTemplate(myTemplates, "contact.html")
 	contactListTemplate = makeTemplate(myTemplates, "contact_list.html")
 	contactFormTemplate = makeTemplate(myTemplates, "contact_form.html")
+	contactImportTemplate = makeTemplate(myTemplates, "contact_import.html")
+	contactCSVImportTemplate = makeTemplate(myTemplates, "contact_csv_import.html")
+	contactTrashTemplate = makeTemplate(myTemplates, "contact_trash.html")
+	contactHistoryTemplate = makeTemplate(myTemplates, "contact_history.html")
+	contactTagsTemplate = makeTemplate(myTemplates, "contact_tags.html")
+	contactDuplicatesTemplate = makeTemplate(myTemplates, "contact_duplicates.html")
+	contactMergeTemplate = makeTemplate(myTemplates, "contact_merge.html")
+	contactTenantsTemplate = makeTemplate(myTemplates, "contact_tenants.html")
 }
 
 func makeTemplate(files fs.FS, templateFile string) *template.Template {
@@ -35,56 +56,619 @@ func makeTemplate(files fs.FS, templateFile string) *template.Template {
 
 type ContactPageURLs struct {
 	ContactList, ContactForm template.URL
+	// Export downloads the contact as a vCard
+	Export template.URL
+	// History lists the changes of the contact
+	History template.URL
 }
 
 type ContactFormPageURLs struct {
 	PatchContactEmail, DeleteContact, ContactList, ContactForm template.URL
+	// NewPhoneRow, NewEmailRow, NewAddressRow return a blank row to add to the form
+	NewPhoneRow, NewEmailRow, NewAddressRow template.URL
 }
 
-func WriteContact(w io.Writer, c contact.Contact, u ContactPageURLs) error {
+// WriteContact also shows the values c has of the custom fields
+func WriteContact(w io.Writer, c contact.Contact, fields contact.CustomFields, u ContactPageURLs) error {
+	var custom []CustomValue
+	for _, f := range fields {
+		if v := c.Custom[f.Name]; v != "" {
+			custom = append(custom, CustomValue{CustomField: f, Value: v})
+		}
+	}
 	return contactTemplate.Execute(w, map[string]any{
 		"Contact": c,
+		"Custom":  custom,
 		"URLs":    u,
 	})
 }
 
+// CustomValue is the value of a custom field, as typed in the form or as stored
+type CustomValue struct {
+	contact.CustomField
+	Value string
+	Error error
+}
+
+// Input is the type of the input of the value, for the field types having one
+func (my CustomValue) Input() string {
+	switch my.Type {
+	case contact.DateType:
+		return "date"
+	case contact.NumberType:
+		return "number"
+	case contact.URLType:
+		return "url"
+	default:
+		return "text"
+	}
+}
+
 type ContactForm struct {
 	contact.Contact
+	// EntryRows hold the phones, e-mails, and addresses as typed, valid or not
+	EntryRows
 	Errors templates.ErrorMap
+	// Fields are the custom fields of the installation, whose inputs are
+	// named "Custom." and the field Name (see CustomRows)
+	Fields contact.CustomFields
+	// Stored is the contact as stored, if it changed since the Version the form
+	// was submitted for; its Id is blank if it was deleted meanwhile.
+	Stored *contact.Contact
+}
+
+// ConflictRow shows a field as submitted, side by side with its Stored value;
+// fields with entries have one value for each.
+type ConflictRow struct {
+	Field         string
+	Yours, Stored []string
+}
+
+func (me ConflictRow) Differs() bool {
+	return !slices.Equal(me.Yours, me.Stored)
+}
+
+// Conflict compares the values submitted with the Stored ones, field by field
+func (my ContactForm) Conflict() []ConflictRow {
+	if my.Stored == nil {
+		return nil
+	}
+	yours, stored := my.Contact, *my.Stored
+	rows := []ConflictRow{
+		{"First Name", []string{yours.FirstName}, []string{stored.FirstName}},
+		{"Last Name", []string{yours.LastName}, []string{stored.LastName}},
+		{"Emails", entryTexts(yours.Emails), entryTexts(stored.Emails)},
+		{"Phones", entryTexts(yours.Phones), entryTexts(stored.Phones)},
+		{"Addresses", entryTexts(yours.Addresses), entryTexts(stored.Addresses)},
+		{"Tags", tagTexts(yours.Tags), tagTexts(stored.Tags)},
+	}
+	for _, f := range my.Fields {
+		rows = append(rows, ConflictRow{f.Label, valueTexts(yours.Custom[f.Name]), valueTexts(stored.Custom[f.Name])})
+	}
+	return rows
+}
+
+func valueTexts(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// CustomRows are the inputs of the custom fields, with their values as typed
+func (my ContactForm) CustomRows() (rows []CustomValue) {
+	for _, f := range my.Fields {
+		v, typed := my.CustomText[f.Name]
+		if !typed {
+			v = my.Custom[f.Name]
+		}
+		rows = append(rows, CustomValue{CustomField: f, Value: v, Error: my.Errors["Custom."+f.Name]})
+	}
+	return rows
+}
+
+// UnknownCustomErrors are the errors of the custom values submitted for fields
+// no longer configured, which have no input to show them next to
+func (my ContactForm) UnknownCustomErrors() (errs []string) {
+	for name, err := range my.Errors {
+		if field, ok := strings.CutPrefix(name, "Custom."); ok && !my.Fields.Has(field) {
+			errs = append(errs, fmt.Sprintf("%s: %v", field, err))
+		}
+	}
+	slices.Sort(errs)
+	return errs
+}
+
+func tagTexts(tags []contact.Tag) (texts []string) {
+	for _, t := range tags {
+		texts = append(texts, string(t))
+	}
+	return texts
+}
+
+// entryTexts describes entries as the contact page does, eg. "joe@example.com (home) ★"
+func entryTexts[E contact.EmailEntry | contact.PhoneEntry | contact.Address](entries []E) (texts []string) {
+	for _, e := range entries {
+		var text string
+		var label contact.Label
+		var primary bool
+		switch e := any(e).(type) {
+		case contact.EmailEntry:
+			text, label, primary = e.Email.String(), e.Label, e.Primary
+		case contact.PhoneEntry:
+			text, label, primary = e.Phone.String(), e.Label, e.Primary
+		case contact.Address:
+			text, label, primary = e.String(), e.Label, e.Primary
+		}
+		if label != "" {
+			text += " (" + string(label) + ")"
+		}
+		if primary {
+			text += " ★"
+		}
+		texts = append(texts, text)
+	}
+	return texts
 }
 
 func NewFormWith(c contact.Contact) ContactForm {
 	return ContactForm{
-		Contact: c,
-		Errors:  templates.NewErrorMap(),
+		Contact:   c,
+		EntryRows: EntryRowsOf(c),
+		Errors:    templates.NewErrorMap(),
 	}
 }
 
+// NewForm returns a blank form, with a blank row for each kind of entry
 func NewForm() ContactForm {
 	return ContactForm{
+		EntryRows: EntryRows{
+			PhoneRows:   []PhoneRow{NewPhoneRow()},
+			EmailRows:   []EmailRow{NewEmailRow()},
+			AddressRows: []AddressRow{NewAddressRow()},
+		},
 		Errors: templates.NewErrorMap(),
 	}
 }
 
+// EntryRow is what the rows of the repeatable entries of the contact form have in common
+type EntryRow struct {
+	// Kind is "Phone", "Email", or "Address", the prefix of the names of the inputs of the row
+	Kind string
+	// Key identifies the row within the form, it is the value of its "primary" radio button
+	Key     string
+	Label   contact.Label
+	Primary bool
+	Error   error
+}
+
+func newEntryRow(kind string, label contact.Label, primary bool) EntryRow {
+	return EntryRow{Kind: kind, Key: contact.NewId().String(), Label: label, Primary: primary}
+}
+
+// Labels are the options of the label of the row
+func (EntryRow) Labels() []contact.Label {
+	return contact.Labels
+}
+
+type PhoneRow struct {
+	EntryRow
+	Number string
+}
+
+type EmailRow struct {
+	EntryRow
+	Address string
+	// CheckURL validates Address as it changes (see PatchContactEmail)
+	CheckURL template.URL
+}
+
+type AddressRow struct {
+	EntryRow
+	Street, City, PostalCode, Region, Country string
+}
+
+func NewPhoneRow() PhoneRow {
+	return PhoneRow{EntryRow: newEntryRow("Phone", contact.Mobile, false)}
+}
+
+func NewEmailRow() EmailRow {
+	return EmailRow{EntryRow: newEntryRow("Email", contact.Home, false)}
+}
+
+func NewAddressRow() AddressRow {
+	return AddressRow{EntryRow: newEntryRow("Address", contact.Home, false)}
+}
+
+type EntryRows struct {
+	PhoneRows   []PhoneRow
+	EmailRows   []EmailRow
+	AddressRows []AddressRow
+	// TagText is the tags as typed, separated by commas
+	TagText string
+	// CustomText is the values of the custom fields as typed, by field Name
+	CustomText map[string]string
+}
+
+func EntryRowsOf(c contact.Contact) (rows EntryRows) {
+	rows.TagText = strings.Join(tagTexts(c.Tags), ", ")
+	for _, p := range c.Phones {
+		rows.PhoneRows = append(rows.PhoneRows, PhoneRow{
+			EntryRow: newEntryRow("Phone", p.Label, p.Primary),
+			Number:   p.Phone.String(),
+		})
+	}
+	for _, e := range c.Emails {
+		rows.EmailRows = append(rows.EmailRows, EmailRow{
+			EntryRow: newEntryRow("Email", e.Label, e.Primary),
+			Address:  e.Email.String(),
+		})
+	}
+	for _, a := range c.Addresses {
+		rows.AddressRows = append(rows.AddressRows, AddressRow{
+			EntryRow:   newEntryRow("Address", a.Label, a.Primary),
+			Street:     a.Street,
+			City:       a.City,
+			PostalCode: a.PostalCode,
+			Region:     a.Region,
+			Country:    a.Country,
+		})
+	}
+	return rows
+}
+
 type ContactFormPage struct {
 	ContactForm
 	URLs ContactFormPageURLs
 }
 
+// WriteContactForm also sets the CheckURL of the e-mail rows
 func WriteContactForm(w io.Writer, c ContactFormPage) error {
+	c.EmailRows = slices.Clone(c.EmailRows)
+	for i := range c.EmailRows {
+		c.EmailRows[i].CheckURL = c.URLs.PatchContactEmail
+	}
 	return contactFormTemplate.Execute(w, c)
 }
 
+// WritePhoneRow, WriteEmailRow, and WriteAddressRow write a row of the contact form, to add it to the form.
+func WritePhoneRow(w io.Writer, row PhoneRow) error {
+	return contactFormTemplate.ExecuteTemplate(w, "phone_row", row)
+}
+
+func WriteEmailRow(w io.Writer, row EmailRow) error {
+	return contactFormTemplate.ExecuteTemplate(w, "email_row", row)
+}
+
+func WriteAddressRow(w io.Writer, row AddressRow) error {
+	return contactFormTemplate.ExecuteTemplate(w, "address_row", row)
+}
+
 type SearchPage struct {
 	SearchTerm string
-	Contacts   []contact.Contact
-	URLs       SearchPageURLs
+	// SearchError explains why SearchTerm is not a valid query
+	SearchError error
+	Contacts    []contact.Contact
+	// Deleted is the contact just deleted, which URLs.Undo restores
+	Deleted *contact.DeletedContact
+	// Tags are all the tags, with how many contacts have each
+	Tags []contact.TagCount
+	// Sort is the order of the contacts (see contact.ParseOrder), blank by
+	// default; Columns are the headers sorting them
+	Sort    string
+	Columns []SortColumn
+	// CustomColumns are the custom fields listed after Columns (see contact.CustomFields.Listed)
+	CustomColumns contact.CustomFields
+	// User is the name of the user signed in, whom URLs.Logout signs out
+	User string
+	// Tenant names the address book listed, if there are tenants
+	Tenant string
+	URLs   SearchPageURLs
+}
+
+// ColumnCount is the number of columns of the list, for a cell spanning all of them
+func (my SearchPage) ColumnCount() int {
+	return len(my.Columns) + len(my.CustomColumns) + 3 // selection, tags, and links
+}
+
+// SortColumn is a header of the contact list
+type SortColumn struct {
+	Label string
+	// Sorted is "ascending" or "descending" if the list is sorted by the column, as aria-sort
+	Sorted string
+	// URL sorts the list by the column, ascending unless it already is
+	URL template.URL
 }
 
 type SearchPageURLs struct {
 	NextPage template.URL
+	// Undo restores the Deleted contact; Trash lists the deleted contacts
+	Undo, Trash template.URL
+	// Export downloads the contacts found, of all pages, as vCards; Import uploads vCards
+	Export, Import template.URL
+	// ExportCSV and ExportJSON download the contacts found, of all pages, as CSV and JSON
+	ExportCSV, ExportJSON template.URL
+	// ImportCSV uploads a CSV file
+	ImportCSV template.URL
+	// Batch applies an action to the contacts selected
+	Batch template.URL
+	// Tags renames and merges the tags
+	Tags template.URL
+	// Duplicates lists the contacts suspected to be duplicates
+	Duplicates template.URL
+	Logout     template.URL
+	// Tenants lists the tenants, to admins
+	Tenants template.URL
 }
 
 func WriteContactList(w io.Writer, s SearchPage) error {
 	return contactListTemplate.Execute(w, s)
 }
+
+// BatchResult tells what became of the contacts selected in the list
+type BatchResult struct {
+	Message string
+	// Error explains why the action was applied to none of the contacts
+	Error error
+	// Deleted are the contacts whose rows are to be removed from the list
+	Deleted []contact.Id
+	URLs    BatchResultURLs
+}
+
+type BatchResultURLs struct {
+	// Show lists the contacts the action was applied to, eg. the trash
+	Show template.URL
+}
+
+func WriteBatchResult(w io.Writer, r BatchResult) error {
+	return contactListTemplate.ExecuteTemplate(w, "batch_result", r)
+}
+
+type ImportPage struct {
+	// Error explains why the upload could not be read
+	Error   error
+	Results []ImportResult
+	// Replace tells if cards with the id of an existing contact replace it
+	Replace bool
+	URLs    ImportPageURLs
+}
+
+// ImportResult tells what became of a card of the file imported
+type ImportResult struct {
+	// Card is the position of the card in the file, from 1
+	Card int
+	Name string
+	// ContactURL is blank if the card was not imported
+	ContactURL template.URL
+	Error      error
+}
+
+type ImportPageURLs struct {
+	Import, ContactList template.URL
+}
+
+func WriteImportPage(w io.Writer, p ImportPage) error {
+	return contactImportTemplate.Execute(w, p)
+}
+
+// CSVFields are the fields a column of a CSV file can be imported as, besides the custom fields
+var CSVFields = []string{"FirstName", "LastName", "Phone", "Email"}
+
+// CSVField is a field a column of a CSV file can be imported as
+type CSVField struct {
+	// Name is one of CSVFields, or "Custom." and the Name of a custom field
+	Name, Label string
+}
+
+// CSVFieldsWith are the CSVFields, then the custom fields
+func CSVFieldsWith(custom contact.CustomFields) (fields []CSVField) {
+	for _, name := range CSVFields {
+		fields = append(fields, CSVField{Name: name, Label: name})
+	}
+	for _, f := range custom {
+		fields = append(fields, CSVField{Name: "Custom." + f.Name, Label: f.Label})
+	}
+	return fields
+}
+
+type CSVImportPageURLs struct {
+	// Preview returns the CSVPreview of the uploaded file, or of its Data as mapped
+	Preview template.URL
+	// Import imports the Data as mapped, returning CSVResults
+	Import      template.URL
+	ContactList template.URL
+}
+
+type CSVColumn struct {
+	Header string
+	// Field is the Name of a CSVField, or blank if the column is not imported
+	Field string
+}
+
+type CSVRow struct {
+	Line  int
+	Cells []string
+	Error error
+}
+
+// CSVRows are rows of a CSV file, under the headers of its columns
+type CSVRows struct {
+	Columns []CSVColumn
+	Rows    []CSVRow
+}
+
+// CSVPreview shows how the rows of a CSV file would be imported
+type CSVPreview struct {
+	// Data is the CSV file uploaded, submitted again along with its mapping
+	Data string
+	// Error explains why Data could not be read
+	Error     error
+	HasHeader bool
+	Columns   []CSVColumn
+	// Fields are those the columns can be imported as
+	Fields []CSVField
+	// Sample are the first rows, Invalid all those that would be skipped
+	Sample, Invalid CSVRows
+	Total, Valid    int
+	// ImportError explains why the rows can not be imported as mapped, or why
+	// the last attempt to import them failed
+	ImportError error
+	URLs        CSVImportPageURLs
+}
+
+type CSVResults struct {
+	Imported int
+	Skipped  CSVRows
+	URLs     CSVImportPageURLs
+}
+
+func WriteCSVImportPage(w io.Writer, urls CSVImportPageURLs) error {
+	return contactCSVImportTemplate.Execute(w, map[string]any{"URLs": urls})
+}
+
+func WriteCSVPreview(w io.Writer, p CSVPreview) error {
+	return contactCSVImportTemplate.ExecuteTemplate(w, "csv_preview", p)
+}
+
+func WriteCSVResults(w io.Writer, r CSVResults) error {
+	return contactCSVImportTemplate.ExecuteTemplate(w, "csv_results", r)
+}
+
+type TrashPage struct {
+	Rows []TrashRow
+	// Error explains why the last contact could not be restored
+	Error error
+	URLs  TrashPageURLs
+}
+
+type TrashRow struct {
+	contact.DeletedContact
+	Restore, Purge template.URL
+}
+
+type TrashPageURLs struct {
+	NextPage, ContactList template.URL
+}
+
+func WriteTrashPage(w io.Writer, p TrashPage) error {
+	return contactTrashTemplate.Execute(w, p)
+}
+
+type HistoryPage struct {
+	// Contact is as stored now, or as it was when last changed if deleted
+	Contact contact.Contact
+	// Rows are the changes of the contact, most recent first
+	Rows []HistoryRow
+	// Error explains why the contact could not be reverted
+	Error error
+	URLs  HistoryPageURLs
+}
+
+type HistoryRow struct {
+	audit.Entry
+	// Revert reverts the contact to the version stored by the change, if it can be
+	Revert template.URL
+}
+
+type HistoryPageURLs struct {
+	// Contact is blank if the contact is deleted
+	Contact, ContactList template.URL
+}
+
+func WriteHistoryPage(w io.Writer, p HistoryPage) error {
+	return contactHistoryTemplate.Execute(w, p)
+}
+
+type TagsPage struct {
+	Tags []contact.TagCount
+	// Error explains why the tags could not be renamed
+	Error error
+	URLs  TagsPageURLs
+}
+
+type TagsPageURLs struct {
+	// Rename renames (POST) the tags From, To the one given
+	Rename, ContactList template.URL
+}
+
+func WriteTagsPage(w io.Writer, p TagsPage) error {
+	return contactTagsTemplate.Execute(w, p)
+}
+
+type DuplicatesPage struct {
+	// Rows are the suspected duplicates, most likely first
+	Rows []DuplicateRow
+	URLs DuplicatesPageURLs
+}
+
+type DuplicateRow struct {
+	duplicate.Pair
+	// Percent is the Score, as a percentage
+	Percent int
+	// Merge reviews the pair, to merge it
+	Merge template.URL
+}
+
+type DuplicatesPageURLs struct {
+	ContactList template.URL
+}
+
+func WriteDuplicatesPage(w io.Writer, p DuplicatesPage) error {
+	return contactDuplicatesTemplate.Execute(w, p)
+}
+
+// MergePage lets pick, from either contact, the values of the contact they merge into
+type MergePage struct {
+	A, B contact.Contact
+	// Tags are those of either contact
+	Tags []contact.Tag
+	// Error explains why the contacts could not be merged
+	Error error
+	URLs  MergePageURLs
+}
+
+type MergePageURLs struct {
+	// Merge merges (POST) the contacts
+	Merge, Duplicates template.URL
+}
+
+func WriteMergePage(w io.Writer, p MergePage) error {
+	return contactMergeTemplate.Execute(w, p)
+}
+
+type TenantsPage struct {
+	Rows []TenantRow
+	// SearchTerm is searched in the books of every tenant, which finds Matches
+	SearchTerm  string
+	SearchError error
+	Matches     []TenantMatches
+	URLs        TenantsPageURLs
+}
+
+// TenantMatches are the first contacts of the book of a tenant matching the search
+type TenantMatches struct {
+	Tenant   string
+	Contacts []contact.Contact
+	// More tells that the book has more matches than those listed
+	More bool
+}
+
+type TenantRow struct {
+	Tenant string
+	// Count is how many contacts the address book of the tenant has, if Open
+	Count int
+	// Open books were opened since the start, eg. by a user signing in
+	Open bool
+	// Current is the book viewed
+	Current bool
+}
+
+type TenantsPageURLs struct {
+	// View views (POST) the address book of the Tenant given, or its contact
+	// with the Id given; it also searches (GET) the books of every tenant
+	View, ContactList template.URL
+}
+
+func WriteTenantsPage(w io.Writer, p TenantsPage) error {
+	return contactTenantsTemplate.Execute(w, p)
+}
@@ -17,8 +17,12 @@ var aContact = contact.Contact{
 	Id:        "CNT_1234",
 	FirstName: "FIRST_NAME",
 	LastName:  "LAST_NAME",
-	Phone:     "PHONE",
-	Email:     "EMAIL",
+	Phones:    []contact.PhoneEntry{{Label: contact.Mobile, Primary: true, Phone: contact.MustParsePhone("07911 123456")}},
+	Emails: []contact.EmailEntry{
+		{Label: contact.Home, Primary: true, Email: contact.MustParseEmail("EMAIL@example.com")},
+		{Label: contact.Work, Email: contact.MustParseEmail("WORK_EMAIL@example.com")},
+	},
+	Addresses: []contact.Address{{Label: contact.Home, Street: "STREET", City: "CITY", Country: "COUNTRY"}},
 }
 
 // Check HTML is syntactically valid and that it contains all properties of the template arguments
@@ -28,7 +32,7 @@ func TestContactHTML(t *testing.T) {
 		ContactForm: template.URL("/contact/form?Id=" + aContact.Id),
 		ContactList: "/contact/list",
 	}
-	if err := ht.WriteContact(&sb, aContact, urls); err != nil {
+	if err := ht.WriteContact(&sb, aContact, nil, urls); err != nil {
 		t.Fatal(err)
 	}
 	htmlDoc := sb.String()
@@ -40,8 +44,10 @@ func TestContactHTML(t *testing.T) {
 		"Id":        aContact.Id.String(),
 		"FirstName": aContact.FirstName,
 		"LastName":  aContact.LastName,
-		"Phone":     aContact.Phone,
-		"Email":     aContact.Email,
+		"Phone":     aContact.Phone().String(),
+		"Email":     aContact.Email().String(),
+		"WorkEmail": aContact.Emails[1].Email.String(),
+		"Address":   aContact.Address().String(),
 
 		"ContactFormURL": string(urls.ContactForm),
 		"ContactListURL": string(urls.ContactList),
@@ -72,8 +78,8 @@ func TestContactsHTML(t *testing.T) {
 		"Id":         aContact.Id.String(),
 		"FirstName":  aContact.FirstName,
 		"LastName":   aContact.LastName,
-		"Phone":      aContact.Phone,
-		"Email":      aContact.Email,
+		"Phone":      aContact.Phone().String(),
+		"Email":      aContact.Email().String(),
 	} {
 		if !strings.Contains(htmlDoc, value) {
 			t.Errorf("value %q of property %q not found in HTML", value, name)
@@ -84,14 +90,13 @@ func TestContactsHTML(t *testing.T) {
 // Check HTML is syntactically valid and that it contains all properties of the template arguments
 func TestContactFormHTML(t *testing.T) {
 	var sb strings.Builder
-	var f = ht.ContactForm{
-		Contact: aContact,
-		Errors: templates.ErrorMap{
-			"Email": fmt.Errorf("Invalid Email"),
-		},
+	var f = ht.NewFormWith(aContact)
+	f.Errors = templates.ErrorMap{
+		"Emails.1": fmt.Errorf("Invalid Email"),
 	}
+	f.EmailRows[1].Error = f.Errors["Emails.1"]
 
-	urls := ht.ContactFormPageURLs{}
+	urls := ht.ContactFormPageURLs{NewEmailRow: "/contact/form/entry?Kind=Email"}
 	// TODO: check URLs presence
 	if err := ht.WriteContactForm(&sb, ht.ContactFormPage{
 		ContactForm: f,
@@ -105,15 +110,41 @@ func TestContactFormHTML(t *testing.T) {
 	}
 
 	for name, value := range map[string]string{
-		"EmailErrorMessage": f.Errors["Email"].Error(),
+		"EmailErrorMessage": f.Errors["Emails.1"].Error(),
 		"Id":                aContact.Id.String(),
 		"FirstName":         aContact.FirstName,
 		"LastName":          aContact.LastName,
-		"Phone":             aContact.Phone,
-		"Email":             aContact.Email,
+		"Phone":             aContact.Phone().String(),
+		"Email":             aContact.Email().String(),
+		"WorkEmail":         aContact.Emails[1].Email.String(),
+		"Street":            aContact.Address().Street,
+		"NewEmailRowURL":    string(urls.NewEmailRow),
 	} {
 		if !strings.Contains(htmlDoc, value) {
 			t.Errorf("value %q of property %q not found in HTML", value, name)
 		}
 	}
 }
+
+func TestImportHTML(t *testing.T) {
+	var sb strings.Builder
+	p := ht.ImportPage{
+		Results: []ht.ImportResult{
+			{Card: 1, Name: "IMPORTED_NAME", ContactURL: "/contact/?Id=CNT_1234"},
+			{Card: 2, Name: "FAILED_NAME", Error: fmt.Errorf("CARD_ERROR")},
+		},
+		URLs: ht.ImportPageURLs{Import: "/contact/import", ContactList: "/contact/list"},
+	}
+	if err := ht.WriteImportPage(&sb, p); err != nil {
+		t.Fatal(err)
+	}
+	htmlDoc := sb.String()
+	if _, err := html.Parse(strings.NewReader(htmlDoc)); err != nil {
+		t.Errorf("invalid HTML: %v", err)
+	}
+	for _, value := range []string{"IMPORTED_NAME", "/contact/?Id=CNT_1234", "FAILED_NAME", "CARD_ERROR", "/contact/import"} {
+		if !strings.Contains(htmlDoc, value) {
+			t.Errorf("expected %q in HTML", value)
+		}
+	}
+}
@@ -1,41 +1,77 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
 	"dev.acorello.it/go/contacts/contact/http/ht"
+	"dev.acorello.it/go/contacts/contact/tenant"
 	"dev.acorello.it/go/contacts/seq"
-	"github.com/acorello/must"
+	"dev.acorello.it/go/contacts/templates"
+	"dev.acorello.it/go/contacts/user"
 	"github.com/acorello/uttpil"
 )
 
 type Paths struct {
 	Root, Form, List, Email Path
+	// EntryRow serves a blank row of phone, e-mail, or address to add to the form
+	EntryRow Path
+	// Export downloads a contact, or the contacts found, as vCards; Import uploads vCards
+	Export, Import Path
+	// CSVImport shows the form to upload a CSV file, and imports it; CSVPreview previews its import
+	CSVImport, CSVPreview Path
+	// Trash lists the deleted contacts, and purges (DELETE) them; Restore (POST) restores them
+	Trash, Restore Path
+	// History lists the changes of a contact, and reverts (POST) it to one of its versions
+	History Path
+	// Batch applies an action (POST) to the contacts selected in the list
+	Batch Path
+	// Tags lists the tags, and renames or merges (POST) them
+	Tags Path
+	// Duplicates lists the contacts suspected to be duplicates; Merge reviews two, and merges (POST) them
+	Duplicates, Merge Path
+	// Logout signs the user out (POST, see package user/http); blank if users don't sign in
+	Logout Path
+	// Tenants lists the tenants to admins, and views (POST) the address book of one; blank without tenants
+	Tenants Path
 }
 
 type paths Paths
 
 // Validated checks that:
-// - paths are distinct
+// - paths are distinct, but for the optional ones left blank (Logout and Tenants)
 func (my Paths) Validated() (v paths, err error) {
-	if seq.HasDuplicates(my.Root, my.Form, my.List, my.Email) {
+	optional := slices.DeleteFunc([]Path{my.Logout, my.Tenants}, func(p Path) bool { return p == "" })
+	if seq.HasDuplicates(append([]Path{my.Root, my.Form, my.List, my.Email, my.EntryRow, my.Export, my.Import, my.CSVImport, my.CSVPreview, my.Trash, my.Restore, my.History, my.Batch, my.Tags, my.Duplicates, my.Merge}, optional...)...) {
 		return v, fmt.Errorf("path elements must be unique. Got %+v", my)
 	}
 	return paths(my), nil
 }
 
-func RegisterHandlers(mux *http.ServeMux, paths paths, repo contact.Repository) {
+// RegisterHandlers registers the handlers of the contact pages; the history
+// of the contacts is read from auditLog, where repo should record it (see audit.Repository).
+// fields are the custom fields of the contacts, in the order they are shown;
+// books, if any, are the address books of the tenants repo acts on, by the
+// tenant of the request context (see tenant.Books.Repository).
+func RegisterHandlers(mux *http.ServeMux, paths paths, repo contact.Repository, auditLog audit.Log, fields contact.CustomFields, books *tenant.Books) {
 	h := contactHTTPHandler{
 		paths:             paths,
 		contactRepository: repo,
+		auditLog:          auditLog,
+		fields:            fields,
+		books:             books,
 	}
 	mux.Handle(paths.Root.String(), uttpil.ForMethod{
 		GET:    h.Get,
@@ -51,85 +87,201 @@ func RegisterHandlers(mux *http.ServeMux, paths paths, repo contact.Repository)
 	mux.Handle(paths.Email.String(), uttpil.ForMethod{
 		PATCH: h.PatchEmail,
 	})
+	mux.Handle(paths.EntryRow.String(), uttpil.ForMethod{
+		GET: h.GetEntryRow,
+	})
+	mux.Handle(paths.Export.String(), uttpil.ForMethod{
+		GET: h.GetExport,
+	})
+	mux.Handle(paths.Import.String(), uttpil.ForMethod{
+		GET:  h.GetImport,
+		POST: h.PostImport,
+	})
+	mux.Handle(paths.CSVImport.String(), uttpil.ForMethod{
+		GET:  h.GetCSVImport,
+		POST: h.PostCSVImport,
+	})
+	mux.Handle(paths.CSVPreview.String(), uttpil.ForMethod{
+		POST: h.PostCSVPreview,
+	})
+	mux.Handle(paths.Trash.String(), uttpil.ForMethod{
+		GET:    h.GetTrash,
+		DELETE: h.Purge,
+	})
+	mux.Handle(paths.Restore.String(), uttpil.ForMethod{
+		POST: h.PostRestore,
+	})
+	mux.Handle(paths.History.String(), uttpil.ForMethod{
+		GET:  h.GetHistory,
+		POST: h.PostHistory,
+	})
+	mux.Handle(paths.Batch.String(), uttpil.ForMethod{
+		POST: h.PostBatch,
+	})
+	mux.Handle(paths.Tags.String(), uttpil.ForMethod{
+		GET:  h.GetTags,
+		POST: h.PostTags,
+	})
+	mux.Handle(paths.Duplicates.String(), uttpil.ForMethod{
+		GET: h.GetDuplicates,
+	})
+	mux.Handle(paths.Merge.String(), uttpil.ForMethod{
+		GET:  h.GetMerge,
+		POST: h.PostMerge,
+	})
+	if paths.Tenants != "" && books != nil {
+		mux.Handle(paths.Tenants.String(), uttpil.ForMethod{
+			GET:  h.GetTenants,
+			POST: h.PostTenants,
+		})
+	}
 }
 
 type contactHTTPHandler struct {
 	paths             paths
 	contactRepository contact.Repository
+	auditLog          audit.Log
+	fields            contact.CustomFields
+	books             *tenant.Books
 }
 
+// Get writes the contact as HTML, JSON, vCard, or CSV, as negotiated with the Accept header
 func (h contactHTTPHandler) Get(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
-	if !q.Has(CustomerId) {
+	representation, acceptable := contactRepresentations.negotiate(w, r)
+	if !acceptable {
+		return
+	} else if !q.Has(CustomerId) {
 		w.WriteHeader(http.StatusBadRequest)
 	} else if id, err := contact.ParseId(q.Get(CustomerId)); err != nil {
 		errMsg := fmt.Sprintf("Failed to parse id %q: %v", q.Get(CustomerId), err)
 		http.Error(w, errMsg, http.StatusBadRequest)
-	} else if theContact, found := h.contactRepository.FindById(id); !found {
+	} else if theContact, found, err := h.contactRepository.FindById(r.Context(), id); err != nil {
+		writeRepositoryError(w, err)
+	} else if !found {
 		w.WriteHeader(http.StatusNotFound)
 	} else {
 		_id := theContact.Id.String()
 		urls := ht.ContactPageURLs{
 			ContactList: template.URL(h.paths.List),
 			ContactForm: h.paths.Form.Add(CustomerId, _id).TemplateURL(),
+			Export:      h.paths.Export.Add(CustomerId, _id).TemplateURL(),
+			History:     h.paths.History.Add(CustomerId, _id).TemplateURL(),
 		}
-		err = ht.WriteContact(w, theContact, urls)
+		w.Header().Set("ETag", etag(theContact, representation.mediaType))
+		err = representation.write(w, contactPage{Contact: theContact, Fields: h.fields, URLs: urls})
 		if err != nil {
 			log.Printf("error rendering template: %v", err)
 		}
 	}
 }
 
+// Delete moves the contact to the trash, redirecting to the list, which offers to undo it
 func (h contactHTTPHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := submittedId(w, r)
+	if !ok {
+		return
+	}
+	if deleted, err := h.contactRepository.Delete(r.Context(), id); err != nil {
+		writeRepositoryError(w, err)
+	} else if !deleted {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		http.Redirect(w, r, h.paths.List.Add("Deleted", id.String()).String(), http.StatusSeeOther)
+	}
+}
+
+// submittedId parses the id submitted; if it fails it writes the error and returns false
+func submittedId(w http.ResponseWriter, r *http.Request) (id contact.Id, ok bool) {
 	form, err := uttpil.NewUrlValuesHelper(r)
 	if err != nil {
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
-		return
+		return id, false
 	}
 	if !form.Has(CustomerId) {
 		msg := fmt.Sprintf("Missing %q from submitted form: %#v", CustomerId, r.Form)
 		http.Error(w, msg, http.StatusBadRequest)
 		log.Print(msg)
-		return
+		return id, false
 	}
 	_id := form.Get(CustomerId, strings.TrimSpace)
-	id, err := contact.ParseId(_id)
+	id, err = contact.ParseId(_id)
 	if err != nil {
 		errMsg := fmt.Sprintf("Failed to parse id %q: %v", _id, err)
 		http.Error(w, errMsg, http.StatusBadRequest)
-		return
+		return id, false
 	}
-	h.contactRepository.Delete(id)
-	http.Redirect(w, r, h.paths.List.String(), http.StatusSeeOther)
+	return id, true
 }
 
 func (h contactHTTPHandler) PostForm(w http.ResponseWriter, r *http.Request) {
-	var renderingError error
-	form, err := uttpil.NewUrlValuesHelper(r) // confusingly enough, the library decodes a form into url.Values,
-	if err != nil {
+	if err := r.ParseForm(); err != nil {
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
 		return
 	}
-	theContact, errors := parseContact(form)
-	if errors != nil && len(errors) > 0 {
-		log.Printf("Error parsing contact form: %+v", errors)
-		contactForm := ht.NewFormWith(theContact)
-		contactForm.Errors = errors
-		_id := theContact.Id.String()
-		renderingError = ht.WriteContactForm(w, ht.ContactFormPage{
-			ContactForm: contactForm,
-			URLs: ht.ContactFormPageURLs{
-				ContactForm:       h.paths.Form.Add(CustomerId, _id).TemplateURL(),
-				PatchContactEmail: h.paths.Email.Add(CustomerId, _id).TemplateURL(),
-			},
-		})
+	theContact, rows, fieldErrors := parseContact(r.Form, h.fields)
+	if len(fieldErrors) > 0 {
+		log.Printf("Error parsing contact form: %+v", fieldErrors)
+		h.writeInvalidContactForm(w, theContact, rows, fieldErrors)
+		return
+	} else if err := keepUnknownCustom(r.Context(), h.contactRepository, h.fields, &theContact); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	var emailOwnerErr contact.EmailOwnerError
+	if err := h.contactRepository.Store(r.Context(), theContact); errors.As(err, &emailOwnerErr) {
+		log.Printf("Error storing contact: %v", err)
+		fieldErrors := failEmail(r.Form, &rows, emailOwnerErr.Email, fmt.Errorf("email address already in use"))
+		h.writeInvalidContactForm(w, theContact, rows, fieldErrors)
+	} else if errors.As(err, new(contact.VersionConflictError)) {
+		log.Printf("Error storing contact: %v", err)
+		h.writeConflictingContactForm(w, r, theContact, rows)
+	} else if err != nil {
+		writeRepositoryError(w, err)
 	} else {
-		h.contactRepository.Store(theContact)
 		log.Printf("Stored: %#v", theContact)
 		http.Redirect(w, r, h.paths.List.String(), http.StatusFound)
 	}
-	if renderingError != nil {
-		log.Printf("error rendering template: %v", renderingError)
+}
+
+func (h contactHTTPHandler) writeInvalidContactForm(w http.ResponseWriter, c contact.Contact, rows ht.EntryRows, fieldErrors templates.ErrorMap) {
+	contactForm := ht.NewFormWith(c)
+	contactForm.EntryRows = rows
+	contactForm.Errors = fieldErrors
+	contactForm.Fields = h.fields
+	err := ht.WriteContactForm(w, ht.ContactFormPage{
+		ContactForm: contactForm,
+		URLs:        h.formURLs(c.Id),
+	})
+	if err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// writeConflictingContactForm writes the form as submitted, side by side with
+// the contact as stored now; the form carries the Version stored, so that
+// submitting it again replaces the stored contact.
+func (h contactHTTPHandler) writeConflictingContactForm(w http.ResponseWriter, r *http.Request, c contact.Contact, rows ht.EntryRows) {
+	stored, found, err := h.contactRepository.FindById(r.Context(), c.Id)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	c.Version = stored.Version // 0 if not found: deleted meanwhile
+	contactForm := ht.NewFormWith(c)
+	contactForm.EntryRows = rows
+	contactForm.Stored = &stored
+	contactForm.Fields = h.fields
+	urls := h.formURLs(c.Id)
+	if found {
+		urls.DeleteContact = h.paths.Root.Add(CustomerId, c.Id.String()).TemplateURL()
+	}
+	err = ht.WriteContactForm(w, ht.ContactFormPage{
+		ContactForm: contactForm,
+		URLs:        urls,
+	})
+	if err != nil {
+		log.Printf("error rendering template: %v", err)
 	}
 }
 
@@ -140,15 +292,10 @@ func (h contactHTTPHandler) GetForm(w http.ResponseWriter, r *http.Request) {
 		// blank form to create a new contact
 		contactForm := ht.NewForm()
 		contactForm.Id = contact.NewId()
-		_id := contactForm.Id.String()
-		urls := ht.ContactFormPageURLs{
-			ContactForm:       h.paths.Form.Add(CustomerId, _id).TemplateURL(),
-			ContactList:       h.paths.List.TemplateURL(),
-			PatchContactEmail: h.paths.Email.Add(CustomerId, _id).TemplateURL(),
-		}
+		contactForm.Fields = h.fields
 		renderingError = ht.WriteContactForm(w, ht.ContactFormPage{
 			ContactForm: contactForm,
-			URLs:        urls,
+			URLs:        h.formURLs(contactForm.Id),
 		})
 	} else {
 		_id := q.Get(CustomerId)
@@ -158,19 +305,19 @@ func (h contactHTTPHandler) GetForm(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, errMsg, http.StatusBadRequest)
 			return
 		}
-		contact, found := h.contactRepository.FindById(id)
-		if !found {
+		contact, found, err := h.contactRepository.FindById(r.Context(), id)
+		if err != nil {
+			writeRepositoryError(w, err)
+		} else if !found {
 			w.WriteHeader(http.StatusNotFound)
 		} else {
-			_id := contact.Id.String()
-			urls := ht.ContactFormPageURLs{
-				ContactList:       h.paths.List.TemplateURL(),
-				ContactForm:       h.paths.Form.Add(CustomerId, _id).TemplateURL(),
-				DeleteContact:     h.paths.Root.Add(CustomerId, _id).TemplateURL(),
-				PatchContactEmail: h.paths.Email.Add(CustomerId, _id).TemplateURL(),
-			}
+			urls := h.formURLs(contact.Id)
+			urls.DeleteContact = h.paths.Root.Add(CustomerId, contact.Id.String()).TemplateURL()
+			contactForm := ht.NewFormWith(contact)
+			contactForm.Fields = h.fields
+			w.Header().Set("ETag", etag(contact, "text/html"))
 			renderingError = ht.WriteContactForm(w, ht.ContactFormPage{
-				ContactForm: ht.NewFormWith(contact),
+				ContactForm: contactForm,
 				URLs:        urls,
 			})
 		}
@@ -180,7 +327,42 @@ func (h contactHTTPHandler) GetForm(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// PatchEmail is only used for validation purposes at the moment
+func (h contactHTTPHandler) formURLs(id contact.Id) ht.ContactFormPageURLs {
+	_id := id.String()
+	entryRow := h.paths.EntryRow.Add(CustomerId, _id)
+	return ht.ContactFormPageURLs{
+		ContactList:       h.paths.List.TemplateURL(),
+		ContactForm:       h.paths.Form.Add(CustomerId, _id).TemplateURL(),
+		PatchContactEmail: h.paths.Email.Add(CustomerId, _id).TemplateURL(),
+		NewPhoneRow:       entryRow.Add("Kind", "Phone").TemplateURL(),
+		NewEmailRow:       entryRow.Add("Kind", "Email").TemplateURL(),
+		NewAddressRow:     entryRow.Add("Kind", "Address").TemplateURL(),
+	}
+}
+
+// GetEntryRow writes a blank row of the Kind of entry requested, to add to the contact form
+func (h contactHTTPHandler) GetEntryRow(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var renderingError error
+	switch kind := q.Get("Kind"); kind {
+	case "Phone":
+		renderingError = ht.WritePhoneRow(w, ht.NewPhoneRow())
+	case "Email":
+		row := ht.NewEmailRow()
+		row.CheckURL = h.paths.Email.Add(CustomerId, q.Get(CustomerId)).TemplateURL()
+		renderingError = ht.WriteEmailRow(w, row)
+	case "Address":
+		renderingError = ht.WriteAddressRow(w, ht.NewAddressRow())
+	default:
+		http.Error(w, fmt.Sprintf("unknown kind of entry %q", kind), http.StatusBadRequest)
+	}
+	if renderingError != nil {
+		log.Printf("error rendering template: %v", renderingError)
+	}
+}
+
+// PatchEmail is only used for validation purposes at the moment: it checks
+// the e-mail is valid and, in its canonical form, not owned by another contact.
 func (h contactHTTPHandler) PatchEmail(w http.ResponseWriter, r *http.Request) {
 	q, err := uttpil.NewUrlValuesHelper(r)
 	if err != nil {
@@ -188,10 +370,17 @@ func (h contactHTTPHandler) PatchEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	contactId := contact.Id(q.Get(CustomerId, strings.TrimSpace))
-	contactEmail := q.Get("Email", strings.TrimSpace)
+	contactEmail, err := contact.ParseEmail(q.Get("Email"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
 	log.Printf("validating e-mail %q for contactId %q", contactEmail, contactId)
-	existingContactId, found := h.contactRepository.FindIdByEmail(contactEmail)
-	if found && existingContactId != contactId {
+	existingContactId, found, err := h.contactRepository.FindIdByEmail(r.Context(), contactEmail)
+	if err != nil {
+		writeRepositoryError(w, err)
+	} else if found && existingContactId != contactId {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "email address already in use")
 	} else {
@@ -199,47 +388,186 @@ func (h contactHTTPHandler) PatchEmail(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetList writes a page of contacts as HTML, JSON, vCard, or CSV, as negotiated
+// with the Accept header; the Link header refers to the next page, if any.
 func (h contactHTTPHandler) GetList(w http.ResponseWriter, r *http.Request) {
+	representation, acceptable := contactListRepresentations.negotiate(w, r)
+	if !acceptable {
+		return
+	}
 	q, err := uttpil.NewUrlValuesHelper(r)
 	if err != nil {
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
 		return
 	}
 	searchTerm := q.Get("SearchTerm", strings.TrimSpace)
-	// does it make sense to create a separate object dedicated to the parsing?
-	page := contact.Page{
-		Offset: must.Get(asInt(q.Get("pageOffset"), 0)),
-		Size:   must.Get(asInt(q.Get("pageSize"), 0)),
+	page, err := parsePage(q.Get("pageAfter"), q.Get("pageSize"), q.Get("Sort"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid page: %v", err), http.StatusBadRequest)
+		return
 	}
-	page.Offset = max(page.Offset, 0)
-	page.Size = max(page.Size, 10)
-	page.Size = min(page.Size, 50)
-
-	var contacts []contact.Contact
-	var more bool
-	if searchTerm == "" {
-		log.Printf("Listing all contacts")
-		contacts, more = h.contactRepository.FindAll(page)
-	} else {
-		log.Printf("Listing contacts containing %q", searchTerm)
-		contacts, more = h.contactRepository.FindBySearchTerm(searchTerm, page)
+	contacts, next, more, queryErr, err := findContacts(r.Context(), h.contactRepository, searchTerm, page)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
 	}
 	var nextPageURL template.URL
 	if more {
-		nextPageURL = searchPageURL(page.Next(), searchTerm, h.paths.List.String())
+		nextPageURL = searchPageURL(next, searchTerm, h.paths.List.String())
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL))
 	}
 	templateParams := ht.SearchPage{
-		SearchTerm: searchTerm,
-		Contacts:   contacts,
+		SearchTerm:    searchTerm,
+		SearchError:   queryErr,
+		Contacts:      contacts,
+		Sort:          page.Order.String(),
+		Columns:       sortColumns(page, searchTerm, h.paths.List.String()),
+		CustomColumns: h.fields.Listed(),
 		URLs: ht.SearchPageURLs{
-			NextPage: nextPageURL,
+			NextPage:   nextPageURL,
+			Trash:      h.paths.Trash.TemplateURL(),
+			Export:     exportURL(h.paths.Export, searchTerm, page.Order, "vcard"),
+			ExportCSV:  exportURL(h.paths.Export, searchTerm, page.Order, "csv"),
+			ExportJSON: exportURL(h.paths.Export, searchTerm, page.Order, "json"),
+			Import:     h.paths.Import.TemplateURL(),
+			ImportCSV:  h.paths.CSVImport.TemplateURL(),
+			Batch:      h.paths.Batch.TemplateURL(),
+			Tags:       h.paths.Tags.TemplateURL(),
+			Duplicates: h.paths.Duplicates.TemplateURL(),
 		},
 	}
-	if err := ht.WriteContactList(w, templateParams); err != nil {
+	if u, ok := user.Of(r.Context()); ok && h.paths.Logout != "" {
+		templateParams.User = u.Name
+		templateParams.URLs.Logout = h.paths.Logout.TemplateURL()
+		if u.Admin && h.paths.Tenants != "" && h.books != nil {
+			templateParams.URLs.Tenants = h.paths.Tenants.TemplateURL()
+		}
+	}
+	if t, ok := tenant.Of(r.Context()); ok {
+		templateParams.Tenant = t.String()
+	}
+	if templateParams.Tags, err = h.contactRepository.FindTags(r.Context()); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	if deletedId, err := contact.ParseId(q.Get("Deleted")); err == nil {
+		if deleted, found, err := h.contactRepository.FindDeletedById(r.Context(), deletedId); err != nil {
+			writeRepositoryError(w, err)
+			return
+		} else if found {
+			templateParams.Deleted = &deleted
+			templateParams.URLs.Undo = h.paths.Restore.Add(CustomerId, deletedId.String()).TemplateURL()
+		}
+	}
+	if err := representation.write(w, templateParams); err != nil {
 		log.Printf("error rendering template: %v", err)
 	}
 }
 
+// parsePage parses the page requested, clamping its size to 10..50; after is
+// the cursor of the previous page, blank for the first one, and order the
+// Sort, parsed by contact.ParseOrder.
+func parsePage(after, size, order string) (page contact.Page, err error) {
+	if page.Size, err = asInt(size, 0); err != nil {
+		return page, err
+	}
+	if page.Order, err = contact.ParseOrder(order); err != nil {
+		return page, err
+	}
+	page.After = contact.Cursor(strings.TrimSpace(after))
+	page.Size = max(page.Size, 10)
+	page.Size = min(page.Size, 50)
+	return page, nil
+}
+
+// findContacts finds all the contacts, or those matching searchTerm if not
+// blank; queryErr explains why searchTerm is not a valid query.
+func findContacts(ctx context.Context, repo contact.Repository, searchTerm string, page contact.Page) (contacts []contact.Contact, next contact.Page, more bool, queryErr, err error) {
+	if searchTerm == "" {
+		log.Printf("Listing all contacts")
+		contacts, next, more, err = repo.FindAll(ctx, page)
+	} else if query, parseErr := contact.ParseQuery(searchTerm); parseErr != nil {
+		log.Printf("Malformed search query %q: %v", searchTerm, parseErr)
+		queryErr = parseErr
+	} else {
+		log.Printf("Listing contacts matching %v", query)
+		contacts, next, more, err = repo.FindByQuery(ctx, query, page)
+	}
+	return contacts, next, more, queryErr, err
+}
+
+// listColumns are the columns of the contact list it can be sorted by
+var listColumns = []struct {
+	label string
+	by    contact.SortField
+}{
+	{"First", contact.ByFirstName},
+	{"Last", contact.ByLastName},
+	{"Phone", contact.ByPhone},
+	{"Email", contact.ByEmail},
+}
+
+// sortColumns are the headers of the contact list: each sorts the first page by
+// its column, ascending unless the list is already.
+func sortColumns(page contact.Page, searchTerm, searchPagePath string) (columns []ht.SortColumn) {
+	sorted := page.Order
+	if sorted == (contact.Order{}) && searchTerm == "" {
+		sorted.By = contact.ByLastName // see contact.PageOf
+	}
+	for _, c := range listColumns {
+		column := ht.SortColumn{Label: c.label}
+		order := contact.Order{By: c.by}
+		if sorted.By == c.by && sorted.Descending {
+			column.Sorted = "descending"
+		} else if sorted.By == c.by {
+			column.Sorted = "ascending"
+			order = order.Reversed()
+		}
+		column.URL = searchPageURL(contact.Page{Size: page.Size, Order: order}, searchTerm, searchPagePath)
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// statusClientClosedRequest is the non-standard code (popularised by nginx)
+// reported when the client went away before the response was ready.
+const statusClientClosedRequest = 499
+
+// repositoryErrorStatus maps an error returned by contact.Repository to an HTTP status code
+func repositoryErrorStatus(err error) int {
+	var emailOwnerErr contact.EmailOwnerError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &emailOwnerErr), errors.As(err, new(contact.VersionConflictError)):
+		return http.StatusConflict
+	case errors.As(err, new(contact.CursorError)):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	status := repositoryErrorStatus(err)
+	log.Printf("repository error (responding %d): %v", status, err)
+	if status == statusClientClosedRequest {
+		w.WriteHeader(status) // nobody is listening
+	} else {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// etag is the entity tag of the representation of the contact in mediaType:
+// its Version and the media subtype, eg. "3-json", as representations of the
+// same version differ.
+func etag(c contact.Contact, mediaType string) string {
+	_, subtype, _ := strings.Cut(mediaType, "/")
+	return strconv.Quote(strconv.Itoa(c.Version) + "-" + subtype)
+}
+
 func asInt(s string, whenBlank int) (int, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -257,8 +585,13 @@ func searchPageURL(page contact.Page, searchTerm, searchPagePath string) templat
 	if searchTerm != "" {
 		q.Add("SearchTerm", searchTerm)
 	}
-	q.Add("pageOffset", strconv.Itoa(page.Offset))
+	if page.After != "" {
+		q.Add("pageAfter", string(page.After))
+	}
 	q.Add("pageSize", strconv.Itoa(page.Size))
+	if page.Order != (contact.Order{}) {
+		q.Add("Sort", page.Order.String())
+	}
 	u := url.URL{
 		Path:     searchPagePath,
 		RawQuery: q.Encode(),
@@ -268,9 +601,19 @@ func searchPageURL(page contact.Page, searchTerm, searchPagePath string) templat
 
 var nameRegEx = re{regexp.MustCompile(`^\w+(?:[- ']\w+)*$`)}
 
-func parseContact(form uttpil.UrlValuesHelper) (c contact.Contact, err map[string]error) {
-	form.Give(CustomerId, func(val string) error {
-		val = strings.TrimSpace(val)
+// parseContact parses the contact form, or the url.Values the JSON API makes of
+// its request (see apiContact.values); the inputs repeated in each row of phones,
+// e-mails, and addresses are parsed by parseEntries, and the values of the
+// custom fields, named "Custom." and the field Name, by fields; the values of
+// fields not among them (eg. removed since) are rejected, see keepUnknownCustom.
+func parseContact(values url.Values, fields contact.CustomFields) (c contact.Contact, rows ht.EntryRows, err templates.ErrorMap) {
+	rows, err = parseEntries(values, &c)
+	give := func(name string, f func(string) error) {
+		if fieldErr := f(strings.TrimSpace(values.Get(name))); fieldErr != nil {
+			err[name] = fieldErr
+		}
+	}
+	give(CustomerId, func(val string) error {
 		if val == "" {
 			c.Id = contact.NewId()
 			log.Printf("Got blank contact id assuming new contact, assigning new id %q", c.Id)
@@ -283,8 +626,17 @@ func parseContact(form uttpil.UrlValuesHelper) (c contact.Contact, err map[strin
 			return nil
 		}
 	})
-	form.Give("FirstName", func(value string) error {
-		value = strings.TrimSpace(value)
+	give("Version", func(value string) error {
+		if version, err := asInt(value, 0); err != nil {
+			return err
+		} else if version < 0 {
+			return fmt.Errorf("negative")
+		} else {
+			c.Version = version
+			return nil
+		}
+	})
+	give("FirstName", func(value string) error {
 		if value == "" {
 			return fmt.Errorf("blank")
 		} else if !nameRegEx.MatchString(value) {
@@ -293,27 +645,53 @@ func parseContact(form uttpil.UrlValuesHelper) (c contact.Contact, err map[strin
 		c.FirstName = value
 		return nil
 	})
-	form.Give("LastName", func(value string) error {
-		value = strings.TrimSpace(value)
+	give("LastName", func(value string) error {
 		if value == "" {
 			return fmt.Errorf("blank")
 		}
 		c.LastName = value
 		return nil
 	})
-	form.Give("Email", func(value string) error {
-		value = strings.TrimSpace(value)
-		if value == "" {
-			return fmt.Errorf("blank")
-		}
-		c.Email = value
-		return nil
+	rows.TagText = values.Get("Tags")
+	give("Tags", func(value string) (err error) {
+		c.Tags, err = contact.ParseTags(value)
+		return err
 	})
-	form.Give("Phone", func(value string) error {
-		c.Phone = strings.TrimSpace(value)
-		return nil
-	})
-	return c, form.Errors()
+	for name := range values {
+		if fieldName, isCustom := strings.CutPrefix(name, "Custom."); !isCustom {
+			continue
+		} else if !fields.Has(fieldName) {
+			err[name] = fmt.Errorf("unknown field")
+		} else {
+			if rows.CustomText == nil {
+				rows.CustomText = make(map[string]string)
+			}
+			rows.CustomText[fieldName] = values.Get(name)
+		}
+	}
+	custom, customErrs := fields.Parse(rows.CustomText)
+	for name, customErr := range customErrs {
+		err["Custom."+name] = customErr
+	}
+	c.Custom = custom
+	return c, rows, err
+}
+
+// keepUnknownCustom gives c the values its stored contact has of fields no
+// longer among fields, which parseContact rejects, so that they are not lost
+// when c replaces it, and are back if the fields are.
+func keepUnknownCustom(ctx context.Context, repo contact.Repository, fields contact.CustomFields, c *contact.Contact) error {
+	stored, found, err := repo.FindById(ctx, c.Id)
+	if err != nil || !found {
+		return err
+	}
+	if unknown := fields.Unknown(stored.Custom); len(unknown) > 0 {
+		if c.Custom == nil {
+			c.Custom = make(map[string]string)
+		}
+		maps.Copy(c.Custom, unknown)
+	}
+	return nil
 }
 
 type re struct {
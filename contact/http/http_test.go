@@ -1,6 +1,19 @@
 package http
 
-import "testing"
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+)
 
 func TestNameRegex(t *testing.T) {
 	val := "Joe!"
@@ -9,3 +22,200 @@ func TestNameRegex(t *testing.T) {
 		t.Errorf("%q should have failed", val)
 	}
 }
+
+func TestParseEntries(t *testing.T) {
+	values := url.Values{
+		"PhoneKey":     {"p1", "p2", "p3"},
+		"PhoneLabel":   {"home", "work", "mobile"},
+		"PhoneNumber":  {"020 7946 0000", " ", "07911 123456"},
+		"PrimaryPhone": {"p3"},
+		"EmailKey":     {"e1", "e2", "e3"},
+		"EmailLabel":   {"home", "work", "nickname"},
+		"EmailAddress": {"joe@example.com", "JOE@Example.com", "joe@work.example.com"},
+		"AddressKey":   {"a1"},
+		"AddressLabel": {""},
+		"AddressCity":  {"London"},
+	}
+	var c contact.Contact
+	rows, errs := parseEntries(values, &c)
+	if len(c.Phones) != 2 || c.Phone().E164() != "+447911123456" || len(rows.PhoneRows) != 2 {
+		t.Errorf("expected 2 phones, the blank one dropped, and the mobile as primary, got %#v", c.Phones)
+	}
+	if len(c.Emails) != 1 || !c.Emails[0].Primary || errs["Emails.1"] == nil || errs["Emails.2"] == nil {
+		t.Errorf("expected the duplicate e-mail and the unknown label to be rejected, got %#v, %v", c.Emails, errs)
+	}
+	if rows.EmailRows[1].Address != "JOE@Example.com" || rows.EmailRows[1].Error == nil {
+		t.Errorf("expected the invalid row to be kept as typed, with its error, got %#v", rows.EmailRows[1])
+	}
+	if len(c.Addresses) != 1 || c.Address().City != "London" || !c.Address().Primary {
+		t.Errorf("unexpected addresses %#v", c.Addresses)
+	}
+	if _, errs := parseEntries(url.Values{}, &contact.Contact{}); errs["Emails"] == nil {
+		t.Errorf("expected an error for missing e-mails")
+	}
+}
+
+func newTestMux(t *testing.T, repo contact.Repository) *http.ServeMux {
+	t.Helper()
+	return newTestMuxWithFields(t, repo, nil)
+}
+
+func newTestMuxWithFields(t *testing.T, repo contact.Repository, fields contact.CustomFields) *http.ServeMux {
+	t.Helper()
+	paths, err := Paths{
+		Root:       "/contact/",
+		Form:       "/contact/form",
+		List:       "/contact/list",
+		Email:      "/contact/email",
+		EntryRow:   "/contact/form/entry",
+		Export:     "/contact/export",
+		Import:     "/contact/import",
+		CSVImport:  "/contact/import/csv",
+		CSVPreview: "/contact/import/csv/preview",
+		Trash:      "/contact/trash",
+		Restore:    "/contact/trash/restore",
+		History:    "/contact/history",
+		Batch:      "/contact/batch",
+		Tags:       "/contact/tags",
+		Duplicates: "/contact/duplicates",
+		Merge:      "/contact/merge",
+	}.Validated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	auditLog := audit.NewInMemoryLog()
+	RegisterHandlers(mux, paths, audit.NewRepository(repo, auditLog), auditLog, fields, nil)
+	return mux
+}
+
+func TestFormShowsVersionConflict(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs",
+		Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("joe@example.com")}}}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestMux(t, repo)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/contact/form?Id="+joe.Id.String(), nil))
+	if etag := res.Header().Get("ETag"); etag != `"1-html"` {
+		t.Errorf("expected ETag %q, got %q", `"1-html"`, etag)
+	}
+	for accept, expected := range map[string]string{"text/html": `"1-html"`, "application/json": `"1-json"`} {
+		req := httptest.NewRequest(http.MethodGet, "/contact/?Id="+joe.Id.String(), nil)
+		req.Header.Set("Accept", accept)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if etag := res.Header().Get("ETag"); etag != expected {
+			t.Errorf("Accept %s: expected ETag %q, got %q", accept, expected, etag)
+		}
+	}
+	if !strings.Contains(res.Body.String(), `name="Version" value="1"`) {
+		t.Errorf("expected the form to carry version 1")
+	}
+
+	post := func(version, lastName string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"Id": {joe.Id.String()}, "Version": {version}, "FirstName": {"Joe"}, "LastName": {lastName},
+			"EmailKey": {"e"}, "EmailAddress": {"joe@example.com"}, "PrimaryEmail": {"e"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/contact/form", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+	if res := post("1", "Theirs"); res.Code != http.StatusFound {
+		t.Fatalf("expected the first change to be stored, got %d", res.Code)
+	}
+	res = post("1", "Mine")
+	page := res.Body.String()
+	for _, expected := range []string{"Someone else changed this contact", "<td><div>Mine</div></td>",
+		"<td><div>Theirs</div></td>", `name="Version" value="2"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the form", expected)
+		}
+	}
+	if stored, _, _ := repo.FindById(ctx, joe.Id); stored.LastName != "Theirs" {
+		t.Errorf("expected the stale change to be rejected, got %#v", stored)
+	}
+	if res := post("2", "Mine"); res.Code != http.StatusFound {
+		t.Errorf("expected the change to be stored once confirmed, got %d", res.Code)
+	}
+	if stored, _, _ := repo.FindById(ctx, joe.Id); stored.LastName != "Mine" || stored.Version != 3 {
+		t.Errorf("expected the confirmed change at version 3, got %#v", stored)
+	}
+}
+
+func TestFormShowsEmailInUseAsSubmitted(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	repo := contact.NewInMemoryContactRepository()
+	jane := contact.Contact{Id: contact.NewId(), FirstName: "Jane", LastName: "Doe",
+		Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("jane@example.com")}}}
+	if err := repo.Store(context.Background(), jane); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{
+		"Id": {contact.NewId().String()}, "FirstName": {"Joe"}, "LastName": {"Bloggs"},
+		"EmailKey": {"e1", "e2", "e3"}, "EmailLabel": {"home", "", "work"},
+		"EmailAddress": {"joe@example.com", " ", "JANE@example.com"}, "PrimaryEmail": {"e1"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/contact/form", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	newTestMux(t, repo).ServeHTTP(res, req)
+	page := res.Body.String()
+	for _, expected := range []string{`name="EmailKey" value="e1"`, `name="EmailKey" value="e3"`,
+		`value="JANE@example.com"`, "email address already in use"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the form", expected)
+		}
+	}
+	if strings.Count(page, "email address already in use") != 1 {
+		t.Errorf("expected the error on the e-mail in use only")
+	}
+}
+
+func TestListIsSortedAndPaged(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	mux := newTestMux(t, contact.NewPopulatedInMemoryContactRepository())
+	get := func(target string, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+		if res.Code != status {
+			t.Fatalf("GET %s: expected status %d, got %d", target, status, res.Code)
+		}
+		return res
+	}
+	first := get("/contact/list?Sort=-first", http.StatusOK)
+	page := first.Body.String()
+	for _, expected := range []string{`aria-sort="descending"><a href="/contact/list?Sort=first&amp;pageSize=10">First ▼`, `<input type="hidden" name="Sort" value="-first" />`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the list", expected)
+		}
+	}
+	link := first.Header().Get("Link")
+	next, _, found := strings.Cut(strings.TrimPrefix(link, "<"), ">")
+	if !found || !strings.Contains(next, "pageAfter=") || !strings.Contains(next, "Sort=-first") {
+		t.Fatalf("expected a Link to the next page, sorted the same, got %q", link)
+	}
+	second := get(next, http.StatusOK)
+	if second.Header().Get("Link") != "" {
+		t.Errorf("expected the second page to be the last")
+	}
+	if n, m := strings.Count(page, `<tr id="Contact-`), strings.Count(second.Body.String(), `<tr id="Contact-`); n != 10 || m != 5 {
+		t.Errorf("expected 10 contacts, then 5, got %d and %d", n, m)
+	}
+	get("/contact/list?Sort=age", http.StatusBadRequest)
+	get("/contact/list?pageAfter=garbage", http.StatusBadRequest)
+}
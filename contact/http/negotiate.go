@@ -0,0 +1,188 @@
+package http
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/csv"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+	"dev.acorello.it/go/contacts/contact/vcard"
+	"dev.acorello.it/go/contacts/seq"
+	"dev.acorello.it/go/contacts/templates"
+)
+
+// representation writes a value of type T in a media type
+type representation[T any] struct {
+	mediaType string
+	write     func(w http.ResponseWriter, v T) error
+}
+
+// representations are those offered for a resource, in order of preference
+type representations[T any] []representation[T]
+
+// negotiate chooses the representation most acceptable to the request (see
+// RFC 9110, section 12.5.1), the first offered if the request has no Accept
+// header. If none is acceptable it answers 406 Not Acceptable and returns false.
+func (me representations[T]) negotiate(w http.ResponseWriter, r *http.Request) (chosen representation[T], ok bool) {
+	w.Header().Add("Vary", "Accept")
+	ranges := parseAccept(r.Header.Values("Accept"))
+	best := 0.0
+	for _, rep := range me {
+		if q := ranges.quality(rep.mediaType); q > best {
+			chosen, best, ok = rep, q, true
+		}
+	}
+	if !ok {
+		offered := make([]string, len(me))
+		for i, rep := range me {
+			offered[i] = rep.mediaType
+		}
+		http.Error(w, "acceptable media types: "+strings.Join(offered, ", "), http.StatusNotAcceptable)
+	}
+	return chosen, ok
+}
+
+type mediaRange struct {
+	// typ and subtype may be "*"
+	typ, subtype string
+	q            float64
+}
+
+type mediaRanges []mediaRange
+
+// parseAccept parses the values of the Accept header, skipping malformed media
+// ranges; no values are the same as "*/*".
+func parseAccept(values []string) (ranges mediaRanges) {
+	if len(values) == 0 {
+		return mediaRanges{{"*", "*", 1}}
+	}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			typ, subtype, found := strings.Cut(mediaType, "/")
+			if !found {
+				continue
+			}
+			q := 1.0
+			if v, has := params["q"]; has {
+				if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+			ranges = append(ranges, mediaRange{typ, subtype, q})
+		}
+	}
+	return ranges
+}
+
+// quality is the q of the most specific range matching mediaType, 0 if none does
+func (me mediaRanges) quality(mediaType string) float64 {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, r := range me {
+		var s int
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// contactPage is what Get represents
+type contactPage struct {
+	contact.Contact
+	Fields contact.CustomFields
+	URLs   ht.ContactPageURLs
+}
+
+var contactRepresentations = representations[contactPage]{
+	{"text/html", func(w http.ResponseWriter, p contactPage) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		return ht.WriteContact(w, p.Contact, p.Fields, p.URLs)
+	}},
+	{"application/json", func(w http.ResponseWriter, p contactPage) error {
+		writeJSON(w, http.StatusOK, apiContactOf(p.Contact))
+		return nil
+	}},
+	{vcard.MediaType, func(w http.ResponseWriter, p contactPage) error {
+		w.Header().Set("Content-Type", vcard.MediaType+"; charset=utf-8")
+		return vcard.Write(w, p.Contact)
+	}},
+	{csv.MediaType, func(w http.ResponseWriter, p contactPage) error {
+		return writeCSV(w, p.Contact)
+	}},
+}
+
+// contactListRepresentations are those of GetList; but for HTML, which shows
+// it along with the search form, they answer 400 Bad Request to a malformed query.
+var contactListRepresentations = representations[ht.SearchPage]{
+	{"text/html", func(w http.ResponseWriter, s ht.SearchPage) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		return ht.WriteContactList(w, s)
+	}},
+	{"application/json", func(w http.ResponseWriter, s ht.SearchPage) error {
+		if s.SearchError != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid search term", templates.ErrorMap{"SearchTerm": s.SearchError})
+		} else {
+			list := apiContactList{Contacts: seq.Map(apiContactOf, s.Contacts...), NextPage: string(s.URLs.NextPage)}
+			if list.Contacts == nil {
+				list.Contacts = []apiContact{}
+			}
+			writeJSON(w, http.StatusOK, list)
+		}
+		return nil
+	}},
+	{vcard.MediaType, func(w http.ResponseWriter, s ht.SearchPage) error {
+		if s.SearchError != nil {
+			http.Error(w, s.SearchError.Error(), http.StatusBadRequest)
+			return nil
+		}
+		w.Header().Set("Content-Type", vcard.MediaType+"; charset=utf-8")
+		for _, c := range s.Contacts {
+			if err := vcard.Write(w, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{csv.MediaType, func(w http.ResponseWriter, s ht.SearchPage) error {
+		if s.SearchError != nil {
+			http.Error(w, s.SearchError.Error(), http.StatusBadRequest)
+			return nil
+		}
+		return writeCSV(w, s.Contacts...)
+	}},
+}
+
+func writeCSV(w http.ResponseWriter, contacts ...contact.Contact) error {
+	w.Header().Set("Content-Type", csv.MediaType+"; charset=utf-8; header=present")
+	cw, err := csv.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		if err := cw.Write(c); err != nil {
+			return err
+		}
+	}
+	return cw.Flush()
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := representations[any]{{mediaType: "text/html"}, {mediaType: "application/json"}, {mediaType: "text/vcard"}}
+	for accept, expected := range map[string]string{
+		"":                                     "text/html",
+		"*/*":                                  "text/html",
+		"application/json":                     "application/json",
+		"text/*;q=0.5, application/json;q=0.4": "text/html",
+		"text/*, text/html;q=0":                "text/vcard",
+		"text/html;q=0.9, */*;q=0.1, garbage":  "text/html",
+		"TEXT/VCARD; charset=utf-8, text/html;q=0.2": "text/vcard",
+		"image/png":                           "",
+		"text/html;q=0, application/json;q=0": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		chosen, ok := offered.negotiate(w, r)
+		if chosen.mediaType != expected || ok != (expected != "") {
+			t.Errorf("Accept %q: expected %q, got %q", accept, expected, chosen.mediaType)
+		}
+		if !ok && w.Code != http.StatusNotAcceptable {
+			t.Errorf("Accept %q: expected status 406, got %d", accept, w.Code)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: expected to vary by Accept, got %q", accept, w.Header().Get("Vary"))
+		}
+	}
+}
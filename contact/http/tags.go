@@ -0,0 +1,62 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+)
+
+// GetTags lists the tags, with how many contacts have each
+func (h contactHTTPHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	h.writeTagsPage(w, r, nil)
+}
+
+// PostTags renames the tags From (one or more) To the one given, merging them
+// with it if it exists, then redirects to the tags.
+func (h contactHTTPHandler) PostTags(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	var from []contact.Tag
+	for _, s := range r.PostForm["From"] {
+		if t, err := contact.ParseTag(s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid tag %q: %v", s, err), http.StatusBadRequest)
+			return
+		} else {
+			from = append(from, t)
+		}
+	}
+	if len(from) == 0 {
+		h.writeTagsPage(w, r, fmt.Errorf("no tags selected"))
+	} else if to, err := contact.ParseTag(r.PostForm.Get("To")); err != nil {
+		h.writeTagsPage(w, r, fmt.Errorf("the tags were not renamed: %w", err))
+	} else if renamed, err := h.contactRepository.RenameTag(r.Context(), to, from...); err != nil {
+		writeRepositoryError(w, err)
+	} else {
+		log.Printf("Renamed tags %q to %q on %d contacts", from, to, renamed)
+		http.Redirect(w, r, h.paths.Tags.String(), http.StatusSeeOther)
+	}
+}
+
+func (h contactHTTPHandler) writeTagsPage(w http.ResponseWriter, r *http.Request, renameErr error) {
+	tags, err := h.contactRepository.FindTags(r.Context())
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	p := ht.TagsPage{
+		Tags:  tags,
+		Error: renameErr,
+		URLs: ht.TagsPageURLs{
+			Rename:      h.paths.Tags.TemplateURL(),
+			ContactList: h.paths.List.TemplateURL(),
+		},
+	}
+	if err := ht.WriteTagsPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestTags(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewPopulatedInMemoryContactRepository()
+	contacts, _, _, err := repo.FindAll(ctx, contact.Page{Size: 3})
+	if err != nil || len(contacts) != 3 {
+		t.Fatalf("expected 3 contacts, got %d (err: %v)", len(contacts), err)
+	}
+	joe, jane, other := contacts[0], contacts[1], contacts[2]
+	mux := newTestMux(t, repo)
+	post := func(path string, form url.Values, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != status {
+			t.Fatalf("expected status %d, got %d: %s", status, res.Code, res.Body)
+		}
+		return res
+	}
+	get := func(path string) string {
+		t.Helper()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+		}
+		return res.Body.String()
+	}
+	tagsOf := func(id contact.Id) []contact.Tag {
+		t.Helper()
+		c, found, err := repo.FindById(ctx, id)
+		if err != nil || !found {
+			t.Fatalf("expected contact %v, found: %t (err: %v)", id, found, err)
+		}
+		return c.Tags
+	}
+
+	page := post("/contact/batch", url.Values{"Action": {"tag"}, "Tag": {" Book  Club "}, "Id": {joe.Id.String(), jane.Id.String()}}, http.StatusOK).Body.String()
+	if !strings.Contains(page, `Tagged 2 contacts with &#34;book club&#34;.`) {
+		t.Errorf("expected the partial to confirm the tagging, got %q", page)
+	}
+	post("/contact/batch", url.Values{"Action": {"tag"}, "Tag": {"work"}, "Id": {joe.Id.String()}}, http.StatusOK)
+	if tags := tagsOf(joe.Id); !slices.Equal(tags, []contact.Tag{"book club", "work"}) {
+		t.Errorf("expected joe tagged, got %q", tags)
+	}
+
+	list := get("/contact/list")
+	for _, expected := range []string{`<aside id="Tags">`, "book club (2)", "work (1)", `<a class="tag"`} {
+		if !strings.Contains(list, expected) {
+			t.Errorf("expected %q in the list", expected)
+		}
+	}
+	filtered := get("/contact/list?" + url.Values{"SearchTerm": {`tag:"book club"`}}.Encode())
+	if !strings.Contains(filtered, joe.Id.String()) || !strings.Contains(filtered, jane.Id.String()) ||
+		strings.Contains(filtered, `id="Contact-`+other.Id.String()) {
+		t.Errorf("expected only the contacts tagged book club listed")
+	}
+
+	post("/contact/tags", url.Values{"From": {"book club", "work"}, "To": {"Friends"}}, http.StatusSeeOther)
+	if tags := tagsOf(joe.Id); !slices.Equal(tags, []contact.Tag{"friends"}) {
+		t.Errorf("expected joe's tags merged into friends, got %q", tags)
+	}
+	if tags := tagsOf(jane.Id); !slices.Equal(tags, []contact.Tag{"friends"}) {
+		t.Errorf("expected jane's tag renamed to friends, got %q", tags)
+	}
+	if page := get("/contact/tags"); !strings.Contains(page, "friends") || strings.Contains(page, "book club") {
+		t.Errorf("expected only the renamed tag, got %q", page)
+	}
+	if page := post("/contact/tags", url.Values{"From": {"friends"}, "To": {"a, b"}}, http.StatusOK).Body.String(); !strings.Contains(page, "the tags were not renamed") {
+		t.Errorf("expected the rename to be refused, got %q", page)
+	}
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+	"dev.acorello.it/go/contacts/contact/tenant"
+	"dev.acorello.it/go/contacts/user"
+)
+
+// TenantCookie names the tenant whose address book an admin views, instead of
+// their own (see PostTenants).
+const TenantCookie = "tenant"
+
+// maxTenantMatches is how many contacts of each tenant GetTenants lists
+const maxTenantMatches = 10
+
+// GetTenants lists, to admins, the tenants with how many contacts each has;
+// books not open are not opened to count them. Given a SearchTerm, it also
+// lists the first contacts matching it in the book of each tenant, which
+// opens them all.
+func (h contactHTTPHandler) GetTenants(w http.ResponseWriter, r *http.Request) {
+	if u, ok := user.Of(r.Context()); !ok || !u.Admin {
+		http.Error(w, "admins only", http.StatusForbidden)
+		return
+	}
+	current, _ := tenant.Of(r.Context())
+	p := ht.TenantsPage{
+		SearchTerm: strings.TrimSpace(r.URL.Query().Get("SearchTerm")),
+		URLs: ht.TenantsPageURLs{
+			View:        h.paths.Tenants.TemplateURL(),
+			ContactList: h.paths.List.TemplateURL(),
+		},
+	}
+	if p.SearchTerm != "" {
+		var err error
+		if p.Matches, p.SearchError, err = h.searchTenants(r.Context(), p.SearchTerm); err != nil {
+			writeRepositoryError(w, err)
+			return
+		}
+	}
+	for _, t := range h.books.Tenants() {
+		row := ht.TenantRow{Tenant: t.String(), Open: h.books.IsOpen(t), Current: t == current}
+		if row.Open {
+			var err error
+			if row.Count, err = h.contactRepository.Count(tenant.With(r.Context(), t)); err != nil {
+				writeRepositoryError(w, err)
+				return
+			}
+		}
+		p.Rows = append(p.Rows, row)
+	}
+	if err := ht.WriteTenantsPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// searchTenants finds the first contacts matching searchTerm in the book of
+// each tenant; queryErr explains why searchTerm is not a valid query.
+func (h contactHTTPHandler) searchTenants(ctx context.Context, searchTerm string) (matches []ht.TenantMatches, queryErr, err error) {
+	query, queryErr := contact.ParseQuery(searchTerm)
+	if queryErr != nil {
+		return nil, queryErr, nil
+	}
+	for _, t := range h.books.Tenants() {
+		found, _, more, err := h.contactRepository.FindByQuery(tenant.With(ctx, t), query, contact.Page{Size: maxTenantMatches})
+		if err != nil {
+			return nil, nil, err
+		} else if len(found) > 0 {
+			matches = append(matches, ht.TenantMatches{Tenant: t.String(), Contacts: found, More: more})
+		}
+	}
+	return matches, nil, nil
+}
+
+// PostTenants lets an admin view the address book of the Tenant given, then
+// redirects to its contacts, or to its contact with the Id given.
+func (h contactHTTPHandler) PostTenants(w http.ResponseWriter, r *http.Request) {
+	u, ok := user.Of(r.Context())
+	if !ok || !u.Admin {
+		http.Error(w, "admins only", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	t := tenant.Id(r.PostForm.Get("Tenant"))
+	if !slices.Contains(h.books.Tenants(), t) {
+		http.Error(w, "unknown tenant", http.StatusBadRequest)
+		return
+	}
+	next := h.paths.List.String()
+	if id := r.PostForm.Get("Id"); id != "" {
+		if _, err := contact.ParseId(id); err != nil {
+			http.Error(w, "invalid contact id", http.StatusBadRequest)
+			return
+		}
+		next = h.paths.Root.Add(CustomerId, id).String()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     TenantCookie,
+		Value:    t.String(),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("Admin %q views the contacts of %q", u.Name, t)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
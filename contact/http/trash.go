@@ -0,0 +1,87 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+)
+
+// GetTrash lists the deleted contacts, a page at a time
+func (h contactHTTPHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, err := parsePage(q.Get("pageAfter"), q.Get("pageSize"), "")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid page: %v", err), http.StatusBadRequest)
+		return
+	}
+	h.writeTrashPage(w, r, page, nil)
+}
+
+// PostRestore restores the contact from the trash, redirecting to it; if one
+// of its e-mails was taken meanwhile, the trash shows why it was not restored.
+func (h contactHTTPHandler) PostRestore(w http.ResponseWriter, r *http.Request) {
+	id, ok := submittedId(w, r)
+	if !ok {
+		return
+	}
+	var emailOwnerErr contact.EmailOwnerError
+	if restored, err := h.contactRepository.Restore(r.Context(), id); errors.As(err, &emailOwnerErr) {
+		log.Printf("Error restoring contact: %v", err)
+		restoreErr := fmt.Errorf("the contact was not restored: its e-mail address %s is now in use by another contact", emailOwnerErr.Email)
+		h.writeTrashPage(w, r, contact.Page{Size: 10}, restoreErr)
+	} else if err != nil {
+		writeRepositoryError(w, err)
+	} else if !restored {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		log.Printf("Restored: %q", id)
+		http.Redirect(w, r, h.paths.Root.Add(CustomerId, id.String()).String(), http.StatusSeeOther)
+	}
+}
+
+// Purge deletes the contact from the trash for good
+func (h contactHTTPHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	id, ok := submittedId(w, r)
+	if !ok {
+		return
+	}
+	if purged, err := h.contactRepository.Purge(r.Context(), id); err != nil {
+		writeRepositoryError(w, err)
+	} else if !purged {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		log.Printf("Purged: %q", id)
+		http.Redirect(w, r, h.paths.Trash.String(), http.StatusSeeOther)
+	}
+}
+
+func (h contactHTTPHandler) writeTrashPage(w http.ResponseWriter, r *http.Request, page contact.Page, restoreErr error) {
+	deleted, next, more, err := h.contactRepository.FindDeleted(r.Context(), page)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	p := ht.TrashPage{
+		Error: restoreErr,
+		URLs:  ht.TrashPageURLs{ContactList: h.paths.List.TemplateURL()},
+	}
+	for _, d := range deleted {
+		_id := d.Id.String()
+		p.Rows = append(p.Rows, ht.TrashRow{
+			DeletedContact: d,
+			Restore:        h.paths.Restore.Add(CustomerId, _id).TemplateURL(),
+			Purge:          h.paths.Trash.Add(CustomerId, _id).TemplateURL(),
+		})
+	}
+	if more {
+		p.URLs.NextPage = h.paths.Trash.Add("pageAfter", string(next.After)).Add("pageSize", strconv.Itoa(next.Size)).TemplateURL()
+	}
+	if err := ht.WriteTrashPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
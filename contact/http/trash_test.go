@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestDeleteUndoAndTrash(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs",
+		Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("joe@example.com")}}}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestMux(t, repo)
+	do := func(method, target string, status int) *httptest.ResponseRecorder {
+		t.Helper()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(method, target, nil))
+		if res.Code != status {
+			t.Fatalf("%s %s: expected status %d, got %d", method, target, status, res.Code)
+		}
+		return res
+	}
+	_id := joe.Id.String()
+
+	list := do(http.MethodDelete, "/contact/?Id="+_id, http.StatusSeeOther).Header().Get("Location")
+	page := do(http.MethodGet, list, http.StatusOK).Body.String()
+	for _, expected := range []string{"Deleted Bloggs, Joe.", `hx-post="/contact/trash/restore?Id=` + _id + `"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the list after deletion", expected)
+		}
+	}
+	if location := do(http.MethodPost, "/contact/trash/restore?Id="+_id, http.StatusSeeOther).Header().Get("Location"); location != "/contact/?Id="+_id {
+		t.Errorf("expected a redirect to the restored contact, got %q", location)
+	}
+	do(http.MethodGet, "/contact/?Id="+_id, http.StatusOK)
+
+	do(http.MethodDelete, "/contact/?Id="+_id, http.StatusSeeOther)
+	if page := do(http.MethodGet, "/contact/trash", http.StatusOK).Body.String(); !strings.Contains(page, "joe@example.com") {
+		t.Errorf("expected the deleted contact in the trash")
+	}
+	impostor := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Impostor", Emails: joe.Emails}
+	if err := repo.Store(ctx, impostor); err != nil {
+		t.Fatal(err)
+	}
+	if page := do(http.MethodPost, "/contact/trash/restore?Id="+_id, http.StatusOK).Body.String(); !strings.Contains(page, "is now in use by another contact") {
+		t.Errorf("expected the trash to explain why the contact was not restored")
+	}
+	do(http.MethodDelete, "/contact/trash?Id="+_id, http.StatusSeeOther)
+	if page := do(http.MethodGet, "/contact/trash", http.StatusOK).Body.String(); !strings.Contains(page, "The trash is empty") {
+		t.Errorf("expected the trash to be empty after purging")
+	}
+	do(http.MethodPost, "/contact/trash/restore?Id="+_id, http.StatusNotFound)
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/http/ht"
+	"dev.acorello.it/go/contacts/contact/vcard"
+	"dev.acorello.it/go/contacts/templates"
+)
+
+// maxImportSize is the size of the largest vCard file accepted by PostImport
+const maxImportSize = 10 << 20
+
+func (h contactHTTPHandler) GetImport(w http.ResponseWriter, r *http.Request) {
+	h.writeImportPage(w, ht.ImportPage{})
+}
+
+// PostImport imports the cards of the uploaded vCard file, validated as by the
+// contact form, one by one, reporting the result of each; cards with an e-mail
+// of another contact are not imported (see contact.EmailOwnerError), nor those
+// with the id of a contact in the trash, and those with the id of an existing
+// contact replace it only if the Replace box was checked.
+func (h contactHTTPHandler) PostImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, _, err := r.FormFile("Cards")
+	if err != nil {
+		log.Printf("Error reading upload: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		h.writeImportPage(w, ht.ImportPage{Error: fmt.Errorf("failed to read the file: %v", err)})
+		return
+	}
+	defer file.Close()
+	replace := r.FormValue("Replace") != ""
+	var results []ht.ImportResult
+	cards := vcard.NewDecoder(file)
+	for card := 1; ; card++ {
+		c, err := cards.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var cardErr *vcard.CardError
+		if err != nil && !errors.As(err, &cardErr) {
+			log.Printf("Error reading upload: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			h.writeImportPage(w, ht.ImportPage{Error: fmt.Errorf("failed to read the file: %v", err), Results: results, Replace: replace})
+			return
+		}
+		result := ht.ImportResult{Card: card, Name: strings.TrimSpace(c.FirstName + " " + c.LastName), Error: err}
+		if err == nil {
+			result.Error = h.importContact(r, c, replace)
+		}
+		if result.Error == nil {
+			result.ContactURL = h.paths.Root.Add(CustomerId, c.Id.String()).TemplateURL()
+		}
+		results = append(results, result)
+	}
+	page := ht.ImportPage{Results: results, Replace: replace}
+	if len(results) == 0 {
+		page.Error = fmt.Errorf("no cards found")
+	}
+	h.writeImportPage(w, page)
+}
+
+func (h contactHTTPHandler) importContact(r *http.Request, c contact.Contact, replace bool) error {
+	// storing the card would take the contact out of the trash
+	if _, trashed, err := h.contactRepository.FindDeletedById(r.Context(), c.Id); err != nil {
+		log.Printf("Error importing contact: %v", err)
+		return fmt.Errorf("failed to store the contact")
+	} else if trashed {
+		return fmt.Errorf("the contact with this card's id is in the trash: restore it to import the card")
+	}
+	// the card replaces the contact with its UID, as stored now, keeping the
+	// values of the custom fields, which cards don't have: a new contact is
+	// invalid if some are required
+	if stored, found, err := h.contactRepository.FindById(r.Context(), c.Id); err != nil {
+		log.Printf("Error importing contact: %v", err)
+		return fmt.Errorf("failed to store the contact")
+	} else if found && !replace {
+		return fmt.Errorf("a contact with this card's id exists: import again replacing existing contacts to overwrite it")
+	} else {
+		c.Version = stored.Version
+		c.Custom = maps.Clone(stored.Custom)
+		maps.DeleteFunc(c.Custom, func(name, _ string) bool { return !h.fields.Has(name) })
+	}
+	c, _, fieldErrors := parseContact(apiContactOf(c).values(), h.fields)
+	if len(fieldErrors) > 0 {
+		return describeFieldErrors(fieldErrors)
+	} else if err := keepUnknownCustom(r.Context(), h.contactRepository, h.fields, &c); err != nil {
+		log.Printf("Error importing contact: %v", err)
+		return fmt.Errorf("failed to store the contact")
+	}
+	var emailOwnerErr contact.EmailOwnerError
+	if err := h.contactRepository.Store(r.Context(), c); errors.As(err, &emailOwnerErr) {
+		return fmt.Errorf("e-mail address %s already in use by another contact", emailOwnerErr.Email)
+	} else if errors.As(err, new(contact.VersionConflictError)) {
+		return fmt.Errorf("the contact changed while importing: import again to overwrite it")
+	} else if err != nil {
+		log.Printf("Error importing contact: %v", err)
+		return fmt.Errorf("failed to store the contact")
+	}
+	log.Printf("Imported: %#v", c)
+	return nil
+}
+
+// describeFieldErrors lists the field errors by field, eg. "FirstName: blank; Emails.1: …"
+func describeFieldErrors(fieldErrors templates.ErrorMap) error {
+	var descriptions []string
+	for field, err := range fieldErrors {
+		descriptions = append(descriptions, fmt.Sprintf("%s: %v", field, err))
+	}
+	slices.Sort(descriptions)
+	return errors.New(strings.Join(descriptions, "; "))
+}
+
+func (h contactHTTPHandler) writeImportPage(w http.ResponseWriter, p ht.ImportPage) {
+	p.URLs = ht.ImportPageURLs{
+		Import:      h.paths.Import.TemplateURL(),
+		ContactList: h.paths.List.TemplateURL(),
+	}
+	if err := ht.WriteImportPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
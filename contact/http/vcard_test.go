@@ -0,0 +1,106 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestImportThenExport(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	mux := newTestMux(t, contact.NewInMemoryContactRepository())
+
+	cards := strings.Join([]string{
+		"BEGIN:VCARD", "VERSION:4.0", "UID:joe", "N:Bloggs;Joe;;;", "EMAIL:joe@example.com", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:4.0", "UID:nobody", "N:Nobody;No;;;", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:4.0", "UID:impostor", "N:Impostor;Joe;;;", "EMAIL:JOE@example.com", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:4.0", "N:Doe;Jane;;;", "EMAIL:not an e-mail", "END:VCARD",
+	}, "\r\n")
+	page := importCards(t, mux, cards, false)
+	for _, expected := range []string{"imported", "Emails: at least one e-mail address is required", "already in use by another contact", "line 21"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in the results", expected)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/contact/export?SearchTerm=bloggs", nil))
+	if res.Code != http.StatusOK || strings.Count(res.Body.String(), "BEGIN:VCARD") != 1 || !strings.Contains(res.Body.String(), "EMAIL;PREF=1:joe@example.com") {
+		t.Errorf("unexpected export %d %q", res.Code, res.Body)
+	}
+	if disposition := res.Header().Get("Content-Disposition"); disposition != `attachment; filename="contacts.vcf"` {
+		t.Errorf("unexpected Content-Disposition %q", disposition)
+	}
+}
+
+// TestImportExistingContacts imports a card with the id of a contact already
+// stored, replacing it only when asked to, and one of a deleted contact, which
+// stays in the trash.
+func TestImportExistingContacts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := context.Background()
+	repo := contact.NewInMemoryContactRepository()
+	joe, jane := contact.Contact{Id: contact.NewId()}, contact.Contact{Id: contact.NewId()}
+	joe.FirstName, joe.LastName, joe.Emails = "Joe", "Bloggs", []contact.EmailEntry{{Email: contact.MustParseEmail("joe@example.com")}}
+	jane.FirstName, jane.LastName, jane.Emails = "Jane", "Doe", []contact.EmailEntry{{Email: contact.MustParseEmail("jane@example.com")}}
+	if err := repo.Store(ctx, joe, jane); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Delete(ctx, jane.Id); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestMux(t, repo)
+	cards := strings.Join([]string{
+		"BEGIN:VCARD", "VERSION:4.0", "UID:urn:uuid:" + joe.Id.String(), "N:Bloggs;Joseph;;;", "EMAIL:joe@example.com", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:4.0", "UID:urn:uuid:" + jane.Id.String(), "N:Doe;Janet;;;", "EMAIL:jane@example.com", "END:VCARD",
+	}, "\r\n")
+
+	for _, replace := range []bool{false, true} {
+		page := importCards(t, mux, cards, replace)
+		if !strings.Contains(page, "is in the trash") {
+			t.Errorf("replace %t: expected the card of the deleted contact not imported, got %q", replace, page)
+		}
+		if stored, _, _ := repo.FindById(ctx, joe.Id); replace != (stored.FirstName == "Joseph") {
+			t.Errorf("replace %t: unexpected first name %q", replace, stored.FirstName)
+		} else if !replace && !strings.Contains(page, "a contact with this card&#39;s id exists") {
+			t.Errorf("expected the existing contact reported, got %q", page)
+		}
+	}
+	if _, trashed, _ := repo.FindDeletedById(ctx, jane.Id); !trashed {
+		t.Error("expected the deleted contact still in the trash")
+	}
+}
+
+// importCards posts the cards to the import form, returning the page
+func importCards(t *testing.T, mux *http.ServeMux, cards string, replace bool) string {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("Cards", "contacts.vcf")
+	part.Write([]byte(cards))
+	if replace {
+		form.WriteField("Replace", "on")
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/contact/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.Code)
+	}
+	return res.Body.String()
+}
@@ -1,98 +1,483 @@
 package contact
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"slices"
+	"sync"
+	"time"
+
+	"dev.acorello.it/go/contacts/search"
 )
 
+// InMemoryRepository is safe for concurrent use: reads share a lock, writes take it exclusively.
+//
+// Contacts are kept in no particular order; the id and e-mail indexes make
+// lookups O(1), the full-text index serves FindByQuery, and a sorted index for
+// each Order, and one for the trash, lets pages start from their cursor rather
+// than sorting all the contacts.
+// Contacts are cloned in and out, so callers can't alter the stored ones.
 type InMemoryRepository struct {
+	mu       sync.RWMutex
 	contacts []Contact
+	byId     map[Id]int    // position in contacts
+	byEmail  map[string]Id // by canonical e-mail
+	text     *search.Index[Id]
+	sorted   map[Order]*sortedIndex[string] // by SortKey, for each Order By a field
+	deleted  map[Id]DeletedContact          // the trash
+	trashed  *sortedIndex[time.Time]        // by DeletedAt, see DeletedPageOf
+}
+
+func NewInMemoryContactRepository() *InMemoryRepository {
+	return newInMemoryContactRepository(nil)
+}
+
+func NewPopulatedInMemoryContactRepository() *InMemoryRepository {
+	contacts := slices.Clone(fixedContactsList)
+	for i := range contacts {
+		contacts[i].Version = 1
+	}
+	return newInMemoryContactRepository(contacts)
+}
+
+func newInMemoryContactRepository(contacts []Contact) *InMemoryRepository {
+	me := &InMemoryRepository{
+		contacts: contacts,
+		byId:     make(map[Id]int, len(contacts)),
+		byEmail:  make(map[string]Id, len(contacts)),
+		text:     search.NewIndex[Id](),
+		sorted:   make(map[Order]*sortedIndex[string]),
+		deleted:  make(map[Id]DeletedContact),
+		trashed:  newTrashIndex(),
+	}
+	for _, by := range SortFields {
+		for _, descending := range []bool{false, true} {
+			order := Order{by, descending}
+			me.sorted[order] = newContactIndex(order)
+		}
+	}
+	for i, c := range contacts {
+		me.byId[c.Id] = i
+		for _, e := range c.Emails {
+			me.byEmail[e.Email.Canonical()] = c.Id
+		}
+		me.text.Put(c.Id, c.SearchFields()...)
+		me.sortIndexes(c, (*sortedIndex[string]).insert)
+	}
+	return me
+}
+
+func (me *InMemoryRepository) FindById(ctx context.Context, id Id) (c Contact, found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return c, false, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	if idx, found := me.byId[id]; found {
+		return me.contacts[idx].Clone(), true, nil
+	} else {
+		return c, false, nil
+	}
 }
 
-func NewPopulatedInMemoryContactRepository() InMemoryRepository {
-	return InMemoryRepository{
-		contacts: slices.Clone(fixedContactsList),
+func (me *InMemoryRepository) FindIdByEmail(ctx context.Context, email Email) (res Id, found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return res, false, err
 	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	res, found = me.findIdByEmail(email)
+	return res, found, nil
+}
+
+func (me *InMemoryRepository) findIdByEmail(email Email) (res Id, found bool) {
+	res, found = me.byEmail[email.Canonical()]
+	return res, found
 }
 
-func (me InMemoryRepository) FindById(id Id) (c Contact, found bool) {
-	idx := slices.IndexFunc(me.contacts, id.HasSameId)
-	if idx >= 0 {
-		return me.contacts[idx], true
+func (me *InMemoryRepository) Delete(ctx context.Context, id Id) (deleted bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if c, found := me.remove(id); !found {
+		return false, nil
 	} else {
+		me.trash(DeletedContact{Contact: c, DeletedAt: time.Now()})
+		return true, nil
+	}
+}
+
+func (me *InMemoryRepository) DeleteAll(ctx context.Context, ids ...Id) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, id := range ids {
+		if _, found := me.byId[id]; !found {
+			return NotFoundError{Id: id}
+		}
+	}
+	now := time.Now()
+	for _, id := range ids {
+		if c, found := me.remove(id); found { // not if listed twice
+			me.trash(DeletedContact{Contact: c, DeletedAt: now})
+		}
+	}
+	return nil
+}
+
+// remove removes the contact, and its index entries, returning it
+func (me *InMemoryRepository) remove(id Id) (c Contact, found bool) {
+	idx, found := me.byId[id]
+	if !found {
 		return c, false
 	}
+	c = me.contacts[idx]
+	delete(me.byId, id)
+	me.unindexEmails(c)
+	me.text.Remove(id)
+	me.sortIndexes(c, (*sortedIndex[string]).remove)
+	// the last contact takes its place, so that no other moves
+	last := len(me.contacts) - 1
+	if idx != last {
+		me.contacts[idx] = me.contacts[last]
+		me.byId[me.contacts[idx].Id] = idx
+	}
+	me.contacts[last] = Contact{}
+	me.contacts = me.contacts[:last]
+	return c, true
+}
+
+func (me *InMemoryRepository) FindDeleted(ctx context.Context, page Page) (result []DeletedContact, next Page, more bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, page, false, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	deletedAt, id, ok, err := page.DeletedAfter()
+	if err != nil {
+		return nil, page, false, err
+	}
+	deleted := func(yield func(DeletedContact) bool) {
+		for id := range me.trashed.after(deletedAt, id, ok) {
+			if !yield(me.deleted[id]) {
+				return
+			}
+		}
+	}
+	result, next, more = pageOf(deleted, page, page.FollowingDeleted)
+	for i, d := range result {
+		result[i].Contact = d.Contact.Clone()
+	}
+	return result, next, more, nil
+}
+
+func (me *InMemoryRepository) FindDeletedById(ctx context.Context, id Id) (d DeletedContact, found bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return d, false, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	d, found = me.deleted[id]
+	d.Contact = d.Contact.Clone()
+	return d, found, nil
+}
+
+func (me *InMemoryRepository) Restore(ctx context.Context, id Id) (restored bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	d, found := me.deleted[id]
+	if !found {
+		return false, nil
+	} else if err := me.checkEmailOwner(d.Contact); err != nil {
+		return false, err
+	}
+	d.Version++
+	me.store(d.Contact)
+	return true, nil
+}
+
+func (me *InMemoryRepository) Purge(ctx context.Context, id Id) (purged bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	_, purged = me.deleted[id]
+	me.untrash(id)
+	return purged, nil
+}
+
+func (me *InMemoryRepository) PurgeDeletedBefore(ctx context.Context, t time.Time) (purged int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for id, d := range me.deleted {
+		if d.DeletedAt.Before(t) {
+			me.untrash(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (me *InMemoryRepository) FindTags(ctx context.Context) ([]TagCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return CountTags(me.contacts), nil
 }
 
-func (me InMemoryRepository) FindIdByEmail(email string) (res Id, found bool) {
-	for i := range me.contacts {
-		if me.contacts[i].Email == email {
-			return me.contacts[i].Id, true
+func (me *InMemoryRepository) RenameTag(ctx context.Context, to Tag, from ...Tag) (renamed int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for i, c := range me.contacts {
+		if c, changed := c.RenameTag(to, from...); changed {
+			c.Version++
+			me.contacts[i] = c // only tags changed: no index to update
+			renamed++
 		}
 	}
-	var zeroId Id
-	return zeroId, false
+	return renamed, nil
+}
+
+func (me *InMemoryRepository) Count(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return len(me.contacts), nil
 }
 
-func (me *InMemoryRepository) Delete(id Id) {
-	me.contacts = slices.DeleteFunc(me.contacts, id.HasSameId)
+func (me *InMemoryRepository) FindAll(ctx context.Context, page Page) (result []Contact, next Page, more bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, page, false, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.walk(page, func(Contact) bool { return true })
 }
 
-func (me InMemoryRepository) FindAll(page Page) (result []Contact, more bool) {
-	maxEnd := len(me.contacts)
-	if page.StartOffset() > maxEnd {
-		return nil, false
+// walk returns the page of the contacts kept, reading the sorted index of the
+// page's Order from its cursor.
+func (me *InMemoryRepository) walk(page Page, keep func(Contact) bool) (result []Contact, next Page, more bool, err error) {
+	key, id, ok, err := page.KeyAfter()
+	if err != nil {
+		return nil, page, false, err
+	}
+	kept := func(yield func(Contact) bool) {
+		for id := range me.sorted[Order{page.Order.Field(), page.Order.Descending}].after(key, id, ok) {
+			if c := me.contacts[me.byId[id]]; keep(c) && !yield(c) {
+				return
+			}
+		}
+	}
+	result, next, more = pageOf(kept, page, page.Following)
+	for i, c := range result {
+		result[i] = c.Clone()
+	}
+	return result, next, more, nil
+}
+
+func (me *InMemoryRepository) Store(ctx context.Context, cs ...Contact) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	claimed := make(map[string]Id) // canonical e-mails of cs
+	for _, c := range cs {
+		if err := me.checkVersion(c); err != nil {
+			return err
+		} else if err := me.checkEmailOwner(c); err != nil {
+			return err
+		}
+		for _, e := range c.Emails {
+			if owner, found := claimed[e.Email.Canonical()]; found && owner != c.Id {
+				return EmailOwnerError{Email: e.Email, Owner: owner}
+			}
+			claimed[e.Email.Canonical()] = c.Id
+		}
 	}
-	start := page.StartOffset()
-	pageEnd := page.EndOffset()
-	end := min(pageEnd, maxEnd)
-	result = slices.Clone(me.contacts[start:end])
-	return result, maxEnd > pageEnd
+	for _, c := range cs {
+		log.Printf("Storing %#v", c)
+		c.Version++
+		me.store(c)
+	}
+	return nil
 }
 
-// TODO: implement validation ( eg. [e-mail]--N--1--[contactId] )
-func (me *InMemoryRepository) Store(c Contact) error {
-	log.Printf("Storing %#v", c)
-	if err := me.checkEmailOwner(c); err != nil {
+func (me *InMemoryRepository) Merge(ctx context.Context, merged, other Contact) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	existingIdx := slices.IndexFunc(me.contacts, c.Id.HasSameId)
-	if existingIdx >= 0 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if merged.Id == other.Id {
+		return fmt.Errorf("contact %q can't be merged into itself", merged.Id)
+	} else if err := me.checkVersion(merged); err != nil {
+		return err
+	} else if _, found := me.byId[other.Id]; !found {
+		return VersionConflictError{Id: other.Id, Version: other.Version}
+	} else if err := me.checkVersion(other); err != nil {
+		return err
+	}
+	if err := merged.CheckEmails(); err != nil {
+		return err
+	}
+	for _, e := range merged.Emails {
+		if owner, found := me.findIdByEmail(e.Email); found && owner != merged.Id && owner != other.Id {
+			return EmailOwnerError{Email: e.Email, Owner: owner}
+		}
+	}
+	log.Printf("Merging %q into %#v", other.Id, merged)
+	removed, _ := me.remove(other.Id)
+	me.trash(DeletedContact{Contact: removed, DeletedAt: time.Now()})
+	merged.Version++
+	me.store(merged)
+	return nil
+}
+
+// Load stores cs as they are, Version included, with no checks: it loads
+// contacts that were checked when stored elsewhere, eg. in a journal.
+func (me *InMemoryRepository) Load(cs ...Contact) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, c := range cs {
+		me.store(c)
+	}
+}
+
+// LoadDeleted puts ds in the trash as they are, removing the stored contacts
+// with their ids; like Load, it loads contacts deleted elsewhere.
+func (me *InMemoryRepository) LoadDeleted(ds ...DeletedContact) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, d := range ds {
+		me.remove(d.Id)
+		d.Contact = d.Contact.Clone()
+		me.trash(d)
+	}
+}
+
+func (me *InMemoryRepository) store(c Contact) {
+	c = c.Clone()
+	if existingIdx, found := me.byId[c.Id]; found {
+		me.unindexEmails(me.contacts[existingIdx])
+		me.sortIndexes(me.contacts[existingIdx], (*sortedIndex[string]).remove)
 		me.contacts[existingIdx] = c
 	} else {
+		me.byId[c.Id] = len(me.contacts)
 		me.contacts = append(me.contacts, c)
 	}
+	for _, e := range c.Emails {
+		me.byEmail[e.Email.Canonical()] = c.Id
+	}
+	me.text.Put(c.Id, c.SearchFields()...)
+	me.sortIndexes(c, (*sortedIndex[string]).insert)
+	me.untrash(c.Id)
+}
+
+// sortIndexes applies update, eg. insert or remove, to c in each sorted index
+func (me *InMemoryRepository) sortIndexes(c Contact, update func(idx *sortedIndex[string], key string, id Id)) {
+	for order, idx := range me.sorted {
+		update(idx, c.SortKey(order.By), c.Id)
+	}
+}
+
+// trash puts d in the trash, replacing the contact deleted with its id
+func (me *InMemoryRepository) trash(d DeletedContact) {
+	me.untrash(d.Id)
+	me.deleted[d.Id] = d
+	me.trashed.insert(d.DeletedAt, d.Id)
+}
+
+// untrash takes the contact with the id out of the trash, if there
+func (me *InMemoryRepository) untrash(id Id) {
+	if d, found := me.deleted[id]; found {
+		me.trashed.remove(d.DeletedAt, id)
+		delete(me.deleted, id)
+	}
+}
+
+func (me *InMemoryRepository) unindexEmails(c Contact) {
+	for _, e := range c.Emails {
+		delete(me.byEmail, e.Email.Canonical())
+	}
+}
+
+// checkVersion checks that c carries the Version of the stored contact
+func (me *InMemoryRepository) checkVersion(c Contact) error {
+	var current int
+	if idx, found := me.byId[c.Id]; found {
+		current = me.contacts[idx].Version
+	}
+	if c.Version != current {
+		return VersionConflictError{Id: c.Id, Version: c.Version, Current: current}
+	}
 	return nil
 }
 
+// checkEmailOwner checks that none of the e-mails of c belongs to another
+// contact, nor to c twice
 func (me *InMemoryRepository) checkEmailOwner(c Contact) error {
-	var alreadyAssignedId, found = me.FindIdByEmail(c.Email)
-	if found && c.Id != alreadyAssignedId {
-		return fmt.Errorf("e-mail already assigned to contact with id %q", alreadyAssignedId)
+	if err := c.CheckEmails(); err != nil {
+		return err
+	}
+	for _, e := range c.Emails {
+		var alreadyAssignedId, found = me.findIdByEmail(e.Email)
+		if found && c.Id != alreadyAssignedId {
+			return EmailOwnerError{Email: e.Email, Owner: alreadyAssignedId}
+		}
 	}
 	return nil
 }
 
-func (me InMemoryRepository) FindBySearchTerm(term string, page Page) (result []Contact, more bool) {
-	// me.contacts.findBy(p).drop(page.StartOffset()).take(page.Size)
-	start := page.StartOffset()
-	foundCount := 0
-	size := page.Size + 1 // we try fetching one more to tell if there is anothe page
-	for _, c := range me.contacts {
-		if len(result) >= size {
-			break
+func (me *InMemoryRepository) FindByQuery(ctx context.Context, q Query, page Page) (result []Contact, next Page, more bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, page, false, err
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	var candidates map[Id]bool
+	if words := RequiredWords(q); len(search.Terms(words)) > 0 {
+		candidates = make(map[Id]bool)
+		for id := range me.text.Search(words) {
+			candidates[id] = true
 		}
-		if c.AnyFieldContains(term) {
-			if foundCount >= start {
-				result = append(result, c)
-			}
-			foundCount += 1
+	}
+	if !page.RankedBy(q) {
+		return me.walk(page, func(c Contact) bool {
+			return (candidates == nil || candidates[c.Id]) && q.Matches(c)
+		})
+	}
+	var matches []Contact
+	if candidates == nil {
+		matches = slices.DeleteFunc(slices.Clone(me.contacts), func(c Contact) bool { return !q.Matches(c) })
+	}
+	for id := range candidates {
+		if c := me.contacts[me.byId[id]]; q.Matches(c) {
+			matches = append(matches, c)
 		}
 	}
-	if len(result) == size {
-		return result[:len(result)-1], true
-	} else {
-		return result, false
+	result, next, more, err = QueryPageOf(q, matches, page)
+	for i, c := range result {
+		result[i] = c.Clone()
 	}
+	return result, next, more, err
 }
@@ -0,0 +1,329 @@
+package contact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteKeepsIndexesConsistent(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPopulatedInMemoryContactRepository()
+	deleted := fixedContactsList[3]
+	if ok, err := repo.Delete(ctx, deleted.Id); !ok || err != nil {
+		t.Fatalf("expected %q to be deleted, got %v, %v", deleted.Id, ok, err)
+	}
+	if _, found, _ := repo.FindIdByEmail(ctx, deleted.Email()); found {
+		t.Errorf("e-mail %q should be free after deletion", deleted.Email())
+	}
+	for _, c := range fixedContactsList[4:] {
+		if got, found, _ := repo.FindById(ctx, c.Id); !found || !got.Equal(c) {
+			t.Errorf("expected %#v, got %#v", c, got)
+		}
+	}
+	moved, _, _ := repo.FindById(ctx, fixedContactsList[5].Id)
+	moved.Emails[0].Email = deleted.Email()
+	if err := repo.Store(ctx, moved); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := repo.FindIdByEmail(ctx, fixedContactsList[5].Email()); id != "" {
+		t.Errorf("previous e-mail of %q should be free, is assigned to %q", moved.Id, id)
+	}
+	if id, _, _ := repo.FindIdByEmail(ctx, MustParseEmail(strings.ToUpper(deleted.Email().String()))); id != moved.Id {
+		t.Errorf("e-mails should be looked up in canonical form, expected %q, got %q", moved.Id, id)
+	}
+}
+
+func TestStoreChecksAllEmails(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPopulatedInMemoryContactRepository()
+	joe := fixedContactsList[0]
+	jane, _, _ := repo.FindById(ctx, fixedContactsList[1].Id)
+	workEmail := joe.Emails[1].Email
+	jane.Emails = append(jane.Emails, EmailEntry{Label: Work, Email: workEmail})
+	var emailOwnerErr EmailOwnerError
+	if err := repo.Store(ctx, jane); !errors.As(err, &emailOwnerErr) || emailOwnerErr.Owner != joe.Id {
+		t.Errorf("expected EmailOwnerError with owner %q, got %v", joe.Id, err)
+	}
+	if id, _, _ := repo.FindIdByEmail(ctx, workEmail); id != joe.Id {
+		t.Errorf("expected %q to own %q, got %q", joe.Id, workEmail, id)
+	}
+	joe, _, _ = repo.FindById(ctx, joe.Id)
+	joe.Emails = append(joe.Emails, EmailEntry{Email: MustParseEmail(strings.ToUpper(workEmail.String()))})
+	var duplicateErr DuplicateEmailError
+	if err := repo.Store(ctx, joe); !errors.As(err, &duplicateErr) || duplicateErr.Position != len(joe.Emails)-1 {
+		t.Errorf("expected DuplicateEmailError at the last e-mail, got %v", err)
+	}
+}
+
+func TestStoreIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPopulatedInMemoryContactRepository()
+	joe := fixedContactsList[0]
+	ann := Contact{Id: NewId(), FirstName: "Ann", LastName: "Lee", Emails: []EmailEntry{{Primary: true, Email: MustParseEmail("ann@example.com")}}}
+	bob := Contact{Id: NewId(), FirstName: "Bob", LastName: "Lee", Emails: []EmailEntry{{Primary: true, Email: MustParseEmail("ANN@example.com")}}}
+	impostor := Contact{Id: NewId(), FirstName: "Joe", LastName: "Impostor", Emails: joe.Emails[:1]}
+	for _, batch := range [][]Contact{{ann, bob}, {ann, impostor}} {
+		var emailOwnerErr EmailOwnerError
+		if err := repo.Store(ctx, batch...); !errors.As(err, &emailOwnerErr) {
+			t.Errorf("expected EmailOwnerError, got %v", err)
+		}
+		if _, found, _ := repo.FindById(ctx, ann.Id); found {
+			t.Errorf("expected none of the batch to be stored")
+		}
+	}
+	bob.Emails = []EmailEntry{{Primary: true, Email: MustParseEmail("bob@example.com")}}
+	if err := repo.Store(ctx, ann, bob); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := repo.FindIdByEmail(ctx, MustParseEmail("bob@example.com")); id != bob.Id {
+		t.Errorf("expected %q to own bob@example.com, got %q", bob.Id, id)
+	}
+}
+
+func TestDeleteAllIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPopulatedInMemoryContactRepository()
+	joe, jane := fixedContactsList[0], fixedContactsList[1]
+	missing := NewId()
+	var notFoundErr NotFoundError
+	if err := repo.DeleteAll(ctx, joe.Id, missing, jane.Id); !errors.As(err, &notFoundErr) || notFoundErr.Id != missing {
+		t.Fatalf("expected NotFoundError of %q, got %v", missing, err)
+	}
+	if _, found, _ := repo.FindById(ctx, joe.Id); !found {
+		t.Errorf("expected none of the batch to be deleted")
+	}
+	if err := repo.DeleteAll(ctx, joe.Id, jane.Id, joe.Id); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _, _, _ := repo.FindDeleted(ctx, Page{Size: 10}); len(deleted) != 2 {
+		t.Errorf("expected 2 contacts in the trash, got %#v", deleted)
+	}
+}
+
+func TestTagsAreCountedAndRenamed(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryContactRepository()
+	joe := Contact{Id: NewId(), FirstName: "Joe", Tags: []Tag{"family", "pals"}}
+	ann := Contact{Id: NewId(), FirstName: "Ann", Tags: []Tag{"friends"}}
+	if err := repo.Store(ctx, joe, ann); err != nil {
+		t.Fatal(err)
+	}
+	if renamed, err := repo.RenameTag(ctx, "friends", "pals"); renamed != 1 || err != nil {
+		t.Fatalf("expected 1 contact renamed, got %d, %v", renamed, err)
+	}
+	if got, _, _ := repo.FindById(ctx, joe.Id); !slices.Equal(got.Tags, []Tag{"family", "friends"}) || got.Version != 2 {
+		t.Errorf("expected the tag renamed at version 2, got %#v", got)
+	}
+	counts, err := repo.FindTags(ctx)
+	if expected := []TagCount{{Tag: "family", Count: 1}, {Tag: "friends", Count: 2}}; err != nil || !slices.Equal(counts, expected) {
+		t.Errorf("expected %v, got %v (err: %v)", expected, counts, err)
+	}
+	if found, _, _, _ := repo.FindByQuery(ctx, TagQuery("friends"), Page{Size: 10}); len(found) != 2 {
+		t.Errorf("expected 2 contacts tagged friends, got %#v", found)
+	}
+}
+
+func TestStoreRejectsStaleVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPopulatedInMemoryContactRepository()
+	mine, _, _ := repo.FindById(ctx, fixedContactsList[0].Id)
+	theirs := mine.Clone()
+	theirs.LastName = "Theirs"
+	if err := repo.Store(ctx, theirs); err != nil {
+		t.Fatal(err)
+	}
+	mine.LastName = "Mine"
+	var conflictErr VersionConflictError
+	if err := repo.Store(ctx, mine); !errors.As(err, &conflictErr) || conflictErr.Current != mine.Version+1 {
+		t.Errorf("expected VersionConflictError at version %d, got %v", mine.Version+1, err)
+	}
+	if stored, _, _ := repo.FindById(ctx, mine.Id); stored.LastName != "Theirs" || stored.Version != mine.Version+1 {
+		t.Errorf("expected their change at version %d, got %#v", mine.Version+1, stored)
+	}
+	if _, err := repo.Delete(ctx, mine.Id); err != nil {
+		t.Fatal(err)
+	}
+	mine.Version = conflictErr.Current
+	if err := repo.Store(ctx, mine); !errors.As(err, &conflictErr) || conflictErr.Current != 0 {
+		t.Errorf("expected VersionConflictError of a deleted contact, got %v", err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPopulatedInMemoryContactRepository()
+	joe, jane := fixedContactsList[0], fixedContactsList[1]
+	for _, c := range []Contact{joe, jane} {
+		if deleted, err := repo.Delete(ctx, c.Id); !deleted || err != nil {
+			t.Fatalf("expected %q to be deleted, got %v, %v", c.Id, deleted, err)
+		}
+	}
+	if _, found, _ := repo.FindById(ctx, joe.Id); found {
+		t.Errorf("expected %q to be found only in the trash", joe.Id)
+	}
+	if deleted, _, _, _ := repo.FindDeleted(ctx, Page{Size: 10}); len(deleted) != 2 || deleted[0].Id != jane.Id {
+		t.Errorf("expected the trash to list %q first, got %#v", jane.Id, deleted)
+	}
+	impostor := Contact{Id: NewId(), FirstName: "Joe", LastName: "Impostor", Emails: joe.Emails[:1]}
+	if err := repo.Store(ctx, impostor); err != nil {
+		t.Fatalf("expected the e-mails of deleted contacts to be free: %v", err)
+	}
+	var emailOwnerErr EmailOwnerError
+	if _, err := repo.Restore(ctx, joe.Id); !errors.As(err, &emailOwnerErr) || emailOwnerErr.Owner != impostor.Id {
+		t.Errorf("expected EmailOwnerError with owner %q, got %v", impostor.Id, err)
+	}
+	if restored, err := repo.Restore(ctx, jane.Id); !restored || err != nil {
+		t.Fatalf("expected %q to be restored, got %v, %v", jane.Id, restored, err)
+	}
+	if got, _, _ := repo.FindById(ctx, jane.Id); !got.Equal(jane) || got.Version != 2 {
+		t.Errorf("expected %#v at version 2, got %#v", jane, got)
+	}
+	if purged, _ := repo.PurgeDeletedBefore(ctx, time.Now().Add(-time.Hour)); purged != 0 {
+		t.Errorf("expected nothing deleted an hour ago, purged %d", purged)
+	}
+	if purged, _ := repo.PurgeDeletedBefore(ctx, time.Now()); purged != 1 {
+		t.Errorf("expected %q to be purged, purged %d", joe.Id, purged)
+	}
+	if purged, _ := repo.Purge(ctx, joe.Id); purged {
+		t.Errorf("expected %q to be purged already", joe.Id)
+	}
+}
+
+const benchmarkSize = 100_000
+
+func benchmarkContacts() []Contact {
+	contacts := make([]Contact, benchmarkSize)
+	for i := range contacts {
+		contacts[i] = Contact{
+			Id:        NewId(),
+			FirstName: fmt.Sprint("First", i),
+			LastName:  fmt.Sprint("Last", i),
+			Phones:    []PhoneEntry{{Primary: true, Phone: MustParsePhone(fmt.Sprintf("07911 %06d", i))}},
+			Emails:    []EmailEntry{{Primary: true, Email: MustParseEmail(fmt.Sprintf("contact%d@example.com", i))}},
+		}
+	}
+	return contacts
+}
+
+// linearScan reproduces the lookups InMemoryRepository did before having indexes, as a baseline
+type linearScan []Contact
+
+func (me linearScan) findById(id Id) (Contact, bool) {
+	if idx := slices.IndexFunc(me, id.HasSameId); idx >= 0 {
+		return me[idx], true
+	}
+	return Contact{}, false
+}
+
+func (me linearScan) findIdByEmail(email Email) (Id, bool) {
+	for i := range me {
+		if me[i].Email().Canonical() == email.Canonical() {
+			return me[i].Id, true
+		}
+	}
+	return "", false
+}
+
+func (me linearScan) store(c Contact) error {
+	if owner, found := me.findIdByEmail(c.Email()); found && owner != c.Id {
+		return EmailOwnerError{Email: c.Email(), Owner: owner}
+	}
+	if idx := slices.IndexFunc(me, c.Id.HasSameId); idx >= 0 {
+		me[idx] = c
+	}
+	return nil
+}
+
+func BenchmarkFindById(b *testing.B) {
+	ctx := context.Background()
+	contacts := benchmarkContacts()
+	target := contacts[len(contacts)-1]
+	b.Run("indexed", func(b *testing.B) {
+		repo := newInMemoryContactRepository(slices.Clone(contacts))
+		for b.Loop() {
+			repo.FindById(ctx, target.Id)
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for b.Loop() {
+			linearScan(contacts).findById(target.Id)
+		}
+	})
+}
+
+func BenchmarkFindIdByEmail(b *testing.B) {
+	ctx := context.Background()
+	contacts := benchmarkContacts()
+	target := contacts[len(contacts)-1]
+	b.Run("indexed", func(b *testing.B) {
+		repo := newInMemoryContactRepository(slices.Clone(contacts))
+		for b.Loop() {
+			repo.FindIdByEmail(ctx, target.Email())
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for b.Loop() {
+			linearScan(contacts).findIdByEmail(target.Email())
+		}
+	})
+}
+
+func BenchmarkStore(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+	contacts := benchmarkContacts()
+	target := contacts[len(contacts)-1]
+	b.Run("indexed", func(b *testing.B) {
+		repo := newInMemoryContactRepository(slices.Clone(contacts))
+		for b.Loop() {
+			if err := repo.Store(ctx, target); err != nil {
+				b.Fatal(err)
+			}
+			target.Version++
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for b.Loop() {
+			if err := linearScan(contacts).store(target); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkDelete deletes the first contact, the one whose removal moved all the others
+func BenchmarkDelete(b *testing.B) {
+	ctx := context.Background()
+	contacts := benchmarkContacts()
+	target := contacts[0]
+	repo := newInMemoryContactRepository(slices.Clone(contacts))
+	for b.Loop() {
+		if deleted, err := repo.Delete(ctx, target.Id); !deleted || err != nil {
+			b.Fatalf("expected deletion, got %v, %v", deleted, err)
+		}
+		repo.Load(target)
+	}
+}
+
+// BenchmarkFindAll reads all the pages, as Compact and the duplicates finder do
+func BenchmarkFindAll(b *testing.B) {
+	ctx := context.Background()
+	repo := newInMemoryContactRepository(benchmarkContacts())
+	for b.Loop() {
+		for page, more := (Page{Size: 1000, Order: Order{By: ByEmail, Descending: true}}), true; more; {
+			var err error
+			if _, page, more, err = repo.FindAll(ctx, page); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
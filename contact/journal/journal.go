@@ -0,0 +1,704 @@
+// Package journal implements a dependency-free, persistent contact.Repository.
+//
+// Every change (Store, Delete, DeleteAll, Restore, Purge, RenameTag) is
+// appended as a record to a journal file; on Open the journal is replayed, on
+// top of the latest snapshot, into a contact.InMemoryRepository which then
+// serves all reads.
+// Compact writes a new snapshot and empties the journal.
+//
+// Each journal record is framed as:
+//
+//	[ payload length: uint32 BE ][ CRC-32C of payload: uint32 BE ][ payload: JSON ]
+//
+// A record whose payload would exceed maxRecordSize is split, by its
+// contacts, in several frames which are replayed only together.
+//
+// A torn or corrupt record at the end of the journal (eg. after a crash
+// mid-write, or a tail of zeros) ends the replay and the journal is truncated
+// to the last complete record; a corrupt record followed by valid ones fails
+// Open instead, not to discard them.
+package journal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+const (
+	journalFile  = "journal.log"
+	snapshotFile = "snapshot.json"
+	headerSize   = 8
+	// maxRecordSize guards against allocating absurd buffers when the length header is garbage
+	maxRecordSize = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type operation string
+
+const (
+	opStore operation = "store"
+	// opDelete moves Contact to the trash At the time given; without it, the
+	// record is of a permanent deletion, from before there was a trash.
+	opDelete operation = "delete"
+	// opPurge removes the contacts from the trash
+	opPurge operation = "purge"
+	// opMerge stores Contact, and moves Contacts, merged into it, to the trash At the time given
+	opMerge operation = "merge"
+)
+
+type record struct {
+	Op      operation       `json:"op"`
+	Contact contact.Contact `json:"contact"`
+	// Contacts are those stored, or purged, together by one operation, or those merged into Contact
+	Contacts []contact.Contact `json:"contacts,omitempty"`
+	At       time.Time         `json:"at,omitzero"`
+	// Continued is set on all the parts, but the last, of a record split to fit maxRecordSize
+	Continued bool `json:"continued,omitempty"`
+}
+
+func (me record) contacts() []contact.Contact {
+	if me.Contacts != nil {
+		return me.Contacts
+	}
+	return []contact.Contact{me.Contact}
+}
+
+type Repository struct {
+	dir     string
+	mu      sync.RWMutex
+	index   *contact.InMemoryRepository
+	journal *os.File
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// Open loads the repository persisted in dir (creating dir if missing).
+// When compactInterval is positive the repository is compacted periodically until Close.
+func Open(dir string, compactInterval time.Duration) (*Repository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	me := &Repository{
+		dir:   dir,
+		index: contact.NewInMemoryContactRepository(),
+		stop:  make(chan struct{}),
+	}
+	if err := me.loadSnapshot(); err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	journal, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	me.journal = journal
+	if err := me.replay(); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("failed to replay journal: %w", err)
+	}
+	if compactInterval > 0 {
+		me.stopped.Add(1)
+		go me.compactEvery(compactInterval)
+	}
+	return me, nil
+}
+
+// Close stops the periodic compaction and closes the journal.
+func (me *Repository) Close() error {
+	close(me.stop)
+	me.stopped.Wait()
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.journal.Close()
+}
+
+func (me *Repository) compactEvery(interval time.Duration) {
+	defer me.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-me.stop:
+			return
+		case <-ticker.C:
+			if err := me.Compact(); err != nil {
+				log.Printf("journal compaction failed: %v", err)
+			}
+		}
+	}
+}
+
+func (me *Repository) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(me.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	me.index.Load(snap.Contacts...)
+	me.index.LoadDeleted(snap.Deleted...)
+	return nil
+}
+
+// snapshot is the content of the snapshot file
+type snapshot struct {
+	Contacts []contact.Contact
+	Deleted  []contact.DeletedContact
+}
+
+// UnmarshalJSON also accepts snapshots written when there was no trash, with
+// just the array of contacts.
+func (me *snapshot) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &me.Contacts)
+	}
+	type snapshotFields snapshot // without methods, to not recurse
+	return json.Unmarshal(data, (*snapshotFields)(me))
+}
+
+// replay applies the journal records to the index and leaves the journal
+// positioned, for appending, after the last complete record.
+func (me *Repository) replay() error {
+	r := &countingReader{r: me.journal}
+	var count int
+	var parts []record // of a split record, not yet complete
+	var validOffset int64
+	for {
+		offset := r.n
+		rec, err := readRecord(r)
+		if errors.Is(err, io.EOF) && len(parts) == 0 {
+			break
+		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || (errors.Is(err, errCorrupt) && me.isTail(offset)) {
+			log.Printf("journal: discarding torn record %d (offset %d): %v", count, validOffset, err)
+			if err := me.journal.Truncate(validOffset); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return fmt.Errorf("record %d (offset %d): %w", count, offset, err)
+		}
+		if parts = append(parts, rec); rec.Continued {
+			continue
+		}
+		for _, part := range parts {
+			if err := me.apply(part); err != nil {
+				return fmt.Errorf("record %d: %w", count, err)
+			}
+		}
+		parts = parts[:0]
+		validOffset = r.n
+		count += 1
+	}
+	_, err := me.journal.Seek(0, io.SeekEnd)
+	log.Printf("journal: replayed %d records", count)
+	return err
+}
+
+func (me *Repository) apply(rec record) error {
+	ctx := context.Background()
+	switch rec.Op {
+	case opStore:
+		me.index.Load(rec.contacts()...)
+		return nil
+	case opDelete:
+		if rec.At.IsZero() {
+			return me.purge(ctx, rec.Contact.Id)
+		}
+		for _, c := range rec.contacts() {
+			me.index.LoadDeleted(contact.DeletedContact{Contact: c, DeletedAt: rec.At})
+		}
+		return nil
+	case opMerge:
+		for _, c := range rec.Contacts {
+			me.index.LoadDeleted(contact.DeletedContact{Contact: c, DeletedAt: rec.At})
+		}
+		me.index.Load(rec.Contact)
+		return nil
+	case opPurge:
+		for _, c := range rec.contacts() {
+			if _, err := me.index.Purge(ctx, c.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+}
+
+// isTail tells whether no valid record starts after the corrupt one at
+// offset; it looks at every byte, as the header of a corrupt record can't be
+// trusted to tell where the next one starts.
+func (me *Repository) isTail(offset int64) bool {
+	info, err := me.journal.Stat()
+	if err != nil {
+		return false
+	}
+	rest := make([]byte, info.Size()-offset)
+	if _, err := me.journal.ReadAt(rest, offset); err != nil {
+		return false
+	}
+	for i := 1; i < len(rest); i++ {
+		if _, err := readRecord(bytes.NewReader(rest[i:])); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// errCorrupt is wrapped by the errors of readRecord for an invalid record
+var errCorrupt = errors.New("corrupt record")
+
+// readRecord returns io.EOF only when r is exhausted at a record boundary,
+// io.ErrUnexpectedEOF when it is exhausted within a record, and errCorrupt for
+// an invalid record.
+func readRecord(r io.Reader) (rec record, err error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return rec, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if size > maxRecordSize {
+		return rec, fmt.Errorf("%w: size %d exceeds %d", errCorrupt, size, maxRecordSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); errors.Is(err, io.EOF) {
+		return rec, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return rec, err
+	}
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return rec, fmt.Errorf("%w: checksum mismatch", errCorrupt)
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, fmt.Errorf("%w: %w", errCorrupt, err)
+	}
+	return rec, nil
+}
+
+// appendRecord writes rec in one frame, or in several when its payload would
+// exceed maxRecordSize.
+func appendRecord(w io.Writer, rec record) error {
+	payloads, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	var frames bytes.Buffer
+	for _, payload := range payloads {
+		frames.Grow(headerSize + len(payload))
+		binary.Write(&frames, binary.BigEndian, uint32(len(payload)))
+		binary.Write(&frames, binary.BigEndian, crc32.Checksum(payload, crcTable))
+		frames.Write(payload)
+	}
+	_, err = w.Write(frames.Bytes())
+	return err
+}
+
+// encodeRecord halves the contacts of rec until each part fits maxRecordSize
+func encodeRecord(rec record) ([][]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) <= maxRecordSize {
+		return [][]byte{payload}, nil
+	}
+	if rec.Op == opMerge || len(rec.Contacts) < 2 {
+		return nil, fmt.Errorf("record size %d exceeds %d", len(payload), maxRecordSize)
+	}
+	first, last := rec, rec
+	half := len(rec.Contacts) / 2
+	first.Contacts, first.Continued = rec.Contacts[:half], true
+	last.Contacts = rec.Contacts[half:]
+	firstPayloads, err := encodeRecord(first)
+	if err != nil {
+		return nil, err
+	}
+	lastPayloads, err := encodeRecord(last)
+	if err != nil {
+		return nil, err
+	}
+	return append(firstPayloads, lastPayloads...), nil
+}
+
+// append writes rec durably; on failure it truncates any partially written frame.
+func (me *Repository) append(rec record) error {
+	offset, err := me.journal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err = appendRecord(me.journal, rec); err == nil {
+		err = me.journal.Sync()
+	}
+	if err != nil {
+		me.journal.Truncate(offset)
+		me.journal.Seek(offset, io.SeekStart)
+	}
+	return err
+}
+
+// Compact writes all contacts, those in the trash too, in a new snapshot and
+// empties the journal.
+func (me *Repository) Compact() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var snap snapshot
+	for page, more := (contact.Page{Size: 1000}), true; more; {
+		var result []contact.Contact
+		var err error
+		result, page, more, err = me.index.FindAll(context.Background(), page)
+		if err != nil {
+			return err
+		}
+		snap.Contacts = append(snap.Contacts, result...)
+	}
+	deleted, err := me.allDeleted()
+	if err != nil {
+		return err
+	}
+	snap.Deleted = deleted
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(filepath.Join(me.dir, snapshotFile), data); err != nil {
+		return err
+	}
+	// a crash before truncating is harmless: replaying the journal on top of the snapshot is idempotent
+	if err := me.journal.Truncate(0); err != nil {
+		return err
+	}
+	_, err = me.journal.Seek(0, io.SeekStart)
+	log.Printf("journal: compacted %d contacts, and %d deleted", len(snap.Contacts), len(snap.Deleted))
+	return err
+}
+
+func (me *Repository) allDeleted() (deleted []contact.DeletedContact, err error) {
+	for page, more := (contact.Page{Size: 1000}), true; more; {
+		var result []contact.DeletedContact
+		result, page, more, err = me.index.FindDeleted(context.Background(), page)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, result...)
+	}
+	return deleted, nil
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err != nil {
+		return err
+	} else {
+		defer dir.Close()
+		return dir.Sync()
+	}
+}
+
+func (me *Repository) FindById(ctx context.Context, id contact.Id) (c contact.Contact, found bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindById(ctx, id)
+}
+
+func (me *Repository) FindIdByEmail(ctx context.Context, email contact.Email) (res contact.Id, found bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindIdByEmail(ctx, email)
+}
+
+func (me *Repository) FindAll(ctx context.Context, page contact.Page) (result []contact.Contact, next contact.Page, more bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindAll(ctx, page)
+}
+
+func (me *Repository) Count(ctx context.Context) (int, error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.Count(ctx)
+}
+
+func (me *Repository) FindByQuery(ctx context.Context, q contact.Query, page contact.Page) (result []contact.Contact, next contact.Page, more bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindByQuery(ctx, q, page)
+}
+
+func (me *Repository) FindTags(ctx context.Context) ([]contact.TagCount, error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindTags(ctx)
+}
+
+// RenameTag journals the contacts renamed, as stored, in one record
+func (me *Repository) RenameTag(ctx context.Context, to contact.Tag, from ...contact.Tag) (renamed int, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var cs []contact.Contact
+	for page, more := (contact.Page{Size: 100}), true; more; {
+		var tagged []contact.Contact
+		if tagged, page, more, err = me.index.FindByQuery(ctx, contact.TagQuery(from...), page); err != nil {
+			return 0, err
+		}
+		for _, c := range tagged {
+			c, _ = c.RenameTag(to, from...)
+			c.Version++
+			cs = append(cs, c)
+		}
+	}
+	if len(cs) == 0 {
+		return 0, nil
+	}
+	if err := me.append(record{Op: opStore, Contacts: cs}); err != nil {
+		return 0, fmt.Errorf("failed to journal renaming of tags %q: %w", from, err)
+	}
+	me.index.Load(cs...)
+	return len(cs), nil
+}
+
+// Store validates cs against the index, then journals them, as stored, in one
+// record; if journaling fails the index is rolled back.
+func (me *Repository) Store(ctx context.Context, cs ...contact.Contact) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var previous []contact.Contact
+	var trashed []contact.DeletedContact // taken out of the trash by Store
+	var added []contact.Id
+	for _, c := range cs {
+		if p, existed, err := me.index.FindById(ctx, c.Id); err != nil {
+			return err
+		} else if existed {
+			previous = append(previous, p)
+		} else if d, found, err := me.index.FindDeletedById(ctx, c.Id); err != nil {
+			return err
+		} else if found {
+			trashed = append(trashed, d)
+		} else {
+			added = append(added, c.Id)
+		}
+	}
+	if err := me.index.Store(ctx, cs...); err != nil {
+		return err
+	}
+	stored := slices.Clone(cs) // as the index stored them, to restore them on replay
+	for i := range stored {
+		stored[i].Version++
+	}
+	rec := record{Op: opStore}
+	if len(stored) == 1 {
+		rec.Contact = stored[0]
+	} else {
+		rec.Contacts = stored
+	}
+	if err := me.append(rec); err != nil {
+		for _, id := range added {
+			me.purge(context.Background(), id)
+		}
+		me.index.Load(previous...)
+		me.index.LoadDeleted(trashed...)
+		return fmt.Errorf("failed to journal %d contacts: %w", len(cs), err)
+	}
+	return nil
+}
+
+// Merge merges in the index, then journals the merge in one record; if
+// journaling fails the index is rolled back.
+func (me *Repository) Merge(ctx context.Context, merged, other contact.Contact) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	previous, existed, err := me.index.FindById(ctx, merged.Id)
+	if err != nil {
+		return err
+	}
+	if err := me.index.Merge(ctx, merged, other); err != nil {
+		return err
+	}
+	stored, _, _ := me.index.FindById(context.Background(), merged.Id)
+	d, _, _ := me.index.FindDeletedById(context.Background(), other.Id)
+	if err := me.append(record{Op: opMerge, Contact: stored, Contacts: []contact.Contact{d.Contact}, At: d.DeletedAt}); err != nil {
+		if existed {
+			me.index.Load(previous)
+		} else {
+			me.purge(context.Background(), merged.Id)
+		}
+		me.index.Load(d.Contact)
+		return fmt.Errorf("failed to journal merge of %q into %q: %w", other.Id, merged.Id, err)
+	}
+	return nil
+}
+
+func (me *Repository) Delete(ctx context.Context, id contact.Id) (deleted bool, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	c, existed, err := me.index.FindById(ctx, id)
+	if err != nil || !existed {
+		return false, err
+	}
+	d := contact.DeletedContact{Contact: c, DeletedAt: time.Now()}
+	if err := me.append(record{Op: opDelete, Contact: c, At: d.DeletedAt}); err != nil {
+		return false, fmt.Errorf("failed to journal deletion of %q: %w", id, err)
+	}
+	me.index.LoadDeleted(d)
+	return true, nil
+}
+
+// DeleteAll journals the deletion of all the contacts as one record
+func (me *Repository) DeleteAll(ctx context.Context, ids ...contact.Id) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	var cs []contact.Contact
+	for _, id := range ids {
+		if c, found, err := me.index.FindById(ctx, id); err != nil {
+			return err
+		} else if !found {
+			return contact.NotFoundError{Id: id}
+		} else if !slices.ContainsFunc(cs, id.HasSameId) {
+			cs = append(cs, c)
+		}
+	}
+	if len(cs) == 0 {
+		return nil
+	}
+	deletedAt := time.Now()
+	if err := me.append(record{Op: opDelete, Contacts: cs, At: deletedAt}); err != nil {
+		return fmt.Errorf("failed to journal deletion of %d contacts: %w", len(cs), err)
+	}
+	for _, c := range cs {
+		me.index.LoadDeleted(contact.DeletedContact{Contact: c, DeletedAt: deletedAt})
+	}
+	return nil
+}
+
+func (me *Repository) FindDeleted(ctx context.Context, page contact.Page) (result []contact.DeletedContact, next contact.Page, more bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindDeleted(ctx, page)
+}
+
+func (me *Repository) FindDeletedById(ctx context.Context, id contact.Id) (d contact.DeletedContact, found bool, err error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.index.FindDeletedById(ctx, id)
+}
+
+// Restore restores the contact in the index, then journals it as stored; if
+// journaling fails it is put back in the trash.
+func (me *Repository) Restore(ctx context.Context, id contact.Id) (restored bool, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	d, found, err := me.index.FindDeletedById(ctx, id)
+	if err != nil || !found {
+		return false, err
+	}
+	if _, err := me.index.Restore(ctx, id); err != nil {
+		return false, err
+	}
+	c, _, err := me.index.FindById(context.Background(), id)
+	if err == nil {
+		err = me.append(record{Op: opStore, Contact: c})
+	}
+	if err != nil {
+		me.index.LoadDeleted(d)
+		return false, fmt.Errorf("failed to journal restoration of %q: %w", id, err)
+	}
+	return true, nil
+}
+
+func (me *Repository) Purge(ctx context.Context, id contact.Id) (purged bool, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, found, err := me.index.FindDeletedById(ctx, id); err != nil || !found {
+		return false, err
+	}
+	if err := me.append(record{Op: opPurge, Contact: contact.Contact{Id: id}}); err != nil {
+		return false, fmt.Errorf("failed to journal purge of %q: %w", id, err)
+	}
+	return me.index.Purge(context.Background(), id)
+}
+
+// PurgeDeletedBefore journals the purge of all the contacts deleted before t
+// in one record.
+func (me *Repository) PurgeDeletedBefore(ctx context.Context, t time.Time) (purged int, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	deleted, err := me.allDeleted()
+	if err != nil {
+		return 0, err
+	}
+	rec := record{Op: opPurge}
+	for _, d := range deleted {
+		if d.DeletedAt.Before(t) {
+			rec.Contacts = append(rec.Contacts, contact.Contact{Id: d.Id})
+		}
+	}
+	if len(rec.Contacts) == 0 {
+		return 0, nil
+	} else if err := me.append(rec); err != nil {
+		return 0, fmt.Errorf("failed to journal purge of %d contacts: %w", len(rec.Contacts), err)
+	}
+	return len(rec.Contacts), me.apply(rec)
+}
+
+// purge removes the contact for good, wherever it is
+func (me *Repository) purge(ctx context.Context, id contact.Id) error {
+	if _, err := me.index.Delete(ctx, id); err != nil {
+		return err
+	}
+	_, err := me.index.Purge(ctx, id)
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (me *countingReader) Read(p []byte) (int, error) {
+	n, err := me.r.Read(p)
+	me.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,428 @@
+package journal_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/journal"
+)
+
+func mustOpen(t *testing.T, dir string) *journal.Repository {
+	t.Helper()
+	repo, err := journal.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func allContacts(t *testing.T, repo contact.Repository) []contact.Contact {
+	t.Helper()
+	result, _, _, err := repo.FindAll(context.Background(), contact.Page{Size: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+var (
+	joe  = contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("joe@example.com")}}}
+	jane = contact.Contact{Id: contact.NewId(), FirstName: "Jane", LastName: "Doe", Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("jane@example.com")}}}
+)
+
+func TestReplayAfterReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	for _, c := range []contact.Contact{joe, jane} {
+		if err := repo.Store(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if deleted, err := repo.Delete(ctx, joe.Id); !deleted || err != nil {
+		t.Fatalf("expected deletion, got %v, %v", deleted, err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(jane) {
+		t.Errorf("expected only %#v, got %#v", jane, got)
+	}
+}
+
+func TestReplayBatch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe, jane); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 2 || !got[0].Equal(joe) || !got[1].Equal(jane) {
+		t.Errorf("expected %#v and %#v, got %#v", joe, jane, got)
+	}
+}
+
+func TestReplayDeleteAll(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe, jane); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteAll(ctx, joe.Id, jane.Id); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 0 {
+		t.Errorf("expected no contacts, got %#v", got)
+	}
+	if deleted, _, _, _ := repo.FindDeleted(ctx, contact.Page{Size: 10}); len(deleted) != 2 {
+		t.Errorf("expected 2 contacts in the trash, got %#v", deleted)
+	}
+}
+
+func TestReplayMerge(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe, jane); err != nil {
+		t.Fatal(err)
+	}
+	merged, other := joe, jane
+	merged.Version, other.Version = 1, 1
+	merged.Emails = append(slices.Clone(joe.Emails), jane.Emails[0])
+	merged.Emails[1].Primary = false
+	if err := repo.Merge(ctx, merged, other); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 1 || len(got[0].Emails) != 2 || got[0].Version != 2 {
+		t.Errorf("expected the merged contact only, got %#v", got)
+	}
+	if _, found, _ := repo.FindDeletedById(ctx, jane.Id); !found {
+		t.Errorf("expected the other contact in the trash")
+	}
+}
+
+func TestReplayRenameTag(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	tagged := joe.Clone()
+	tagged.Tags = []contact.Tag{"pals"}
+	if err := repo.Store(ctx, tagged); err != nil {
+		t.Fatal(err)
+	}
+	if renamed, err := repo.RenameTag(ctx, "friends", "pals"); renamed != 1 || err != nil {
+		t.Fatalf("expected 1 contact renamed, got %d, %v", renamed, err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got, _, _ := repo.FindById(ctx, joe.Id); !got.HasTag("friends") || got.HasTag("pals") || got.Version != 2 {
+		t.Errorf("expected the tag renamed at version 2, got %#v", got)
+	}
+}
+
+func TestTornFinalRecordIsDiscarded(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	journalPath := filepath.Join(dir, "journal.log")
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2, 3, 4, '{', '"'}) // header promising 42 bytes, then the crash
+	f.Close()
+	tornSize := fileSize(t, journalPath)
+
+	repo = mustOpen(t, dir)
+	if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(joe) {
+		t.Errorf("expected only %#v, got %#v", joe, got)
+	}
+	if size := fileSize(t, journalPath); size >= tornSize {
+		t.Errorf("expected torn record to be truncated, journal is still %d bytes", size)
+	}
+	if err := repo.Store(ctx, jane); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 2 {
+		t.Errorf("expected records appended after recovery to survive, got %#v", got)
+	}
+}
+
+// manyContacts returns n contacts whose batch record exceeds the 1 MiB a record may be
+func manyContacts(n int) []contact.Contact {
+	cs := make([]contact.Contact, n)
+	for i := range cs {
+		cs[i] = contact.Contact{
+			Id:        contact.NewId(),
+			FirstName: "Joe",
+			LastName:  fmt.Sprintf("Bloggs %d", i),
+			Emails:    []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail(fmt.Sprintf("joe.bloggs.%d@example.com", i))}},
+		}
+	}
+	return cs
+}
+
+func TestReplayBatchLargerThanARecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, manyContacts(8000)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if count, err := repo.Count(ctx); count != 8001 || err != nil {
+		t.Errorf("expected 8001 contacts, got %d, %v", count, err)
+	}
+}
+
+func TestTornBatchIsDiscardedWhole(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	journalPath := filepath.Join(dir, "journal.log")
+	storedSize := fileSize(t, journalPath)
+	if err := repo.Store(ctx, manyContacts(8000)...); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+	// the crash tears the last part of the batch
+	if err := os.Truncate(journalPath, fileSize(t, journalPath)-10); err != nil {
+		t.Fatal(err)
+	}
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(joe) {
+		t.Errorf("expected only %#v, got %d contacts", joe, len(got))
+	}
+	if size := fileSize(t, journalPath); size != storedSize {
+		t.Errorf("expected the journal truncated to %d bytes, got %d", storedSize, size)
+	}
+}
+
+func TestCorruptTailIsDiscarded(t *testing.T) {
+	for name, tail := range map[string][]byte{
+		"zeros":             make([]byte, 4096), // eg. the file grew, but its data wasn't written
+		"garbage size":      {0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, '{', '"'},
+		"checksum mismatch": {0, 0, 0, 2, 1, 2, 3, 4, '{', '}'},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			repo := mustOpen(t, dir)
+			if err := repo.Store(ctx, joe); err != nil {
+				t.Fatal(err)
+			}
+			repo.Close()
+
+			journalPath := filepath.Join(dir, "journal.log")
+			storedSize := fileSize(t, journalPath)
+			f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(tail)
+			f.Close()
+
+			repo = mustOpen(t, dir)
+			defer repo.Close()
+			if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(joe) {
+				t.Errorf("expected only %#v, got %#v", joe, got)
+			}
+			if size := fileSize(t, journalPath); size != storedSize {
+				t.Errorf("expected the journal truncated to %d bytes, got %d", storedSize, size)
+			}
+		})
+	}
+}
+
+func TestCorruptRecordFailsOpen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	for _, c := range []contact.Contact{joe, jane} {
+		if err := repo.Store(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo.Close()
+
+	journalPath := filepath.Join(dir, "journal.log")
+	f, err := os.OpenFile(journalPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("X"), 9) // within the first record, followed by a valid one
+	f.Close()
+	corruptSize := fileSize(t, journalPath)
+
+	if repo, err := journal.Open(dir, 0); err == nil {
+		repo.Close()
+		t.Fatal("expected Open to fail")
+	}
+	if size := fileSize(t, journalPath); size != corruptSize {
+		t.Errorf("expected the journal left as it was, %d bytes, got %d", corruptSize, size)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	for _, c := range []contact.Contact{joe, jane} {
+		if err := repo.Store(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, filepath.Join(dir, "journal.log")); size != 0 {
+		t.Errorf("expected empty journal after compaction, got %d bytes", size)
+	}
+	if _, err := repo.Delete(ctx, jane.Id); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(joe) {
+		t.Errorf("expected only %#v, got %#v", joe, got)
+	}
+}
+
+func TestReplayKeepsVersions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	changed := joe
+	changed.Version, changed.LastName = 1, "Blogs"
+	if err := repo.Store(ctx, changed); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if got, _, _ := repo.FindById(ctx, joe.Id); got.Version != 2 || !got.Equal(changed) {
+		t.Errorf("expected %#v at version 2, got %#v", changed, got)
+	}
+	var conflictErr contact.VersionConflictError
+	if err := repo.Store(ctx, changed); !errors.As(err, &conflictErr) {
+		t.Errorf("expected VersionConflictError, got %v", err)
+	}
+}
+
+func TestReplayTrash(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := mustOpen(t, dir)
+	if err := repo.Store(ctx, joe, jane); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []contact.Contact{joe, jane} {
+		if _, err := repo.Delete(ctx, c.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := repo.Restore(ctx, jane.Id); !restored || err != nil {
+		t.Fatalf("expected restoration, got %v, %v", restored, err)
+	}
+	deletedAt := func(d contact.DeletedContact, _ bool, _ error) time.Time { return d.DeletedAt }(repo.FindDeletedById(ctx, joe.Id))
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(jane) || got[0].Version != 2 {
+		t.Errorf("expected only %#v at version 2, got %#v", jane, got)
+	}
+	if d, found, _ := repo.FindDeletedById(ctx, joe.Id); !found || !d.Equal(joe) || !d.DeletedAt.Equal(deletedAt) {
+		t.Errorf("expected %#v deleted at %v, got %#v", joe, deletedAt, d)
+	}
+	if purged, err := repo.PurgeDeletedBefore(ctx, time.Now()); purged != 1 || err != nil {
+		t.Errorf("expected 1 contact purged, got %d, %v", purged, err)
+	}
+	repo.Close()
+
+	repo = mustOpen(t, dir)
+	defer repo.Close()
+	if deleted, _, _, _ := repo.FindDeleted(ctx, contact.Page{Size: 10}); len(deleted) != 0 {
+		t.Errorf("expected an empty trash, got %#v", deleted)
+	}
+}
+
+func TestLoadSnapshotOfContactsOnly(t *testing.T) {
+	dir := t.TempDir()
+	data, err := json.Marshal([]contact.Contact{joe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "snapshot.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repo := mustOpen(t, dir)
+	defer repo.Close()
+	if got := allContacts(t, repo); len(got) != 1 || !got[0].Equal(joe) {
+		t.Errorf("expected only %#v, got %#v", joe, got)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
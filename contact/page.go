@@ -0,0 +1,251 @@
+package contact
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SortField is a column of the contact list the contacts can be sorted by
+type SortField string
+
+const (
+	ByFirstName SortField = "first"
+	ByLastName  SortField = "last"
+	ByPhone     SortField = "phone"
+	ByEmail     SortField = "email"
+)
+
+var SortFields = []SortField{ByFirstName, ByLastName, ByPhone, ByEmail}
+
+// Order is how the contacts of a page are sorted: By a SortField, ascending
+// unless Descending. The zero Order sorts by relevance the contacts found by a
+// query (see Score), and by last name the others. Ties are broken by Id.
+type Order struct {
+	By         SortField
+	Descending bool
+}
+
+// ParseOrder parses a sort field, eg. "last", prefixed by "-" if descending;
+// the blank string is the zero Order.
+func ParseOrder(s string) (o Order, err error) {
+	name, descending := strings.CutPrefix(strings.TrimSpace(s), "-")
+	if name == "" && !descending {
+		return o, nil
+	} else if field := SortField(name); !slices.Contains(SortFields, field) {
+		return o, fmt.Errorf("unknown sort field %q, expected one of %q", name, SortFields)
+	} else {
+		return Order{By: field, Descending: descending}, nil
+	}
+}
+
+// String is the inverse of ParseOrder
+func (me Order) String() string {
+	if me.Descending {
+		return "-" + string(me.By)
+	}
+	return string(me.By)
+}
+
+// Reversed is the opposite Order By the same field
+func (me Order) Reversed() Order {
+	me.Descending = !me.Descending
+	return me
+}
+
+// Page selects Size contacts, in Order, after the one the After cursor points
+// to; the first page has no cursor.
+type Page struct {
+	Size  int
+	Order Order
+	After Cursor
+}
+
+// Cursor is an opaque token pointing to a contact in an Order. It holds the
+// values the contact was sorted by, not its position, so that the next page
+// starts where the previous one ended even if contacts were added or deleted
+// meanwhile.
+type Cursor string
+
+// CursorError is returned when a page's Cursor is malformed, or was made for a different Order.
+type CursorError struct {
+	Cursor Cursor
+}
+
+func (me CursorError) Error() string {
+	return fmt.Sprintf("invalid page cursor %q", string(me.Cursor))
+}
+
+// cursorKey is what an item is sorted by: the Key of an order, then the Id
+type cursorKey[K any] struct {
+	Order string `json:"o"`
+	Key   K      `json:"k"`
+	Id    Id     `json:"id"`
+}
+
+func (me cursorKey[K]) cursor() Cursor {
+	data, err := json.Marshal(me)
+	if err != nil {
+		panic(err) // keys are strings, numbers, and times
+	}
+	return Cursor(base64.RawURLEncoding.EncodeToString(data))
+}
+
+func parseCursor[K any](c Cursor, order string) (key cursorKey[K], err error) {
+	if data, err := base64.RawURLEncoding.DecodeString(string(c)); err != nil {
+		return key, CursorError{c}
+	} else if err := json.Unmarshal(data, &key); err != nil || key.Order != order {
+		return key, CursorError{c}
+	} else {
+		return key, nil
+	}
+}
+
+// paginate sorts the items by their key, compared by compare, then by Id, and
+// returns page.Size of them after page.After; next points past the last one
+// returned, more tells if there are others after it.
+func paginate[T, K any](items []T, page Page, order string, key func(T) (K, Id), compare func(a, b K) int) (result []T, next Page, more bool, err error) {
+	type keyed struct {
+		item T
+		key  cursorKey[K]
+	}
+	compareKeys := func(a, b cursorKey[K]) int {
+		return cmp.Or(compare(a.Key, b.Key), cmp.Compare(a.Id, b.Id))
+	}
+	sorted := make([]keyed, len(items))
+	for i, item := range items {
+		k, id := key(item)
+		sorted[i] = keyed{item, cursorKey[K]{Order: order, Key: k, Id: id}}
+	}
+	slices.SortFunc(sorted, func(a, b keyed) int { return compareKeys(a.key, b.key) })
+	start := 0
+	if page.After != "" {
+		after, err := parseCursor[K](page.After, order)
+		if err != nil {
+			return nil, page, false, err
+		}
+		var found bool
+		start, found = slices.BinarySearchFunc(sorted, after, func(e keyed, t cursorKey[K]) int { return compareKeys(e.key, t) })
+		if found {
+			start++
+		}
+	}
+	end := min(start+max(page.Size, 0), len(sorted))
+	for _, s := range sorted[start:end] {
+		result = append(result, s.item)
+	}
+	next = page
+	if end > start {
+		next.After = sorted[end-1].key.cursor()
+	}
+	return result, next, end < len(sorted), nil
+}
+
+// SortKey is the value of the field c is sorted by, folded to lower case; names
+// are followed by the other name, to sort namesakes. Stores sorting the
+// contacts themselves, eg. in SQL, compare the keys byte-wise (see KeyAfter).
+func (my Contact) SortKey(f SortField) string {
+	var k string
+	switch f {
+	case ByFirstName:
+		k = my.FirstName + "\x00" + my.LastName
+	case ByPhone:
+		k = my.Phone().E164()
+	case ByEmail:
+		k = my.Email().Canonical()
+	default:
+		k = my.LastName + "\x00" + my.FirstName
+	}
+	return strings.ToLower(k)
+}
+
+// Field is the field the contacts are sorted by, the last name for the zero Order
+func (me Order) Field() SortField {
+	return cmp.Or(me.By, ByLastName)
+}
+
+// KeyAfter returns the SortKey, in the page's Order, and the Id of the contact
+// page.After points to; ok is false for the first page. It lets stores pick
+// the page themselves: the contacts after the one pointed to are those with a
+// greater key (less, if Descending), or the same key and a greater Id.
+func (me Page) KeyAfter() (key string, id Id, ok bool, err error) {
+	if me.After == "" {
+		return "", "", false, nil
+	}
+	after, err := parseCursor[string](me.After, Order{me.Order.Field(), me.Order.Descending}.String())
+	return after.Key, after.Id, err == nil, err
+}
+
+// Following returns the page after the contact c, the last of this page
+func (me Page) Following(c Contact) Page {
+	by := me.Order.Field()
+	me.After = cursorKey[string]{Order: Order{by, me.Order.Descending}.String(), Key: c.SortKey(by), Id: c.Id}.cursor()
+	return me
+}
+
+// DeletedAfter returns the time and the Id of the deleted contact page.After
+// points to; ok is false for the first page. The deleted contacts after it are
+// those deleted earlier, or at the same time with a greater Id (see DeletedPageOf).
+func (me Page) DeletedAfter() (deletedAt time.Time, id Id, ok bool, err error) {
+	if me.After == "" {
+		return deletedAt, "", false, nil
+	}
+	after, err := parseCursor[time.Time](me.After, "deleted")
+	return after.Key, after.Id, err == nil, err
+}
+
+// FollowingDeleted returns the page after the deleted contact d, the last of this page
+func (me Page) FollowingDeleted(d DeletedContact) Page {
+	me.After = cursorKey[time.Time]{Order: "deleted", Key: d.DeletedAt, Id: d.Id}.cursor()
+	return me
+}
+
+// PageOf returns the page of the contacts, sorted in the page's Order.
+func PageOf(cs []Contact, page Page) (result []Contact, next Page, more bool, err error) {
+	by := page.Order.Field()
+	compare := strings.Compare
+	if page.Order.Descending {
+		compare = func(a, b string) int { return strings.Compare(b, a) }
+	}
+	key := func(c Contact) (string, Id) { return c.SortKey(by), c.Id }
+	return paginate(cs, page, Order{by, page.Order.Descending}.String(), key, compare)
+}
+
+// RankedBy tells if the page of the contacts matching q is sorted by
+// relevance: if it has the zero Order and q has words to rank by (see
+// RankingWords). Otherwise it is sorted as PageOf does, and stores can pick it
+// past its cursor (see KeyAfter).
+func (me Page) RankedBy(q Query) bool {
+	return me.Order == (Order{}) && RankingWords(q) != ""
+}
+
+// QueryPageOf returns the page of the contacts matching q, sorted in the
+// page's Order or, if it is RankedBy q, best matches first.
+func QueryPageOf(q Query, cs []Contact, page Page) (result []Contact, next Page, more bool, err error) {
+	if !page.RankedBy(q) {
+		return PageOf(cs, page)
+	}
+	type relevance struct {
+		Score float64 `json:"s"`
+		Name  string  `json:"n"`
+	}
+	key := func(c Contact) (relevance, Id) {
+		return relevance{Score(q, c), c.SortKey(ByLastName)}, c.Id
+	}
+	compare := func(a, b relevance) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Name, b.Name))
+	}
+	return paginate(cs, page, "relevance", key, compare)
+}
+
+// DeletedPageOf returns the page of the deleted contacts, most recently
+// deleted first; the page's Order does not apply.
+func DeletedPageOf(ds []DeletedContact, page Page) (result []DeletedContact, next Page, more bool, err error) {
+	key := func(d DeletedContact) (time.Time, Id) { return d.DeletedAt, d.Id }
+	compare := func(a, b time.Time) int { return b.Compare(a) }
+	return paginate(ds, page, "deleted", key, compare)
+}
@@ -0,0 +1,174 @@
+package contact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseOrder(t *testing.T) {
+	for s, expected := range map[string]Order{
+		"":        {},
+		"last":    {By: ByLastName},
+		" -email": {By: ByEmail, Descending: true},
+	} {
+		if o, err := ParseOrder(s); err != nil || o != expected {
+			t.Errorf("ParseOrder(%q): expected %v, got %v (err: %v)", s, expected, o, err)
+		} else if o.String() != strings.TrimSpace(s) {
+			t.Errorf("expected %v to print as %q, got %q", o, s, o.String())
+		}
+	}
+	for _, s := range []string{"-", "age", "--last"} {
+		if _, err := ParseOrder(s); err == nil {
+			t.Errorf("ParseOrder(%q): expected an error", s)
+		}
+	}
+}
+
+// TestPagesAreStableWhenContactsChange adds and deletes contacts between pages:
+// the next page starts after the last contact seen, none skipped nor repeated.
+func TestPagesAreStableWhenContactsChange(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryContactRepository()
+	var stored []Contact
+	for _, name := range []string{"Bea", "ann", "Carl", "Dora", "Ed"} {
+		c := Contact{Id: NewId(), FirstName: name, LastName: "Smith"}
+		if err := repo.Store(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, c)
+	}
+	names := func(cs []Contact) (res []string) {
+		for _, c := range cs {
+			res = append(res, c.FirstName)
+		}
+		return res
+	}
+	first, next, more, err := repo.FindAll(ctx, Page{Size: 2, Order: Order{By: ByFirstName}})
+	if err != nil || !more || !slices.Equal(names(first), []string{"ann", "Bea"}) {
+		t.Fatalf("expected the first names in case-insensitive order, got %v (more: %v, err: %v)", names(first), more, err)
+	}
+	if _, err := repo.Delete(ctx, stored[1].Id); err != nil { // ann, already seen
+		t.Fatal(err)
+	}
+	if err := repo.Store(ctx, Contact{Id: NewId(), FirstName: "Al", LastName: "Smith"}); err != nil {
+		t.Fatal(err)
+	}
+	second, next, more, err := repo.FindAll(ctx, next)
+	if err != nil || !more || !slices.Equal(names(second), []string{"Carl", "Dora"}) {
+		t.Errorf("expected the second page to follow the first, got %v (more: %v, err: %v)", names(second), more, err)
+	}
+	if last, _, more, _ := repo.FindAll(ctx, next); more || !slices.Equal(names(last), []string{"Ed"}) {
+		t.Errorf("expected the last page, got %v (more: %v)", names(last), more)
+	}
+
+	descending, _, _, _ := repo.FindAll(ctx, Page{Size: 3, Order: Order{By: ByFirstName, Descending: true}})
+	if !slices.Equal(names(descending), []string{"Ed", "Dora", "Carl"}) {
+		t.Errorf("expected the first names in descending order, got %v", names(descending))
+	}
+	next.Order = Order{By: ByEmail}
+	if _, _, _, err := repo.FindAll(ctx, next); !errors.As(err, new(CursorError)) {
+		t.Errorf("expected a CursorError for a cursor of another order, got %v", err)
+	}
+	if _, _, _, err := repo.FindAll(ctx, Page{Size: 2, After: "garbage"}); !errors.As(err, new(CursorError)) {
+		t.Errorf("expected a CursorError for a malformed cursor, got %v", err)
+	}
+}
+
+func TestQueryPagesByRelevance(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryContactRepository()
+	for _, name := range []string{"Annie", "Ann", "Anna", "Bob"} {
+		if err := repo.Store(ctx, Contact{Id: NewId(), FirstName: name, LastName: "Smith"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var seen []string
+	for page, more := (Page{Size: 1}), true; more; {
+		var found []Contact
+		var err error
+		if found, page, more, err = repo.FindByQuery(ctx, Term{Value: "ann"}, page); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range found {
+			seen = append(seen, c.FirstName)
+		}
+	}
+	if seen[0] != "Ann" || len(seen) != 3 {
+		t.Errorf("expected the 3 matches, exact one first, got %v", seen)
+	}
+	byName, _, _, _ := repo.FindByQuery(ctx, Term{Value: "ann"}, Page{Size: 10, Order: Order{By: ByFirstName}})
+	if names := []string{byName[0].FirstName, byName[1].FirstName, byName[2].FirstName}; !slices.Equal(names, []string{"Ann", "Anna", "Annie"}) {
+		t.Errorf("expected the matches by first name, got %v", names)
+	}
+}
+
+// TestPagesOfManyContacts walks the pages, in each Order, of more contacts
+// than a chunk of the sorted indexes holds, some with the same names, and of
+// those deleted, comparing them with PageOf and DeletedPageOf of all of them.
+func TestPagesOfManyContacts(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryContactRepository()
+	var stored []Contact
+	for i := range 3 * maxChunkSize {
+		c := Contact{Id: NewId(), FirstName: fmt.Sprint("First", i%7), LastName: fmt.Sprint("Last", i%500),
+			Emails: []EmailEntry{{Primary: true, Email: MustParseEmail(fmt.Sprintf("contact%d@example.com", i))}}}
+		stored = append(stored, c)
+	}
+	if err := repo.Store(ctx, stored...); err != nil {
+		t.Fatal(err)
+	}
+	var trashed []Id
+	for _, c := range stored[:maxChunkSize] {
+		trashed = append(trashed, c.Id)
+	}
+	if err := repo.DeleteAll(ctx, trashed...); err != nil {
+		t.Fatal(err)
+	}
+	var kept []Contact
+	for _, c := range stored[maxChunkSize:] {
+		c.Version = 1
+		kept = append(kept, c)
+	}
+	ids := func(cs []Contact) (res []Id) {
+		for _, c := range cs {
+			res = append(res, c.Id)
+		}
+		return res
+	}
+	for _, by := range SortFields {
+		for _, descending := range []bool{false, true} {
+			page := Page{Size: 100, Order: Order{By: by, Descending: descending}}
+			expected, _, _, _ := PageOf(kept, Page{Size: len(kept), Order: page.Order})
+			var walked []Contact
+			for more := true; more; {
+				var found []Contact
+				var err error
+				if found, page, more, err = repo.FindAll(ctx, page); err != nil {
+					t.Fatal(err)
+				}
+				walked = append(walked, found...)
+			}
+			if !slices.Equal(ids(walked), ids(expected)) {
+				t.Errorf("%v: expected the pages to list the %d contacts as PageOf, got %d", page.Order, len(expected), len(walked))
+			}
+		}
+	}
+	deleted, _, _, _ := repo.FindDeleted(ctx, Page{Size: maxChunkSize})
+	var walked []DeletedContact
+	for page, more := (Page{Size: 100}), true; more; {
+		var found []DeletedContact
+		var err error
+		if found, page, more, err = repo.FindDeleted(ctx, page); err != nil {
+			t.Fatal(err)
+		}
+		walked = append(walked, found...)
+	}
+	expected, _, _, _ := DeletedPageOf(deleted, Page{Size: len(deleted)})
+	if len(walked) != maxChunkSize || !slices.EqualFunc(walked, expected, func(a, b DeletedContact) bool { return a.Id == b.Id }) {
+		t.Errorf("expected the pages to list the %d deleted contacts as DeletedPageOf, got %d", maxChunkSize, len(walked))
+	}
+}
@@ -0,0 +1,126 @@
+package contact
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dev.acorello.it/go/contacts/search"
+)
+
+// DefaultCallingCode is the country calling code assumed for numbers in national format (eg. "07911 123456")
+var DefaultCallingCode = "44"
+
+// Phone is a phone number as the user formatted it, along with its E.164 form.
+// The zero value is the blank phone number.
+type Phone struct {
+	display, e164 string
+}
+
+// ParsePhone accepts numbers in international format (eg. "+44 (0)7911 123456",
+// "0044 7911 123456") or in the national format of DefaultCallingCode
+// (eg. "07911 123456"); digits may be grouped with spaces, dots, dashes, slashes,
+// and parentheses. A blank s is the blank Phone.
+func ParsePhone(s string) (Phone, error) {
+	display := strings.TrimSpace(s)
+	if display == "" {
+		return Phone{}, nil
+	}
+	if i := strings.IndexFunc(display, isNotPhoneRune); i >= 0 {
+		return Phone{}, fmt.Errorf("unexpected %q in phone number", display[i:i+1])
+	}
+	if i := strings.LastIndex(display, "+"); i > 0 {
+		return Phone{}, fmt.Errorf("\"+\" allowed only at the start of the phone number")
+	}
+	var digits string
+	switch {
+	case strings.HasPrefix(display, "+"), strings.HasPrefix(display, "00"):
+		digits = search.PhoneDigits(display)
+	default:
+		digits = DefaultCallingCode + strings.TrimPrefix(search.Digits(display), "0")
+	}
+	if strings.HasPrefix(digits, "0") {
+		return Phone{}, fmt.Errorf("country calling code can't start with 0")
+	}
+	if len(digits) < 8 || len(digits) > 15 {
+		return Phone{}, fmt.Errorf("phone number must have between 8 and 15 digits, including the country code")
+	}
+	return Phone{display: display, e164: "+" + digits}, nil
+}
+
+func isNotPhoneRune(r rune) bool {
+	return !('0' <= r && r <= '9') && !strings.ContainsRune("+ .-/()", r)
+}
+
+func MustParsePhone(s string) Phone {
+	p, err := ParsePhone(s)
+	if err != nil {
+		panic(fmt.Sprintf("MustParsePhone failed for %q: %v", s, err))
+	}
+	return p
+}
+
+// lenientPhone parses s like ParsePhone but keeps, without an E.164 form, a
+// number it can't parse; for numbers stored before they were validated.
+func lenientPhone(s string) Phone {
+	if p, err := ParsePhone(s); err == nil {
+		return p
+	}
+	return Phone{display: strings.TrimSpace(s)}
+}
+
+// String returns the number as the user formatted it
+func (me Phone) String() string {
+	return me.display
+}
+
+// E164 returns the number in E.164 format (eg. "+447911123456"), or "" if it's unknown.
+func (me Phone) E164() string {
+	return me.e164
+}
+
+// National returns the number in the national format ("0" followed by the
+// national number, without grouping) if it belongs to DefaultCallingCode,
+// or "" otherwise.
+func (me Phone) National() string {
+	if nationalNumber, found := strings.CutPrefix(me.e164, "+"+DefaultCallingCode); found {
+		return "0" + nationalNumber
+	}
+	return ""
+}
+
+func (me Phone) IsZero() bool {
+	return me.display == ""
+}
+
+// MarshalJSON encodes the number as the user formatted it; the E.164 form is derived again when decoding.
+func (me Phone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(me.display)
+}
+
+func (me *Phone) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*me = lenientPhone(s)
+	return nil
+}
+
+// Value stores the number as the user formatted it, see Scan.
+func (me Phone) Value() (driver.Value, error) {
+	return me.display, nil
+}
+
+func (me *Phone) Scan(src any) error {
+	switch src := src.(type) {
+	case string:
+		*me = lenientPhone(src)
+	case []byte:
+		*me = lenientPhone(string(src))
+	default:
+		return fmt.Errorf("can't scan %T into a Phone", src)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package contact
+
+import "testing"
+
+func TestParsePhone(t *testing.T) {
+	for input, expected := range map[string]string{
+		"+44 (0)7911 123456": "+447911123456",
+		"+44(0)751123456":    "+44751123456",
+		"0044 7911-123456":   "+447911123456",
+		"07911 123456":       "+447911123456",
+		"(07911) 123.456":    "+447911123456",
+		"+1 415/555 0100":    "+14155550100",
+		"  ":                 "",
+	} {
+		if got, err := ParsePhone(input); err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+		} else if got.E164() != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, got.E164())
+		}
+	}
+	for _, input := range []string{"123", "07911 1234567890123", "+0 7911 123456", "0791 l23456", "07911+123456"} {
+		if p, err := ParsePhone(input); err == nil {
+			t.Errorf("%q: expected an error, got %q", input, p.E164())
+		}
+	}
+}
+
+func TestPhoneKeepsDisplayFormat(t *testing.T) {
+	p := MustParsePhone(" +44 (0)7911 123456 ")
+	if p.String() != "+44 (0)7911 123456" || p.National() != "07911123456" {
+		t.Errorf("unexpected display %q or national format %q", p.String(), p.National())
+	}
+}
@@ -0,0 +1,454 @@
+package contact
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"dev.acorello.it/go/contacts/search"
+)
+
+// Query is a parsed search query, see ParseQuery.
+type Query interface {
+	Matches(c Contact) bool
+	String() string
+}
+
+type QueryField string
+
+const (
+	AnyField     QueryField = ""
+	FirstField   QueryField = "first"
+	LastField    QueryField = "last"
+	PhoneField   QueryField = "phone"
+	EmailField   QueryField = "email"
+	AddressField QueryField = "address"
+	// TagField matches a whole tag, eg. `tag:family` or `tag:"best friends"`
+	TagField QueryField = "tag"
+)
+
+var queryFields = []QueryField{FirstField, LastField, PhoneField, EmailField, AddressField, TagField}
+
+// fields returns the search fields f selects, all of them for AnyField.
+func (my Contact) fields(f QueryField) (fields []search.Field) {
+	switch f {
+	case FirstField:
+		return []search.Field{{Kind: search.Text, Weight: 3, Value: my.FirstName}}
+	case LastField:
+		return []search.Field{{Kind: search.Text, Weight: 3, Value: my.LastName}}
+	case EmailField:
+		for _, e := range my.Emails {
+			fields = append(fields, search.Field{Kind: search.Email, Weight: 2, Value: e.Email.String()})
+		}
+	case PhoneField:
+		for _, p := range my.Phones {
+			phone := p.Phone.E164()
+			if phone == "" {
+				phone = p.Phone.String()
+			}
+			fields = append(fields,
+				search.Field{Kind: search.Phone, Weight: 1, Value: phone},
+				search.Field{Kind: search.Phone, Weight: 1, Value: p.Phone.National()})
+		}
+	case AddressField:
+		for _, a := range my.Addresses {
+			fields = append(fields, search.Field{Kind: search.Text, Weight: 1, Value: a.String()})
+		}
+	case TagField:
+		return nil // tags are not searched for words, see Term.Matches
+	default:
+		return my.SearchFields()
+	}
+	return fields
+}
+
+// Term matches a contact when its words match the start of words of the field
+// (see search.MatchesFields) or, if it's a Phrase, when the field contains it
+// (ignoring case and accents). A tag term matches a tag as a whole. A phone
+// term starting with "+" matches the start of the E.164 form of any phone
+// (eg. "phone:+44" matches UK numbers).
+type Term struct {
+	Field  QueryField
+	Value  string
+	Phrase bool
+}
+
+func (me Term) Matches(c Contact) bool {
+	if me.Field == TagField {
+		t, err := ParseTag(me.Value)
+		return err == nil && c.HasTag(t)
+	}
+	if me.Field == PhoneField && strings.HasPrefix(me.Value, "+") {
+		prefix := "+" + search.Digits(me.Value)
+		return slices.ContainsFunc(c.Phones, func(p PhoneEntry) bool {
+			return p.Phone.E164() != "" && strings.HasPrefix(p.Phone.E164(), prefix)
+		})
+	}
+	fields := c.fields(me.Field)
+	if !me.Phrase {
+		return search.MatchesFields(me.Value, fields...)
+	}
+	phrase := search.Fold(me.Value)
+	return slices.ContainsFunc(fields, func(f search.Field) bool {
+		return strings.Contains(search.Fold(f.Value), phrase)
+	})
+}
+
+// String returns the term as ParseQuery reads it: a Phrase is between quotes,
+// with no escapes, as a phrase can't contain quotes.
+func (me Term) String() string {
+	var sb strings.Builder
+	if me.Field != AnyField {
+		sb.WriteString(string(me.Field) + ":")
+	}
+	if me.Phrase {
+		sb.WriteString(`"` + me.Value + `"`)
+	} else {
+		sb.WriteString(me.Value)
+	}
+	return sb.String()
+}
+
+// AllOf matches when all its queries match
+type AllOf []Query
+
+func (me AllOf) Matches(c Contact) bool {
+	for _, q := range me {
+		if !q.Matches(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (me AllOf) String() string {
+	return joinQueries(me, " ")
+}
+
+// AnyOf matches when any of its queries matches
+type AnyOf []Query
+
+func (me AnyOf) Matches(c Contact) bool {
+	for _, q := range me {
+		if q.Matches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (me AnyOf) String() string {
+	return "(" + joinQueries(me, " OR ") + ")"
+}
+
+func joinQueries(qs []Query, sep string) string {
+	var parts []string
+	for _, q := range qs {
+		parts = append(parts, q.String())
+	}
+	return strings.Join(parts, sep)
+}
+
+type Not struct {
+	Query
+}
+
+func (me Not) Matches(c Contact) bool {
+	return !me.Query.Matches(c)
+}
+
+func (me Not) String() string {
+	return "-" + me.Query.String()
+}
+
+// RequiredWords returns the words that every contact matching q must contain:
+// those of the unquoted terms that q requires, not negated nor in OR groups,
+// nor of tags, which are not indexed.
+// Repositories can look them up in a search index to narrow the contacts to test with q.
+func RequiredWords(q Query) string {
+	switch q := q.(type) {
+	case Term:
+		if !q.Phrase && q.Field != TagField {
+			return q.Value
+		}
+	case AllOf:
+		var words []string
+		for _, sub := range q {
+			if w := RequiredWords(sub); w != "" {
+				words = append(words, w)
+			}
+		}
+		return strings.Join(words, " ")
+	}
+	return ""
+}
+
+// RequiredTags returns the tags that every contact matching q must have: those
+// of the tag terms that q requires, not negated nor in OR groups.
+// Repositories can look them up to narrow the contacts to test with q.
+func RequiredTags(q Query) (tags []Tag) {
+	switch q := q.(type) {
+	case Term:
+		if t, err := ParseTag(q.Value); q.Field == TagField && err == nil {
+			return []Tag{t}
+		}
+	case AllOf:
+		for _, sub := range q {
+			tags = append(tags, RequiredTags(sub)...)
+		}
+	}
+	return tags
+}
+
+// RankingWords returns the words of all the terms of q that aren't negated,
+// nor of tags; the contacts matching them best are the most relevant.
+func RankingWords(q Query) string {
+	switch q := q.(type) {
+	case Term:
+		if q.Field != TagField {
+			return q.Value
+		}
+	case AllOf:
+		return rankingWords(q)
+	case AnyOf:
+		return rankingWords(q)
+	}
+	return ""
+}
+
+func rankingWords(qs []Query) string {
+	var words []string
+	for _, sub := range qs {
+		if w := RankingWords(sub); w != "" {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Score is the relevance of c for the words of q, see RankingWords.
+func Score(q Query, c Contact) float64 {
+	return search.ScoreFields(RankingWords(q), c.SearchFields()...)
+}
+
+// QueryError describes why and where a query is malformed.
+type QueryError struct {
+	Query  string
+	Offset int // in bytes
+	Reason string
+}
+
+func (me QueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", me.Reason, me.Offset+1)
+}
+
+// ParseQuery parses a search query. Its syntax is:
+//
+//	query  = group { "OR" group }       matches if any group matches
+//	group  = unary { unary }            matches if all unary match
+//	unary  = [ "-" ] primary            "-" negates
+//	primary = "(" query ")" | [ field ":" ] ( word | `"` phrase `"` )
+//	field  = "first" | "last" | "phone" | "email" | "address" | "tag"
+//
+// eg. `last:smith email:@example.com -phone:+44` or `first:"Mary Ann" OR (first:mary last:ann)`
+func ParseQuery(s string) (Query, error) {
+	p := queryParser{input: s}
+	p.skipSpaces()
+	if p.done() {
+		return nil, QueryError{Query: s, Offset: 0, Reason: "empty query"}
+	}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return q, nil
+}
+
+type queryParser struct {
+	input string
+	pos   int
+	depth int // of open parenthesis
+}
+
+func (me *queryParser) errorf(format string, args ...any) error {
+	return QueryError{Query: me.input, Offset: me.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (me *queryParser) done() bool {
+	return me.pos >= len(me.input)
+}
+
+func (me *queryParser) skipSpaces() {
+	for !me.done() && unicode.IsSpace(rune(me.input[me.pos])) {
+		me.pos += 1
+	}
+}
+
+// atOr tells whether the next word is the OR operator
+func (me *queryParser) atOr() bool {
+	rest := me.input[me.pos:]
+	if !strings.HasPrefix(rest, "OR") {
+		return false
+	}
+	return len(rest) == 2 || strings.ContainsRune(" \t\n()", rune(rest[2]))
+}
+
+func (me *queryParser) atGroupEnd() bool {
+	return me.done() || me.atOr() || (me.depth > 0 && me.input[me.pos] == ')')
+}
+
+func (me *queryParser) parseOr() (Query, error) {
+	var alternatives AnyOf
+	for {
+		if me.atOr() {
+			return nil, me.errorf("OR must be preceded by a term")
+		}
+		group, err := me.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, group)
+		if !me.atOr() {
+			break
+		}
+		me.pos += len("OR")
+		me.skipSpaces()
+		if me.atGroupEnd() {
+			return nil, me.errorf("OR must be followed by a term")
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return alternatives, nil
+}
+
+func (me *queryParser) parseGroup() (Query, error) {
+	var all AllOf
+	for !me.atGroupEnd() {
+		q, err := me.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, q)
+		me.skipSpaces()
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
+
+func (me *queryParser) parseUnary() (Query, error) {
+	if me.input[me.pos] != '-' {
+		return me.parsePrimary()
+	}
+	me.pos += 1
+	if me.done() || unicode.IsSpace(rune(me.input[me.pos])) || me.input[me.pos] == '-' {
+		return nil, me.errorf("\"-\" must be followed by a term")
+	}
+	q, err := me.parsePrimary()
+	return Not{q}, err
+}
+
+func (me *queryParser) parsePrimary() (Query, error) {
+	switch me.input[me.pos] {
+	case '(':
+		start := me.pos
+		me.pos += 1
+		me.depth += 1
+		me.skipSpaces()
+		if !me.done() && me.input[me.pos] == ')' {
+			return nil, me.errorf("empty parentheses")
+		}
+		q, err := me.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if me.done() {
+			return nil, QueryError{Query: me.input, Offset: start, Reason: "unclosed parenthesis"}
+		}
+		me.pos += 1 // ')'
+		me.depth -= 1
+		return q, nil
+	case ')':
+		return nil, me.errorf("unexpected \")\"")
+	}
+	return me.parseTerm()
+}
+
+func (me *queryParser) parseTerm() (Query, error) {
+	var t Term
+	start := me.pos
+	word := me.readWord()
+	if field, value, found := strings.Cut(word, ":"); found && field != "" {
+		t.Field = QueryField(strings.ToLower(field))
+		if !slices.Contains(queryFields, t.Field) {
+			return nil, QueryError{
+				Query:  me.input,
+				Offset: start,
+				Reason: fmt.Sprintf("unknown field %q, expected one of %s", field, queryFieldNames()),
+			}
+		}
+		if value == "" {
+			if me.done() || me.input[me.pos] != '"' {
+				return nil, me.errorf("%q must be followed by a value", field+":")
+			}
+		}
+		word = value
+	}
+	if word == "" && !me.done() && me.input[me.pos] == '"' {
+		phrase, err := me.readPhrase()
+		if err != nil {
+			return nil, err
+		}
+		t.Value, t.Phrase = phrase, true
+		return t, nil
+	}
+	if t.Field != TagField && len(search.Terms(word)) == 0 {
+		// it would match every contact
+		return nil, QueryError{
+			Query:  me.input,
+			Offset: start,
+			Reason: fmt.Sprintf("%q has no letters nor digits to search", word),
+		}
+	}
+	t.Value = word
+	return t, nil
+}
+
+// readWord reads up to a space, a parenthesis or a quote
+func (me *queryParser) readWord() string {
+	start := me.pos
+	for !me.done() && !strings.ContainsRune(" \t\n()\"", rune(me.input[me.pos])) {
+		me.pos += 1
+	}
+	return me.input[start:me.pos]
+}
+
+func (me *queryParser) readPhrase() (string, error) {
+	start := me.pos
+	me.pos += 1 // opening quote
+	end := strings.IndexByte(me.input[me.pos:], '"')
+	if end < 0 {
+		return "", QueryError{Query: me.input, Offset: start, Reason: "unclosed quote"}
+	}
+	phrase := me.input[me.pos : me.pos+end]
+	me.pos += end + 1
+	if strings.TrimSpace(phrase) == "" {
+		return "", QueryError{Query: me.input, Offset: start, Reason: "empty phrase"}
+	}
+	return phrase, nil
+}
+
+func queryFieldNames() string {
+	var names []string
+	for _, f := range queryFields {
+		names = append(names, string(f))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,128 @@
+package contact
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	for input, expected := range map[string]string{
+		`joe`: `joe`,
+		`last:smith email:@example.com -phone:+44`: `last:smith email:@example.com -phone:+44`,
+		`first:"Mary Ann"`:                         `first:"Mary Ann"`,
+		`"Mary Ann" bloggs`:                        `"Mary Ann" bloggs`,
+		`first:joe OR first:jane last:doe`:         `(first:joe OR first:jane last:doe)`,
+		`-(first:joe OR FIRST:jane) doe`:           `-(first:joe OR first:jane) doe`,
+		`ORla`:                                     `ORla`,
+	} {
+		q, err := ParseQuery(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+		} else if q.String() != expected {
+			t.Errorf("%q: expected %s, got %s", input, expected, q)
+		}
+	}
+}
+
+func TestParseMalformedQuery(t *testing.T) {
+	for input, expected := range map[string]QueryError{
+		`nick:joe`:        {Offset: 0, Reason: `unknown field "nick", expected one of first, last, phone, email, address, tag`},
+		`joe first:`:      {Offset: 10, Reason: `"first:" must be followed by a value`},
+		`first:"Mary Ann`: {Offset: 6, Reason: "unclosed quote"},
+		`(joe OR jane`:    {Offset: 0, Reason: "unclosed parenthesis"},
+		`joe)`:            {Offset: 3, Reason: `unexpected ")"`},
+		`OR joe`:          {Offset: 0, Reason: "OR must be preceded by a term"},
+		`joe OR`:          {Offset: 6, Reason: "OR must be followed by a term"},
+		`joe - bloggs`:    {Offset: 5, Reason: `"-" must be followed by a term`},
+		`()`:              {Offset: 1, Reason: "empty parentheses"},
+		`   `:             {Offset: 0, Reason: "empty query"},
+		`joe email:@`:     {Offset: 4, Reason: `"@" has no letters nor digits to search`},
+		`phone:+`:         {Offset: 0, Reason: `"+" has no letters nor digits to search`},
+	} {
+		_, err := ParseQuery(input)
+		var queryErr QueryError
+		if !errors.As(err, &queryErr) {
+			t.Errorf("%q: expected QueryError, got %v", input, err)
+			continue
+		}
+		queryErr.Query = ""
+		if queryErr != expected {
+			t.Errorf("%q: expected %+v, got %+v", input, expected, queryErr)
+		}
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	maryAnn := Contact{
+		FirstName: "Mary Ann",
+		LastName:  "Smith",
+		Phones:    []PhoneEntry{{Phone: MustParsePhone("+44 751 123456")}, {Label: Work, Phone: MustParsePhone("+1 415 555 0100")}},
+		Emails:    []EmailEntry{{Email: MustParseEmail("mary@Example.com")}},
+		Addresses: []Address{{Street: "1 Main Street", City: "Cambridge", Country: "United Kingdom"}},
+		Tags:      []Tag{"best friends", "family"},
+	}
+	for input, expected := range map[string]bool{
+		`tag:family`:                      true,
+		`tag:FAMILY last:smith`:           true,
+		`tag:fam`:                         false,
+		`tag:"best  friends"`:             true,
+		`tag:work OR tag:family`:          true,
+		`-tag:family`:                     false,
+		`family`:                          false,
+		`smi`:                             true,
+		`last:smith email:@example.com`:   true,
+		`first:"mary ann"`:                true,
+		`first:"ann mary"`:                false,
+		`-phone:123456`:                   false,
+		`first:joe OR last:smith`:         true,
+		`(first:joe OR last:jones) mary`:  false,
+		`phone:751-123`:                   true,
+		`phone:+44`:                       true,
+		`phone:+1`:                        true,
+		`phone:+33`:                       false,
+		`address:cambridge`:               true,
+		`address:smith`:                   false,
+		`phone:0751123456`:                true,
+		`email:mary@example.com -last:sm`: false,
+	} {
+		if got := mustParseQuery(t, input).Matches(maryAnn); got != expected {
+			t.Errorf("%q: expected %v, got %v", input, expected, got)
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, s string) Query {
+	t.Helper()
+	q, err := ParseQuery(s)
+	if err != nil {
+		t.Fatalf("%q: %v", s, err)
+	}
+	return q
+}
+
+func TestTagTerm(t *testing.T) {
+	tag := MustParseTag(`c:\docs & notes`)
+	c := Contact{Tags: []Tag{tag}}
+	if term := tag.Term(); term != `tag:"c:\docs & notes"` {
+		t.Errorf("expected the tag quoted as a phrase, got %s", term)
+	} else if !mustParseQuery(t, term).Matches(c) {
+		t.Errorf("expected %s to match the contact having the tag", term)
+	}
+	if _, err := ParseTag(`"quoted"`); err == nil {
+		t.Error("expected a tag with quotes to be invalid")
+	}
+}
+
+func TestRequiredWordsSkipTags(t *testing.T) {
+	if got := RequiredWords(mustParseQuery(t, `tag:family smith`)); got != "smith" {
+		t.Errorf("expected only %q required, got %q", "smith", got)
+	}
+}
+
+func TestRequiredTags(t *testing.T) {
+	got := RequiredTags(mustParseQuery(t, `tag:family smith (tag:pals OR tag:work) -tag:old tag:"best friends"`))
+	if expected := []Tag{"family", "best friends"}; !slices.Equal(got, expected) {
+		t.Errorf("expected %v required, got %v", expected, got)
+	}
+}
@@ -0,0 +1,132 @@
+package contact
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxChunkSize is the size past which a chunk of a sortedIndex is split in two
+const maxChunkSize = 128
+
+// indexEntry is an item of a sortedIndex: the key of a contact, and its Id
+type indexEntry[K any] struct {
+	key K
+	id  Id
+}
+
+// sortedIndex keeps entries sorted by compare in chunks, at most maxChunkSize
+// long, so that adding or removing one moves a chunk at most, and pages are
+// read from where the previous one ended rather than sorting all the entries.
+type sortedIndex[K any] struct {
+	compare func(a, b indexEntry[K]) int
+	chunks  [][]indexEntry[K]
+}
+
+// newSortedIndex sorts by key, compared by compare, then by Id, as paginate does
+func newSortedIndex[K any](compare func(a, b K) int) *sortedIndex[K] {
+	return &sortedIndex[K]{compare: func(a, b indexEntry[K]) int {
+		return cmp.Or(compare(a.key, b.key), cmp.Compare(a.id, b.id))
+	}}
+}
+
+// newContactIndex sorts the contacts by their SortKey in order
+func newContactIndex(order Order) *sortedIndex[string] {
+	if order.Descending {
+		return newSortedIndex(func(a, b string) int { return strings.Compare(b, a) })
+	}
+	return newSortedIndex(strings.Compare)
+}
+
+// newTrashIndex sorts the deleted contacts as DeletedPageOf does
+func newTrashIndex() *sortedIndex[time.Time] {
+	return newSortedIndex(func(a, b time.Time) int { return b.Compare(a) })
+}
+
+// chunkOf returns the position of the chunk e belongs to: the first whose last
+// entry is not less than e, or the last chunk.
+func (me *sortedIndex[K]) chunkOf(e indexEntry[K]) int {
+	i, _ := slices.BinarySearchFunc(me.chunks, e, func(chunk []indexEntry[K], e indexEntry[K]) int {
+		return me.compare(chunk[len(chunk)-1], e)
+	})
+	return min(i, len(me.chunks)-1)
+}
+
+func (me *sortedIndex[K]) insert(key K, id Id) {
+	e := indexEntry[K]{key, id}
+	if len(me.chunks) == 0 {
+		me.chunks = [][]indexEntry[K]{{e}}
+		return
+	}
+	i := me.chunkOf(e)
+	chunk := me.chunks[i]
+	j, found := slices.BinarySearchFunc(chunk, e, me.compare)
+	if found {
+		return
+	}
+	chunk = slices.Insert(chunk, j, e)
+	if len(chunk) <= maxChunkSize {
+		me.chunks[i] = chunk
+		return
+	}
+	half := len(chunk) / 2
+	me.chunks[i] = slices.Clip(chunk[:half])
+	me.chunks = slices.Insert(me.chunks, i+1, slices.Clone(chunk[half:]))
+}
+
+func (me *sortedIndex[K]) remove(key K, id Id) {
+	e := indexEntry[K]{key, id}
+	if len(me.chunks) == 0 {
+		return
+	}
+	i := me.chunkOf(e)
+	j, found := slices.BinarySearchFunc(me.chunks[i], e, me.compare)
+	if !found {
+		return
+	}
+	me.chunks[i] = slices.Delete(me.chunks[i], j, j+1)
+	if len(me.chunks[i]) == 0 {
+		me.chunks = slices.Delete(me.chunks, i, i+1)
+	}
+}
+
+// after yields, in order, the ids of the entries following the one with key
+// and id, or all of them if !ok.
+func (me *sortedIndex[K]) after(key K, id Id, ok bool) iter.Seq[Id] {
+	return func(yield func(Id) bool) {
+		i, j := 0, 0
+		if e := (indexEntry[K]{key, id}); ok && len(me.chunks) > 0 {
+			i = me.chunkOf(e)
+			var found bool
+			if j, found = slices.BinarySearchFunc(me.chunks[i], e, me.compare); found {
+				j++
+			}
+		}
+		for ; i < len(me.chunks); i, j = i+1, 0 {
+			for _, e := range me.chunks[i][j:] {
+				if !yield(e.id) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// pageOf reads the page of the items yielded, up to one past page.Size to
+// tell if there are more; following returns the page after the last one.
+func pageOf[T any](items iter.Seq[T], page Page, following func(T) Page) (result []T, next Page, more bool) {
+	for item := range items {
+		if len(result) == max(page.Size, 0) {
+			more = true
+			break
+		}
+		result = append(result, item)
+	}
+	next = page
+	if len(result) > 0 {
+		next = following(result[len(result)-1])
+	}
+	return result, next, more
+}
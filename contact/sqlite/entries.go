@@ -0,0 +1,241 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// storeEntries replaces the phones, e-mails, addresses, tags, and custom
+// values of the contact stored with sequence number seq
+func storeEntries(ctx context.Context, tx *sql.Tx, seq int64, c contact.Contact) error {
+	for _, table := range []string{"contact_phones", "contact_emails", "contact_addresses", "contact_tags", "contact_custom"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE contact_seq = ?`, seq); err != nil {
+			return err
+		}
+	}
+	for i, p := range c.Phones {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO contact_phones (contact_seq, position, label, is_primary, phone, phone_e164)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			seq, i, p.Label, p.Primary, p.Phone, p.Phone.E164())
+		if err != nil {
+			return err
+		}
+	}
+	for i, e := range c.Emails {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO contact_emails (contact_seq, position, label, is_primary, email, email_canonical)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			seq, i, e.Label, e.Primary, e.Email, e.Email.Canonical())
+		if err != nil {
+			return err
+		}
+	}
+	for i, a := range c.Addresses {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO contact_addresses (contact_seq, position, label, is_primary, street, city, postal_code, region, country)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			seq, i, a.Label, a.Primary, a.Street, a.City, a.PostalCode, a.Region, a.Country)
+		if err != nil {
+			return err
+		}
+	}
+	for i, t := range c.Tags {
+		_, err := tx.ExecContext(ctx, `INSERT INTO contact_tags (contact_seq, position, tag) VALUES (?, ?, ?)`, seq, i, t)
+		if err != nil {
+			return err
+		}
+	}
+	for i, name := range slices.Sorted(maps.Keys(c.Custom)) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO contact_custom (contact_seq, position, name, value) VALUES (?, ?, ?, ?)`,
+			seq, i, name, c.Custom[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEntries fills in the phones, e-mails, addresses, tags, and custom values of contacts
+func loadEntries(ctx context.Context, tx *sql.Tx, contacts []storedContact) error {
+	bySeq := make(map[int64]*contact.Contact, len(contacts))
+	seqs := make([]int64, len(contacts))
+	for i := range contacts {
+		bySeq[contacts[i].seq] = &contacts[i].Contact
+		seqs[i] = contacts[i].seq
+	}
+	err := forEachEntry(ctx, tx, `SELECT contact_seq, label, is_primary, phone FROM contact_phones`, seqs,
+		func(rows *sql.Rows) error {
+			var seq int64
+			var p contact.PhoneEntry
+			if err := rows.Scan(&seq, &p.Label, &p.Primary, &p.Phone); err != nil {
+				return err
+			}
+			bySeq[seq].Phones = append(bySeq[seq].Phones, p)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	err = forEachEntry(ctx, tx, `SELECT contact_seq, label, is_primary, email FROM contact_emails`, seqs,
+		func(rows *sql.Rows) error {
+			var seq int64
+			var e contact.EmailEntry
+			if err := rows.Scan(&seq, &e.Label, &e.Primary, &e.Email); err != nil {
+				return err
+			}
+			bySeq[seq].Emails = append(bySeq[seq].Emails, e)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	err = forEachEntry(ctx, tx, `
+		SELECT contact_seq, label, is_primary, street, city, postal_code, region, country FROM contact_addresses`, seqs,
+		func(rows *sql.Rows) error {
+			var seq int64
+			var a contact.Address
+			if err := rows.Scan(&seq, &a.Label, &a.Primary, &a.Street, &a.City, &a.PostalCode, &a.Region, &a.Country); err != nil {
+				return err
+			}
+			bySeq[seq].Addresses = append(bySeq[seq].Addresses, a)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	err = forEachEntry(ctx, tx, `SELECT contact_seq, tag FROM contact_tags`, seqs,
+		func(rows *sql.Rows) error {
+			var seq int64
+			var t contact.Tag
+			if err := rows.Scan(&seq, &t); err != nil {
+				return err
+			}
+			bySeq[seq].Tags = append(bySeq[seq].Tags, t)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return forEachEntry(ctx, tx, `SELECT contact_seq, name, value FROM contact_custom`, seqs,
+		func(rows *sql.Rows) error {
+			var seq int64
+			var name, value string
+			if err := rows.Scan(&seq, &name, &value); err != nil {
+				return err
+			}
+			if bySeq[seq].Custom == nil {
+				bySeq[seq].Custom = make(map[string]string)
+			}
+			bySeq[seq].Custom[name] = value
+			return nil
+		})
+}
+
+// forEachEntry calls scan on each row of the entries selected by query, of the
+// contacts with sequence numbers seqs, in their position order.
+func forEachEntry(ctx context.Context, tx *sql.Tx, query string, seqs []int64, scan func(*sql.Rows) error) error {
+	for chunk := range slices.Chunk(seqs, maxSeqsPerQuery) {
+		placeholders, args := inList(chunk)
+		rows, err := tx.QueryContext(ctx, query+` WHERE contact_seq IN (`+placeholders+`) ORDER BY contact_seq, position`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inList returns the placeholders and the arguments of an `IN (...)` list of seqs
+func inList(seqs []int64) (placeholders string, args []any) {
+	args = make([]any, len(seqs))
+	for i, seq := range seqs {
+		args[i] = seq
+	}
+	return strings.Repeat(",?", len(seqs))[1:], args
+}
+
+// normalizeEntries fills phone_e164 and email_canonical of the entries stored before they existed.
+// Contacts whose e-mails differ only by case make it fail: one of them must be changed by hand.
+func normalizeEntries(ctx context.Context, db *sql.DB) error {
+	return inTx(ctx, db, func(tx *sql.Tx) error {
+		type phoneRow struct {
+			seq, position int64
+			phone         contact.Phone
+		}
+		var phones []phoneRow
+		err := forEachRow(ctx, tx, `
+			SELECT contact_seq, position, phone FROM contact_phones WHERE phone <> '' AND phone_e164 = ''`,
+			func(rows *sql.Rows) error {
+				var p phoneRow
+				err := rows.Scan(&p.seq, &p.position, &p.phone)
+				phones = append(phones, p)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+		for _, p := range phones {
+			_, err := tx.ExecContext(ctx, `UPDATE contact_phones SET phone_e164 = ? WHERE contact_seq = ? AND position = ?`,
+				p.phone.E164(), p.seq, p.position)
+			if err != nil {
+				return err
+			}
+		}
+		type emailRow struct {
+			seq, position int64
+			email         contact.Email
+		}
+		var emails []emailRow
+		err = forEachRow(ctx, tx, `
+			SELECT contact_seq, position, email FROM contact_emails WHERE email_canonical IS NULL`,
+			func(rows *sql.Rows) error {
+				var e emailRow
+				err := rows.Scan(&e.seq, &e.position, &e.email)
+				emails = append(emails, e)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+		for _, e := range emails {
+			_, err := tx.ExecContext(ctx, `UPDATE contact_emails SET email_canonical = ? WHERE contact_seq = ? AND position = ?`,
+				e.email.Canonical(), e.seq, e.position)
+			if isUniqueConstraintViolation(err) {
+				return fmt.Errorf("e-mail %q is used more than once: %w", e.email, err)
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func forEachRow(ctx context.Context, tx *sql.Tx, query string, scan func(*sql.Rows) error, args ...any) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,12 @@
+-- seq preserves insertion order, the same order InMemoryRepository lists contacts in
+CREATE TABLE contacts (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT    NOT NULL UNIQUE,
+    first_name TEXT    NOT NULL,
+    last_name  TEXT    NOT NULL,
+    phone      TEXT    NOT NULL,
+    email      TEXT    NOT NULL
+);
+
+-- one e-mail belongs to at most one contact ( [e-mail]--N--1--[contactId] )
+CREATE UNIQUE INDEX contacts_email ON contacts (email);
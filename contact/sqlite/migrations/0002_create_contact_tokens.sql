@@ -0,0 +1,10 @@
+-- full-text search index: the tokens of each contact (see search.Tokenize);
+-- rows are (re)built by Repository, contacts missing them are indexed at Open
+CREATE TABLE contact_tokens (
+    contact_seq INTEGER NOT NULL REFERENCES contacts (seq) ON DELETE CASCADE,
+    token       TEXT    NOT NULL,
+    weight      REAL    NOT NULL,
+    PRIMARY KEY (token, contact_seq)
+) WITHOUT ROWID;
+
+CREATE INDEX contact_tokens_contact ON contact_tokens (contact_seq);
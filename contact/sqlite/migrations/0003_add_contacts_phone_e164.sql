@@ -0,0 +1,7 @@
+-- the E.164 form of phone (see contact.Phone), '' if unknown; filled at Open for existing rows
+ALTER TABLE contacts ADD COLUMN phone_e164 TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX contacts_phone_e164 ON contacts (phone_e164);
+
+-- phones are now indexed in E.164 and national format: rebuilt at Open
+DELETE FROM contact_tokens;
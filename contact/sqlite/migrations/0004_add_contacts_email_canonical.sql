@@ -0,0 +1,7 @@
+-- the canonical form of email (see contact.Email), filled at Open for existing rows;
+-- it's what makes two e-mails the same: Joe@Example.com and joe@example.com are
+DROP INDEX contacts_email;
+
+ALTER TABLE contacts ADD COLUMN email_canonical TEXT;
+
+CREATE UNIQUE INDEX contacts_email_canonical ON contacts (email_canonical);
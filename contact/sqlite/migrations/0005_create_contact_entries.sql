@@ -0,0 +1,55 @@
+-- a contact has any number of phones, e-mails, and addresses, kept in the
+-- order of position; the single phone and e-mail of contacts become primary entries
+CREATE TABLE contact_phones (
+    contact_seq INTEGER NOT NULL REFERENCES contacts (seq) ON DELETE CASCADE,
+    position    INTEGER NOT NULL,
+    label       TEXT    NOT NULL,
+    is_primary  INTEGER NOT NULL,
+    phone       TEXT    NOT NULL,
+    phone_e164  TEXT    NOT NULL, -- '' if unknown
+    PRIMARY KEY (contact_seq, position)
+);
+
+CREATE INDEX contact_phones_e164 ON contact_phones (phone_e164);
+
+CREATE TABLE contact_emails (
+    contact_seq     INTEGER NOT NULL REFERENCES contacts (seq) ON DELETE CASCADE,
+    position        INTEGER NOT NULL,
+    label           TEXT    NOT NULL,
+    is_primary      INTEGER NOT NULL,
+    email           TEXT    NOT NULL,
+    email_canonical TEXT, -- filled at Open if NULL
+    PRIMARY KEY (contact_seq, position)
+);
+
+-- one e-mail belongs to at most one contact, across all of its e-mails
+CREATE UNIQUE INDEX contact_emails_canonical ON contact_emails (email_canonical);
+
+CREATE TABLE contact_addresses (
+    contact_seq INTEGER NOT NULL REFERENCES contacts (seq) ON DELETE CASCADE,
+    position    INTEGER NOT NULL,
+    label       TEXT    NOT NULL,
+    is_primary  INTEGER NOT NULL,
+    street      TEXT    NOT NULL,
+    city        TEXT    NOT NULL,
+    postal_code TEXT    NOT NULL,
+    region      TEXT    NOT NULL,
+    country     TEXT    NOT NULL,
+    PRIMARY KEY (contact_seq, position)
+);
+
+INSERT INTO contact_phones (contact_seq, position, label, is_primary, phone, phone_e164)
+SELECT seq, 0, '', 1, phone, phone_e164 FROM contacts WHERE phone <> '';
+
+INSERT INTO contact_emails (contact_seq, position, label, is_primary, email, email_canonical)
+SELECT seq, 0, '', 1, email, email_canonical FROM contacts WHERE email <> '';
+
+DROP INDEX contacts_phone_e164;
+DROP INDEX contacts_email_canonical;
+ALTER TABLE contacts DROP COLUMN phone;
+ALTER TABLE contacts DROP COLUMN phone_e164;
+ALTER TABLE contacts DROP COLUMN email;
+ALTER TABLE contacts DROP COLUMN email_canonical;
+
+-- addresses are indexed too: rebuilt at Open
+DELETE FROM contact_tokens;
@@ -0,0 +1,2 @@
+-- the number of times a contact was stored (see contact.Contact.Version)
+ALTER TABLE contacts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
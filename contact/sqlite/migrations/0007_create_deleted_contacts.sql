@@ -0,0 +1,8 @@
+-- the trash: deleted contacts are kept whole, as JSON, until restored or purged
+CREATE TABLE deleted_contacts (
+    id         TEXT    PRIMARY KEY,
+    deleted_at INTEGER NOT NULL, -- Unix time in nanoseconds
+    contact    TEXT    NOT NULL
+);
+
+CREATE INDEX deleted_contacts_deleted_at ON deleted_contacts (deleted_at);
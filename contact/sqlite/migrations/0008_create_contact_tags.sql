@@ -0,0 +1,9 @@
+-- a contact has any number of tags, kept in the order of position (sorted, see contact.SortedTags)
+CREATE TABLE contact_tags (
+    contact_seq INTEGER NOT NULL REFERENCES contacts (seq) ON DELETE CASCADE,
+    position    INTEGER NOT NULL,
+    tag         TEXT    NOT NULL,
+    PRIMARY KEY (contact_seq, position)
+);
+
+CREATE INDEX contact_tags_tag ON contact_tags (tag);
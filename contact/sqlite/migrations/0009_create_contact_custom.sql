@@ -0,0 +1,10 @@
+-- the values of the custom fields of a contact, by field name (see contact.CustomField);
+-- position keeps them in name order
+CREATE TABLE contact_custom (
+    contact_seq INTEGER NOT NULL REFERENCES contacts (seq) ON DELETE CASCADE,
+    position    INTEGER NOT NULL,
+    name        TEXT    NOT NULL,
+    value       TEXT    NOT NULL,
+    PRIMARY KEY (contact_seq, position),
+    UNIQUE (contact_seq, name)
+);
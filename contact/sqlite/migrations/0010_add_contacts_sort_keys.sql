@@ -0,0 +1,15 @@
+-- the keys the contacts are sorted by (see contact.Contact.SortKey), to pick
+-- the pages of the list in SQL; filled at Open for existing rows
+ALTER TABLE contacts ADD COLUMN sort_first TEXT;
+ALTER TABLE contacts ADD COLUMN sort_last TEXT;
+ALTER TABLE contacts ADD COLUMN sort_phone TEXT;
+ALTER TABLE contacts ADD COLUMN sort_email TEXT;
+
+CREATE INDEX contacts_sort_first ON contacts (sort_first, id);
+CREATE INDEX contacts_sort_last ON contacts (sort_last, id);
+CREATE INDEX contacts_sort_phone ON contacts (sort_phone, id);
+CREATE INDEX contacts_sort_email ON contacts (sort_email, id);
+
+-- the trash lists the most recently deleted first, ties broken by id
+DROP INDEX deleted_contacts_deleted_at;
+CREATE INDEX deleted_contacts_deleted_at ON deleted_contacts (deleted_at DESC, id);
@@ -0,0 +1,189 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/search"
+)
+
+// maxRune sorts after any valid UTF-8 sequence: `token < prefix+maxRune` bounds a prefix range
+const maxRune = "\U0010FFFF"
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// indexContact replaces the search tokens of the contact stored with sequence number seq
+func indexContact(ctx context.Context, db execer, seq int64, c contact.Contact) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM contact_tokens WHERE contact_seq = ?`, seq); err != nil {
+		return err
+	}
+	for token, weight := range search.Tokenize(c.SearchFields()...) {
+		_, err := db.ExecContext(ctx, `INSERT INTO contact_tokens (contact_seq, token, weight) VALUES (?, ?, ?)`,
+			seq, token, weight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexMissing indexes the contacts without search tokens (eg. stored before the index existed)
+func indexMissing(ctx context.Context, db *sql.DB) error {
+	return inTx(ctx, db, func(tx *sql.Tx) error {
+		found, err := queryStored(ctx, tx, selectStoredContact+`
+			WHERE seq NOT IN (SELECT contact_seq FROM contact_tokens)`)
+		if err != nil {
+			return err
+		}
+		for _, m := range found {
+			if err := indexContact(ctx, tx, m.seq, m.Contact); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// storedContact is a contact with its insertion sequence number
+type storedContact struct {
+	seq int64
+	contact.Contact
+}
+
+// FindByQuery has the same semantics as contact.InMemoryRepository.FindByQuery:
+// the words q requires are looked up by prefix in the search tokens, and the
+// tags it requires in the contact tags, to select the candidates, which are
+// then matched in Go. Unless ranked (see contact.Page.RankedBy), the page is
+// walked from its cursor as FindAll does; else all the matches are scored.
+func (me *Repository) FindByQuery(ctx context.Context, q contact.Query, page contact.Page) (result []contact.Contact, next contact.Page, more bool, err error) {
+	lookups := requiredLookups(q)
+	if !page.RankedBy(q) {
+		err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+			result, next, more, err = walk(ctx, tx, page, lookups, q.Matches)
+			return err
+		})
+		if err != nil {
+			return nil, page, false, err
+		}
+		return result, next, more, nil
+	}
+	var matches []contact.Contact
+	err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+		var candidates []storedContact
+		if len(lookups) > 0 {
+			seqs, err := findSeqsMatchingAll(ctx, tx, lookups)
+			if err != nil {
+				return err
+			}
+			if candidates, err = findBySeqs(ctx, tx, seqs); err != nil {
+				return err
+			}
+		} else if candidates, err = findAllStored(ctx, tx); err != nil {
+			return err
+		}
+		for _, c := range candidates {
+			if q.Matches(c.Contact) {
+				matches = append(matches, c.Contact)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, page, false, err
+	}
+	return contact.QueryPageOf(q, matches, page)
+}
+
+// lookup is a query selecting the sequence numbers of some contacts
+type lookup struct {
+	query string
+	args  []any
+}
+
+// requiredLookups returns a lookup for each of the words and tags q requires
+func requiredLookups(q contact.Query) (lookups []lookup) {
+	for _, term := range search.Terms(contact.RequiredWords(q)) {
+		lookups = append(lookups, lookup{`SELECT DISTINCT contact_seq FROM contact_tokens
+			WHERE token >= ? AND token < ?`, []any{term, term + maxRune}})
+	}
+	for _, t := range contact.RequiredTags(q) {
+		lookups = append(lookups, lookup{`SELECT contact_seq FROM contact_tags WHERE tag = ?`, []any{t}})
+	}
+	return lookups
+}
+
+// findSeqsMatchingAll returns the sequence numbers of the contacts selected by all the lookups
+func findSeqsMatchingAll(ctx context.Context, tx *sql.Tx, lookups []lookup) ([]int64, error) {
+	var matching map[int64]bool
+	for _, l := range lookups {
+		lookupMatching := make(map[int64]bool)
+		err := forEachRow(ctx, tx, l.query, func(rows *sql.Rows) error {
+			var seq int64
+			if err := rows.Scan(&seq); err != nil {
+				return err
+			}
+			if matching == nil || matching[seq] {
+				lookupMatching[seq] = true
+			}
+			return nil
+		}, l.args...)
+		if err != nil {
+			return nil, err
+		}
+		matching = lookupMatching
+		if len(matching) == 0 {
+			break
+		}
+	}
+	var seqs []int64
+	for seq := range matching {
+		seqs = append(seqs, seq)
+	}
+	return seqs, nil
+}
+
+const selectStoredContact = `SELECT seq, id, version, first_name, last_name FROM contacts`
+
+func findAllStored(ctx context.Context, tx *sql.Tx) ([]storedContact, error) {
+	return queryStored(ctx, tx, selectStoredContact)
+}
+
+// maxSeqsPerQuery keeps the query parameters within SQLite limits
+const maxSeqsPerQuery = 500
+
+func findBySeqs(ctx context.Context, tx *sql.Tx, seqs []int64) (result []storedContact, err error) {
+	for chunk := range slices.Chunk(seqs, maxSeqsPerQuery) {
+		placeholders, args := inList(chunk)
+		found, err := queryStored(ctx, tx, selectStoredContact+` WHERE seq IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, found...)
+	}
+	return result, nil
+}
+
+// queryStored runs query, selecting the columns of selectStoredContact, and loads the entries of the contacts found
+func queryStored(ctx context.Context, tx *sql.Tx, query string, args ...any) (result []storedContact, err error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c storedContact
+		if err := rows.Scan(&c.seq, &c.Id, &c.Version, &c.FirstName, &c.LastName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, loadEntries(ctx, tx, result)
+}
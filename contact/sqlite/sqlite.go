@@ -0,0 +1,381 @@
+// Package sqlite implements contact.Repository on top of a SQLite database file.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+	"github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Repository keeps two pools of connections to the database: db, whose
+// transactions take the write lock as they begin (BEGIN IMMEDIATE), so that
+// those that read, then write, wait for each other rather than fail with
+// SQLITE_BUSY; and read, for the read-only ones, which WAL lets run meanwhile.
+type Repository struct {
+	db   *sql.DB
+	read *sql.DB
+}
+
+// Open opens (creating it if missing) the database at path and applies the pending migrations.
+func Open(ctx context.Context, path string) (*Repository, error) {
+	db, err := openDB(path, "immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate %q: %w", path, err)
+	}
+	if err := normalizeEntries(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to normalize phone numbers and e-mails of %q: %w", path, err)
+	}
+	if err := fillSortKeys(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to fill the sort keys of %q: %w", path, err)
+	}
+	if err := indexMissing(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to build search index of %q: %w", path, err)
+	}
+	read, err := openDB(path, "deferred")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Repository{db: db, read: read}, nil
+}
+
+// openDB opens a pool of connections whose transactions begin with the given _txlock
+func openDB(path, txlock string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_busy_timeout", "5000")
+	params.Add("_journal_mode", "WAL")
+	params.Add("_foreign_keys", "on")
+	params.Add("_txlock", txlock)
+	return sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+}
+
+func (me *Repository) Close() error {
+	return errors.Join(me.read.Close(), me.db.Close())
+}
+
+// migrate applies, in lexical order, the scripts in migrations/ not yet applied;
+// the number of applied scripts is tracked in the database `user_version`.
+func migrate(ctx context.Context, db *sql.DB) error {
+	scripts, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(scripts) {
+		return fmt.Errorf("database version %d is newer than the %d known migrations", version, len(scripts))
+	}
+	for i := version; i < len(scripts); i++ {
+		script, err := migrations.ReadFile(scripts[i])
+		if err != nil {
+			return err
+		}
+		log.Printf("Applying migration %q", scripts[i])
+		if err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+				return fmt.Errorf("%s: %w", scripts[i], err)
+			}
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1))
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inTx(ctx context.Context, db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// inReadTx runs f in a read-only transaction, for a consistent view of contacts and their entries
+func inReadTx(ctx context.Context, db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+func (me *Repository) FindById(ctx context.Context, id contact.Id) (c contact.Contact, found bool, err error) {
+	err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+		stored, err := queryStored(ctx, tx, selectStoredContact+` WHERE id = ?`, id)
+		if err != nil || len(stored) == 0 {
+			return err
+		}
+		c, found = stored[0].Contact, true
+		return nil
+	})
+	return c, found, err
+}
+
+func (me *Repository) FindIdByEmail(ctx context.Context, email contact.Email) (res contact.Id, found bool, err error) {
+	return findIdByEmail(ctx, me.read, email)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func findIdByEmail(ctx context.Context, db queryer, email contact.Email) (res contact.Id, found bool, err error) {
+	err = db.QueryRowContext(ctx, `
+		SELECT c.id FROM contact_emails e JOIN contacts c ON c.seq = e.contact_seq
+		WHERE e.email_canonical = ?`, email.Canonical()).Scan(&res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return res, false, nil
+	} else if err != nil {
+		return res, false, err
+	}
+	return res, true, nil
+}
+
+// sortColumns are the columns of the sort keys of the contacts, by field
+var sortColumns = map[contact.SortField]string{
+	contact.ByFirstName: "sort_first",
+	contact.ByLastName:  "sort_last",
+	contact.ByPhone:     "sort_phone",
+	contact.ByEmail:     "sort_email",
+}
+
+// FindAll picks the page in SQL, by the sort key columns: the contacts after
+// the cursor (see contact.Page.KeyAfter), and one more to tell if there are more.
+func (me *Repository) FindAll(ctx context.Context, page contact.Page) (result []contact.Contact, next contact.Page, more bool, err error) {
+	err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+		result, next, more, err = walk(ctx, tx, page, nil, func(contact.Contact) bool { return true })
+		return err
+	})
+	if err != nil {
+		return nil, page, false, err
+	}
+	return result, next, more, nil
+}
+
+// maxWalkBatch bounds the contacts walk reads at a time
+const maxWalkBatch = 1000
+
+// walk picks the page of the contacts kept among those all the lookups
+// select, reading them in the page's Order from its cursor, as FindAll does,
+// in batches: the first one page long, the others twice the previous one.
+func walk(ctx context.Context, tx *sql.Tx, page contact.Page, lookups []lookup, keep func(contact.Contact) bool) (result []contact.Contact, next contact.Page, more bool, err error) {
+	key, id, after, err := page.KeyAfter()
+	if err != nil {
+		return nil, page, false, err
+	}
+	column, op, direction := sortColumns[page.Order.Field()], ">", "ASC"
+	if page.Order.Descending {
+		op, direction = "<", "DESC"
+	}
+	var conditions []string
+	var lookupArgs []any
+	for _, l := range lookups {
+		conditions = append(conditions, `seq IN (`+l.query+`)`)
+		lookupArgs = append(lookupArgs, l.args...)
+	}
+	size := max(page.Size, 0)
+	for batch := size + 1; ; batch = min(2*batch, maxWalkBatch) {
+		query, args := selectStoredContact, slices.Clone(lookupArgs)
+		where := conditions
+		if after {
+			where = append(slices.Clip(where), `(`+column+` `+op+` ? OR (`+column+` = ? AND id > ?))`)
+			args = append(args, key, key, id)
+		}
+		if len(where) > 0 {
+			query += ` WHERE ` + strings.Join(where, ` AND `)
+		}
+		query += ` ORDER BY ` + column + ` ` + direction + `, id LIMIT ?`
+		args = append(args, batch)
+		stored, err := queryStored(ctx, tx, query, args...)
+		if err != nil {
+			return nil, page, false, err
+		}
+		for _, c := range stored {
+			if len(result) > size {
+				break
+			} else if keep(c.Contact) {
+				result = append(result, c.Contact)
+			}
+		}
+		if len(result) > size || len(stored) < batch {
+			break
+		}
+		last := stored[len(stored)-1]
+		key, id, after = last.SortKey(page.Order.Field()), last.Id, true
+	}
+	more = len(result) > size
+	result = result[:min(len(result), size)]
+	next = page
+	if len(result) > 0 {
+		next = page.Following(result[len(result)-1])
+	}
+	return result, next, more, nil
+}
+
+func (me *Repository) Count(ctx context.Context) (count int, err error) {
+	err = me.read.QueryRowContext(ctx, `SELECT count(*) FROM contacts`).Scan(&count)
+	return count, err
+}
+
+// fillSortKeys fills the sort keys of the contacts stored before they existed
+func fillSortKeys(ctx context.Context, db *sql.DB) error {
+	return inTx(ctx, db, func(tx *sql.Tx) error {
+		found, err := queryStored(ctx, tx, selectStoredContact+` WHERE sort_last IS NULL`)
+		if err != nil {
+			return err
+		}
+		for _, c := range found {
+			_, err := tx.ExecContext(ctx, `
+				UPDATE contacts SET sort_first = ?, sort_last = ?, sort_phone = ?, sort_email = ? WHERE seq = ?`,
+				append(sortKeys(c.Contact), c.seq)...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sortKeys are the values of the sort key columns of c, in the order of the columns
+func sortKeys(c contact.Contact) []any {
+	return []any{c.SortKey(contact.ByFirstName), c.SortKey(contact.ByLastName), c.SortKey(contact.ByPhone), c.SortKey(contact.ByEmail)}
+}
+
+func (me *Repository) Store(ctx context.Context, cs ...contact.Contact) error {
+	return inTx(ctx, me.db, func(tx *sql.Tx) error {
+		for _, c := range cs {
+			log.Printf("Storing %#v", c)
+			if err := store(ctx, tx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Merge moves other to the trash, freeing its e-mails, then stores merged, in one transaction
+func (me *Repository) Merge(ctx context.Context, merged, other contact.Contact) error {
+	if merged.Id == other.Id {
+		return fmt.Errorf("contact %q can't be merged into itself", merged.Id)
+	}
+	return inTx(ctx, me.db, func(tx *sql.Tx) error {
+		if err := checkVersion(ctx, tx, other); err != nil {
+			return err
+		} else if deleted, err := trash(ctx, tx, other.Id, time.Now()); err != nil {
+			return err
+		} else if !deleted {
+			return contact.VersionConflictError{Id: other.Id, Version: other.Version}
+		}
+		log.Printf("Merging %q into %#v", other.Id, merged)
+		return store(ctx, tx, merged)
+	})
+}
+
+// store stores c; the e-mails of the contacts stored before in tx count as owned
+func store(ctx context.Context, tx *sql.Tx, c contact.Contact) error {
+	if err := checkVersion(ctx, tx, c); err != nil {
+		return err
+	}
+	return write(ctx, tx, c)
+}
+
+// write stores c with the next Version, taking it out of the trash, unless
+// another contact has one of its e-mails; the callers check its Version
+// beforehand, in tx.
+func write(ctx context.Context, tx *sql.Tx, c contact.Contact) error {
+	if err := checkEmailOwner(ctx, tx, c); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM deleted_contacts WHERE id = ?`, c.Id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO contacts (id, version, first_name, last_name, sort_first, sort_last, sort_phone, sort_email)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			version    = excluded.version,
+			first_name = excluded.first_name,
+			last_name  = excluded.last_name,
+			sort_first = excluded.sort_first,
+			sort_last  = excluded.sort_last,
+			sort_phone = excluded.sort_phone,
+			sort_email = excluded.sort_email`,
+		slices.Concat([]any{c.Id, c.Version + 1, c.FirstName, c.LastName}, sortKeys(c))...)
+	if err != nil {
+		return err
+	}
+	var seq int64
+	if err := tx.QueryRowContext(ctx, `SELECT seq FROM contacts WHERE id = ?`, c.Id).Scan(&seq); err != nil {
+		return err
+	}
+	if err := storeEntries(ctx, tx, seq, c); err != nil {
+		return err
+	}
+	return indexContact(ctx, tx, seq, c)
+}
+
+// checkVersion checks that c carries the Version of the stored contact
+func checkVersion(ctx context.Context, tx *sql.Tx, c contact.Contact) error {
+	var current int
+	err := tx.QueryRowContext(ctx, `SELECT version FROM contacts WHERE id = ?`, c.Id).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if c.Version != current {
+		return contact.VersionConflictError{Id: c.Id, Version: c.Version, Current: current}
+	}
+	return nil
+}
+
+// checkEmailOwner checks that none of the e-mails of c belongs to another
+// contact, nor to c twice
+func checkEmailOwner(ctx context.Context, tx *sql.Tx, c contact.Contact) error {
+	if err := c.CheckEmails(); err != nil {
+		return err
+	}
+	for _, e := range c.Emails {
+		alreadyAssignedId, found, err := findIdByEmail(ctx, tx, e.Email)
+		if err != nil {
+			return err
+		}
+		if found && c.Id != alreadyAssignedId {
+			return contact.EmailOwnerError{Email: e.Email, Owner: alreadyAssignedId}
+		}
+	}
+	return nil
+}
+
+func isUniqueConstraintViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
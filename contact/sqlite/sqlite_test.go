@@ -0,0 +1,427 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/sqlite"
+)
+
+func openTestRepository(t *testing.T) *sqlite.Repository {
+	t.Helper()
+	repo, err := sqlite.Open(context.Background(), filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func emails(addresses ...string) (entries []contact.EmailEntry) {
+	for i, a := range addresses {
+		entries = append(entries, contact.EmailEntry{Primary: i == 0, Email: contact.MustParseEmail(a)})
+	}
+	return entries
+}
+
+func TestStoreEnforcesEmailOwner(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com")}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	joe.Version = 1
+	joe.Phones = []contact.PhoneEntry{{Label: contact.Mobile, Primary: true, Phone: contact.MustParsePhone("+44751123456")}}
+	joe.Emails = append(joe.Emails, contact.EmailEntry{Label: contact.Work, Email: contact.MustParseEmail("bloggs@work.example.com")})
+	joe.Addresses = []contact.Address{{Label: contact.Home, Primary: true, Street: "1 Main Street", City: "London"}}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatalf("updating a contact should keep its own e-mail: %v", err)
+	}
+	for _, email := range []string{"JOE@Example.com", "Bloggs@WORK.example.com"} {
+		jane := contact.Contact{Id: contact.NewId(), FirstName: "Jane", LastName: "Doe", Emails: emails("jane@example.com", email)}
+		var emailOwnerErr contact.EmailOwnerError
+		if err := repo.Store(ctx, jane); !errors.As(err, &emailOwnerErr) || emailOwnerErr.Owner != joe.Id {
+			t.Errorf("%q: expected EmailOwnerError with owner %q, got %v", email, joe.Id, err)
+		}
+	}
+	if found, _, err := repo.FindById(ctx, joe.Id); err != nil || !found.Equal(joe) {
+		t.Errorf("expected %#v, got %#v (err: %v)", joe, found, err)
+	}
+	if found, _, _, _ := repo.FindByQuery(ctx, contact.Term{Value: "0751 123 456"}, contact.Page{Size: 10}); len(found) != 1 {
+		t.Errorf("expected to find the phone by its national format, got %#v", found)
+	}
+}
+
+func TestStoreIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com")}
+	jane := contact.Contact{Id: contact.NewId(), FirstName: "Jane", LastName: "Doe", Emails: emails("jane@example.com", "JOE@example.com")}
+	var emailOwnerErr contact.EmailOwnerError
+	if err := repo.Store(ctx, joe, jane); !errors.As(err, &emailOwnerErr) || emailOwnerErr.Owner != joe.Id {
+		t.Errorf("expected EmailOwnerError with owner %q, got %v", joe.Id, err)
+	}
+	if _, found, _ := repo.FindById(ctx, joe.Id); found {
+		t.Errorf("expected none of the batch to be stored")
+	}
+	jane.Emails = emails("jane@example.com", "Jane@Example.com")
+	if err := repo.Store(ctx, jane); !errors.As(err, new(contact.DuplicateEmailError)) {
+		t.Errorf("expected DuplicateEmailError, got %v", err)
+	}
+	jane.Emails = emails("jane@example.com")
+	if err := repo.Store(ctx, joe, jane); err != nil {
+		t.Fatal(err)
+	}
+	if all, _, _, _ := repo.FindAll(ctx, contact.Page{Size: 10}); len(all) != 2 {
+		t.Errorf("expected both contacts, got %#v", all)
+	}
+}
+
+func TestMergeIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com")}
+	joseph := contact.Contact{Id: contact.NewId(), FirstName: "Joseph", LastName: "Bloggs", Emails: emails("joseph@example.com")}
+	if err := repo.Store(ctx, joe, joseph); err != nil {
+		t.Fatal(err)
+	}
+	joe.Version, joseph.Version = 1, 1
+
+	merged := joe
+	merged.Emails = emails("joe@example.com", "joseph@example.com")
+	stale := merged
+	stale.Version = 0
+	if err := repo.Merge(ctx, stale, joseph); !errors.As(err, new(contact.VersionConflictError)) {
+		t.Errorf("expected a VersionConflictError for a stale merged contact, got %v", err)
+	}
+	if c, found, _ := repo.FindById(ctx, joseph.Id); !found || c.Version != 1 {
+		t.Errorf("expected the other contact untouched, got %#v", c)
+	}
+
+	if err := repo.Merge(ctx, merged, joseph); err != nil {
+		t.Fatal(err)
+	}
+	if c, _, _ := repo.FindById(ctx, joe.Id); len(c.Emails) != 2 || c.Version != 2 {
+		t.Errorf("expected the merged contact, with the e-mails of both, got %#v", c)
+	}
+	if _, found, _ := repo.FindDeletedById(ctx, joseph.Id); !found {
+		t.Errorf("expected the other contact in the trash")
+	}
+}
+
+func TestSearchAndPagination(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	for _, name := range []string{"Annie", "Bob", "Ann", "Joanna", "Anna", "Dan"} {
+		c := contact.Contact{Id: contact.NewId(), FirstName: name, LastName: "X", Emails: emails(name + "@example.com")}
+		if err := repo.Store(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(cs []contact.Contact) (res []string) {
+		for _, c := range cs {
+			res = append(res, c.FirstName)
+		}
+		return res
+	}
+	page := contact.Page{Size: 2}
+	result, next, more, err := repo.FindByQuery(ctx, contact.Term{Value: "ann"}, page)
+	if err != nil || !more || !slices.Equal(names(result), []string{"Ann", "Anna"}) {
+		t.Errorf("expected exact match first, got %v (more: %v, err: %v)", names(result), more, err)
+	}
+	result, _, more, err = repo.FindByQuery(ctx, contact.Term{Value: "ann"}, next)
+	if err != nil || more || !slices.Equal(names(result), []string{"Annie"}) {
+		t.Errorf("unexpected second page %v (more: %v, err: %v)", names(result), more, err)
+	}
+	if result, _, _, _ := repo.FindByQuery(ctx, contact.Term{Value: "ANNIE x"}, page); !slices.Equal(names(result), []string{"Annie"}) {
+		t.Errorf("search should be case-insensitive and match all words, got %v", names(result))
+	}
+	query := contact.AllOf{contact.Term{Field: contact.EmailField, Value: "@example.com"}, contact.Not{Query: contact.Term{Value: "ann"}}}
+	if result, _, _, _ := repo.FindByQuery(ctx, query, contact.Page{Size: 10}); !slices.Equal(names(result), []string{"Bob", "Dan", "Joanna"}) {
+		t.Errorf("expected contacts not matching \"ann\" equally relevant, by name, got %v", names(result))
+	}
+	_, next, _, _ = repo.FindAll(ctx, contact.Page{Size: 4, Order: contact.Order{By: contact.ByFirstName}})
+	if result, _, more, err := repo.FindAll(ctx, next); err != nil || more || !slices.Equal(names(result), []string{"Dan", "Joanna"}) {
+		t.Errorf("unexpected last page %v (more: %v, err: %v)", names(result), more, err)
+	}
+}
+
+// TestPagesAsInMemory walks the pages of every order, and of the trash, in
+// SQL as InMemoryRepository does in Go, namesakes and ties included.
+func TestPagesAsInMemory(t *testing.T) {
+	ctx := context.Background()
+	repo, inMemory := openTestRepository(t), contact.NewInMemoryContactRepository()
+	var ids []contact.Id
+	for i, name := range []string{"Ann", "bob", "Ann", "Zoë", "Dan", "ann", "Bob"} {
+		c := contact.Contact{Id: contact.NewId(), FirstName: name, LastName: []string{"Lee", "Smith"}[i%2], Emails: emails(fmt.Sprintf("%s%d@example.com", name, i))}
+		if i%3 == 0 {
+			c.Phones = []contact.PhoneEntry{{Primary: true, Phone: contact.MustParsePhone(fmt.Sprintf("+44 7911 12345%d", i))}}
+		}
+		for _, r := range []contact.Repository{repo, inMemory} {
+			if err := r.Store(ctx, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids = append(ids, c.Id)
+	}
+	walk := func(r contact.Repository, q contact.Query, page contact.Page) (found []contact.Id) {
+		for more := true; more; {
+			var cs []contact.Contact
+			var err error
+			if q == nil {
+				cs, page, more, err = r.FindAll(ctx, page)
+			} else {
+				cs, page, more, err = r.FindByQuery(ctx, q, page)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range cs {
+				found = append(found, c.Id)
+			}
+		}
+		return found
+	}
+	for _, query := range []string{"", "ann OR bob", "-ann", "lee phone:+44"} {
+		var q contact.Query
+		if query != "" {
+			q, _ = contact.ParseQuery(query)
+		}
+		for _, by := range append(contact.SortFields, "") {
+			for _, descending := range []bool{false, true} {
+				page := contact.Page{Size: 2, Order: contact.Order{By: by, Descending: descending}}
+				if expected, actual := walk(inMemory, q, page), walk(repo, q, page); !slices.Equal(expected, actual) {
+					t.Errorf("%q %+v: expected %v, got %v", query, page.Order, expected, actual)
+				}
+			}
+		}
+	}
+
+	for _, r := range []contact.Repository{repo, inMemory} {
+		if err := r.DeleteAll(ctx, ids[:4]...); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Delete(ctx, ids[4]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	walkDeleted := func(r contact.Repository) (found []contact.Id) {
+		for page, more := (contact.Page{Size: 2}), true; more; {
+			var ds []contact.DeletedContact
+			var err error
+			if ds, page, more, err = r.FindDeleted(ctx, page); err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range ds {
+				found = append(found, d.Id)
+			}
+		}
+		return found
+	}
+	if expected, actual := walkDeleted(inMemory), walkDeleted(repo); len(actual) != 5 || actual[0] != ids[4] || !slices.Equal(expected[1:], actual[1:]) {
+		t.Errorf("expected the trash %v, got %v", expected, actual)
+	}
+}
+
+// TestOpenMigratesSingleEntries opens a database created when contacts had a
+// single phone and e-mail, stored as typed.
+func TestOpenMigratesSingleEntries(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "contacts.db")
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range []string{"migrations/0001_create_contacts.sql", "migrations/0002_create_contact_tokens.sql"} {
+		if ddl, err := os.ReadFile(script); err != nil {
+			t.Fatal(err)
+		} else if _, err := db.Exec(string(ddl)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec(`PRAGMA user_version = 2;
+		INSERT INTO contacts (id, first_name, last_name, phone, email)
+		VALUES ('00000000-0000-0000-0000-000000000001', 'Joe', 'Bloggs', '07911 123456', 'Joe@EXAMPLE.com')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := sqlite.Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	id, found, err := repo.FindIdByEmail(ctx, contact.MustParseEmail("joe@example.com"))
+	if err != nil || !found {
+		t.Fatalf("expected the e-mail to be found by its canonical form (err: %v)", err)
+	}
+	joe, _, err := repo.FindById(ctx, id)
+	if err != nil || joe.Phone().E164() != "+447911123456" || joe.Email().String() != "Joe@example.com" {
+		t.Errorf("unexpected %#v (err: %v)", joe, err)
+	}
+	if found, _, _, _ := repo.FindByQuery(ctx, contact.Term{Field: contact.PhoneField, Value: "+44"}, contact.Page{Size: 1}); len(found) != 1 {
+		t.Errorf("expected the contact to be indexed, got %#v", found)
+	}
+}
+
+func TestStoreRejectsStaleVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com")}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	var conflictErr contact.VersionConflictError
+	if err := repo.Store(ctx, joe); !errors.As(err, &conflictErr) || conflictErr.Current != 1 {
+		t.Errorf("expected VersionConflictError at version 1, got %v", err)
+	}
+	joe.Version, joe.LastName = 1, "Blogs"
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	if found, _, err := repo.FindById(ctx, joe.Id); err != nil || found.Version != 2 || !found.Equal(joe) {
+		t.Errorf("expected %#v at version 2, got %#v (err: %v)", joe, found, err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com")}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := repo.Delete(ctx, joe.Id); !deleted || err != nil {
+		t.Fatalf("expected deletion, got %v, %v", deleted, err)
+	}
+	if _, found, _ := repo.FindIdByEmail(ctx, contact.MustParseEmail("joe@example.com")); found {
+		t.Errorf("expected the e-mail of a deleted contact to be free")
+	}
+	if d, found, err := repo.FindDeletedById(ctx, joe.Id); err != nil || !found || !d.Equal(joe) || d.Version != 1 || d.DeletedAt.IsZero() {
+		t.Errorf("expected %#v in the trash, got %#v (err: %v)", joe, d, err)
+	}
+	if restored, err := repo.Restore(ctx, joe.Id); !restored || err != nil {
+		t.Fatalf("expected restoration, got %v, %v", restored, err)
+	}
+	if got, _, _ := repo.FindById(ctx, joe.Id); !got.Equal(joe) || got.Version != 2 {
+		t.Errorf("expected %#v at version 2, got %#v", joe, got)
+	}
+	if deleted, _, _, _ := repo.FindDeleted(ctx, contact.Page{Size: 10}); len(deleted) != 0 {
+		t.Errorf("expected an empty trash, got %#v", deleted)
+	}
+	repo.Delete(ctx, joe.Id)
+	if purged, err := repo.PurgeDeletedBefore(ctx, time.Now()); purged != 1 || err != nil {
+		t.Errorf("expected 1 contact purged, got %d, %v", purged, err)
+	}
+}
+
+func TestDeleteAllIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com")}
+	ann := contact.Contact{Id: contact.NewId(), FirstName: "Ann", LastName: "Lee", Emails: emails("ann@example.com")}
+	if err := repo.Store(ctx, joe, ann); err != nil {
+		t.Fatal(err)
+	}
+	missing := contact.NewId()
+	var notFoundErr contact.NotFoundError
+	if err := repo.DeleteAll(ctx, joe.Id, missing); !errors.As(err, &notFoundErr) || notFoundErr.Id != missing {
+		t.Fatalf("expected NotFoundError of %q, got %v", missing, err)
+	}
+	if _, found, _ := repo.FindById(ctx, joe.Id); !found {
+		t.Errorf("expected none of the batch to be deleted")
+	}
+	if err := repo.DeleteAll(ctx, joe.Id, ann.Id, joe.Id); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _, _, _ := repo.FindDeleted(ctx, contact.Page{Size: 10}); len(deleted) != 2 {
+		t.Errorf("expected 2 contacts in the trash, got %#v", deleted)
+	}
+}
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com"), Tags: []contact.Tag{"family", "pals"}}
+	ann := contact.Contact{Id: contact.NewId(), FirstName: "Ann", LastName: "Lee", Emails: emails("ann@example.com"), Tags: []contact.Tag{"friends"}}
+	if err := repo.Store(ctx, joe, ann); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := repo.FindById(ctx, joe.Id); !got.Equal(joe) {
+		t.Errorf("expected %#v, got %#v", joe, got)
+	}
+	if renamed, err := repo.RenameTag(ctx, "friends", "pals"); renamed != 1 || err != nil {
+		t.Fatalf("expected 1 contact renamed, got %d, %v", renamed, err)
+	}
+	if got, _, _ := repo.FindById(ctx, joe.Id); !slices.Equal(got.Tags, []contact.Tag{"family", "friends"}) || got.Version != 2 {
+		t.Errorf("expected the tag renamed at version 2, got %#v", got)
+	}
+	counts, err := repo.FindTags(ctx)
+	if expected := []contact.TagCount{{Tag: "family", Count: 1}, {Tag: "friends", Count: 2}}; err != nil || !slices.Equal(counts, expected) {
+		t.Errorf("expected %v, got %v (err: %v)", expected, counts, err)
+	}
+	q, _ := contact.ParseQuery("tag:friends lee")
+	if found, _, _, _ := repo.FindByQuery(ctx, q, contact.Page{Size: 10}); len(found) != 1 || found[0].Id != ann.Id {
+		t.Errorf("expected only Ann, got %#v", found)
+	}
+	q, _ = contact.ParseQuery("tag:friends -tag:family")
+	if found, _, _, _ := repo.FindByQuery(ctx, q, contact.Page{Size: 10}); len(found) != 1 || found[0].Id != ann.Id {
+		t.Errorf("expected only Ann by tags alone, got %#v", found)
+	}
+}
+
+func TestCustomValues(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	joe := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: emails("joe@example.com"),
+		Custom: map[string]string{"company": "Acme", "birthday": "1980-02-29"}}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := repo.FindById(ctx, joe.Id); !got.Equal(joe) {
+		t.Errorf("expected %#v, got %#v", joe, got)
+	}
+	joe.Version = 1
+	joe.Custom = map[string]string{"company": "Initech"}
+	if err := repo.Store(ctx, joe); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := repo.FindById(ctx, joe.Id); !got.Equal(joe) {
+		t.Errorf("expected the values replaced, %#v, got %#v", joe.Custom, got.Custom)
+	}
+}
+
+func TestConcurrentStores(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	errs := make(chan error, 20)
+	for i := range cap(errs) {
+		go func() {
+			var err error
+			for j := range 10 {
+				c := contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: fmt.Sprint("Bloggs", i, j), Emails: emails(fmt.Sprintf("joe.%d.%d@example.com", i, j))}
+				if err = repo.Store(ctx, c); err != nil {
+					break
+				}
+			}
+			errs <- err
+		}()
+	}
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if count, err := repo.Count(ctx); count != 200 || err != nil {
+		t.Errorf("expected 200 contacts, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func (me *Repository) FindTags(ctx context.Context) (counts []contact.TagCount, err error) {
+	err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+		counts = nil
+		return forEachRow(ctx, tx, `SELECT tag, COUNT(*) FROM contact_tags GROUP BY tag ORDER BY tag`,
+			func(rows *sql.Rows) error {
+				var c contact.TagCount
+				if err := rows.Scan(&c.Tag, &c.Count); err != nil {
+					return err
+				}
+				counts = append(counts, c)
+				return nil
+			})
+	})
+	return counts, err
+}
+
+func (me *Repository) RenameTag(ctx context.Context, to contact.Tag, from ...contact.Tag) (renamed int, err error) {
+	if len(from) == 0 {
+		return 0, nil
+	}
+	err = inTx(ctx, me.db, func(tx *sql.Tx) error {
+		renamed = 0
+		args := make([]any, len(from))
+		for i, t := range from {
+			args[i] = t
+		}
+		var seqs []int64
+		err := forEachRow(ctx, tx, `SELECT DISTINCT contact_seq FROM contact_tags
+			WHERE tag IN (`+strings.Repeat(",?", len(from))[1:]+`)`,
+			func(rows *sql.Rows) error {
+				var seq int64
+				err := rows.Scan(&seq)
+				seqs = append(seqs, seq)
+				return err
+			}, args...)
+		if err != nil {
+			return err
+		}
+		tagged, err := findBySeqs(ctx, tx, seqs)
+		if err != nil {
+			return err
+		}
+		for _, c := range tagged {
+			if c, changed := c.RenameTag(to, from...); changed {
+				if err := write(ctx, tx, c); err != nil {
+					return err
+				}
+				renamed++
+			}
+		}
+		return nil
+	})
+	return renamed, err
+}
@@ -0,0 +1,159 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// Delete moves the contact, as a whole, to the deleted_contacts table
+func (me *Repository) Delete(ctx context.Context, id contact.Id) (deleted bool, err error) {
+	err = inTx(ctx, me.db, func(tx *sql.Tx) error {
+		deleted, err = trash(ctx, tx, id, time.Now())
+		return err
+	})
+	return deleted, err
+}
+
+func (me *Repository) DeleteAll(ctx context.Context, ids ...contact.Id) error {
+	deletedAt := time.Now()
+	return inTx(ctx, me.db, func(tx *sql.Tx) error {
+		for i, id := range ids {
+			if deleted, err := trash(ctx, tx, id, deletedAt); err != nil {
+				return err
+			} else if !deleted && !slices.Contains(ids[:i], id) { // not if listed twice
+				return contact.NotFoundError{Id: id}
+			}
+		}
+		return nil
+	})
+}
+
+// trash moves the contact to the trash, reporting whether it existed
+func trash(ctx context.Context, tx *sql.Tx, id contact.Id, deletedAt time.Time) (bool, error) {
+	stored, err := queryStored(ctx, tx, selectStoredContact+` WHERE id = ?`, id)
+	if err != nil || len(stored) == 0 {
+		return false, err
+	}
+	data, err := json.Marshal(stored[0].Contact)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO deleted_contacts (id, deleted_at, contact) VALUES (?, ?, ?)`,
+		id, deletedAt.UnixNano(), data)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM contacts WHERE id = ?`, id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+const selectDeletedContact = `SELECT contact, deleted_at FROM deleted_contacts`
+
+// FindDeleted picks the page in SQL: the contacts deleted before the one the
+// cursor points to (see contact.Page.DeletedAfter), and one more to tell if there are more.
+func (me *Repository) FindDeleted(ctx context.Context, page contact.Page) (result []contact.DeletedContact, next contact.Page, more bool, err error) {
+	deletedAt, id, after, err := page.DeletedAfter()
+	if err != nil {
+		return nil, page, false, err
+	}
+	query, args := selectDeletedContact, []any{}
+	if after {
+		query += ` WHERE deleted_at < ? OR (deleted_at = ? AND id > ?)`
+		args = append(args, deletedAt.UnixNano(), deletedAt.UnixNano(), id)
+	}
+	query += ` ORDER BY deleted_at DESC, id LIMIT ?`
+	args = append(args, max(page.Size, 0)+1)
+	err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+		result, err = queryDeleted(ctx, tx, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, page, false, err
+	}
+	more = len(result) > max(page.Size, 0)
+	result = result[:min(len(result), max(page.Size, 0))]
+	next = page
+	if len(result) > 0 {
+		next = page.FollowingDeleted(result[len(result)-1])
+	}
+	return result, next, more, nil
+}
+
+func (me *Repository) FindDeletedById(ctx context.Context, id contact.Id) (d contact.DeletedContact, found bool, err error) {
+	err = inReadTx(ctx, me.read, func(tx *sql.Tx) error {
+		d, found, err = findDeletedById(ctx, tx, id)
+		return err
+	})
+	return d, found, err
+}
+
+func findDeletedById(ctx context.Context, tx *sql.Tx, id contact.Id) (d contact.DeletedContact, found bool, err error) {
+	deleted, err := queryDeleted(ctx, tx, selectDeletedContact+` WHERE id = ?`, id)
+	if err != nil || len(deleted) == 0 {
+		return d, false, err
+	}
+	return deleted[0], true, nil
+}
+
+func queryDeleted(ctx context.Context, tx *sql.Tx, query string, args ...any) (result []contact.DeletedContact, err error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		var deletedAt int64
+		if err := rows.Scan(&data, &deletedAt); err != nil {
+			return nil, err
+		}
+		d := contact.DeletedContact{DeletedAt: time.Unix(0, deletedAt)}
+		if err := json.Unmarshal(data, &d.Contact); err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+// Restore stores the contact again, as Store would, but whatever its Version
+func (me *Repository) Restore(ctx context.Context, id contact.Id) (restored bool, err error) {
+	err = inTx(ctx, me.db, func(tx *sql.Tx) error {
+		d, found, err := findDeletedById(ctx, tx, id)
+		if err != nil || !found {
+			return err
+		}
+		if err := write(ctx, tx, d.Contact); err != nil {
+			return err
+		}
+		restored = true
+		return nil
+	})
+	return restored, err
+}
+
+func (me *Repository) Purge(ctx context.Context, id contact.Id) (purged bool, err error) {
+	n, err := me.purge(ctx, `DELETE FROM deleted_contacts WHERE id = ?`, id)
+	return n > 0, err
+}
+
+func (me *Repository) PurgeDeletedBefore(ctx context.Context, t time.Time) (purged int, err error) {
+	n, err := me.purge(ctx, `DELETE FROM deleted_contacts WHERE deleted_at < ?`, t.UnixNano())
+	return int(n), err
+}
+
+func (me *Repository) purge(ctx context.Context, query string, args ...any) (n int64, err error) {
+	res, err := me.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,120 @@
+package contact
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tag categorizes contacts, eg. "family"; a contact has any number of them,
+// and a tag exists as long as some contact has it. Tags are lower case, with
+// single spaces between words (see ParseTag).
+type Tag string
+
+// maxTagLength is in runes
+const maxTagLength = 40
+
+// ParseTag normalizes s to a tag: trimmed, lower case, with single spaces.
+// Commas are not allowed, as they separate tags (see ParseTags), nor quotes,
+// as they delimit the tag in its search Term.
+func ParseTag(s string) (Tag, error) {
+	t := strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	switch {
+	case t == "":
+		return "", errors.New("blank tag")
+	case strings.ContainsRune(t, ','):
+		return "", fmt.Errorf("tag %q contains a comma", t)
+	case strings.ContainsRune(t, '"'):
+		return "", fmt.Errorf("tag %q contains a quote", t)
+	case utf8.RuneCountInString(t) > maxTagLength:
+		return "", fmt.Errorf("tag %q is longer than %d characters", t, maxTagLength)
+	}
+	return Tag(t), nil
+}
+
+// Term is the search term matching the contacts having the tag, eg. `tag:"book club"`
+func (me Tag) Term() string {
+	return Term{Field: TagField, Value: string(me), Phrase: true}.String()
+}
+
+func MustParseTag(s string) Tag {
+	t, err := ParseTag(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseTags parses tags separated by commas, eg. "family, friends", skipping
+// blank ones; the tags are returned sorted, without repetitions.
+func ParseTags(s string) (tags []Tag, err error) {
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		t, err := ParseTag(part)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return SortedTags(tags), nil
+}
+
+// SortedTags returns the tags sorted, without repetitions
+func SortedTags(tags []Tag) []Tag {
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// TagCount is how many contacts have a tag
+type TagCount struct {
+	Tag
+	Count int
+}
+
+// CountTags counts the contacts having each tag, in tag order
+func CountTags(cs []Contact) (counts []TagCount) {
+	byTag := make(map[Tag]int)
+	for _, c := range cs {
+		for _, t := range c.Tags {
+			byTag[t]++
+		}
+	}
+	for t, n := range byTag {
+		counts = append(counts, TagCount{Tag: t, Count: n})
+	}
+	slices.SortFunc(counts, func(a, b TagCount) int { return strings.Compare(string(a.Tag), string(b.Tag)) })
+	return counts
+}
+
+func (my Contact) HasTag(t Tag) bool {
+	return slices.Contains(my.Tags, t)
+}
+
+// RenameTag returns the contact with the tags from replaced by to, and
+// whether it had any of them.
+func (my Contact) RenameTag(to Tag, from ...Tag) (renamed Contact, changed bool) {
+	if !slices.ContainsFunc(from, my.HasTag) {
+		return my, false
+	}
+	renamed = my.Clone()
+	renamed.Tags = slices.DeleteFunc(renamed.Tags, func(t Tag) bool { return slices.Contains(from, t) })
+	renamed.Tags = SortedTags(append(renamed.Tags, to))
+	return renamed, true
+}
+
+// TagQuery matches the contacts having any of the tags
+func TagQuery(tags ...Tag) Query {
+	var q AnyOf
+	for _, t := range tags {
+		q = append(q, Term{Field: TagField, Value: string(t), Phrase: true})
+	}
+	if len(q) == 1 {
+		return q[0]
+	}
+	return q
+}
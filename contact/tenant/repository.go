@@ -0,0 +1,168 @@
+package tenant
+
+import (
+	"context"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+)
+
+// repository delegates each call to the Repository of the book of the tenant of its context
+type repository struct {
+	books *Books
+}
+
+func (me repository) of(ctx context.Context) (contact.Repository, error) {
+	b, err := me.books.Book(ctx)
+	return b.Repository, err
+}
+
+func (me repository) FindById(ctx context.Context, id contact.Id) (c contact.Contact, found bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return c, false, err
+	} else {
+		return repo.FindById(ctx, id)
+	}
+}
+
+func (me repository) Delete(ctx context.Context, id contact.Id) (deleted bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return false, err
+	} else {
+		return repo.Delete(ctx, id)
+	}
+}
+
+func (me repository) DeleteAll(ctx context.Context, ids ...contact.Id) error {
+	if repo, err := me.of(ctx); err != nil {
+		return err
+	} else {
+		return repo.DeleteAll(ctx, ids...)
+	}
+}
+
+func (me repository) FindDeleted(ctx context.Context, page contact.Page) (result []contact.DeletedContact, next contact.Page, more bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return nil, next, false, err
+	} else {
+		return repo.FindDeleted(ctx, page)
+	}
+}
+
+func (me repository) FindDeletedById(ctx context.Context, id contact.Id) (d contact.DeletedContact, found bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return d, false, err
+	} else {
+		return repo.FindDeletedById(ctx, id)
+	}
+}
+
+func (me repository) Restore(ctx context.Context, id contact.Id) (restored bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return false, err
+	} else {
+		return repo.Restore(ctx, id)
+	}
+}
+
+func (me repository) Purge(ctx context.Context, id contact.Id) (purged bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return false, err
+	} else {
+		return repo.Purge(ctx, id)
+	}
+}
+
+func (me repository) PurgeDeletedBefore(ctx context.Context, t time.Time) (purged int, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return 0, err
+	} else {
+		return repo.PurgeDeletedBefore(ctx, t)
+	}
+}
+
+func (me repository) FindAll(ctx context.Context, page contact.Page) (result []contact.Contact, next contact.Page, more bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return nil, next, false, err
+	} else {
+		return repo.FindAll(ctx, page)
+	}
+}
+
+func (me repository) Count(ctx context.Context) (int, error) {
+	if repo, err := me.of(ctx); err != nil {
+		return 0, err
+	} else {
+		return repo.Count(ctx)
+	}
+}
+
+func (me repository) Store(ctx context.Context, cs ...contact.Contact) error {
+	if repo, err := me.of(ctx); err != nil {
+		return err
+	} else {
+		return repo.Store(ctx, cs...)
+	}
+}
+
+func (me repository) Merge(ctx context.Context, merged, other contact.Contact) error {
+	if repo, err := me.of(ctx); err != nil {
+		return err
+	} else {
+		return repo.Merge(ctx, merged, other)
+	}
+}
+
+func (me repository) FindByQuery(ctx context.Context, q contact.Query, page contact.Page) (result []contact.Contact, next contact.Page, more bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return nil, next, false, err
+	} else {
+		return repo.FindByQuery(ctx, q, page)
+	}
+}
+
+func (me repository) FindIdByEmail(ctx context.Context, email contact.Email) (res contact.Id, found bool, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return res, false, err
+	} else {
+		return repo.FindIdByEmail(ctx, email)
+	}
+}
+
+func (me repository) FindTags(ctx context.Context) ([]contact.TagCount, error) {
+	if repo, err := me.of(ctx); err != nil {
+		return nil, err
+	} else {
+		return repo.FindTags(ctx)
+	}
+}
+
+func (me repository) RenameTag(ctx context.Context, to contact.Tag, from ...contact.Tag) (renamed int, err error) {
+	if repo, err := me.of(ctx); err != nil {
+		return 0, err
+	} else {
+		return repo.RenameTag(ctx, to, from...)
+	}
+}
+
+// auditLog delegates each call to the Log of the book of the tenant of its context
+type auditLog struct {
+	books *Books
+}
+
+func (me auditLog) Append(ctx context.Context, entries ...audit.Entry) error {
+	if b, err := me.books.Book(ctx); err != nil {
+		return err
+	} else {
+		return b.Log.Append(ctx, entries...)
+	}
+}
+
+func (me auditLog) History(ctx context.Context, id contact.Id) ([]audit.Entry, error) {
+	if b, err := me.books.Book(ctx); err != nil {
+		return nil, err
+	} else {
+		return b.Log.History(ctx, id)
+	}
+}
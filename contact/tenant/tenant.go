@@ -0,0 +1,180 @@
+// Package tenant keeps an address book for each tenant, a user or a team,
+// isolated from the others: each book has contacts and history of its own, so
+// that e-mails, for one, are unique within a book only.
+//
+// The tenant of a request is carried by its context (see With): Books.Repository
+// and Books.Log act on the book of the tenant of the context they are given.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sync"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+)
+
+// Id identifies a tenant; it is fit to name files (see ParseId)
+type Id string
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ParseId parses an id of lower case letters, digits, "-", and "_"
+func ParseId(s string) (Id, error) {
+	if !idPattern.MatchString(s) {
+		return "", fmt.Errorf("invalid tenant %q: expected lower case letters, digits, \"-\", and \"_\"", s)
+	}
+	return Id(s), nil
+}
+
+func (me Id) String() string {
+	return string(me)
+}
+
+type tenantKey struct{}
+
+// With returns a copy of ctx acting on the book of tenant t
+func With(ctx context.Context, t Id) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// Of returns the tenant of ctx, if any (see With)
+func Of(ctx context.Context) (t Id, ok bool) {
+	t, ok = ctx.Value(tenantKey{}).(Id)
+	return t, ok
+}
+
+// ErrNoTenant is returned by the books when the context carries no tenant
+var ErrNoTenant = errors.New("no tenant in context")
+
+// UnknownError is returned by the books for a tenant they don't have
+type UnknownError struct {
+	Tenant Id
+}
+
+func (me UnknownError) Error() string {
+	return fmt.Sprintf("unknown tenant %q", me.Tenant)
+}
+
+// Book is the address book of a tenant: its contacts, and their history
+type Book struct {
+	// Repository should record the changes of the contacts in Log (see audit.Repository)
+	Repository contact.Repository
+	Log        audit.Log
+	// Close, if any, closes the book
+	Close func() error
+}
+
+// Opener opens the book of tenant t, eg. a database of its own
+type Opener func(ctx context.Context, t Id) (Book, error)
+
+// Books are the address books of the tenants, each opened on first use; they
+// are safe for concurrent use.
+type Books struct {
+	tenants []Id
+	open    Opener
+	// books holds a slot for each tenant: a book is opened holding the lock of
+	// its slot only, so that the others are served meanwhile
+	books map[Id]*slot
+	// opened are the tenants whose book is open, told apart without waiting
+	// for the lock of their slot
+	mu     sync.RWMutex
+	opened map[Id]bool
+}
+
+type slot struct {
+	mu   sync.Mutex
+	book *Book
+}
+
+// NewBooks returns the books of tenants, opened by open
+func NewBooks(open Opener, tenants ...Id) *Books {
+	tenants = slices.Clone(tenants)
+	slices.Sort(tenants)
+	tenants = slices.Compact(tenants)
+	books := make(map[Id]*slot, len(tenants))
+	for _, t := range tenants {
+		books[t] = &slot{}
+	}
+	return &Books{tenants: tenants, open: open, books: books, opened: make(map[Id]bool)}
+}
+
+// Tenants are the tenants having a book, sorted
+func (me *Books) Tenants() []Id {
+	return slices.Clone(me.tenants)
+}
+
+// Book returns the book of the tenant of ctx, opening it if needed; a book
+// that failed to open is opened again the next time.
+func (me *Books) Book(ctx context.Context) (Book, error) {
+	t, ok := Of(ctx)
+	if !ok {
+		return Book{}, ErrNoTenant
+	}
+	s, found := me.books[t]
+	if !found {
+		return Book{}, UnknownError{Tenant: t}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.book != nil {
+		return *s.book, nil
+	}
+	b, err := me.open(ctx, t)
+	if err != nil {
+		return Book{}, fmt.Errorf("opening the book of %q: %w", t, err)
+	}
+	s.book = &b
+	me.mu.Lock()
+	me.opened[t] = true
+	me.mu.Unlock()
+	return b, nil
+}
+
+// IsOpen tells if the book of tenant t was opened, eg. not to open it just to peek at it
+func (me *Books) IsOpen(t Id) bool {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return me.opened[t]
+}
+
+// Opened are the tenants whose book was opened, sorted
+func (me *Books) Opened() []Id {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	return slices.Sorted(maps.Keys(me.opened))
+}
+
+// Close closes the books opened
+func (me *Books) Close() error {
+	var errs []error
+	for _, s := range me.books {
+		s.mu.Lock()
+		if s.book != nil && s.book.Close != nil {
+			errs = append(errs, s.book.Close())
+		}
+		s.book = nil
+		s.mu.Unlock()
+	}
+	me.mu.Lock()
+	clear(me.opened)
+	me.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Repository acts on the contacts of the book of the tenant of each context
+// it is given; without one it returns ErrNoTenant.
+func (me *Books) Repository() contact.Repository {
+	return repository{me}
+}
+
+// Log acts on the history of the book of the tenant of each context it is
+// given; without one it returns ErrNoTenant.
+func (me *Books) Log() audit.Log {
+	return auditLog{me}
+}
@@ -0,0 +1,131 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+	"dev.acorello.it/go/contacts/contact/tenant"
+)
+
+func newBooks(opened *[]tenant.Id, tenants ...tenant.Id) *tenant.Books {
+	return tenant.NewBooks(func(ctx context.Context, t tenant.Id) (tenant.Book, error) {
+		*opened = append(*opened, t)
+		log := audit.NewInMemoryLog()
+		return tenant.Book{Repository: audit.NewRepository(contact.NewInMemoryContactRepository(), log), Log: log}, nil
+	}, tenants...)
+}
+
+func joe() contact.Contact {
+	return contact.Contact{Id: contact.NewId(), FirstName: "Joe", LastName: "Bloggs", Emails: []contact.EmailEntry{{Primary: true, Email: contact.MustParseEmail("joe@example.com")}}}
+}
+
+func TestBooksAreIsolated(t *testing.T) {
+	var opened []tenant.Id
+	books := newBooks(&opened, "bob", "ann", "bob")
+	defer books.Close()
+	if tenants := books.Tenants(); !slices.Equal(tenants, []tenant.Id{"ann", "bob"}) {
+		t.Errorf("expected the tenants sorted and distinct, got %q", tenants)
+	}
+	repo := books.Repository()
+	ann, bob := tenant.With(context.Background(), "ann"), tenant.With(context.Background(), "bob")
+
+	annsJoe, bobsJoe := joe(), joe()
+	if err := repo.Store(ann, annsJoe); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Store(bob, bobsJoe); err != nil {
+		t.Errorf("expected the e-mail of ann's contact free in the book of bob, got %v", err)
+	}
+	var emailOwnerErr contact.EmailOwnerError
+	if err := repo.Store(ann, joe()); !errors.As(err, &emailOwnerErr) || emailOwnerErr.Owner != annsJoe.Id {
+		t.Errorf("expected the e-mail taken in the book of ann, got %v", err)
+	}
+	if _, found, _ := repo.FindById(bob, annsJoe.Id); found {
+		t.Error("expected ann's contact not in the book of bob")
+	}
+	if history, _ := books.Log().History(bob, annsJoe.Id); len(history) != 0 {
+		t.Errorf("expected ann's history not in the book of bob, got %d entries", len(history))
+	}
+	if history, _ := books.Log().History(ann, annsJoe.Id); len(history) != 1 {
+		t.Errorf("expected ann's history in her book, got %d entries", len(history))
+	}
+	if !slices.Equal(opened, []tenant.Id{"ann", "bob"}) {
+		t.Errorf("expected each book opened once, got %q", opened)
+	}
+}
+
+func TestBooksNeedAKnownTenant(t *testing.T) {
+	var opened []tenant.Id
+	books := newBooks(&opened, "ann")
+	defer books.Close()
+
+	if _, _, _, err := books.Repository().FindAll(context.Background(), contact.Page{Size: 10}); !errors.Is(err, tenant.ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	var unknownErr tenant.UnknownError
+	if _, err := books.Log().History(tenant.With(context.Background(), "eve"), contact.NewId()); !errors.As(err, &unknownErr) || unknownErr.Tenant != "eve" {
+		t.Errorf("expected eve unknown, got %v", err)
+	}
+	if len(opened) != 0 {
+		t.Errorf("expected no book opened, got %q", opened)
+	}
+}
+
+func TestParseId(t *testing.T) {
+	for _, valid := range []string{"ann", "acme-corp", "team_2"} {
+		if _, err := tenant.ParseId(valid); err != nil {
+			t.Errorf("expected %q valid, got %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"", "Ann", "-ann", "../ann", "ann.db", "ann smith"} {
+		if _, err := tenant.ParseId(invalid); err == nil {
+			t.Errorf("expected %q invalid", invalid)
+		}
+	}
+}
+
+func TestBooksOpenApart(t *testing.T) {
+	slow, failing := make(chan struct{}), true
+	books := tenant.NewBooks(func(ctx context.Context, t tenant.Id) (tenant.Book, error) {
+		switch {
+		case t == "slow":
+			<-slow
+		case t == "flaky" && failing:
+			failing = false
+			return tenant.Book{}, errors.New("unavailable")
+		}
+		return tenant.Book{Repository: contact.NewInMemoryContactRepository(), Log: audit.NewInMemoryLog()}, nil
+	}, "slow", "flaky", "ann")
+	defer books.Close()
+
+	opened := make(chan error)
+	go func() {
+		_, err := books.Book(tenant.With(context.Background(), "slow"))
+		opened <- err
+	}()
+	if _, err := books.Book(tenant.With(context.Background(), "ann")); err != nil {
+		t.Fatal(err)
+	}
+	if !books.IsOpen("ann") || books.IsOpen("slow") {
+		t.Error("expected the book of ann open, while the one of slow is being opened")
+	}
+	close(slow)
+	if err := <-opened; err != nil || !books.IsOpen("slow") {
+		t.Errorf("expected the book of slow open, got %v", err)
+	}
+	if got := books.Opened(); !slices.Equal(got, []tenant.Id{"ann", "slow"}) {
+		t.Errorf("expected the books of ann and slow opened, got %v", got)
+	}
+
+	flaky := tenant.With(context.Background(), "flaky")
+	if _, err := books.Book(flaky); err == nil || books.IsOpen("flaky") {
+		t.Errorf("expected the book of flaky to fail to open, got %v", err)
+	}
+	if _, err := books.Book(flaky); err != nil {
+		t.Errorf("expected the book of flaky opened again, got %v", err)
+	}
+}
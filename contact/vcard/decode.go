@@ -0,0 +1,362 @@
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact"
+	"github.com/google/uuid"
+)
+
+// CardError tells why a card could not be decoded
+type CardError struct {
+	// Line is the number of the line of the card the error was found at
+	Line int
+	Err  error
+}
+
+func (me *CardError) Error() string {
+	return fmt.Sprintf("line %d: %v", me.Line, me.Err)
+}
+
+func (me *CardError) Unwrap() error {
+	return me.Err
+}
+
+// Decoder reads contacts from a stream of cards, as exported by most phone
+// and mail clients; lines outside of cards, and unknown properties, are ignored.
+type Decoder struct {
+	lines *bufio.Scanner
+	// line is the number of the last line scanned
+	line int
+	// next is the line read ahead, to unfold the one before, if hasNext
+	next     string
+	nextLine int
+	hasNext  bool
+}
+
+// maxCardLine is the length of the longest (unfolded) line read, eg. of an embedded PHOTO
+const maxCardLine = 1 << 20
+
+func NewDecoder(r io.Reader) *Decoder {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, maxCardLine)
+	return &Decoder{lines: lines}
+}
+
+// Decode decodes the next card, returning io.EOF when there are no more.
+// A card that can not be decoded is skipped, reporting why as a *CardError:
+// the next call decodes the card after it. Any other error is of the reader.
+//
+// The Id of the contact is the UUID of the UID of the card, if it is one
+// (eg. "urn:uuid:…"), otherwise it is derived from the UID, so that
+// importing the same card twice updates the same contact.
+func (me *Decoder) Decode() (c contact.Contact, err error) {
+	var begin int
+	for begin == 0 {
+		line, number, ok := me.readLine()
+		if !ok {
+			return c, me.readErr(io.EOF)
+		} else if strings.EqualFold(strings.TrimSpace(line), "BEGIN:VCARD") {
+			begin = number
+		}
+	}
+	var b card
+	var cardErr *CardError
+	for {
+		line, number, ok := me.readLine()
+		if !ok {
+			return c, me.readErr(&CardError{Line: begin, Err: errors.New("missing END:VCARD")})
+		} else if strings.EqualFold(strings.TrimSpace(line), "END:VCARD") {
+			break
+		} else if cardErr != nil || strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseProperty(line)
+		if err == nil {
+			err = b.add(p)
+		}
+		if err != nil {
+			cardErr = &CardError{Line: number, Err: err}
+		}
+	}
+	if cardErr != nil {
+		return c, cardErr
+	}
+	if c, err = b.contact(); err != nil {
+		return c, &CardError{Line: begin, Err: err}
+	}
+	return c, nil
+}
+
+func (me *Decoder) readErr(orElse error) error {
+	if err := me.lines.Err(); err != nil {
+		return err
+	}
+	return orElse
+}
+
+// readLine returns the next line unfolded, and the number of its first line
+func (me *Decoder) readLine() (line string, number int, ok bool) {
+	if !me.hasNext && !me.scan() {
+		return "", 0, false
+	}
+	line, number = me.next, me.nextLine
+	me.hasNext = false
+	for me.scan() {
+		if next := me.next; next == "" || (next[0] != ' ' && next[0] != '\t') {
+			break
+		}
+		line += me.next[1:]
+		me.hasNext = false
+	}
+	return line, number, true
+}
+
+func (me *Decoder) scan() bool {
+	if !me.lines.Scan() {
+		return false
+	}
+	me.line++
+	me.next, me.nextLine, me.hasNext = strings.TrimSuffix(me.lines.Text(), "\r"), me.line, true
+	return true
+}
+
+type property struct {
+	// name is upper case, without group (eg. "TEL" of "item1.TEL")
+	name string
+	// params are keyed by their upper case name; the values of a parameter
+	// with no name (eg. "TEL;HOME:…") are of TYPE.
+	params map[string][]string
+	value  string
+}
+
+// parseProperty parses a content line: name *(";" param) ":" value
+func parseProperty(line string) (p property, err error) {
+	colon := indexUnquoted(line, ':')
+	if colon < 0 {
+		return p, fmt.Errorf("missing \":\" in %q", abbreviate(line))
+	}
+	head := splitUnquoted(line[:colon], ';')
+	p.name = strings.ToUpper(strings.TrimSpace(head[0]))
+	if i := strings.LastIndexByte(p.name, '.'); i >= 0 {
+		p.name = p.name[i+1:]
+	}
+	if p.name == "" {
+		return p, fmt.Errorf("missing property name in %q", abbreviate(line))
+	}
+	p.params = make(map[string][]string)
+	for _, param := range head[1:] {
+		name, value, found := strings.Cut(param, "=")
+		if !found {
+			name, value = "TYPE", param
+		}
+		name = strings.ToUpper(strings.TrimSpace(name))
+		for _, v := range splitUnquoted(value, ',') {
+			p.params[name] = append(p.params[name], strings.Trim(strings.TrimSpace(v), `"`))
+		}
+	}
+	p.value = line[colon+1:]
+	return p, nil
+}
+
+func (me property) hasType(types ...string) bool {
+	return slices.ContainsFunc(me.params["TYPE"], func(t string) bool {
+		return slices.ContainsFunc(types, func(typ string) bool { return strings.EqualFold(t, typ) })
+	})
+}
+
+func (me property) label() contact.Label {
+	switch {
+	case me.hasType("cell", "x-mobile"):
+		return contact.Mobile
+	case me.hasType("work"):
+		return contact.Work
+	case me.hasType("home"):
+		return contact.Home
+	case me.hasType("x-other"):
+		return contact.Other
+	default:
+		return ""
+	}
+}
+
+func (me property) isPrimary() bool {
+	return slices.Contains(me.params["PREF"], "1") || me.hasType("pref")
+}
+
+// card collects the properties of a card
+type card struct {
+	version, uid, formattedName string
+	hasName                     bool
+	c                           contact.Contact
+}
+
+func (me *card) add(p property) error {
+	switch p.name {
+	case "VERSION":
+		if p.value != "4.0" && p.value != "3.0" {
+			return fmt.Errorf("unsupported version %q", p.value)
+		}
+		me.version = p.value
+	case "UID":
+		me.uid = strings.TrimSpace(p.value)
+	case "FN":
+		me.formattedName = strings.TrimSpace(unescape(p.value))
+	case "N":
+		n := structuredValue(p.value, 2)
+		me.c.LastName, me.c.FirstName = strings.TrimSpace(n[0]), strings.TrimSpace(n[1])
+		me.hasName = true
+	case "TEL":
+		number := unescape(p.value)
+		if scheme, uri, found := strings.Cut(number, ":"); found && strings.EqualFold(scheme, "tel") {
+			number, _, _ = strings.Cut(uri, ";") // drop parameters, eg. ";ext=123"
+		}
+		phone, err := contact.ParsePhone(number)
+		if err != nil {
+			return fmt.Errorf("TEL %q: %w", number, err)
+		} else if !phone.IsZero() {
+			me.c.Phones = append(me.c.Phones, contact.PhoneEntry{Label: p.label(), Primary: p.isPrimary(), Phone: phone})
+		}
+	case "EMAIL":
+		address := strings.TrimSpace(unescape(p.value))
+		email, err := contact.ParseEmail(address)
+		if err != nil {
+			return fmt.Errorf("EMAIL %q: %w", address, err)
+		} else if !email.IsZero() {
+			me.c.Emails = append(me.c.Emails, contact.EmailEntry{Label: p.label(), Primary: p.isPrimary(), Email: email})
+		}
+	case "ADR":
+		// post office box; extended address; street; locality; region; postal code; country
+		adr := structuredValue(p.value, 7)
+		street := slices.DeleteFunc(adr[:3], func(s string) bool { return strings.TrimSpace(s) == "" })
+		address := contact.Address{
+			Label:      p.label(),
+			Primary:    p.isPrimary(),
+			Street:     strings.Join(street, ", "),
+			City:       strings.TrimSpace(adr[3]),
+			Region:     strings.TrimSpace(adr[4]),
+			PostalCode: strings.TrimSpace(adr[5]),
+			Country:    strings.TrimSpace(adr[6]),
+		}
+		if !address.IsZero() {
+			me.c.Addresses = append(me.c.Addresses, address)
+		}
+	case "CATEGORIES":
+		for _, category := range splitUnescaped(p.value, ',') {
+			if category = unescape(category); strings.TrimSpace(category) == "" {
+				continue
+			} else if tag, err := contact.ParseTag(category); err != nil {
+				return fmt.Errorf("CATEGORIES %q: %w", category, err)
+			} else {
+				me.c.Tags = contact.SortedTags(append(me.c.Tags, tag))
+			}
+		}
+	}
+	return nil
+}
+
+func (me *card) contact() (c contact.Contact, err error) {
+	if me.version == "" {
+		return c, errors.New("missing VERSION")
+	}
+	c = me.c
+	if id, err := contact.ParseId(strings.TrimPrefix(strings.ToLower(me.uid), "urn:uuid:")); err == nil {
+		c.Id = id
+	} else if me.uid != "" {
+		c.Id = contact.Id(uuid.NewSHA1(uuid.NameSpaceURL, []byte(me.uid)).String())
+	} else {
+		c.Id = contact.NewId()
+	}
+	if !me.hasName {
+		if i := strings.LastIndexByte(me.formattedName, ' '); i >= 0 {
+			c.FirstName, c.LastName = me.formattedName[:i], me.formattedName[i+1:]
+		} else {
+			c.FirstName = me.formattedName
+		}
+	}
+	c.NormalizePrimaries()
+	return c, nil
+}
+
+// structuredValue splits value by the unescaped ";" into at least n unescaped components
+func structuredValue(value string, n int) []string {
+	components := splitUnescaped(value, ';')
+	for i, c := range components {
+		components[i] = unescape(c)
+	}
+	for len(components) < n {
+		components = append(components, "")
+	}
+	return components
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func splitUnescaped(s string, sep byte) (parts []string) {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func indexUnquoted(s string, b byte) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == b && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+func splitUnquoted(s string, sep byte) (parts []string) {
+	for {
+		i := indexUnquoted(s, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func abbreviate(s string) string {
+	if len(s) > 40 {
+		return s[:40] + "…"
+	}
+	return s
+}
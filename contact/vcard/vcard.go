@@ -0,0 +1,115 @@
+// Package vcard encodes contacts as vCard 4.0 (RFC 6350), and decodes them
+// from vCard 4.0 or 3.0 (RFC 2426).
+//
+// Labels map to the TYPE parameter: "home" and "work" as they are, "mobile"
+// as "cell" for phones and "x-mobile" otherwise, "other" as "x-other".
+// Primary entries have PREF=1 (or, in 3.0, TYPE=pref). Tags are CATEGORIES.
+package vcard
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+// MediaType is the media type of vCard 4.0
+const MediaType = "text/vcard"
+
+// maxLineLength is the length in octets after which lines are folded, CRLF excluded
+const maxLineLength = 75
+
+// Write writes c as a vCard
+func Write(w io.Writer, c contact.Contact) error {
+	b := bufio.NewWriter(w)
+	writeLine(b, "BEGIN:VCARD")
+	writeLine(b, "VERSION:4.0")
+	writeLine(b, "UID:urn:uuid:"+c.Id.String())
+	writeLine(b, "FN:"+escape(strings.TrimSpace(c.FirstName+" "+c.LastName)))
+	writeLine(b, "N:"+structured(c.LastName, c.FirstName, "", "", ""))
+	for _, p := range c.Phones {
+		if e164 := p.Phone.E164(); e164 != "" {
+			writeLine(b, "TEL;VALUE=uri"+params(phoneType(p.Label), p.Primary)+":tel:"+e164)
+		} else {
+			writeLine(b, "TEL"+params(phoneType(p.Label), p.Primary)+":"+escape(p.Phone.String()))
+		}
+	}
+	for _, e := range c.Emails {
+		writeLine(b, "EMAIL"+params(labelType(e.Label), e.Primary)+":"+escape(e.Email.String()))
+	}
+	for _, a := range c.Addresses {
+		// post office box; extended address; street; locality; region; postal code; country
+		value := structured("", "", a.Street, a.City, a.Region, a.PostalCode, a.Country)
+		writeLine(b, "ADR"+params(labelType(a.Label), a.Primary)+":"+value)
+	}
+	if len(c.Tags) > 0 {
+		var categories []string
+		for _, t := range c.Tags {
+			categories = append(categories, escape(string(t)))
+		}
+		writeLine(b, "CATEGORIES:"+strings.Join(categories, ","))
+	}
+	writeLine(b, "END:VCARD")
+	return b.Flush()
+}
+
+func params(typ string, primary bool) (p string) {
+	if typ != "" {
+		p += ";TYPE=" + typ
+	}
+	if primary {
+		p += ";PREF=1"
+	}
+	return p
+}
+
+func labelType(l contact.Label) string {
+	switch l {
+	case contact.Home, contact.Work:
+		return string(l)
+	case "":
+		return ""
+	default:
+		return "x-" + string(l)
+	}
+}
+
+func phoneType(l contact.Label) string {
+	if l == contact.Mobile {
+		return "cell"
+	}
+	return labelType(l)
+}
+
+// structured joins the components of a structured value, eg. N or ADR
+func structured(components ...string) string {
+	for i, c := range components {
+		components[i] = escape(c)
+	}
+	return strings.Join(components, ";")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// writeLine folds line every maxLineLength octets, without splitting runes
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		w.WriteString(line[:i])
+		w.WriteString("\r\n ")
+		line = line[i:]
+		limit = maxLineLength - 1 // the leading space counts
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,126 @@
+package vcard
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"dev.acorello.it/go/contacts/contact"
+)
+
+func TestWrite(t *testing.T) {
+	c := contact.Contact{
+		Id:        contact.MustParseId("00000000-0000-0000-0000-000000000001"),
+		FirstName: "Joe",
+		LastName:  "Bloggs; Jr.",
+		Phones:    []contact.PhoneEntry{{Label: contact.Mobile, Primary: true, Phone: contact.MustParsePhone("07911 123456")}},
+		Emails: []contact.EmailEntry{
+			{Label: contact.Work, Email: contact.MustParseEmail("joe@example.com")},
+			{Label: contact.Other, Primary: true, Email: contact.MustParseEmail("joe@example.org")},
+		},
+		Addresses: []contact.Address{{Label: contact.Home, Street: "1 Main St, Flat 2", City: "London", Country: strings.Repeat("Ü", 40)}},
+	}
+	var b strings.Builder
+	if err := Write(&b, c); err != nil {
+		t.Fatal(err)
+	}
+	expected := "BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"UID:urn:uuid:00000000-0000-0000-0000-000000000001\r\n" +
+		"FN:Joe Bloggs\\; Jr.\r\n" +
+		"N:Bloggs\\; Jr.;Joe;;;\r\n" +
+		"TEL;VALUE=uri;TYPE=cell;PREF=1:tel:+447911123456\r\n" +
+		"EMAIL;TYPE=work:joe@example.com\r\n" +
+		"EMAIL;TYPE=x-other;PREF=1:joe@example.org\r\n" +
+		"ADR;TYPE=home:;;1 Main St\\, Flat 2;London;;;" + strings.Repeat("Ü", 15) + "\r\n" +
+		" " + strings.Repeat("Ü", 25) + "\r\n" +
+		"END:VCARD\r\n"
+	if b.String() != expected {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, b.String())
+	}
+}
+
+func TestWriteThenDecode(t *testing.T) {
+	c := contact.Contact{
+		Id:        contact.NewId(),
+		FirstName: "Mary Ann",
+		LastName:  "O'Neil, Jr.",
+		Phones:    []contact.PhoneEntry{{Label: contact.Work, Phone: contact.MustParsePhone("020 7946 0000")}, {Label: contact.Mobile, Primary: true, Phone: contact.MustParsePhone("+44 7911 123456")}},
+		Emails:    []contact.EmailEntry{{Label: contact.Other, Primary: true, Email: contact.MustParseEmail("mary@example.com")}},
+		Addresses: []contact.Address{{Primary: true, Street: `1 Main St; Back\Door`, City: "London", PostalCode: "N1 9GU", Country: strings.Repeat("Long Country Name, ", 10)[:99]}},
+		Tags:      []contact.Tag{contact.MustParseTag("book club"), contact.MustParseTag("work; main")},
+	}
+	var b strings.Builder
+	if err := Write(&b, c); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := NewDecoder(strings.NewReader(b.String())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// phones keep their E.164 form only
+	c.Phones[0].Phone = contact.MustParsePhone("+442079460000")
+	c.Phones[1].Phone = contact.MustParsePhone("+447911123456")
+	if !decoded.Equal(c) {
+		t.Errorf("expected\n%#v\ngot\n%#v", c, decoded)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cards := strings.Join([]string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"UID:some-client-id",
+		"FN:Joe Bloggs",
+		"item1.TEL;TYPE=HOME,VOICE;TYPE=pref:020 7946",
+		"  0000",
+		"EMAIL;TYPE=INTERNET:joe@example.com",
+		"X-UNKNOWN;PARAM=\"a:b\":ignored",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"FN:Invalid",
+		"EMAIL:not an e-mail",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:2.1",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+		"N:Doe;Jane;;;",
+		"EMAIL;PREF=2:jane@example.com",
+		"EMAIL;PREF=1;TYPE=work:jane@work.example.com",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"VERSION:4.0",
+	}, "\r\n")
+	d := NewDecoder(strings.NewReader(cards))
+
+	joe, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joe.FirstName != "Joe" || joe.LastName != "Bloggs" || joe.Phone().E164() != "+442079460000" || !joe.Phones[0].Primary || joe.Phones[0].Label != contact.Home {
+		t.Errorf("unexpected %#v", joe)
+	}
+	if again, _ := NewDecoder(strings.NewReader(cards)).Decode(); again.Id != joe.Id {
+		t.Errorf("expected the same id from the same UID, got %q and %q", joe.Id, again.Id)
+	}
+	var cardErr *CardError
+	if _, err := d.Decode(); !errors.As(err, &cardErr) || cardErr.Line != 13 {
+		t.Errorf("expected an error at line 13, got %v", err)
+	}
+	if _, err := d.Decode(); !errors.As(err, &cardErr) || cardErr.Line != 16 {
+		t.Errorf("expected an unsupported version at line 16, got %v", err)
+	}
+	if jane, err := d.Decode(); err != nil || jane.Email().String() != "jane@work.example.com" || jane.LastName != "Doe" {
+		t.Errorf("unexpected %#v, %v", jane, err)
+	}
+	if _, err := d.Decode(); !errors.As(err, &cardErr) {
+		t.Errorf("expected an error for the unterminated card, got %v", err)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
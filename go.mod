@@ -3,8 +3,9 @@ module dev.acorello.it/go/contacts
 go 1.24.2
 
 require (
-	github.com/acorello/must v0.1.0
 	github.com/acorello/uttpil v0.0.0-20250619171426-08dffd395489
 	github.com/google/uuid v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/crypto v0.40.0
 	golang.org/x/net v0.41.0
 )
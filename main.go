@@ -1,25 +1,36 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
 	contactHTTP "dev.acorello.it/go/contacts/contact/http"
+	"dev.acorello.it/go/contacts/contact/journal"
+	"dev.acorello.it/go/contacts/contact/sqlite"
+	"dev.acorello.it/go/contacts/contact/tenant"
 	"dev.acorello.it/go/contacts/public_assets"
+	"dev.acorello.it/go/contacts/user"
+	userHTTP "dev.acorello.it/go/contacts/user/http"
 	"github.com/acorello/uttpil"
 )
 
-var repo = contact.NewPopulatedInMemoryContactRepository()
-
 var CommitHash = func() string {
 	if sha, found := os.LookupEnv("GITHUB_SHA"); found {
 		return sha
@@ -34,29 +45,40 @@ var CommitHash = func() string {
 }()
 
 func main() {
-	mux := http.NewServeMux()
-	const publicRootPath = "/public/"
-	mux.Handle(publicRootPath, http.StripPrefix(publicRootPath, public_assets.FileServer()))
-
-	contactResourcePaths := contactHTTP.Paths{
-		Root:  "/contact/",
-		Form:  "/contact/form",
-		List:  "/contact/list",
-		Email: "/contact/email",
+	if len(os.Args) == 2 && os.Args[1] == "hash-password" {
+		if err := hashPassword(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-
-	if validatedPaths, err := contactResourcePaths.Validated(); err != nil {
+	users, err := openUsers()
+	if err != nil {
 		log.Fatal(err)
-	} else {
-		contactHTTP.RegisterHandlers(mux, validatedPaths, &repo)
-		homeRedirect := http.RedirectHandler(validatedPaths.List.String(), http.StatusFound)
-		mux.Handle("/", homeRedirect)
 	}
+	if err := adoptLegacyFiles(tenantsOf(users)); err != nil {
+		log.Fatal(err)
+	}
+	books := tenant.NewBooks(openBook, tenantsOf(users)...)
+	defer books.Close()
+	retention, err := trashRetention()
+	if err != nil {
+		log.Fatal(err)
+	}
+	purgeCtx, stopPurging := context.WithCancel(context.Background())
+	defer stopPurging()
+	go purgeTrashEvery(purgeCtx, books, retention, trashPurgeInterval)
 
-	mux.HandleFunc(healthCheckPath, healthcheck)
+	fields, err := customFields()
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler, err := newHandler(books, fields, users, user.NewSessions(sessionLifetime))
+	if err != nil {
+		log.Fatal(err)
+	}
 	var srv = http.Server{
 		Addr:    bindAddress(),
-		Handler: uttpil.LoggingHandler(mux),
+		Handler: uttpil.LoggingHandler(handler),
 	}
 
 	shutdownDone := make(chan struct{})
@@ -71,6 +93,68 @@ func main() {
 	}
 }
 
+// newHandler serves the app to the users signed in, each the address book of
+// their tenant; only the login page, the public assets, and the health check
+// are served to anyone.
+func newHandler(books *tenant.Books, fields contact.CustomFields, users user.Users, sessions *user.Sessions) (http.Handler, error) {
+	repo, auditLog := books.Repository(), books.Log()
+	mux := http.NewServeMux()
+	const publicRootPath = "/public/"
+	mux.Handle(publicRootPath, http.StripPrefix(publicRootPath, public_assets.FileServer()))
+
+	userPaths, err := userHTTP.Paths{
+		Login:  "/login",
+		Logout: "/logout",
+	}.Validated()
+	if err != nil {
+		return nil, err
+	}
+	userHTTP.RegisterHandlers(mux, userPaths, users, sessions)
+
+	contactResourcePaths := contactHTTP.Paths{
+		Root:       "/contact/",
+		Form:       "/contact/form",
+		List:       "/contact/list",
+		Email:      "/contact/email",
+		EntryRow:   "/contact/form/entry",
+		Export:     "/contact/export",
+		Import:     "/contact/import",
+		CSVImport:  "/contact/import/csv",
+		CSVPreview: "/contact/import/csv/preview",
+		Trash:      "/contact/trash",
+		Restore:    "/contact/trash/restore",
+		History:    "/contact/history",
+		Batch:      "/contact/batch",
+		Tags:       "/contact/tags",
+		Duplicates: "/contact/duplicates",
+		Merge:      "/contact/merge",
+		Logout:     contactHTTP.Path(userPaths.Logout),
+		Tenants:    "/contact/tenants",
+	}
+
+	if validatedPaths, err := contactResourcePaths.Validated(); err != nil {
+		return nil, err
+	} else {
+		contactHTTP.RegisterHandlers(mux, validatedPaths, repo, auditLog, fields, books)
+		homeRedirect := http.RedirectHandler(validatedPaths.List.String(), http.StatusFound)
+		mux.Handle("/", homeRedirect)
+	}
+
+	contactAPIPaths := contactHTTP.APIPaths{
+		Contacts: "/api/contacts",
+		Contact:  "/api/contacts/{id}",
+	}
+
+	if validatedPaths, err := contactAPIPaths.Validated(); err != nil {
+		return nil, err
+	} else {
+		contactHTTP.RegisterAPIHandlers(mux, validatedPaths, repo, fields)
+	}
+
+	mux.HandleFunc(healthCheckPath, healthcheck)
+	return userHTTP.RequireUser(withTenant(withActor(mux), books), userPaths, sessions, publicRootPath, healthCheckPath), nil
+}
+
 func waitShutdownSignal(srv *http.Server, done chan<- struct{}) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
@@ -84,6 +168,297 @@ func waitShutdownSignal(srv *http.Server, done chan<- struct{}) {
 	close(done)
 }
 
+const journalCompactInterval = 10 * time.Minute
+
+// openBook opens the address book of tenant t: its contacts, and their history
+// (see openRepository and openAuditLog).
+func openBook(ctx context.Context, t tenant.Id) (tenant.Book, error) {
+	store, err := openRepository(ctx, t)
+	if err != nil {
+		return tenant.Book{}, err
+	}
+	auditLog, err := openAuditLog(t)
+	if err != nil {
+		return tenant.Book{}, errors.Join(err, closeAll(store))
+	}
+	return tenant.Book{
+		Repository: audit.NewRepository(store, auditLog),
+		Log:        auditLog,
+		Close:      func() error { return closeAll(store, auditLog) },
+	}, nil
+}
+
+// closeAll closes those of xs that are io.Closers
+func closeAll(xs ...any) error {
+	var errs []error
+	for _, x := range xs {
+		if closer, ok := x.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tenantPath is the path of the file, or directory, of tenant t: path with
+// the tenant before its extension, eg. "contacts.ann.db" for "contacts.db".
+func tenantPath(path string, t tenant.Id) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + t.String() + ext
+}
+
+// openRepository picks the contact.Repository implementation of tenant t from the environment.
+// CONTACTS_STORE is either "memory" (the default, pre-populated), "sqlite", or "journal";
+// CONTACTS_STORE_PATH is the database file (default "contacts.db") or the
+// journal directory (default "contacts-journal"), of which each tenant has
+// its own (see tenantPath).
+func openRepository(ctx context.Context, t tenant.Id) (contact.Repository, error) {
+	store := os.Getenv("CONTACTS_STORE")
+	switch store {
+	case "", "memory":
+		log.Printf("Using in-memory contact repository of %q", t)
+		return contact.NewPopulatedInMemoryContactRepository(), nil
+	case "sqlite":
+		path := tenantPath(storePath(store), t)
+		log.Printf("Using SQLite contact repository %q", path)
+		return sqlite.Open(ctx, path)
+	case "journal":
+		path := tenantPath(storePath(store), t)
+		log.Printf("Using journal contact repository %q", path)
+		return journal.Open(path, journalCompactInterval)
+	default:
+		return nil, fmt.Errorf("unknown CONTACTS_STORE %q", store)
+	}
+}
+
+// openAuditLog opens the log of the contact changes of tenant t: in memory
+// for the in-memory store, else the file CONTACTS_AUDIT_PATH (default
+// "contacts-audit.jsonl"), of which each tenant has its own (see tenantPath).
+func openAuditLog(t tenant.Id) (audit.Log, error) {
+	if store := os.Getenv("CONTACTS_STORE"); store == "" || store == "memory" {
+		return audit.NewInMemoryLog(), nil
+	}
+	path := tenantPath(auditPath(), t)
+	log.Printf("Using audit log %q", path)
+	return audit.OpenFileLog(path)
+}
+
+// storePath is CONTACTS_STORE_PATH, or the default of the store, before the
+// tenant is added (see tenantPath).
+func storePath(store string) string {
+	if path := os.Getenv("CONTACTS_STORE_PATH"); path != "" {
+		return path
+	} else if store == "journal" {
+		return "contacts-journal"
+	}
+	return "contacts.db"
+}
+
+// auditPath is CONTACTS_AUDIT_PATH, or its default, before the tenant is added (see tenantPath)
+func auditPath() string {
+	return cmp.Or(os.Getenv("CONTACTS_AUDIT_PATH"), "contacts-audit.jsonl")
+}
+
+// adoptLegacyFiles gives the files of the store and of the audit log made
+// before there were tenants, named without one, to the tenant
+// CONTACTS_LEGACY_TENANT, renaming them as its own (see tenantPath). Without
+// it, it fails rather than start with the books empty and the files ignored.
+func adoptLegacyFiles(tenants []tenant.Id) error {
+	store := os.Getenv("CONTACTS_STORE")
+	if store == "" || store == "memory" {
+		return nil
+	}
+	t := tenant.Id(os.Getenv("CONTACTS_LEGACY_TENANT"))
+	type rename struct{ from, to string }
+	renames := []rename{
+		{storePath(store), tenantPath(storePath(store), t)},
+		{auditPath(), tenantPath(auditPath(), t)},
+	}
+	if store == "sqlite" {
+		for _, suffix := range []string{"-wal", "-shm"} {
+			renames = append(renames, rename{storePath(store) + suffix, tenantPath(storePath(store), t) + suffix})
+		}
+	}
+	renames = slices.DeleteFunc(renames, func(r rename) bool {
+		_, err := os.Stat(r.from)
+		return errors.Is(err, fs.ErrNotExist)
+	})
+	if len(renames) == 0 {
+		return nil
+	} else if !slices.Contains(tenants, t) {
+		return fmt.Errorf("found %q, made before there were tenants: set CONTACTS_LEGACY_TENANT to the tenant of the users to give it to", renames[0].from)
+	}
+	for _, r := range renames {
+		if _, err := os.Stat(r.to); !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("can't give %q to %q: %q exists", r.from, t, r.to)
+		}
+	}
+	for _, r := range renames {
+		if err := os.Rename(r.from, r.to); err != nil {
+			return err
+		}
+		log.Printf("Gave %q to %q, as %q", r.from, t, r.to)
+	}
+	return nil
+}
+
+// customFields reads the custom fields of the contacts from the JSON file
+// CONTACTS_FIELDS_PATH (see contact.ParseCustomFields); there are none if unset.
+func customFields() (contact.CustomFields, error) {
+	path := os.Getenv("CONTACTS_FIELDS_PATH")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fields, err := contact.ParseCustomFields(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONTACTS_FIELDS_PATH %q: %w", path, err)
+	}
+	log.Printf("Using %d custom fields from %q", len(fields), path)
+	return fields, nil
+}
+
+// withActor attributes the changes made by a request to the user signed in,
+// else to its client address.
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.RemoteAddr
+		if u, ok := user.Of(r.Context()); ok {
+			actor = u.Name
+		} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			actor = host
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
+
+// withTenant scopes the requests of the user signed in to the address book
+// of their tenant; admins may view the book of any tenant instead (see
+// contactHTTP.TenantCookie).
+func withTenant(next http.Handler, books *tenant.Books) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := user.Of(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t := u.Tenant
+		if c, err := r.Cookie(contactHTTP.TenantCookie); err == nil && u.Admin && slices.Contains(books.Tenants(), tenant.Id(c.Value)) {
+			t = tenant.Id(c.Value)
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.With(r.Context(), t)))
+	})
+}
+
+// tenantsOf returns the tenants of the users
+func tenantsOf(users user.Users) (tenants []tenant.Id) {
+	for _, u := range users {
+		tenants = append(tenants, u.Tenant)
+	}
+	return tenants
+}
+
+const sessionLifetime = 12 * time.Hour
+
+// openUsers reads the accounts (see user.ParseUsers) from CONTACTS_USERS, eg.
+// a secret of the deployment, or else from the JSON file CONTACTS_USERS_PATH;
+// both may be unset only to try the app out, with the in-memory store and
+// CONTACTS_DEMO=1, for a "demo" account with password "demo".
+func openUsers() (user.Users, error) {
+	if users := os.Getenv("CONTACTS_USERS"); users != "" {
+		parsed, err := user.ParseUsers(strings.NewReader(users))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONTACTS_USERS: %w", err)
+		}
+		log.Printf("Using %d accounts from CONTACTS_USERS", len(parsed))
+		return parsed, nil
+	}
+	path := os.Getenv("CONTACTS_USERS_PATH")
+	if path == "" {
+		if store := os.Getenv("CONTACTS_STORE"); os.Getenv("CONTACTS_DEMO") != "1" || (store != "" && store != "memory") {
+			return nil, errors.New("CONTACTS_USERS or CONTACTS_USERS_PATH is required, but for a demo: CONTACTS_DEMO=1 with the in-memory store")
+		}
+		log.Printf("Using the demo account")
+		hash, err := user.HashPassword("demo")
+		return user.Users{"demo": {Name: "demo", PasswordHash: hash, Tenant: "demo"}}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users, err := user.ParseUsers(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONTACTS_USERS_PATH %q: %w", path, err)
+	}
+	log.Printf("Using %d accounts from %q", len(users), path)
+	return users, nil
+}
+
+// hashPassword writes the hash of the password read from in, to put in the
+// users file: `echo -n secret | contacts hash-password`.
+func hashPassword(in io.Reader, out io.Writer) error {
+	password, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	hash, err := user.HashPassword(strings.TrimRight(string(password), "\r\n"))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, hash)
+	return err
+}
+
+const trashPurgeInterval = time.Hour
+
+// trashRetention is how long deleted contacts stay in the trash before being
+// purged: CONTACTS_TRASH_RETENTION, eg. "72h" (default 30 days).
+func trashRetention() (time.Duration, error) {
+	retention := os.Getenv("CONTACTS_TRASH_RETENTION")
+	if retention == "" {
+		return 30 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(retention)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CONTACTS_TRASH_RETENTION: %w", err)
+	} else if d <= 0 {
+		return 0, fmt.Errorf("CONTACTS_TRASH_RETENTION must be positive, got %q", retention)
+	}
+	return d, nil
+}
+
+// purgeTrashEvery purges the trash (see purgeTrash) now and then every
+// interval, until ctx is done.
+func purgeTrashEvery(ctx context.Context, books *tenant.Books, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purgeTrash(ctx, books, retention)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrash purges the contacts deleted more than retention ago from the
+// books opened; the others are left to the first purge after they are.
+func purgeTrash(ctx context.Context, books *tenant.Books, retention time.Duration) {
+	for _, t := range books.Opened() {
+		purged, err := books.Repository().PurgeDeletedBefore(tenant.With(ctx, t), time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Error purging the trash of %q: %v", t, err)
+		} else if purged > 0 {
+			log.Printf("Purged %d contacts of %q deleted more than %v ago", purged, t, retention)
+		}
+	}
+}
+
 func bindAddress() string {
 	host := os.Getenv("HOST")
 	if host == "" {
@@ -96,7 +471,7 @@ const healthCheckPath = "/healthcheck"
 
 func healthcheck(w http.ResponseWriter, r *http.Request) {
 	now := time.Now().Format(time.RFC1123Z)
-	_, err := fmt.Fprintf(w, "Commit: %s\nTime: %s\n", CommitHash, now)
+	_, err := fmt.Fprintf(w, "Commit: %s\nTime: %s\nAudit entries lost: %d\n", CommitHash, now, audit.FailedEntries())
 	if err != nil {
 		log.Printf("error reporting %s: %v", healthCheckPath, err)
 	} else {
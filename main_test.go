@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dev.acorello.it/go/contacts/contact"
+	"dev.acorello.it/go/contacts/contact/audit"
+	contactHTTP "dev.acorello.it/go/contacts/contact/http"
+	"dev.acorello.it/go/contacts/contact/tenant"
+	"dev.acorello.it/go/contacts/user"
+	userHTTP "dev.acorello.it/go/contacts/user/http"
+)
+
+// TestConcurrentRequests hammers the shared repo through the real handlers;
+// it is meant to be run with the race detector (`make test.race`).
+func TestConcurrentRequests(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hash, err := user.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := user.Users{"ann": {Name: "ann", PasswordHash: hash, Tenant: "ann"}}
+	books := newInMemoryBooks(t, "ann")
+	repo, ctx := books.Repository(), tenant.With(context.Background(), "ann")
+	mux, err := newHandler(books, nil, users, user.NewSessions(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := signIn(t, mux, "ann", "secret")
+	const workers, iterations = 16, 40
+	joeBloggs := contact.MustParseId("00000000-0000-0000-0000-000000000001")
+
+	expectOneOf := func(statuses []int, method, target string, form url.Values) {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req := httptest.NewRequest(method, target, body)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.AddCookie(session)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if !slices.Contains(statuses, res.Code) {
+			t.Errorf("%s %s: expected status in %v, got %d", method, target, statuses, res.Code)
+		}
+	}
+	expect := func(status int, method, target string, form url.Values) {
+		expectOneOf([]int{status}, method, target, form)
+	}
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				id := contact.NewId().String()
+				email := fmt.Sprintf("stress-%d-%d@example.com", w, i)
+				expect(http.StatusFound, http.MethodPost, "/contact/form", url.Values{
+					"Id":           {id},
+					"FirstName":    {"Worker"},
+					"LastName":     {"Stress"},
+					"EmailKey":     {"e"},
+					"EmailAddress": {email},
+					"PhoneKey":     {"p"},
+					"PhoneNumber":  {fmt.Sprintf("07911 %06d", i)},
+				})
+				joe, _, err := repo.FindById(ctx, joeBloggs)
+				if err != nil {
+					t.Error(err)
+				}
+				// a concurrent update may win: then the form shows the conflict
+				expectOneOf([]int{http.StatusFound, http.StatusOK}, http.MethodPost, "/contact/form", url.Values{
+					"Id":           {joeBloggs.String()},
+					"Version":      {strconv.Itoa(joe.Version)},
+					"FirstName":    {"Joe"},
+					"LastName":     {"Bloggs"},
+					"EmailKey":     {"e"},
+					"EmailAddress": {"joebloggs@example.com"},
+					"PhoneKey":     {"p"},
+					"PhoneNumber":  {fmt.Sprintf("+44 7911 %03d%03d", w, i)},
+				})
+				expect(http.StatusOK, http.MethodGet, "/contact/list?SearchTerm=Stress&pageSize=20", nil)
+				expect(http.StatusOK, http.MethodGet, "/contact/list?Sort=-first", nil)
+				expect(http.StatusOK, http.MethodGet, "/contact/?Id="+id, nil)
+				expect(http.StatusOK, http.MethodPatch, "/contact/email", url.Values{
+					"Id":    {id},
+					"Email": {email},
+				})
+				if i%2 == 0 {
+					expect(http.StatusSeeOther, http.MethodDelete, "/contact/?Id="+id, nil)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	survivors, _, _, err := repo.FindByQuery(ctx, contact.Term{Field: contact.LastField, Value: "Stress"}, contact.Page{Size: workers * iterations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := workers * iterations / 2; len(survivors) != expected {
+		t.Errorf("expected %d contacts to survive, got %d", expected, len(survivors))
+	}
+}
+
+// newInMemoryBooks returns the books of tenants, each populated in memory
+func newInMemoryBooks(t *testing.T, tenants ...tenant.Id) *tenant.Books {
+	t.Helper()
+	books := tenant.NewBooks(func(ctx context.Context, _ tenant.Id) (tenant.Book, error) {
+		auditLog := audit.NewInMemoryLog()
+		return tenant.Book{
+			Repository: audit.NewRepository(contact.NewPopulatedInMemoryContactRepository(), auditLog),
+			Log:        auditLog,
+		}, nil
+	}, tenants...)
+	t.Cleanup(func() { books.Close() })
+	return books
+}
+
+func signIn(t *testing.T, h http.Handler, name, password string) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"Name": {name}, "Password": {password}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	for _, c := range res.Result().Cookies() {
+		if c.Name == userHTTP.SessionCookie && res.Code == http.StatusSeeOther {
+			return c
+		}
+	}
+	t.Fatalf("expected %q to sign in, got status %d", name, res.Code)
+	return nil
+}
+
+func TestOnlyUsersSignedInAreServed(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hash, err := user.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := newInMemoryBooks(t, "ann")
+	h, err := newHandler(books, nil, user.Users{"ann": {Name: "ann", PasswordHash: hash, Tenant: "ann"}}, user.NewSessions(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, target string, session *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Accept", "text/html")
+		if session != nil {
+			req.AddCookie(session)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+	for _, public := range []string{healthCheckPath, "/public/pico.classless.css", "/login"} {
+		if res := serve(http.MethodGet, public, nil); res.Code != http.StatusOK {
+			t.Errorf("GET %s: expected it public, got status %d", public, res.Code)
+		}
+	}
+	if res := serve(http.MethodGet, "/contact/list?SearchTerm=joe", nil); res.Code != http.StatusSeeOther ||
+		res.Header().Get("Location") != "/login?Next=%2Fcontact%2Flist%3FSearchTerm%3Djoe" {
+		t.Errorf("expected a redirect to the login page, got %d to %q", res.Code, res.Header().Get("Location"))
+	}
+	if res := serve(http.MethodDelete, "/contact/?Id=00000000-0000-0000-0000-000000000001", nil); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected a DELETE to be refused, got status %d", res.Code)
+	}
+
+	session := signIn(t, h, "ann", "secret")
+	if res := serve(http.MethodGet, "/contact/list", session); res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "Signed in as ann") {
+		t.Errorf("expected the list, got status %d", res.Code)
+	}
+	serve(http.MethodDelete, "/contact/?Id=00000000-0000-0000-0000-000000000001", session)
+	if entries, _ := books.Log().History(tenant.With(context.Background(), "ann"), contact.MustParseId("00000000-0000-0000-0000-000000000001")); len(entries) != 1 || entries[0].Actor != "ann" {
+		t.Errorf("expected the deletion attributed to ann, got %#v", entries)
+	}
+	serve(http.MethodPost, "/logout", session)
+	if res := serve(http.MethodGet, "/contact/list", session); res.Code != http.StatusSeeOther {
+		t.Errorf("expected the session to end, got status %d", res.Code)
+	}
+}
+
+func TestUsersSeeTheBookOfTheirTenant(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hash, err := user.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := user.Users{
+		"ann": {Name: "ann", PasswordHash: hash, Tenant: "ann"},
+		"bob": {Name: "bob", PasswordHash: hash, Tenant: "acme", Admin: true},
+	}
+	h, err := newHandler(newInMemoryBooks(t, tenantsOf(users)...), nil, users, user.NewSessions(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, target string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+	const joeBloggs = "/contact/?Id=00000000-0000-0000-0000-000000000001"
+	ann, bob := signIn(t, h, "ann", "secret"), signIn(t, h, "bob", "secret")
+
+	serve(http.MethodDelete, joeBloggs, nil, ann)
+	if res := serve(http.MethodGet, joeBloggs, nil, ann); res.Code != http.StatusNotFound {
+		t.Errorf("expected ann to have deleted Joe, got status %d", res.Code)
+	}
+	if res := serve(http.MethodGet, joeBloggs, nil, bob); res.Code != http.StatusOK {
+		t.Errorf("expected Joe still in the book of bob, got status %d", res.Code)
+	}
+
+	if res := serve(http.MethodGet, "/contact/tenants", nil, ann); res.Code != http.StatusForbidden {
+		t.Errorf("expected the tenants hidden from ann, got status %d", res.Code)
+	}
+	if res := serve(http.MethodGet, "/contact/tenants", nil, bob); res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "acme") {
+		t.Errorf("expected bob to list the tenants, got status %d", res.Code)
+	}
+	res := serve(http.MethodGet, "/contact/tenants?SearchTerm=bloggs", nil, bob)
+	if page := res.Body.String(); res.Code != http.StatusOK || !strings.Contains(page, "<caption>acme</caption>") || strings.Contains(page, "<caption>ann</caption>") {
+		t.Errorf("expected bob to find Joe in the book of acme only, got status %d: %s", res.Code, page)
+	}
+	res = serve(http.MethodPost, "/contact/tenants", url.Values{"Tenant": {"acme"}, "Id": {"00000000-0000-0000-0000-000000000001"}}, bob)
+	if location := res.Header().Get("Location"); res.Code != http.StatusSeeOther || location != joeBloggs {
+		t.Errorf("expected bob to view the contact found, got status %d to %q", res.Code, location)
+	}
+	res = serve(http.MethodPost, "/contact/tenants", url.Values{"Tenant": {"ann"}}, bob)
+	var viewing *http.Cookie
+	for _, c := range res.Result().Cookies() {
+		if c.Name == contactHTTP.TenantCookie {
+			viewing = c
+		}
+	}
+	if res.Code != http.StatusSeeOther || viewing == nil {
+		t.Fatalf("expected bob to view the book of ann, got status %d", res.Code)
+	}
+	if res := serve(http.MethodGet, joeBloggs, nil, bob, viewing); res.Code != http.StatusNotFound {
+		t.Errorf("expected bob to view the book of ann, got status %d", res.Code)
+	}
+	if res := serve(http.MethodGet, joeBloggs, nil, ann, viewing); res.Code != http.StatusNotFound {
+		t.Errorf("expected ann to view her own book only, got status %d", res.Code)
+	}
+}
+
+func TestPurgeTrashOfOpenBooksOnly(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var opened []tenant.Id
+	books := tenant.NewBooks(func(ctx context.Context, t tenant.Id) (tenant.Book, error) {
+		opened = append(opened, t)
+		return tenant.Book{Repository: contact.NewPopulatedInMemoryContactRepository()}, nil
+	}, "acme", "ann")
+	defer books.Close()
+	ctx := tenant.With(context.Background(), "ann")
+	joeBloggs := contact.Id("00000000-0000-0000-0000-000000000001")
+	if deleted, err := books.Repository().Delete(ctx, joeBloggs); !deleted || err != nil {
+		t.Fatalf("expected deletion, got %v, %v", deleted, err)
+	}
+
+	purgeTrash(context.Background(), books, 0)
+	if _, found, _ := books.Repository().FindDeletedById(ctx, joeBloggs); found {
+		t.Errorf("expected %q purged from the trash of ann", joeBloggs)
+	}
+	if !slices.Equal(opened, []tenant.Id{"ann"}) {
+		t.Errorf("expected only the book of ann opened, got %q", opened)
+	}
+}
+
+func TestTenantPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"contacts.db":           "contacts.ann.db",
+		"contacts-journal":      "contacts-journal.ann",
+		"data.d/contacts-audit": "data.d/contacts-audit.ann",
+	} {
+		if actual := tenantPath(path, "ann"); actual != expected {
+			t.Errorf("tenantPath(%q): expected %q, got %q", path, expected, actual)
+		}
+	}
+}
+
+func TestDemoAccountIsOptIn(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	t.Setenv("CONTACTS_USERS", "")
+	t.Setenv("CONTACTS_USERS_PATH", "")
+	for _, env := range []struct{ store, demo string }{{"", ""}, {"memory", ""}, {"sqlite", "1"}} {
+		t.Setenv("CONTACTS_STORE", env.store)
+		t.Setenv("CONTACTS_DEMO", env.demo)
+		if _, err := openUsers(); err == nil {
+			t.Errorf("expected no demo account with %+v", env)
+		}
+	}
+	t.Setenv("CONTACTS_STORE", "")
+	t.Setenv("CONTACTS_DEMO", "1")
+	if users, err := openUsers(); err != nil || users["demo"].Admin {
+		t.Errorf("expected a demo account, not an admin, got %v, %v", users, err)
+	}
+}
+
+func TestUsersFromTheEnvironment(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hash, err := user.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONTACTS_USERS_PATH", "")
+	t.Setenv("CONTACTS_DEMO", "")
+	t.Setenv("CONTACTS_USERS", fmt.Sprintf(`[{"name": "ann", "passwordHash": %q, "tenant": "acme"}]`, hash))
+	if users, err := openUsers(); err != nil || users["ann"].Tenant != "acme" {
+		t.Errorf("expected the account of ann, got %v, %v", users, err)
+	}
+	t.Setenv("CONTACTS_USERS", `[{"name": "ann"}]`)
+	if _, err := openUsers(); err == nil {
+		t.Errorf("expected invalid accounts rejected")
+	}
+}
+
+func TestLegacyFilesGoToATenant(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	t.Setenv("CONTACTS_STORE", "sqlite")
+	t.Setenv("CONTACTS_STORE_PATH", filepath.Join(dir, "contacts.db"))
+	t.Setenv("CONTACTS_AUDIT_PATH", filepath.Join(dir, "contacts-audit.jsonl"))
+	for _, name := range []string{"contacts.db", "contacts.db-wal", "contacts-audit.jsonl"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tenants := []tenant.Id{"acme", "ann"}
+	for _, legacyTenant := range []string{"", "bob"} {
+		t.Setenv("CONTACTS_LEGACY_TENANT", legacyTenant)
+		if err := adoptLegacyFiles(tenants); err == nil {
+			t.Errorf("expected the legacy files refused to %q", legacyTenant)
+		}
+	}
+	t.Setenv("CONTACTS_LEGACY_TENANT", "acme")
+	if err := adoptLegacyFiles(tenants); err != nil {
+		t.Fatal(err)
+	}
+	for legacy, renamed := range map[string]string{
+		"contacts.db":          "contacts.acme.db",
+		"contacts.db-wal":      "contacts.acme.db-wal",
+		"contacts-audit.jsonl": "contacts-audit.acme.jsonl",
+	} {
+		if data, err := os.ReadFile(filepath.Join(dir, renamed)); err != nil || string(data) != legacy {
+			t.Errorf("expected %q renamed %q, got %q, %v", legacy, renamed, data, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "contacts.db"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := adoptLegacyFiles(tenants); err == nil {
+		t.Errorf("expected the legacy files not to replace those of the tenant")
+	}
+}
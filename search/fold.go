@@ -0,0 +1,58 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// foldings maps accented (and a few composite) letters to their plain ASCII spelling.
+// It covers Latin-1 Supplement and Latin Extended-A, which is where the stdlib,
+// lacking Unicode normalization, leaves us on our own.
+var foldings = func() map[rune]string {
+	m := make(map[rune]string)
+	for plain, accented := range map[string]string{
+		"a":  "àáâãäåāăą",
+		"c":  "çćĉċč",
+		"d":  "ďđð",
+		"e":  "èéêëēĕėęě",
+		"g":  "ĝğġģ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįı",
+		"j":  "ĵ",
+		"k":  "ķ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňŉ",
+		"o":  "òóôõöøōŏő",
+		"r":  "ŕŗř",
+		"s":  "śŝşš",
+		"t":  "ţťŧ",
+		"u":  "ùúûüũūŭůűų",
+		"w":  "ŵ",
+		"y":  "ýÿŷ",
+		"z":  "źżž",
+		"ae": "æ",
+		"oe": "œ",
+		"ss": "ß",
+		"th": "þ",
+	} {
+		for _, r := range accented {
+			m[r] = plain
+		}
+	}
+	return m
+}()
+
+// Fold lower-cases s and strips accents, so that "Zoë" and "zoe" compare equal.
+func Fold(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		r = unicode.ToLower(r)
+		if plain, found := foldings[r]; found {
+			sb.WriteString(plain)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
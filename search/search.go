@@ -0,0 +1,239 @@
+// Package search implements a small full-text search index: fields are split
+// into case- and accent-folded tokens, kept in an inverted index, and queries
+// match tokens by prefix and rank results by relevance.
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Kind int
+
+const (
+	// Text is split into words
+	Text Kind = iota
+	// Email is split, like Text, at any punctuation (eg. local part and domain labels)
+	Email
+	// Phone is reduced to its digits (see PhoneDigits); every suffix of 3 or more digits is a token
+	// so that a query matches any trailing part of the number.
+	Phone
+)
+
+// minPhoneSuffix is the shortest phone suffix indexed; shorter ones would match almost anything
+const minPhoneSuffix = 3
+
+type Field struct {
+	Kind   Kind
+	Weight float64
+	Value  string
+}
+
+// Tokenize returns the tokens of the fields, each with the highest weight of the fields containing it.
+func Tokenize(fields ...Field) map[string]float64 {
+	tokens := make(map[string]float64)
+	for _, f := range fields {
+		for _, t := range fieldTokens(f) {
+			tokens[t] = max(tokens[t], f.Weight)
+		}
+	}
+	return tokens
+}
+
+func fieldTokens(f Field) []string {
+	switch f.Kind {
+	case Phone:
+		digits := PhoneDigits(f.Value)
+		var suffixes []string
+		for i := 0; i <= len(digits)-minPhoneSuffix; i++ {
+			suffixes = append(suffixes, digits[i:])
+		}
+		return suffixes
+	default:
+		return words(f.Value)
+	}
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Digits returns only the decimal digits in s
+func Digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if '0' <= r && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// PhoneDigits returns the digits of the phone number s; a number in
+// international format (eg. "+44 (0)751 123" or "0044 751 123") loses the
+// international prefix "00" and the trunk prefix "(0)", as in its E.164 form
+// ("44751123").
+func PhoneDigits(s string) string {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "+"):
+		// "(0)" is the trunk prefix, dialled only within the country
+		return Digits(strings.Replace(s, "(0)", "", 1))
+	case strings.HasPrefix(s, "00"):
+		return Digits(strings.Replace(s, "(0)", "", 1))[2:]
+	default:
+		return Digits(s)
+	}
+}
+
+// Terms splits a query into folded terms. Consecutive words made only of
+// digits and phone punctuation (eg. "+44 (0)751 123") are reduced to a single
+// term of their PhoneDigits, so they match phone numbers however they were punctuated.
+func Terms(query string) (terms []string) {
+	var phone strings.Builder
+	for _, word := range strings.Fields(query) {
+		if isPhoneLike(word) {
+			phone.WriteString(word)
+			continue
+		}
+		if phone.Len() > 0 {
+			terms = append(terms, PhoneDigits(phone.String()))
+			phone.Reset()
+		}
+		terms = append(terms, words(word)...)
+	}
+	if phone.Len() > 0 {
+		terms = append(terms, PhoneDigits(phone.String()))
+	}
+	return terms
+}
+
+func isPhoneLike(word string) bool {
+	hasDigits := false
+	for _, r := range word {
+		switch {
+		case '0' <= r && r <= '9':
+			hasDigits = true
+		case strings.ContainsRune("+-().", r):
+		default:
+			return false
+		}
+	}
+	return hasDigits
+}
+
+// Score tells how well term matches token, indexed with the given weight: zero
+// when it doesn't, the full weight for an exact match, and less for a prefix
+// match, the less the shorter the prefix.
+func Score(term, token string, weight float64) float64 {
+	if token == term {
+		return weight
+	} else if strings.HasPrefix(token, term) {
+		return weight / 2 * (1 + float64(len(term))/float64(len(token)))
+	}
+	return 0
+}
+
+// Index is an inverted index from tokens to the keys of the documents containing them.
+// Searches may run concurrently with each other, but not with Put or Remove.
+type Index[K comparable] struct {
+	postings map[string]map[K]float64 // token → document → weight
+	docs     map[K][]string           // document → tokens, to remove it
+	tokens   tokenSet                 // the tokens, sorted to find those having a prefix
+}
+
+func NewIndex[K comparable]() *Index[K] {
+	return &Index[K]{
+		postings: make(map[string]map[K]float64),
+		docs:     make(map[K][]string),
+	}
+}
+
+// Put indexes the document key, replacing its previous fields if any.
+func (me *Index[K]) Put(key K, fields ...Field) {
+	me.Remove(key)
+	tokens := Tokenize(fields...)
+	for token, weight := range tokens {
+		docs, found := me.postings[token]
+		if !found {
+			docs = make(map[K]float64)
+			me.postings[token] = docs
+			me.tokens.insert(token)
+		}
+		docs[key] = weight
+		me.docs[key] = append(me.docs[key], token)
+	}
+}
+
+func (me *Index[K]) Remove(key K) {
+	for _, token := range me.docs[key] {
+		docs := me.postings[token]
+		delete(docs, key)
+		if len(docs) == 0 {
+			delete(me.postings, token)
+			me.tokens.remove(token)
+		}
+	}
+	delete(me.docs, key)
+}
+
+// Search returns the score of every document matching all the terms of query;
+// each term scores its best match, see Score.
+func (me *Index[K]) Search(query string) map[K]float64 {
+	var result map[K]float64
+	for _, term := range Terms(query) {
+		termScores := make(map[K]float64)
+		for token := range me.tokens.withPrefix(term) {
+			for key, weight := range me.postings[token] {
+				termScores[key] = max(termScores[key], Score(term, token, weight))
+			}
+		}
+		if result == nil {
+			result = termScores
+		} else {
+			for key, score := range result {
+				if termScore, found := termScores[key]; found {
+					result[key] = score + termScore
+				} else {
+					delete(result, key)
+				}
+			}
+		}
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result
+}
+
+// MatchesFields tells whether every term of query matches (see Score) a token of the fields.
+func MatchesFields(query string, fields ...Field) bool {
+	tokens := Tokenize(fields...)
+	for _, term := range Terms(query) {
+		matched := false
+		for token := range tokens {
+			if strings.HasPrefix(token, term) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// ScoreFields sums, for each term of query, the score of its best match in the fields.
+func ScoreFields(query string, fields ...Field) (score float64) {
+	tokens := Tokenize(fields...)
+	for _, term := range Terms(query) {
+		var best float64
+		for token, weight := range tokens {
+			best = max(best, Score(term, token, weight))
+		}
+		score += best
+	}
+	return score
+}
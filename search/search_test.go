@@ -0,0 +1,100 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	for in, expected := range map[string]string{
+		"Zoë":     "zoe",
+		"ÉLODIE":  "elodie",
+		"Straße":  "strasse",
+		"Łukasz":  "lukasz",
+		"plain 1": "plain 1",
+	} {
+		if got := Fold(in); got != expected {
+			t.Errorf("Fold(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	got := Terms("Joe  +44 (0)751-123 joe@Example.com 0751 123 Jo 0044 751 123")
+	expected := []string{"joe", "44751123", "joe", "example", "com", "0751123", "jo", "44751123"}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := NewIndex[string]()
+	idx.Put("joanna", Field{Kind: Text, Weight: 3, Value: "Joanna"})
+	idx.Put("joe", Field{Kind: Text, Weight: 3, Value: "Joe"}, Field{Kind: Phone, Weight: 1, Value: "+44 (0)751 123456"})
+	idx.Put("jo", Field{Kind: Text, Weight: 3, Value: "Jo"}, Field{Kind: Email, Weight: 2, Value: "jo@example.com"})
+	idx.Put("zoe", Field{Kind: Text, Weight: 3, Value: "Zoë"})
+
+	scores := idx.Search("JO")
+	if len(scores) != 3 || !(scores["jo"] > scores["joe"] && scores["joe"] > scores["joanna"]) {
+		t.Errorf("expected exact match, then shorter prefix matches first, got %v", scores)
+	}
+	if scores := idx.Search("zoe"); len(scores) != 1 {
+		t.Errorf("expected accent-insensitive match, got %v", scores)
+	}
+	if scores := idx.Search("123-456"); len(scores) != 1 || scores["joe"] == 0 {
+		t.Errorf("expected phone suffix match, got %v", scores)
+	}
+	if scores := idx.Search("+44(0)751123456"); len(scores) != 1 || scores["joe"] == 0 {
+		t.Errorf("expected a match of the number in international format, with the trunk prefix, got %v", scores)
+	}
+	if scores := idx.Search("joe 999"); len(scores) != 0 {
+		t.Errorf("expected every term to match, got %v", scores)
+	}
+
+	idx.Put("joe", Field{Kind: Text, Weight: 3, Value: "Bob"})
+	if scores := idx.Search("joe"); len(scores) != 0 {
+		t.Errorf("expected replaced fields not to match, got %v", scores)
+	}
+	idx.Remove("zoe")
+	if scores := idx.Search("zoe"); len(scores) != 0 || slices.Contains(slices.Collect(idx.tokens.all()), "zoe") {
+		t.Errorf("expected removed document not to match, got %v", scores)
+	}
+}
+
+// BenchmarkPut indexes documents with a name, an e-mail and a phone, whose
+// suffixes make many tokens.
+func BenchmarkPut(b *testing.B) {
+	for b.Loop() {
+		idx := NewIndex[int]()
+		for i := range 100_000 {
+			idx.Put(i,
+				Field{Kind: Text, Weight: 3, Value: fmt.Sprintf("Joe Bloggs%d", i)},
+				Field{Kind: Email, Weight: 2, Value: fmt.Sprintf("joe%d@example.com", i)},
+				Field{Kind: Phone, Weight: 1, Value: fmt.Sprintf("+44 7700 %06d", i)},
+			)
+		}
+		idx.Search("joe 123")
+	}
+}
+
+// BenchmarkPutAndSearch interleaves, as the repository does, the changes of
+// some documents with searches of an index of many.
+func BenchmarkPutAndSearch(b *testing.B) {
+	idx := NewIndex[int]()
+	put := func(i int) {
+		idx.Put(i,
+			Field{Kind: Text, Weight: 3, Value: fmt.Sprintf("Joe Bloggs%d", i)},
+			Field{Kind: Phone, Weight: 1, Value: fmt.Sprintf("+44 7700 %06d", i)},
+		)
+	}
+	for i := range 100_000 {
+		put(i)
+	}
+	i := 100_000
+	for b.Loop() {
+		put(i)
+		idx.Search("bloggs1234")
+		i++
+	}
+}
@@ -0,0 +1,85 @@
+package search
+
+import (
+	"iter"
+	"slices"
+	"strings"
+)
+
+// maxChunkSize is the size past which a chunk of tokens is split in two
+const maxChunkSize = 128
+
+// tokenSet keeps the tokens of an Index sorted in chunks, at most maxChunkSize
+// long, so that adding or removing one moves a chunk at most, rather than
+// sorting them all again before the next search.
+type tokenSet struct {
+	chunks [][]string
+}
+
+// chunkOf returns the position of the chunk token belongs to: the first whose
+// last token is not less than it, or the last chunk.
+func (me *tokenSet) chunkOf(token string) int {
+	i, _ := slices.BinarySearchFunc(me.chunks, token, func(chunk []string, token string) int {
+		return strings.Compare(chunk[len(chunk)-1], token)
+	})
+	return min(i, len(me.chunks)-1)
+}
+
+func (me *tokenSet) insert(token string) {
+	if len(me.chunks) == 0 {
+		me.chunks = [][]string{{token}}
+		return
+	}
+	i := me.chunkOf(token)
+	chunk := me.chunks[i]
+	j, found := slices.BinarySearch(chunk, token)
+	if found {
+		return
+	}
+	chunk = slices.Insert(chunk, j, token)
+	if len(chunk) <= maxChunkSize {
+		me.chunks[i] = chunk
+		return
+	}
+	half := len(chunk) / 2
+	me.chunks[i] = slices.Clip(chunk[:half])
+	me.chunks = slices.Insert(me.chunks, i+1, slices.Clone(chunk[half:]))
+}
+
+func (me *tokenSet) remove(token string) {
+	if len(me.chunks) == 0 {
+		return
+	}
+	i := me.chunkOf(token)
+	j, found := slices.BinarySearch(me.chunks[i], token)
+	if !found {
+		return
+	}
+	me.chunks[i] = slices.Delete(me.chunks[i], j, j+1)
+	if len(me.chunks[i]) == 0 {
+		me.chunks = slices.Delete(me.chunks, i, i+1)
+	}
+}
+
+// withPrefix yields, in order, the tokens starting with prefix
+func (me *tokenSet) withPrefix(prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if len(me.chunks) == 0 {
+			return
+		}
+		i := me.chunkOf(prefix)
+		j, _ := slices.BinarySearch(me.chunks[i], prefix)
+		for ; i < len(me.chunks); i, j = i+1, 0 {
+			for _, token := range me.chunks[i][j:] {
+				if !strings.HasPrefix(token, prefix) || !yield(token) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// all yields the tokens in order
+func (me *tokenSet) all() iter.Seq[string] {
+	return me.withPrefix("")
+}
@@ -0,0 +1,35 @@
+package ht
+
+import (
+	"embed"
+	"html/template"
+	"io"
+
+	"dev.acorello.it/go/contacts/templates"
+)
+
+//go:embed *.html
+var myTemplates embed.FS
+
+var loginTemplate = func() *template.Template {
+	t := template.Must(template.ParseFS(templates.CommonFS(), "layout.html"))
+	return template.Must(t.ParseFS(myTemplates, "login.html"))
+}()
+
+type LoginPage struct {
+	// Name is the user name typed
+	Name string
+	// Next is the path to go to once signed in
+	Next string
+	// Error explains why the user was not signed in
+	Error error
+	URLs  LoginPageURLs
+}
+
+type LoginPageURLs struct {
+	Login template.URL
+}
+
+func WriteLoginPage(w io.Writer, p LoginPage) error {
+	return loginTemplate.Execute(w, p)
+}
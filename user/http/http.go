@@ -0,0 +1,159 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dev.acorello.it/go/contacts/seq"
+	"dev.acorello.it/go/contacts/user"
+	"dev.acorello.it/go/contacts/user/http/ht"
+	"github.com/acorello/uttpil"
+)
+
+// SessionCookie is the cookie carrying the token of the session of the user signed in
+const SessionCookie = "session"
+
+type Paths struct {
+	// Login shows the form to sign in, and signs in (POST); Logout signs out (POST)
+	Login, Logout string
+}
+
+type paths Paths
+
+// Validated checks that:
+// - paths are distinct
+func (my Paths) Validated() (v paths, err error) {
+	if seq.HasDuplicates(my.Login, my.Logout) {
+		return v, fmt.Errorf("path elements must be unique. Got %+v", my)
+	}
+	return paths(my), nil
+}
+
+// RegisterHandlers registers the handlers signing users in and out
+func RegisterHandlers(mux *http.ServeMux, paths paths, users user.Users, sessions *user.Sessions) {
+	h := userHTTPHandler{
+		paths:    paths,
+		users:    users,
+		sessions: sessions,
+	}
+	mux.Handle(paths.Login, uttpil.ForMethod{
+		GET:  h.GetLogin,
+		POST: h.PostLogin,
+	})
+	mux.Handle(paths.Logout, uttpil.ForMethod{
+		POST: h.PostLogout,
+	})
+}
+
+type userHTTPHandler struct {
+	paths    paths
+	users    user.Users
+	sessions *user.Sessions
+}
+
+func (h userHTTPHandler) GetLogin(w http.ResponseWriter, r *http.Request) {
+	h.writeLoginPage(w, http.StatusOK, ht.LoginPage{Next: localPath(r.URL.Query().Get("Next"))})
+}
+
+// PostLogin signs the user in, if the Name and Password match, starting a
+// session and redirecting to Next; the login page tells if they don't match.
+func (h userHTTPHandler) PostLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	name, next := strings.TrimSpace(r.PostForm.Get("Name")), localPath(r.PostForm.Get("Next"))
+	u, ok := h.users.Authenticate(name, r.PostForm.Get("Password"))
+	if !ok {
+		log.Printf("Failed sign in of %q", name)
+		h.writeLoginPage(w, http.StatusUnauthorized, ht.LoginPage{
+			Name:  name,
+			Next:  next,
+			Error: errors.New("wrong name or password"),
+		})
+		return
+	}
+	token, expires := h.sessions.Start(u)
+	http.SetCookie(w, sessionCookie(token, expires))
+	log.Printf("Signed in %q", u.Name)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// PostLogout ends the session, if any, and redirects to the login page
+func (h userHTTPHandler) PostLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		h.sessions.End(c.Value)
+	}
+	http.SetCookie(w, sessionCookie("", time.Unix(0, 0)))
+	http.Redirect(w, r, h.paths.Login, http.StatusSeeOther)
+}
+
+func (h userHTTPHandler) writeLoginPage(w http.ResponseWriter, status int, p ht.LoginPage) {
+	p.URLs.Login = template.URL(h.paths.Login)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := ht.WriteLoginPage(w, p); err != nil {
+		log.Printf("error rendering template: %v", err)
+	}
+}
+
+// sessionCookie is only sent back over HTTPS, and not to scripts nor with
+// requests from other sites but following links to this one.
+func sessionCookie(token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// localPath returns next if it is a path of this site, else "/"
+func localPath(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return "/"
+	}
+	return next
+}
+
+// RequireUser lets through to next the requests of users signed in, carrying
+// the user (see user.WithUser), and the requests for the public paths: those
+// equal to one of them, or under it if it ends with "/". Pages requested by
+// anyone else redirect to the login page, which comes back to them; other
+// requests answer 401 Unauthorized, and tell HTMX to go to the login page.
+func RequireUser(next http.Handler, paths paths, sessions *user.Sessions, public ...string) http.Handler {
+	public = append(public, paths.Login)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range public {
+			if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if u, found := sessions.Find(c.Value); found {
+				next.ServeHTTP(w, r.WithContext(user.WithUser(r.Context(), u)))
+				return
+			}
+		}
+		login := paths.Login + "?" + url.Values{"Next": {r.URL.RequestURI()}}.Encode()
+		if r.Header.Get("HX-Request") == "true" {
+			w.Header().Set("HX-Redirect", paths.Login)
+			http.Error(w, "sign in required", http.StatusUnauthorized)
+		} else if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, login, http.StatusSeeOther)
+		} else {
+			http.Error(w, "sign in required", http.StatusUnauthorized)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dev.acorello.it/go/contacts/user"
+)
+
+func TestLocalPath(t *testing.T) {
+	for next, expected := range map[string]string{
+		"/contact/list?SearchTerm=joe": "/contact/list?SearchTerm=joe",
+		"":                             "/",
+		"contact/list":                 "/",
+		"https://example.com/":         "/",
+		"//example.com/":               "/",
+		`/\example.com/`:               "/",
+	} {
+		if got := localPath(next); got != expected {
+			t.Errorf("localPath(%q): expected %q, got %q", next, expected, got)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hash, err := user.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := Paths{Login: "/login", Logout: "/logout"}.Validated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := user.NewSessions(time.Hour)
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, paths, user.Users{"ann": {Name: "ann", PasswordHash: hash}}, sessions)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.Of(r.Context())
+		io.WriteString(w, "hello "+u.Name)
+	})
+	h := RequireUser(mux, paths, sessions)
+	login := func(name, password string) *httptest.ResponseRecorder {
+		form := url.Values{"Name": {name}, "Password": {password}, "Next": {"/contacts?page=2"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	if res := login("ann", "wrong"); res.Code != http.StatusUnauthorized || !strings.Contains(res.Body.String(), "wrong name or password") ||
+		!strings.Contains(res.Body.String(), `value="/contacts?page=2"`) {
+		t.Errorf("expected the login page again, keeping Next, got %d: %s", res.Code, res.Body)
+	}
+	res := login("ann", "secret")
+	if res.Code != http.StatusSeeOther || res.Header().Get("Location") != "/contacts?page=2" {
+		t.Fatalf("expected a redirect to Next, got %d to %q", res.Code, res.Header().Get("Location"))
+	}
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected a secure session cookie, got %#v", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/contacts", nil)
+	req.AddCookie(cookies[0])
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Body.String() != "hello ann" {
+		t.Errorf("expected the request to carry the user, got %q", res.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/contacts", nil)
+	req.Header.Set("HX-Request", "true")
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != http.StatusUnauthorized || res.Header().Get("HX-Redirect") != "/login" {
+		t.Errorf("expected HTMX to be sent to the login page, got %d, %q", res.Code, res.Header().Get("HX-Redirect"))
+	}
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// Sessions are the users signed in, each by the token of their session; a
+// session lasts lifetime from when it was started.
+type Sessions struct {
+	lifetime time.Duration
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+type session struct {
+	user    User
+	expires time.Time
+}
+
+// NewSessions returns no sessions, each to last lifetime
+func NewSessions(lifetime time.Duration) *Sessions {
+	return &Sessions{lifetime: lifetime, sessions: make(map[string]session)}
+}
+
+// Start starts a session of u, returning its token, unguessable, and when it expires
+func (me *Sessions) Start(u User) (token string, expires time.Time) {
+	token = rand.Text()
+	expires = time.Now().Add(me.lifetime)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for t, s := range me.sessions {
+		if !time.Now().Before(s.expires) {
+			delete(me.sessions, t)
+		}
+	}
+	me.sessions[token] = session{user: u, expires: expires}
+	return token, expires
+}
+
+// Find returns the user of the session with token, unless it expired or ended
+func (me *Sessions) Find(token string) (u User, found bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s, found := me.sessions[token]
+	if !found || !time.Now().Before(s.expires) {
+		return u, false
+	}
+	return s.user, true
+}
+
+// End ends the session with token, if any
+func (me *Sessions) End(token string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.sessions, token)
+}
@@ -0,0 +1,94 @@
+// Package user has the accounts allowed to use the app, and their sessions.
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"dev.acorello.it/go/contacts/contact/tenant"
+	"dev.acorello.it/go/contacts/seq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is an account, identified by its Name
+type User struct {
+	Name string `json:"name"`
+	// PasswordHash is the bcrypt hash of the password (see HashPassword)
+	PasswordHash string `json:"passwordHash"`
+	// Tenant names the address book of the user, shared with the users of the
+	// same Tenant; a book of their own is a Tenant no one else has
+	Tenant tenant.Id `json:"tenant"`
+	// Admin users may view the address books of all the tenants
+	Admin bool `json:"admin,omitempty"`
+}
+
+// Users are the accounts, by Name
+type Users map[string]User
+
+// ParseUsers decodes a JSON array of users, eg.
+//
+//	[{"name": "ann", "passwordHash": "$2a$12$…", "tenant": "acme", "admin": true}]
+//
+// checking that their names are distinct, their hashes are bcrypt hashes, and
+// their tenants are valid (see tenant.ParseId).
+func ParseUsers(r io.Reader) (Users, error) {
+	var list []User
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding users: %w", err)
+	}
+	if seq.HasDuplicates(seq.Map(func(u User) string { return u.Name }, list...)...) {
+		return nil, errors.New("user names must be unique")
+	}
+	users := make(Users, len(list))
+	for _, u := range list {
+		if strings.TrimSpace(u.Name) == "" {
+			return nil, errors.New("a user name is blank")
+		} else if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %q: invalid password hash: %w", u.Name, err)
+		} else if _, err := tenant.ParseId(u.Tenant.String()); err != nil {
+			return nil, fmt.Errorf("user %q: %w", u.Name, err)
+		}
+		users[u.Name] = u
+	}
+	return users, nil
+}
+
+// HashPassword returns the bcrypt hash of password, to store as PasswordHash
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// unknownUserHash is compared to the passwords of unknown users, so that
+// Authenticate takes as long for them as for the known ones.
+var unknownUserHash, _ = HashPassword("unknown user")
+
+// Authenticate returns the user with the name, if password is theirs
+func (me Users) Authenticate(name, password string) (u User, ok bool) {
+	u, found := me[name]
+	hash := u.PasswordHash
+	if !found {
+		hash = unknownUserHash
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return u, found && err == nil
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying the user signed in
+func WithUser(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// Of returns the user signed in carried by ctx, if any (see WithUser)
+func Of(ctx context.Context) (u User, ok bool) {
+	u, ok = ctx.Value(userKey{}).(User)
+	return u, ok
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUsers(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := ParseUsers(strings.NewReader(`[{"name": "ann", "passwordHash": "` + hash + `", "tenant": "acme"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := users.Authenticate("ann", "secret"); !ok {
+		t.Errorf("expected ann to authenticate")
+	}
+	for _, wrong := range [][2]string{{"ann", "Secret"}, {"bob", "secret"}, {"", ""}} {
+		if _, ok := users.Authenticate(wrong[0], wrong[1]); ok {
+			t.Errorf("expected %q with password %q not to authenticate", wrong[0], wrong[1])
+		}
+	}
+	for json, expectedErr := range map[string]string{
+		`[{"name": "ann", "passwordHash": "secret", "tenant": "acme"}]`:                                      "invalid password hash",
+		`[{"name": "ann", "passwordHash": "` + hash + `"}, {"name": "ann", "passwordHash": "` + hash + `"}]`: "unique",
+		`[{"name": " ", "passwordHash": "` + hash + `"}]`:                                                    "blank",
+		`[{"name": "ann", "password": "secret"}]`:                                                            "unknown field",
+		`[{"name": "ann", "passwordHash": "` + hash + `"}]`:                                                  "invalid tenant",
+		`[{"name": "ann", "passwordHash": "` + hash + `", "tenant": "Acme Inc"}]`:                            "invalid tenant",
+	} {
+		if _, err := ParseUsers(strings.NewReader(json)); err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Errorf("%s: expected an error about %q, got %v", json, expectedErr, err)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions(time.Hour)
+	ann, bob := User{Name: "ann"}, User{Name: "bob"}
+	annToken, _ := sessions.Start(ann)
+	bobToken, _ := sessions.Start(bob)
+	if u, found := sessions.Find(annToken); !found || u.Name != "ann" {
+		t.Errorf("expected ann's session, got %#v, %t", u, found)
+	}
+	sessions.End(annToken)
+	if _, found := sessions.Find(annToken); found {
+		t.Errorf("expected ann's session to end")
+	}
+	if _, found := sessions.Find(bobToken); !found {
+		t.Errorf("expected bob's session to last")
+	}
+
+	expired := NewSessions(-time.Second)
+	token, _ := expired.Start(ann)
+	if _, found := expired.Find(token); found {
+		t.Errorf("expected the session to expire")
+	}
+}